@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"rakia.ai/blog-api/v2/config"
+	"rakia.ai/blog-api/v2/internal"
+)
+
+// auditCommand runs `blog-api audit verify [-config file] [-audit_file path]`, it checks the hash chain of the
+// audit log and prints the hash of the last entry, which can be kept elsewhere to notice entries cut off the end.
+// The audit log is found with the same config file, environment and flags as the server
+func auditCommand(args []string) int {
+	if len(args) < 1 || args[0] != "verify" {
+		fmt.Fprintln(os.Stderr, "usage: blog-api audit verify [-config file] [-audit_file path]")
+		return 2
+	}
+
+	cfg, err := config.Load("blog_api audit verify", args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	auditFile := cfg.DataPath(cfg.AuditFile)
+
+	file, err := os.Open(auditFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer file.Close()
+
+	entries, last, err := internal.VerifyAuditLog(file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("%s: %d entries verified, last hash %s\n", auditFile, entries, last)
+	return 0
+}
@@ -2,31 +2,85 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/honeycombio/beeline-go/wrappers/hnygorilla"
+	"rakia.ai/blog-api/v2/config"
 	"rakia.ai/blog-api/v2/internal"
 	"rakia.ai/blog-api/v2/server"
 )
 
+// Set at build time with -ldflags "-X main.commit=... -X main.buildTime=..."
+var (
+	commit    string
+	buildTime string
+)
+
 func main() {
-	fs := flag.NewFlagSet("blog_api", flag.ExitOnError)
+	// Subcommands run instead of the server
+	if len(os.Args) > 1 && os.Args[1] == "audit" {
+		os.Exit(auditCommand(os.Args[2:]))
+	}
 
-	var (
-		listenPort = fs.String("port", "8080", "port to listen on")
-		wait       = fs.Duration("graceful_timeout", time.Second*15, "the duration for which the server gracefully wait for existing connections to finish - e.g. 15s or 1m")
-	)
+	// The config subcommand prints the effective configuration instead of starting the server
+	printConfig := len(os.Args) > 1 && os.Args[1] == "config"
+	args := os.Args[1:]
+	if printConfig {
+		args = os.Args[2:]
+	}
 
-	fs.Parse(os.Args[1:])
+	cfg, err := config.Load("blog_api", args, os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if printConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 
 	// Logger for the server
-	logger := server.NewLogger()
+	logger, err := server.NewLogger(cfg.LogFormat, cfg.LogLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	// Tracing of requests and of the posts service
+	stopTracing, err := setupTracing(cfg.TraceExporter, cfg.TraceFile, cfg.TraceSampleRatio)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("error setting up tracing")
+	}
+
+	// Sign JWTs with the configured secret, the development key is only fit for trying the server out and
+	// Validate only lets it be used in development
+	jwtKey := []byte(cfg.JWTSecret)
+	if len(jwtKey) == 0 && cfg.Development {
+		logger.Warn().Msg("jwt_secret is not set, signing JWTs with the development key")
+		jwtKey = server.DevelopmentJWTKey
+	}
+	if err := server.ConfigureJWT(jwtKey, cfg.JWTExpiration); err != nil {
+		logger.Fatal().Err(err).Msg("error configuring jwt")
+	}
+
+	// The webhooks, the audit log, media and the links of identities are kept in the data directory
+	if err := os.MkdirAll(cfg.DataDir, 0o700); err != nil {
+		logger.Fatal().Err(err).Msg("error creating data directory")
+	}
 
 	// Initialize the author posts map
 	// This is a map of author names to a map of post IDs to posts
@@ -40,8 +94,85 @@ func main() {
 	}
 
 	logger.Info().Msg("seeding blog posts")
-	// Seed the blog posts
-	posts.Seed()
+	// Seed the blog posts, the server is not ready without them
+	if err := posts.Seed(cfg.SeedFile); err != nil {
+		logger.Error().Err(err).Msg("error seeding blog posts")
+	}
+
+	// Create a new event bus, post changes are streamed to /api/events
+	events, err := internal.NewEventBus(1000, 64, internal.SystemClock{}, logger)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("error creating event bus")
+	}
+	posts.PublishEvents(events)
+
+	// Create a new webhook service, it queues deliveries for the post changes on the event bus
+	logger.Info().Msg("creating webhook service")
+	webhooks, err := internal.NewWebhookService(internal.DefaultWebhookConfig, cfg.DataPath(cfg.WebhooksFile), nil, internal.SystemClock{}, logger)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("error creating webhook service")
+	}
+	hooksCtx, stopHooks := context.WithCancel(context.Background())
+	go webhooks.Listen(hooksCtx, events)
+	go webhooks.Run(hooksCtx, time.Second)
+
+	// Create a new lock service, only the holder of the edit lock of a post can update it
+	locks, err := internal.NewLockService(cfg.EditLockTTL, internal.SystemClock{}, logger)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("error creating lock service")
+	}
+	posts.UseLocks(locks)
+	posts.OnDelete(locks.DeletePostLock)
+
+	// Create a new media service, media no post references anymore are deleted after a grace period
+	logger.Info().Msg("creating media service")
+	blobs, err := internal.NewDiskBlobStore(cfg.DataPath(cfg.MediaDir))
+	if err != nil {
+		logger.Fatal().Err(err).Msg("error creating media store")
+	}
+	media, err := internal.NewMediaService(blobs, cfg.MediaMaxSize, cfg.DataPath(cfg.MediaIndex), internal.SystemClock{}, logger)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("error creating media service")
+	}
+	posts.UseMedia(media)
+	posts.OnDelete(media.DeletePostMedia)
+	mediaCtx, stopMedia := context.WithCancel(context.Background())
+	go media.Run(mediaCtx, time.Hour, cfg.MediaGCGrace)
+
+	// Thumbnails of uploaded images are made in the background, not while the upload waits
+	thumbnailer, err := internal.NewThumbnailer(media, internal.DefaultThumbnailWidths, 100, logger)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("error creating thumbnailer")
+	}
+	media.OnUpload(thumbnailer.Enqueue)
+	go thumbnailer.Run(mediaCtx, cfg.ThumbnailWorkers)
+
+	// Create a new comments service, comments are deleted together with their post
+	logger.Info().Msg("creating comments service")
+	comments, err := internal.NewCommentService(internal.SystemClock{}, logger)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("error creating comments service")
+	}
+	posts.OnDelete(comments.DeletePostComments)
+
+	// Create a new reactions service and view counter, views are flushed to the posts service in batches
+	logger.Info().Msg("creating reactions service")
+	reactions, err := internal.NewReactionService(logger)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("error creating reactions service")
+	}
+	posts.OnDelete(reactions.DeletePostReactions)
+	views, err := internal.NewViewCounter(context.Background(), posts, logger)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("error creating view counter")
+	}
+	posts.OnDelete(views.DeletePostViews)
+	viewsCtx, stopViews := context.WithCancel(context.Background())
+	viewsDone := make(chan struct{})
+	go func() {
+		views.Run(viewsCtx, cfg.ViewsFlushInterval)
+		close(viewsDone)
+	}()
 
 	a := make(internal.AuthorPassword)
 	// Create a new author service
@@ -52,7 +183,111 @@ func main() {
 	}
 
 	// Seed the authors
-	authors.Seed()
+	if err := authors.Seed(cfg.SeedFile); err != nil {
+		logger.Error().Err(err).Msg("error seeding authors")
+	}
+
+	// Posts of contributors are reviewed by an editor before they are published
+	posts.RequireReview(authors.NeedsReview)
+
+	// Create a new personal access token service
+	logger.Info().Msg("creating token service")
+	tokens, err := internal.NewTokenService(cfg.DataPath(cfg.TokensFile), logger)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("error creating token service")
+	}
+
+	// Create a new login guard against password guessing
+	guard, err := internal.NewLoginGuard(internal.DefaultLoginGuardConfig, internal.SystemClock{}, logger)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("error creating login guard")
+	}
+
+	// Create a new two-factor authentication service
+	twoFactor, err := internal.NewTwoFactorService(cfg.DataPath(cfg.TwoFactorFile), internal.SystemClock{}, logger)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("error creating two-factor authentication service")
+	}
+
+	// Open the audit log, the server refuses to start when it has been tampered with
+	auditLog, err := internal.NewAuditLog(cfg.DataPath(cfg.AuditFile), internal.SystemClock{}, logger)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("error opening audit log")
+	}
+
+	// Create a new mailer, without an SMTP server mails are written to a file or the log
+	var mailer internal.Mailer
+	switch {
+	case cfg.SMTPAddr != "":
+		mailer, err = internal.NewSMTPMailer(cfg.SMTPAddr, cfg.SMTPFrom, cfg.SMTPUsername, cfg.SMTPPassword)
+	case cfg.MailFile != "":
+		mailer, err = internal.NewFileMailer(cfg.MailFile)
+	default:
+		mailer = internal.NewLogMailer(os.Stderr)
+	}
+	if err != nil {
+		logger.Fatal().Err(err).Msg("error creating mailer")
+	}
+
+	// Create a new password reset service, reset tokens are valid for an hour
+	resets, err := internal.NewPasswordResetService(authors, mailer, internal.SystemClock{}, time.Hour, cfg.ResetURL, logger)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("error creating password reset service")
+	}
+	// Reset mails are sent in the background, so the request is answered right away
+	mailCtx, stopMails := context.WithCancel(context.Background())
+	go resets.Run(mailCtx)
+
+	// Create a new OpenID Connect login when an identity provider is configured
+	authenticators := make(map[string]server.Authenticator)
+	if cfg.OIDCIssuer != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		provider, err := internal.NewOIDCProvider(ctx, internal.OIDCConfig{
+			Issuer:        cfg.OIDCIssuer,
+			ClientID:      cfg.OIDCClientID,
+			ClientSecret:  cfg.OIDCClientSecret,
+			RedirectURL:   cfg.OIDCRedirectURL,
+			Scopes:        strings.Fields(cfg.OIDCScopes),
+			AutoProvision: cfg.OIDCAutoProvision,
+		}, cfg.DataPath(cfg.OIDCLinksFile), authors, nil, logger)
+		cancel()
+		if err != nil {
+			logger.Fatal().Err(err).Msg("error creating oidc login")
+		}
+		authenticators["oidc"] = server.NewOIDCAuthenticator(provider)
+	}
+
+	// Rate limit anonymous readers of the public API, logins and changes, the limits share one store
+	limits := internal.NewMemoryRateLimitStore()
+	newLimiter := func(name string, perMinute int) server.RateLimiter {
+		if perMinute <= 0 {
+			return nil
+		}
+		limiter, err := internal.NewRateLimiter(name, perMinute, time.Minute, limits, internal.SystemClock{})
+		if err != nil {
+			logger.Fatal().Err(err).Str("limit", name).Msg("error creating rate limiter")
+		}
+		return limiter
+	}
+	trustedProxies, err := internal.ParseTrustedProxies(strings.Join(cfg.TrustedProxies, ","))
+	if err != nil {
+		logger.Fatal().Err(err).Msg("error parsing trusted proxies")
+	}
+
+	// Let the browser-based editor call the API from its own origin
+	var cors *server.CORS
+	if len(cfg.CORSOrigins) > 0 {
+		cors, err = server.NewCORS(server.CORSConfig{
+			Origins:     cfg.CORSOrigins,
+			Methods:     cfg.CORSMethods,
+			Headers:     cfg.CORSHeaders,
+			Credentials: cfg.CORSCredentials,
+			MaxAge:      cfg.CORSMaxAge,
+		})
+		if err != nil {
+			logger.Fatal().Err(err).Msg("error configuring cors")
+		}
+	}
 
 	// Create a new mux router
 	router := mux.NewRouter()
@@ -60,20 +295,52 @@ func main() {
 	// Create a new server
 	logger.Info().Msg("creating server")
 	s := server.NewServer(router, posts, authors, logger)
+	s.TokensService = tokens
+	s.LoginGuard = guard
+	s.TwoFactorService = twoFactor
+	s.PasswordResets = resets
+	s.CommentsService = comments
+	s.ReactionsService = reactions
+	s.ViewCounter = views
+	s.Authenticators = authenticators
+	s.BaseURL = strings.TrimSuffix(cfg.BaseURL, "/")
+	s.Development = cfg.Development
+	s.PublicLimiter = newLimiter("public", cfg.PublicRateLimit)
+	s.LoginLimiter = newLimiter("login", cfg.LoginRateLimit)
+	s.WriteLimiter = newLimiter("write", cfg.WriteRateLimit)
+	s.TrustedProxies = trustedProxies
+	s.CORS = cors
+	s.Events = events
+	s.WebhooksService = webhooks
+	s.AuditLog = auditLog
+	s.ReviewService = posts
+	s.Changes = posts
+	s.Locks = locks
+	s.MediaService = media
+	if cfg.Metrics {
+		s.Metrics = server.NewMetrics(posts)
+	}
+	s.Version = server.NewBuildInfo(commit, buildTime)
+
+	// The subsystems which have to work for the server to be ready
+	s.Health = server.NewHealth()
+	s.Health.Register("posts", posts)
+	s.Health.Register("media", blobs)
+	s.Health.Register("audit", auditLog)
 
 	s.Routes()
-	s.Router.Use(hnygorilla.Middleware)
+	s.Router.Use(server.Tracing)
 
 	// Create a new server
 	srv := &http.Server{
-		Addr:         ":" + *listenPort,
-		WriteTimeout: time.Second * 15,
-		ReadTimeout:  time.Second * 15,
-		IdleTimeout:  time.Second * 60,
+		Addr:         ":" + cfg.Port,
+		WriteTimeout: cfg.WriteTimeout,
+		ReadTimeout:  cfg.ReadTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
 		Handler:      s.Router,
 	}
 
-	logger.Info().Msgf("starting server on port: %s", *listenPort)
+	logger.Info().Msgf("starting server on port: %s", cfg.Port)
 	go func() {
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			logger.Err(err).Msg("ListenAndServe failed")
@@ -85,13 +352,35 @@ func main() {
 
 	<-c // Wait for interrupt signal to gracefully shutdown the server
 
-	ctx, cancel := context.WithTimeout(context.Background(), *wait)
+	// Stop being ready right away and keep serving until the load balancers have noticed, so no new requests
+	// are sent while the open ones finish
+	s.Health.ShuttingDown()
+	logger.Info().Dur("delay", cfg.ShutdownDelay).Msg("draining before shutdown")
+	time.Sleep(cfg.ShutdownDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.GracefulTimeout)
 	defer cancel()
 
 	logger.Info().Msg("shutting down server")
 	if err := srv.Shutdown(ctx); err != nil {
 		logger.Err(err).Msg("server shutdown failed")
 	}
+	// Stop collecting unreferenced media and making thumbnails
+	stopMedia()
+	// Stop sending webhooks, the queue is picked up again on the next start
+	stopHooks()
+	// Stop sending reset mails, their tokens expire unused
+	stopMails()
+	// Flush the views counted since the last flush
+	stopViews()
+	<-viewsDone
+	if err := auditLog.Close(); err != nil {
+		logger.Err(err).Msg("error closing audit log")
+	}
+	// Send the spans which are still buffered
+	if err := stopTracing(ctx); err != nil {
+		logger.Err(err).Msg("error flushing traces")
+	}
 	logger.Info().Msg("server exited properly")
 	os.Exit(0)
 }
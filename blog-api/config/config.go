@@ -0,0 +1,418 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"gopkg.in/yaml.v3"
+	"rakia.ai/blog-api/v2/internal"
+)
+
+// Prefix of the environment variables, e.g. BLOG_API_PORT sets port
+const EnvPrefix = "BLOG_API_"
+
+// Shown instead of the value of secrets
+const redacted = "[redacted]"
+
+// Config is the configuration of the server. Every setting has a key in the config file, an environment variable
+// named EnvPrefix and the key in upper case, and a flag named like the key. Secrets have no flag, since the
+// arguments of a process can be seen by other users
+type Config struct {
+	Port            string        `yaml:"port" usage:"port to listen on"`
+	GracefulTimeout time.Duration `yaml:"graceful_timeout" usage:"the duration for which the server gracefully wait for existing connections to finish - e.g. 15s or 1m"`
+	ShutdownDelay   time.Duration `yaml:"shutdown_delay" usage:"how long the server keeps serving while unready before it stops accepting connections, so load balancers notice it is going away"`
+	ReadTimeout     time.Duration `yaml:"read_timeout" usage:"longest time to read a request, including its body"`
+	WriteTimeout    time.Duration `yaml:"write_timeout" usage:"longest time to write a response, counted from the end of reading the request headers"`
+	IdleTimeout     time.Duration `yaml:"idle_timeout" usage:"how long an idle keep-alive connection is kept open"`
+	SeedFile        string        `yaml:"seed_file" usage:"JSON file the authors and posts are seeded from"`
+
+	JWTSecret     string        `yaml:"jwt_secret" secret:"true" usage:"key JWTs are signed with, it must be set unless development is on"`
+	JWTExpiration time.Duration `yaml:"jwt_expiration" usage:"how long a JWT from a login is valid"`
+	Development   bool          `yaml:"development" usage:"sign JWTs with the public development key when jwt_secret is empty and take links from the Host header when base_url is empty, never turn it on in production"`
+
+	LogFormat string `yaml:"log_format" usage:"format of the log, console or json"`
+	LogLevel  string `yaml:"log_level" usage:"lowest level which is logged: trace, debug, info, warn, error, fatal or panic"`
+
+	SMTPAddr     string `yaml:"smtp_addr" usage:"host:port of the SMTP server used to send mails, when empty mails are written to -mail_file"`
+	SMTPFrom     string `yaml:"smtp_from" usage:"sender address of mails"`
+	SMTPUsername string `yaml:"smtp_username" usage:"username for the SMTP server"`
+	SMTPPassword string `yaml:"smtp_password" secret:"true" usage:"password for the SMTP server"`
+	MailFile     string `yaml:"mail_file" usage:"file mails are written to when no SMTP server is set, defaults to the log"`
+	ResetURL     string `yaml:"password_reset_url" usage:"page the password reset token is appended to in the mail"`
+
+	OIDCIssuer        string `yaml:"oidc_issuer" usage:"issuer URL of the OpenID Connect identity provider, login through it is off when empty"`
+	OIDCClientID      string `yaml:"oidc_client_id" usage:"client ID at the identity provider"`
+	OIDCClientSecret  string `yaml:"oidc_client_secret" secret:"true" usage:"client secret at the identity provider, empty for public clients"`
+	OIDCRedirectURL   string `yaml:"oidc_redirect_url" usage:"URL of /login/oidc/callback as registered at the identity provider"`
+	OIDCScopes        string `yaml:"oidc_scopes" usage:"scopes requested besides openid"`
+	OIDCAutoProvision bool   `yaml:"oidc_auto_provision" usage:"create an author for identities which do not match an existing author"`
+	OIDCLinksFile     string `yaml:"oidc_links_file" usage:"file the links between identities and authors are kept in, in memory only when empty"`
+
+	BaseURL         string   `yaml:"base_url" usage:"public URL of the server used for links in feeds and to media, e.g. https://blog.example.com, required unless development is on"`
+	PublicRateLimit int      `yaml:"public_rate_limit" usage:"requests per minute an IP address can make to the public API without logging in, 0 turns the limit off"`
+	LoginRateLimit  int      `yaml:"login_rate_limit" usage:"logins and password resets per minute an IP address can make, 0 turns the limit off"`
+	WriteRateLimit  int      `yaml:"write_rate_limit" usage:"changes per minute an author can make through the API, 0 turns the limit off"`
+	TrustedProxies  []string `yaml:"trusted_proxies" usage:"comma separated IP addresses and CIDR ranges of reverse proxies whose X-Forwarded-For header is believed"`
+
+	CORSOrigins     []string      `yaml:"cors_origins" usage:"comma separated origins browsers may call the API from, e.g. https://editor.example.com or https://*.example.com, CORS is off when empty"`
+	CORSMethods     []string      `yaml:"cors_methods" usage:"comma separated methods other origins may use, all methods of a route when empty"`
+	CORSHeaders     []string      `yaml:"cors_headers" usage:"comma separated request headers other origins may send, * for any"`
+	CORSCredentials bool          `yaml:"cors_credentials" usage:"let browsers send cookies and client certificates to the API from other origins"`
+	CORSMaxAge      time.Duration `yaml:"cors_max_age" usage:"how long browsers cache the answer to a preflight"`
+
+	DataDir            string        `yaml:"data_dir" usage:"directory the state of the server is kept in, relative paths of webhooks_file, tokens_file, two_factor_file, audit_file, media_dir, media_index and oidc_links_file are inside it"`
+	WebhooksFile       string        `yaml:"webhooks_file" usage:"file the webhooks and their delivery queue are kept in, in memory only when empty"`
+	TokensFile         string        `yaml:"tokens_file" usage:"file the hashes of personal access tokens are kept in, in memory only when empty"`
+	TwoFactorFile      string        `yaml:"two_factor_file" usage:"file the two-factor authentication secrets and used recovery codes are kept in, in memory only when empty"`
+	AuditFile          string        `yaml:"audit_file" usage:"file the tamper-evident audit log of post changes and logins is appended to, in memory only when empty"`
+	EditLockTTL        time.Duration `yaml:"edit_lock_ttl" usage:"how long an edit lock on a post lasts unless its holder renews it"`
+	MediaDir           string        `yaml:"media_dir" usage:"directory uploaded media are stored in"`
+	MediaIndex         string        `yaml:"media_index" usage:"file the metadata of uploaded media is kept in, outside of media_dir, in memory only when empty"`
+	MediaMaxSize       int64         `yaml:"media_max_size" usage:"largest media upload in bytes"`
+	MediaGCGrace       time.Duration `yaml:"media_gc_grace" usage:"how long media no post references are kept before they are deleted"`
+	ThumbnailWorkers   int           `yaml:"thumbnail_workers" usage:"number of images thumbnails are made of at the same time"`
+	ViewsFlushInterval time.Duration `yaml:"views_flush_interval" usage:"how often post views counted in memory are flushed to storage"`
+
+	Metrics          bool    `yaml:"metrics" usage:"serve Prometheus metrics on /metrics"`
+	TraceExporter    string  `yaml:"trace_exporter" usage:"where OpenTelemetry spans are sent: none, stdout, file or otlp, the OTLP endpoint is read from OTEL_EXPORTER_OTLP_ENDPOINT"`
+	TraceFile        string  `yaml:"trace_file" usage:"file spans are appended to with the file exporter"`
+	TraceSampleRatio float64 `yaml:"trace_sample_ratio" usage:"share of requests which are traced, unless the caller's trace is sampled"`
+}
+
+// DefaultCORSHeaders are the request headers the API reads
+var DefaultCORSHeaders = []string{"Authorization", "Content-Type", "Last-Event-ID", "X-Request-ID"}
+
+// Default returns the configuration used for every setting which is not set otherwise
+func Default() Config {
+	return Config{
+		Port:            "8080",
+		GracefulTimeout: time.Second * 15,
+		ShutdownDelay:   time.Second * 5,
+		ReadTimeout:     time.Second * 15,
+		WriteTimeout:    time.Second * 15,
+		IdleTimeout:     time.Second * 60,
+		SeedFile:        internal.FILEPATH,
+
+		JWTExpiration: time.Minute * 30,
+
+		LogFormat: "console",
+		LogLevel:  "info",
+
+		SMTPFrom:      "blog@localhost",
+		OIDCScopes:    "email profile",
+		OIDCLinksFile: "oidc_links.json",
+
+		PublicRateLimit: 60,
+		LoginRateLimit:  10,
+		WriteRateLimit:  60,
+
+		CORSHeaders: append([]string(nil), DefaultCORSHeaders...),
+		CORSMaxAge:  time.Minute * 10,
+
+		DataDir:            "data",
+		WebhooksFile:       "webhooks.json",
+		TokensFile:         "tokens.json",
+		TwoFactorFile:      "two_factor.json",
+		AuditFile:          "audit.log",
+		EditLockTTL:        time.Minute * 2,
+		MediaDir:           "media",
+		MediaIndex:         "media.json",
+		MediaMaxSize:       10 << 20,
+		MediaGCGrace:       time.Hour * 24,
+		ThumbnailWorkers:   runtime.NumCPU(),
+		ViewsFlushInterval: time.Second * 10,
+
+		Metrics:          true,
+		TraceExporter:    "none",
+		TraceFile:        "traces.json",
+		TraceSampleRatio: 1,
+	}
+}
+
+// setting is a field of Config with its key
+type setting struct {
+	key    string
+	usage  string
+	secret bool
+	index  int
+}
+
+// settings returns the settings of Config in the order they are declared
+func settings() []setting {
+	t := reflect.TypeOf(Config{})
+	result := make([]setting, t.NumField())
+	for i := range result {
+		field := t.Field(i)
+		result[i] = setting{
+			key:    field.Tag.Get("yaml"),
+			usage:  field.Tag.Get("usage"),
+			secret: field.Tag.Get("secret") == "true",
+			index:  i,
+		}
+	}
+	return result
+}
+
+// Load merges the configuration, each source overriding the one before: the defaults, the config file, the
+// environment and the flags. The config file is named by the -config flag or BLOG_API_CONFIG, it is YAML or JSON.
+// lookupEnv is os.LookupEnv outside of tests
+func Load(name string, args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	config := Default()
+
+	// The flags are parsed into their own config, so only the flags which were set override the other sources
+	flags := Default()
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	file := fs.String("config", "", "YAML or JSON config file, read from "+EnvPrefix+"CONFIG when not set")
+	for _, s := range settings() {
+		if s.secret {
+			continue
+		}
+		usage := s.usage + " (env " + envName(s.key) + ")"
+		// Typed flags show their type and default in the usage
+		switch field := reflect.ValueOf(&flags).Elem().Field(s.index).Addr().Interface().(type) {
+		case *string:
+			fs.StringVar(field, s.key, *field, usage)
+		case *bool:
+			fs.BoolVar(field, s.key, *field, usage)
+		case *int:
+			fs.IntVar(field, s.key, *field, usage)
+		case *int64:
+			fs.Int64Var(field, s.key, *field, usage)
+		case *float64:
+			fs.Float64Var(field, s.key, *field, usage)
+		case *time.Duration:
+			fs.DurationVar(field, s.key, *field, usage)
+		default:
+			fs.Var(&value{reflect.ValueOf(field).Elem()}, s.key, usage)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *file == "" {
+		*file, _ = lookupEnv(EnvPrefix + "CONFIG")
+	}
+	if *file != "" {
+		if err := config.readFile(*file); err != nil {
+			return nil, err
+		}
+	}
+
+	fields := reflect.ValueOf(&config).Elem()
+	for _, s := range settings() {
+		env, ok := lookupEnv(envName(s.key))
+		if !ok {
+			continue
+		}
+		if err := (&value{fields.Field(s.index)}).Set(env); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", envName(s.key), err)
+		}
+	}
+
+	byKey := make(map[string]setting)
+	for _, s := range settings() {
+		byKey[s.key] = s
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if s, ok := byKey[f.Name]; ok {
+			fields.Field(s.index).Set(reflect.ValueOf(flags).Field(s.index))
+		}
+	})
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// DataPath returns the path of a state file or directory setting, relative paths are inside data_dir and an
+// empty path stays empty
+func (c *Config) DataPath(path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(c.DataDir, path)
+}
+
+// envName returns the environment variable of the key
+func envName(key string) string {
+	return EnvPrefix + strings.ToUpper(key)
+}
+
+// readFile reads the config file over the config, unknown keys are an error so typos do not go unnoticed
+func (c *Config) readFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading config file: %w", err)
+	}
+	// JSON is YAML too
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && err != io.EOF {
+		return fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate checks every setting and returns all invalid ones
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	port, err := strconv.Atoi(c.Port)
+	check(err == nil && port > 0 && port < 65536, "port must be a number from 1 to 65535")
+	check(c.GracefulTimeout > 0, "graceful_timeout must be positive")
+	check(c.ShutdownDelay >= 0, "shutdown_delay must not be negative")
+	check(c.ReadTimeout > 0, "read_timeout must be positive")
+	check(c.WriteTimeout >= 0, "write_timeout must not be negative")
+	check(c.IdleTimeout > 0, "idle_timeout must be positive")
+	check(c.SeedFile != "", "seed_file must be set")
+	check(c.JWTExpiration > 0, "jwt_expiration must be positive")
+	check(c.JWTSecret != "" || c.Development, "jwt_secret must be set, only development signs JWTs with the development key")
+	check(c.JWTSecret == "" || len(c.JWTSecret) >= 32, "jwt_secret must be at least 32 characters")
+
+	check(c.LogFormat == "console" || c.LogFormat == "json", "log_format must be console or json")
+	_, err = zerolog.ParseLevel(c.LogLevel)
+	check(err == nil && c.LogLevel != "", "log_level %q is not a level", c.LogLevel)
+
+	check(c.BaseURL != "" || c.Development, "base_url must be set, only development takes links from the request")
+	if c.BaseURL != "" {
+		base, err := url.Parse(c.BaseURL)
+		check(err == nil && (base.Scheme == "http" || base.Scheme == "https") && base.Host != "",
+			"base_url must be an http or https URL")
+	}
+
+	check(c.OIDCIssuer == "" || (c.OIDCClientID != "" && c.OIDCRedirectURL != ""),
+		"oidc_client_id and oidc_redirect_url must be set with oidc_issuer")
+
+	check(c.PublicRateLimit >= 0, "public_rate_limit must not be negative")
+	check(c.LoginRateLimit >= 0, "login_rate_limit must not be negative")
+	check(c.WriteRateLimit >= 0, "write_rate_limit must not be negative")
+	if _, err := internal.ParseTrustedProxies(strings.Join(c.TrustedProxies, ",")); err != nil {
+		errs = append(errs, fmt.Errorf("trusted_proxies: %w", err))
+	}
+	check(c.CORSMaxAge >= 0, "cors_max_age must not be negative")
+
+	check(c.DataDir != "", "data_dir must be set")
+	check(c.EditLockTTL > 0, "edit_lock_ttl must be positive")
+	check(c.MediaDir != "", "media_dir must be set")
+	check(c.MediaMaxSize > 0, "media_max_size must be positive")
+	check(c.MediaGCGrace > 0, "media_gc_grace must be positive")
+	check(c.ThumbnailWorkers > 0, "thumbnail_workers must be at least 1")
+	check(c.ViewsFlushInterval > 0, "views_flush_interval must be positive")
+
+	switch c.TraceExporter {
+	case "none", "stdout", "file", "otlp":
+	default:
+		check(false, "trace_exporter must be none, stdout, file or otlp")
+	}
+	check(c.TraceExporter != "file" || c.TraceFile != "", "trace_file must be set with the file exporter")
+	check(c.TraceSampleRatio >= 0 && c.TraceSampleRatio <= 1, "trace_sample_ratio must be from 0 to 1")
+
+	// A printed config was used as a config file
+	fields := reflect.ValueOf(c).Elem()
+	for _, s := range settings() {
+		check(!s.secret || fields.Field(s.index).String() != redacted, "%s is %s, set the secret itself", s.key, redacted)
+	}
+
+	return errors.Join(errs...)
+}
+
+// Redacted returns a copy of the config with the secrets which are set replaced
+func (c Config) Redacted() Config {
+	fields := reflect.ValueOf(&c).Elem()
+	for _, s := range settings() {
+		if s.secret && fields.Field(s.index).String() != "" {
+			fields.Field(s.index).SetString(redacted)
+		}
+	}
+	return c
+}
+
+// Print writes the config as YAML with the secrets redacted, it can be used as a config file
+func (c *Config) Print(w io.Writer) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(c.Redacted()); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// value sets a field of the config from an environment variable, and is the flag of comma separated lists
+type value struct {
+	field reflect.Value
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func (v *value) String() string {
+	if !v.field.IsValid() || v.field.Kind() != reflect.Slice {
+		return ""
+	}
+	return strings.Join(v.field.Interface().([]string), ",")
+}
+
+func (v *value) Set(text string) error {
+	switch {
+	case v.field.Type() == durationType:
+		d, err := time.ParseDuration(text)
+		if err != nil {
+			return err
+		}
+		v.field.SetInt(int64(d))
+	case v.field.Kind() == reflect.String:
+		v.field.SetString(text)
+	case v.field.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return err
+		}
+		v.field.SetBool(b)
+	case v.field.Kind() == reflect.Int, v.field.Kind() == reflect.Int64:
+		i, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.field.SetInt(i)
+	case v.field.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return err
+		}
+		v.field.SetFloat(f)
+	case v.field.Kind() == reflect.Slice:
+		v.field.Set(reflect.ValueOf(splitList(text)))
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.field.Type())
+	}
+	return nil
+}
+
+// splitList splits a comma separated list, leaving out empty entries
+func splitList(list string) []string {
+	var result []string
+	for _, entry := range strings.Split(list, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
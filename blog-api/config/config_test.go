@@ -0,0 +1,179 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// env returns a lookupEnv reading from the map
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := vars[key]
+		return value, ok
+	}
+}
+
+func writeFile(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	// Without a jwt_secret the server only starts in development
+	_, err := Load("test", nil, env(nil))
+	assert.ErrorContains(t, err, "jwt_secret")
+
+	config, err := Load("test", []string{"-development"}, env(nil))
+	assert.NoError(t, err)
+	want := Default()
+	want.Development = true
+	assert.Equal(t, want, *config)
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeFile(t, "config.yaml", `
+port: "9000"
+log_level: debug
+read_timeout: 30s
+cors_origins: [https://editor.example.com]
+media_max_size: 1024
+base_url: https://blog.example.com
+`)
+
+	config, err := Load("test", []string{"-config", file, "-log_level", "warn"}, env(map[string]string{
+		"BLOG_API_PORT":         "9001",
+		"BLOG_API_LOG_LEVEL":    "error",
+		"BLOG_API_METRICS":      "false",
+		"BLOG_API_CORS_METHODS": "GET, POST,",
+		"BLOG_API_JWT_SECRET":   "a secret of at least thirty-two characters",
+	}))
+	assert.NoError(t, err)
+
+	// The flag beats the environment, which beats the file, which beats the defaults
+	assert.Equal(t, "warn", config.LogLevel)
+	assert.Equal(t, "9001", config.Port)
+	assert.Equal(t, time.Second*30, config.ReadTimeout)
+	assert.Equal(t, []string{"https://editor.example.com"}, config.CORSOrigins)
+	assert.Equal(t, []string{"GET", "POST"}, config.CORSMethods)
+	assert.Equal(t, int64(1024), config.MediaMaxSize)
+	assert.False(t, config.Metrics)
+	assert.Equal(t, Default().WriteTimeout, config.WriteTimeout)
+}
+
+func TestLoadFlagsOverrideOnlyWhenSet(t *testing.T) {
+	// The default of a flag does not override the environment
+	config, err := Load("test", []string{"-log_format", "json", "-development"}, env(map[string]string{
+		"BLOG_API_GRACEFUL_TIMEOUT": "1m",
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, time.Minute, config.GracefulTimeout)
+	assert.Equal(t, "json", config.LogFormat)
+}
+
+func TestLoadJSONFileFromEnvironment(t *testing.T) {
+	file := writeFile(t, "config.json", `{"port": "9000", "trusted_proxies": ["10.0.0.0/8"], "trace_sample_ratio": 0.5, "development": true}`)
+
+	config, err := Load("test", nil, env(map[string]string{"BLOG_API_CONFIG": file}))
+	assert.NoError(t, err)
+	assert.Equal(t, "9000", config.Port)
+	assert.Equal(t, []string{"10.0.0.0/8"}, config.TrustedProxies)
+	assert.Equal(t, 0.5, config.TraceSampleRatio)
+}
+
+func TestLoadSecrets(t *testing.T) {
+	config, err := Load("test", nil, env(map[string]string{"BLOG_API_SMTP_PASSWORD": "hunter2", "BLOG_API_DEVELOPMENT": "true"}))
+	assert.NoError(t, err)
+	assert.Equal(t, "hunter2", config.SMTPPassword)
+
+	// Secrets cannot be passed as flags, other users can see the arguments of a process
+	_, err = Load("test", []string{"-smtp_password", "hunter2"}, env(nil))
+	assert.Error(t, err)
+}
+
+func TestLoadErrors(t *testing.T) {
+	_, err := Load("test", nil, env(map[string]string{"BLOG_API_READ_TIMEOUT": "soon"}))
+	assert.ErrorContains(t, err, "BLOG_API_READ_TIMEOUT")
+
+	_, err = Load("test", []string{"-config", writeFile(t, "config.yaml", "prot: 8080\n")}, env(nil))
+	assert.ErrorContains(t, err, "prot")
+
+	_, err = Load("test", []string{"-config", filepath.Join(t.TempDir(), "missing.yaml")}, env(nil))
+	assert.Error(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	config := Default()
+	config.JWTSecret = "a secret of at least thirty-two characters"
+	config.BaseURL = "https://blog.example.com"
+	assert.NoError(t, config.Validate())
+
+	config.Port = "http"
+	config.LogLevel = "loud"
+	config.TraceSampleRatio = 2
+	config.TrustedProxies = []string{"proxy"}
+	config.JWTSecret = "short"
+	config.BaseURL = "blog.example.com"
+	err := config.Validate()
+
+	// Every invalid setting is reported at once
+	assert.ErrorContains(t, err, "port")
+	assert.ErrorContains(t, err, "log_level")
+	assert.ErrorContains(t, err, "trace_sample_ratio")
+	assert.ErrorContains(t, err, "trusted_proxies")
+	assert.ErrorContains(t, err, "jwt_secret")
+	assert.ErrorContains(t, err, "base_url")
+
+	// Outside of development links are not taken from the request
+	config = Default()
+	config.JWTSecret = "a secret of at least thirty-two characters"
+	assert.ErrorContains(t, config.Validate(), "base_url must be set")
+}
+
+func TestPrintRedactsSecrets(t *testing.T) {
+	config := Default()
+	config.JWTSecret = "a secret of at least thirty-two characters"
+	config.OIDCClientSecret = "client secret"
+
+	var out bytes.Buffer
+	assert.NoError(t, config.Print(&out))
+	assert.NotContains(t, out.String(), "thirty-two")
+	assert.NotContains(t, out.String(), "client secret")
+	assert.Contains(t, out.String(), "jwt_secret: '[redacted]'")
+	assert.Contains(t, out.String(), `smtp_password: ""`)
+	assert.Contains(t, out.String(), "graceful_timeout: 15s")
+
+	assert.Equal(t, "a secret of at least thirty-two characters", config.JWTSecret)
+}
+
+func TestPrintReadBack(t *testing.T) {
+	config := Default()
+	config.CORSOrigins = []string{"https://editor.example.com"}
+	config.Development = true
+
+	var out bytes.Buffer
+	assert.NoError(t, config.Print(&out))
+
+	// Without secrets the printed config can be used as a config file
+	printed, err := Load("test", []string{"-config", writeFile(t, "config.yaml", out.String())}, env(nil))
+	assert.NoError(t, err)
+	assert.Equal(t, config.CORSOrigins, printed.CORSOrigins)
+	assert.Equal(t, config.CORSHeaders, printed.CORSHeaders)
+	assert.Equal(t, config.IdleTimeout, printed.IdleTimeout)
+	assert.Equal(t, config.TraceSampleRatio, printed.TraceSampleRatio)
+	assert.Empty(t, printed.JWTSecret)
+}
+
+func TestDataPath(t *testing.T) {
+	config := Default()
+	config.DataDir = "/var/lib/blog-api"
+
+	assert.Equal(t, "/var/lib/blog-api/webhooks.json", config.DataPath(config.WebhooksFile))
+	assert.Equal(t, "/srv/audit.log", config.DataPath("/srv/audit.log"))
+	assert.Empty(t, config.DataPath(""))
+}
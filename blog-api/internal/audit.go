@@ -0,0 +1,288 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Actions recorded in the audit log
+const (
+	AuditPostCreate     = "post.create"
+	AuditPostUpdate     = "post.update"
+	AuditPostDelete     = "post.delete"
+	AuditPostSubmit     = "post.submit"
+	AuditPostApprove    = "post.approve"
+	AuditPostReject     = "post.reject"
+	AuditAuthorDelete   = "author.delete"
+	AuditLoginSucceeded = "login.succeeded"
+	AuditLoginFailed    = "login.failed"
+	AuditLoginLocked    = "login.locked"
+	AuditLoginChallenge = "login.challenged"
+	AuditLoginUnlocked  = "login.unlocked"
+)
+
+// Previous hash of the first entry
+const auditGenesisHash = "0000000000000000000000000000000000000000000000000000000000000000"
+
+// Number of entries returned by a query unless it asks for fewer
+const auditQueryLimit = 100
+
+var ErrAuditTampered = fmt.Errorf("audit log has been tampered with")
+
+// AuditEntry records who did what, each entry holds the hash of the entry before it so changing,
+// removing or reordering entries breaks the chain
+type AuditEntry struct {
+	Seq        int64     `json:"seq"`
+	Time       time.Time `json:"time"`
+	Actor      string    `json:"actor"`
+	Action     string    `json:"action"`
+	PostID     int       `json:"post_id,omitempty"`
+	Target     string    `json:"target,omitempty"`
+	BeforeHash string    `json:"before_hash,omitempty"`
+	AfterHash  string    `json:"after_hash,omitempty"`
+	IP         string    `json:"ip,omitempty"`
+	RequestID  string    `json:"request_id,omitempty"`
+	PrevHash   string    `json:"prev_hash"`
+	Hash       string    `json:"hash"`
+}
+
+// AuditFilter selects audit entries, empty fields match everything
+type AuditFilter struct {
+	Actor  string
+	Action string
+	PostID int
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+// hash returns the hash of the entry, which covers every field but the hash itself
+func (e AuditEntry) hash() string {
+	e.Hash = ""
+	data, _ := json.Marshal(e)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// HashPost returns a hash of the post to record its state before and after a change, nil has no hash
+func HashPost(post *Post) string {
+	if post == nil {
+		return ""
+	}
+	data, _ := json.Marshal(post)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// AuditLog appends the entries to a file with one JSON entry per line, and keeps them in memory for queries
+type AuditLog struct {
+	entries []AuditEntry
+	file    *os.File
+	clock   Clock
+	failed  error      // Error of the last write, until a write works again
+	mutex   sync.Mutex // Protects access to entries, file and failed
+	logger  *zerolog.Logger
+}
+
+// NewAuditLog opens the audit log at path and verifies the entries already in it. A last line cut off by
+// a crash while it was appended is logged and removed, a broken chain is an error.
+// With an empty path the log is only kept in memory
+func NewAuditLog(path string, clock Clock, logger *zerolog.Logger) (*AuditLog, error) {
+	if clock == nil {
+		clock = SystemClock{}
+	}
+	a := &AuditLog{clock: clock, logger: logger}
+	if path == "" {
+		return a, nil
+	}
+
+	// Verify what is there before appending to it
+	existing, err := os.Open(path)
+	if err == nil {
+		var size int64
+		a.entries, size, err = readAuditLog(existing)
+		existing.Close()
+		if err != nil {
+			return nil, err
+		}
+		if err := a.dropTornLine(path, size); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	a.file, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// dropTornLine truncates the file at path to size, the end of its last whole entry, when a line follows it
+func (a *AuditLog) dropTornLine(path string, size int64) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Size() == size {
+		return nil
+	}
+	if a.logger != nil {
+		a.logger.Warn().Str("path", path).Int64("offset", size).Int64("bytes", info.Size()-size).
+			Msg("removing incomplete last line of audit log")
+	}
+	return os.Truncate(path, size)
+}
+
+// Record numbers the entry, chains it to the previous one and appends it to the log.
+// A failed write is reported by CheckHealth until a later write works
+func (a *AuditLog) Record(entry AuditEntry) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	entry.Seq = 1
+	entry.PrevHash = auditGenesisHash
+	if n := len(a.entries); n > 0 {
+		entry.Seq = a.entries[n-1].Seq + 1
+		entry.PrevHash = a.entries[n-1].Hash
+	}
+	entry.Time = a.clock.Now().UTC()
+	entry.Hash = entry.hash()
+
+	if a.file != nil {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		if _, err := a.file.Write(append(line, '\n')); err != nil {
+			a.failed = err
+			return err
+		}
+		if err := a.file.Sync(); err != nil {
+			a.failed = err
+			return err
+		}
+		a.failed = nil
+	}
+	a.entries = append(a.entries, entry)
+	return nil
+}
+
+// Query returns the entries matching the filter, newest first
+func (a *AuditLog) Query(filter AuditFilter) ([]AuditEntry, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	limit := filter.Limit
+	if limit <= 0 || limit > auditQueryLimit {
+		limit = auditQueryLimit
+	}
+	result := make([]AuditEntry, 0)
+	for i := len(a.entries) - 1; i >= 0 && len(result) < limit; i-- {
+		e := a.entries[i]
+		if filter.Actor != "" && e.Actor != filter.Actor {
+			continue
+		}
+		if filter.Action != "" && e.Action != filter.Action {
+			continue
+		}
+		if filter.PostID != 0 && e.PostID != filter.PostID {
+			continue
+		}
+		if !filter.Since.IsZero() && e.Time.Before(filter.Since) {
+			continue
+		}
+		if !filter.Until.IsZero() && e.Time.After(filter.Until) {
+			continue
+		}
+		result = append(result, e)
+	}
+	return result, nil
+}
+
+// CheckHealth fails once the file of the audit log is closed or cannot be accessed, or the last write failed
+func (a *AuditLog) CheckHealth(ctx context.Context) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.file == nil {
+		return nil
+	}
+	if a.failed != nil {
+		return fmt.Errorf("writing audit log: %w", a.failed)
+	}
+	_, err := a.file.Stat()
+	return err
+}
+
+// Close closes the file of the audit log
+func (a *AuditLog) Close() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.file == nil {
+		return nil
+	}
+	return a.file.Close()
+}
+
+// readAuditLog reads the entries and checks the chain, the error wraps ErrAuditTampered when it is broken.
+// A last line without a newline was cut off while it was appended and is not an entry, the returned size
+// is where the last whole entry ends
+func readAuditLog(r io.Reader) ([]AuditEntry, int64, error) {
+	var entries []AuditEntry
+	var size int64
+	prevHash := auditGenesisHash
+	reader := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return entries, size, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		var entry AuditEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return nil, 0, fmt.Errorf("%w: line %d is not an audit entry", ErrAuditTampered, line)
+		}
+		if entry.Seq != int64(line) {
+			return nil, 0, fmt.Errorf("%w: line %d has sequence number %d", ErrAuditTampered, line, entry.Seq)
+		}
+		if entry.PrevHash != prevHash {
+			return nil, 0, fmt.Errorf("%w: entry %d does not follow the entry before it", ErrAuditTampered, entry.Seq)
+		}
+		if entry.hash() != entry.Hash {
+			return nil, 0, fmt.Errorf("%w: entry %d has been changed", ErrAuditTampered, entry.Seq)
+		}
+		prevHash = entry.Hash
+		size += int64(len(data))
+		entries = append(entries, entry)
+	}
+}
+
+// VerifyAuditLog checks the chain of an audit log and returns the number of entries and the hash of the last one.
+// Keep the last hash somewhere else to also notice entries being cut off the end. An incomplete last line
+// is not counted, the server removes it when it starts
+func VerifyAuditLog(r io.Reader) (int, string, error) {
+	entries, _, err := readAuditLog(r)
+	if err != nil {
+		return 0, "", err
+	}
+	if len(entries) == 0 {
+		return 0, auditGenesisHash, nil
+	}
+	return len(entries), entries[len(entries)-1].Hash, nil
+}
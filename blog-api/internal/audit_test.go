@@ -0,0 +1,132 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditLogChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	audit, err := NewAuditLog(path, clock, nil)
+	assert.NoError(t, err)
+
+	post := &Post{ID: 1, Title: "Title 1", Author: "Author 1"}
+	assert.NoError(t, audit.Record(AuditEntry{Actor: "Author 1", Action: AuditPostCreate, PostID: 1, AfterHash: HashPost(post)}))
+	clock.Advance(time.Minute)
+	assert.NoError(t, audit.Record(AuditEntry{Actor: "Author 1", Action: AuditLoginSucceeded, IP: "127.0.0.1"}))
+	assert.NoError(t, audit.Close())
+
+	// Reopening continues the chain
+	audit, err = NewAuditLog(path, clock, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, audit.Record(AuditEntry{Actor: "admin", Action: AuditPostDelete, PostID: 1, BeforeHash: HashPost(post)}))
+	assert.NoError(t, audit.Close())
+
+	data, _ := os.ReadFile(path)
+	entries, last, err := VerifyAuditLog(bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, entries)
+	assert.NotEqual(t, auditGenesisHash, last)
+
+	// Changing an entry breaks its hash
+	tampered := strings.Replace(string(data), `"actor":"admin"`, `"actor":"Author 1"`, 1)
+	_, _, err = VerifyAuditLog(strings.NewReader(tampered))
+	assert.True(t, errors.Is(err, ErrAuditTampered))
+
+	// Removing an entry breaks the chain
+	lines := strings.SplitAfter(string(data), "\n")
+	_, _, err = VerifyAuditLog(strings.NewReader(lines[0] + lines[2]))
+	assert.True(t, errors.Is(err, ErrAuditTampered))
+
+	// A tampered log is not appended to
+	assert.NoError(t, os.WriteFile(path, []byte(tampered), 0o600))
+	_, err = NewAuditLog(path, clock, nil)
+	assert.True(t, errors.Is(err, ErrAuditTampered))
+}
+
+func TestAuditLogTornLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	audit, err := NewAuditLog(path, clock, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, audit.Record(AuditEntry{Actor: "Author 1", Action: AuditLoginSucceeded}))
+	assert.NoError(t, audit.Close())
+	data, _ := os.ReadFile(path)
+
+	// A crash while appending leaves half a line, it is removed and the chain continues
+	file, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	file.WriteString(`{"seq":2,"time":"2024-01-01T12:00:00Z","act`)
+	file.Close()
+	entries, _, err := VerifyAuditLog(strings.NewReader(string(data) + `{"seq":2`))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, entries)
+
+	audit, err = NewAuditLog(path, clock, nil)
+	assert.NoError(t, err)
+	truncated, _ := os.ReadFile(path)
+	assert.Equal(t, data, truncated)
+	assert.NoError(t, audit.Record(AuditEntry{Actor: "admin", Action: AuditPostDelete, PostID: 1}))
+	assert.NoError(t, audit.Close())
+	data, _ = os.ReadFile(path)
+	entries, _, err = VerifyAuditLog(bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, entries)
+
+	// A broken line followed by whole ones has been tampered with
+	lines := strings.SplitAfter(string(data), "\n")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"seq":1`+"\n"+lines[1]), 0o600))
+	_, err = NewAuditLog(path, clock, nil)
+	assert.True(t, errors.Is(err, ErrAuditTampered))
+}
+
+func TestAuditLogWriteFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	audit, err := NewAuditLog(path, nil, nil)
+	assert.NoError(t, err)
+	writable := audit.file
+
+	// A failed write is reported by the health check until a write works again
+	audit.file, _ = os.Open(path)
+	assert.Error(t, audit.Record(AuditEntry{Actor: "admin", Action: AuditPostDelete, PostID: 1}))
+	assert.Error(t, audit.CheckHealth(context.Background()))
+	audit.file.Close()
+	audit.file = writable
+	assert.NoError(t, audit.Record(AuditEntry{Actor: "admin", Action: AuditPostDelete, PostID: 1}))
+	assert.NoError(t, audit.CheckHealth(context.Background()))
+	assert.NoError(t, audit.Close())
+}
+
+func TestAuditLogQuery(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	audit, _ := NewAuditLog("", clock, nil)
+
+	for i := 1; i <= 3; i++ {
+		assert.NoError(t, audit.Record(AuditEntry{Actor: "Author 1", Action: AuditPostUpdate, PostID: i}))
+		clock.Advance(time.Hour)
+	}
+	assert.NoError(t, audit.Record(AuditEntry{Actor: "admin", Action: AuditLoginSucceeded}))
+
+	// Newest first
+	entries, _ := audit.Query(AuditFilter{})
+	assert.Len(t, entries, 4)
+	assert.Equal(t, int64(4), entries[0].Seq)
+
+	entries, _ = audit.Query(AuditFilter{Actor: "Author 1", Limit: 2})
+	assert.Len(t, entries, 2)
+	assert.Equal(t, 3, entries[0].PostID)
+
+	entries, _ = audit.Query(AuditFilter{PostID: 2})
+	assert.Len(t, entries, 1)
+
+	entries, _ = audit.Query(AuditFilter{Action: AuditPostUpdate, Since: time.Date(2024, 1, 1, 13, 0, 0, 0, time.UTC), Until: time.Date(2024, 1, 1, 14, 0, 0, 0, time.UTC)})
+	assert.Len(t, entries, 2)
+}
@@ -3,19 +3,80 @@ package internal
 import (
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
 	"os"
+	"sort"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 
 	"github.com/rs/zerolog"
 )
 
+// Default file the authors and posts are seeded from
 const FILEPATH = "./resources/blog_data.json"
 
+var (
+	ErrDisplayNameInvalid = newError("display_name_invalid", http.StatusBadRequest, "display_name", "display name must not be longer than 70 characters")
+	ErrBioInvalid         = newError("bio_invalid", http.StatusBadRequest, "bio", "bio must not be longer than 500 characters and must be valid UTF-8")
+	ErrAvatarURLInvalid   = newError("avatar_url_invalid", http.StatusBadRequest, "avatar_url", "avatar url must be an absolute http or https url")
+	ErrWebsiteInvalid     = newError("website_invalid", http.StatusBadRequest, "website", "website must be an absolute http or https url")
+	ErrAuthorAdmin        = newError("author_admin", http.StatusBadRequest, "", "admin cannot be disabled, deleted or given another role")
+	ErrEmailInvalid       = newError("email_invalid", http.StatusBadRequest, "email", "email must be a valid email address")
+	ErrPasswordInvalid    = newError("password_invalid", http.StatusBadRequest, "password", "password must not be longer than 72 characters or shorter than 8 characters")
+	ErrPasswordIncorrect  = newError("password_incorrect", http.StatusUnauthorized, "old_password", "current password is incorrect")
+	ErrAuthorExists       = newError("author_exists", http.StatusConflict, "", "author already exists")
+	ErrAuthorDisabled     = newError("author_disabled", http.StatusForbidden, "", "author is disabled")
+	ErrCredentialsRevoked = newError("credentials_revoked", http.StatusUnauthorized, "", "credentials were issued before the password was changed")
+	ErrRoleInvalid        = newError("role_invalid", http.StatusBadRequest, "role", "role must be author, contributor or editor")
+	ErrCredentialsInvalid = newError("credentials_invalid", http.StatusUnauthorized, "", "invalid credentials")
+	ErrAdminRequired      = newError("admin_required", http.StatusForbidden, "", "only admin can manage authors")
+	ErrReassignAuthor     = newError("reassign_author_not_found", http.StatusBadRequest, "to", "author to reassign posts to not found")
+)
+
+// Roles of authors, posts of contributors are reviewed by an editor or admin before they are published
+const (
+	RoleAuthor      = "author"
+	RoleContributor = "contributor"
+	RoleEditor      = "editor"
+)
+
 type Author struct {
 	Author   string `json:"author"`
 	Password string `json:"password"`
 }
 
+// AuthorProfile is the public information about an author
+type AuthorProfile struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	Bio         string `json:"bio,omitempty"`
+	AvatarURL   string `json:"avatar_url,omitempty"`
+	Website     string `json:"website,omitempty"`
+	Email       string `json:"email,omitempty"`
+	Role        string `json:"role"`
+	Disabled    bool   `json:"disabled"`
+}
+
+// AuthorSummary is the short version of the profile embedded in posts
+type AuthorSummary struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	AvatarURL   string `json:"avatar_url,omitempty"`
+}
+
+// Summary returns the short version of the profile
+func (p *AuthorProfile) Summary() *AuthorSummary {
+	return &AuthorSummary{
+		Name:        p.Name,
+		DisplayName: p.DisplayName,
+		AvatarURL:   p.AvatarURL,
+	}
+}
+
 type AuthorData struct {
 	Authors []Author `json:"posts"` // from json file
 }
@@ -23,16 +84,25 @@ type AuthorData struct {
 type AuthorPassword map[string]string
 
 type AuthorService struct {
-	authors AuthorPassword
-	logger  *zerolog.Logger
+	authors         AuthorPassword
+	profiles        map[string]*AuthorProfile
+	deleting        map[string]bool      // Authors whose posts are being moved or deleted before they are removed
+	passwordChanged map[string]time.Time // Credentials issued before are not accepted anymore, see revokeCredentials
+	clock           Clock
+	mutex           sync.Mutex // Protects access to authors, profiles, deleting and passwordChanged
+	logger          *zerolog.Logger
 }
 
 // NewAuthorService creates a new author service
 func NewAuthorService(a *AuthorPassword, logger *zerolog.Logger) (*AuthorService, error) {
 	// Add the authors from the json file in the resources folder to the authors slice
 	return &AuthorService{
-		authors: *a,
-		logger:  logger,
+		authors:         *a,
+		profiles:        make(map[string]*AuthorProfile),
+		deleting:        make(map[string]bool),
+		passwordChanged: make(map[string]time.Time),
+		clock:           SystemClock{},
+		logger:          logger,
 	}, nil
 }
 
@@ -41,11 +111,11 @@ func convertAuthorToPassword(author string) string {
 	return strings.Replace(author, "Author ", "password", 1)
 }
 
-// Seed adds the authors from the json file in the resources folder to the authors slice
-func (a *AuthorService) Seed() error {
+// Seed adds the authors from the json file at path, e.g. FILEPATH, to the authors slice
+func (a *AuthorService) Seed(path string) error {
 	// Add the authors from the json file in the resources folder to the authors slice
 	// Open the JSON file
-	file, err := os.Open(FILEPATH)
+	file, err := os.Open(path)
 	if err != nil {
 		return fmt.Errorf("error opening JSON file: %w", err)
 	}
@@ -57,23 +127,334 @@ func (a *AuthorService) Seed() error {
 		return fmt.Errorf("error decoding JSON: %w", err)
 	}
 
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
 	// Add the authors to the authors slice
 	for _, author := range data.Authors {
 		a.authors[author.Author] = convertAuthorToPassword(author.Author)
+		a.profiles[author.Author] = &AuthorProfile{Name: author.Author, DisplayName: author.Author, Role: RoleAuthor}
 	}
 
 	// Add admin user
 	a.authors["admin"] = "admin"
+	a.profiles["admin"] = &AuthorProfile{Name: "admin", DisplayName: "Admin", Role: RoleEditor}
 	return nil
 }
 
 // ValidAuthor returns the author id if the username and password are valid
 func (a *AuthorService) ValidAuthor(username string, password string) (bool, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	// Disabled authors cannot log in
+	if profile, ok := a.profiles[username]; ok && profile.Disabled {
+		return false, nil
+	}
 	if val, ok := (a.authors)[username]; ok {
-		if val == password {
+		// Authors created through an identity provider have no password
+		if val != "" && val == password {
 			return true, nil
 		}
 		return false, nil
 	}
 	return false, nil
 }
+
+// validateURL checks that the value is empty or an absolute http or https URL
+func validateURL(value string, invalid error) error {
+	if value == "" {
+		return nil
+	}
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return invalid
+	}
+	return nil
+}
+
+// validateProfile checks the fields an author can change in their profile, all failures are returned together
+func validateProfile(profile AuthorProfile) error {
+	var displayName, bio, email error
+	if utf8.RuneCountInString(profile.DisplayName) > 70 {
+		displayName = ErrDisplayNameInvalid
+	}
+	if !utf8.ValidString(profile.Bio) || utf8.RuneCountInString(profile.Bio) > 500 {
+		bio = ErrBioInvalid
+	}
+	if profile.Email != "" {
+		address, err := mail.ParseAddress(profile.Email)
+		if err != nil || address.Address != profile.Email {
+			email = ErrEmailInvalid
+		}
+	}
+	return joinValidation(
+		displayName,
+		bio,
+		validateURL(profile.AvatarURL, ErrAvatarURLInvalid),
+		validateURL(profile.Website, ErrWebsiteInvalid),
+		email,
+	)
+}
+
+// validatePassword checks the length of a new password
+func validatePassword(password string) error {
+	if len(password) < 8 || len(password) > 72 {
+		return ErrPasswordInvalid
+	}
+	return nil
+}
+
+// ListAuthors returns the profiles of all authors ordered by name
+func (a *AuthorService) ListAuthors() ([]*AuthorProfile, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	result := []*AuthorProfile{}
+	for _, profile := range a.profiles {
+		p := *profile
+		result = append(result, &p)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
+
+// GetAuthor returns the profile of an author
+func (a *AuthorService) GetAuthor(name string) (*AuthorProfile, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	profile, ok := a.profiles[name]
+	if !ok {
+		return nil, ErrAuthorNotFound
+	}
+	result := *profile
+	return &result, nil
+}
+
+// UpdateProfile replaces the editable fields of the profile, the name and disabled flag are kept
+func (a *AuthorService) UpdateProfile(name string, profile AuthorProfile) (*AuthorProfile, error) {
+	profile.DisplayName = strings.TrimSpace(profile.DisplayName)
+	if err := validateProfile(profile); err != nil {
+		return nil, err
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	existing, ok := a.profiles[name]
+	if !ok {
+		return nil, ErrAuthorNotFound
+	}
+	// Fall back to the name when the display name is cleared
+	if profile.DisplayName == "" {
+		profile.DisplayName = name
+	}
+	existing.DisplayName = profile.DisplayName
+	existing.Bio = profile.Bio
+	existing.AvatarURL = profile.AvatarURL
+	existing.Website = profile.Website
+	existing.Email = profile.Email
+
+	result := *existing
+	return &result, nil
+}
+
+// SetAuthorDisabled disables or enables an author, disabled authors cannot log in
+func (a *AuthorService) SetAuthorDisabled(name string, disabled bool) error {
+	if name == "admin" {
+		return ErrAuthorAdmin
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	profile, ok := a.profiles[name]
+	if !ok || a.deleting[name] {
+		return ErrAuthorNotFound
+	}
+	profile.Disabled = disabled
+	return nil
+}
+
+// CheckCredentials returns an error unless the author exists, is not disabled and has not changed their password
+// since the session or token was issued at, so stolen credentials stop working with a password change or reset
+func (a *AuthorService) CheckCredentials(name string, issuedAt time.Time) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	profile, ok := a.profiles[name]
+	if !ok {
+		return ErrAuthorNotFound
+	}
+	if profile.Disabled {
+		return ErrAuthorDisabled
+	}
+	if changed, ok := a.passwordChanged[name]; ok && issuedAt.Before(changed) {
+		return ErrCredentialsRevoked
+	}
+	return nil
+}
+
+// SetAuthorRole changes the role of an author, admin is always an editor
+func (a *AuthorService) SetAuthorRole(name string, role string) error {
+	switch role {
+	case RoleAuthor, RoleContributor, RoleEditor:
+	default:
+		return ErrRoleInvalid
+	}
+	if name == "admin" {
+		return ErrAuthorAdmin
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	profile, ok := a.profiles[name]
+	if !ok {
+		return ErrAuthorNotFound
+	}
+	profile.Role = role
+	return nil
+}
+
+// IsEditor reports whether the author may review posts
+func (a *AuthorService) IsEditor(name string) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	profile, ok := a.profiles[name]
+	return name == "admin" || (ok && !profile.Disabled && profile.Role == RoleEditor)
+}
+
+// NeedsReview reports whether the posts of the author are reviewed before they are published
+func (a *AuthorService) NeedsReview(name string) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	profile, ok := a.profiles[name]
+	return ok && profile.Role == RoleContributor
+}
+
+// DeleteAuthor removes the author and their password once removePosts has moved or deleted their posts. The author
+// is disabled while removePosts runs, so they cannot write anymore, and is enabled again when it fails. Credentials
+// issued before the deletion stay revoked, also for a new author who gets the same name
+func (a *AuthorService) DeleteAuthor(name string, removePosts func() error) error {
+	if name == "admin" {
+		return ErrAuthorAdmin
+	}
+
+	a.mutex.Lock()
+	profile, ok := a.profiles[name]
+	if !ok || a.deleting[name] {
+		a.mutex.Unlock()
+		return ErrAuthorNotFound
+	}
+	disabled := profile.Disabled
+	profile.Disabled = true
+	a.deleting[name] = true
+	a.mutex.Unlock()
+
+	// The posts are handled without holding the mutex, the PostService asks the AuthorService about roles
+	err := removePosts()
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	delete(a.deleting, name)
+	if err != nil {
+		profile.Disabled = disabled
+		return err
+	}
+	delete(a.profiles, name)
+	delete(a.authors, name)
+	a.revokeCredentials(name)
+	return nil
+}
+
+// revokeCredentials stops accepting the sessions and tokens of the author issued until now. JWTs carry whole
+// seconds, so a session from the second of the change could have been issued before it: the cutoff is the
+// start of the next second. Must be called with the mutex held
+func (a *AuthorService) revokeCredentials(name string) {
+	a.passwordChanged[name] = a.clock.Now().Truncate(time.Second).Add(time.Second)
+}
+
+// ChangePassword sets a new password after checking the current one
+func (a *AuthorService) ChangePassword(name string, oldPassword string, newPassword string) error {
+	if err := validatePassword(newPassword); err != nil {
+		return err
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	current, ok := a.authors[name]
+	if !ok {
+		return ErrAuthorNotFound
+	}
+	if current == "" || current != oldPassword {
+		return ErrPasswordIncorrect
+	}
+	a.authors[name] = newPassword
+	a.revokeCredentials(name)
+	return nil
+}
+
+// SetPassword sets a new password without checking the current one, used by password resets
+func (a *AuthorService) SetPassword(name string, password string) error {
+	if err := validatePassword(password); err != nil {
+		return err
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if _, ok := a.authors[name]; !ok {
+		return ErrAuthorNotFound
+	}
+	a.authors[name] = password
+	a.revokeCredentials(name)
+	return nil
+}
+
+// FindAuthorByEmail returns the profile of the author with the email address
+func (a *AuthorService) FindAuthorByEmail(email string) (*AuthorProfile, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	for _, profile := range a.profiles {
+		if profile.Email != "" && strings.EqualFold(profile.Email, email) {
+			result := *profile
+			return &result, nil
+		}
+	}
+	return nil, ErrAuthorNotFound
+}
+
+// CreateAuthor adds an author without a password, who can only log in through an identity provider
+func (a *AuthorService) CreateAuthor(profile AuthorProfile) error {
+	if err := validateAuthor(profile.Name); err != nil {
+		return err
+	}
+	profile.DisplayName = strings.TrimSpace(profile.DisplayName)
+	if err := validateProfile(profile); err != nil {
+		return err
+	}
+	if profile.DisplayName == "" {
+		profile.DisplayName = profile.Name
+	}
+	profile.Disabled = false
+	profile.Role = RoleAuthor
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if _, ok := a.profiles[profile.Name]; ok {
+		return ErrAuthorExists
+	}
+	a.authors[profile.Name] = ""
+	a.profiles[profile.Name] = &profile
+	return nil
+}
@@ -0,0 +1,114 @@
+package internal
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestAuthorService() *AuthorService {
+	logger := zerolog.New(os.Stdout)
+	a := make(AuthorPassword)
+	authors, _ := NewAuthorService(&a, &logger)
+	authors.authors["Author 1"] = "password1"
+	authors.profiles["Author 1"] = &AuthorProfile{Name: "Author 1", DisplayName: "Author 1"}
+	return authors
+}
+
+func TestValidateProfile(t *testing.T) {
+	cases := []struct {
+		profile AuthorProfile
+		want    error
+		test    string
+	}{
+		{AuthorProfile{DisplayName: "Jane", Bio: "Writes", AvatarURL: "https://example.com/a.png", Website: "http://example.com"}, nil, "valid profile"},
+		{AuthorProfile{}, nil, "empty profile"},
+		{AuthorProfile{AvatarURL: "javascript:alert(1)"}, ErrAvatarURLInvalid, "avatar with script scheme"},
+		{AuthorProfile{Website: "example.com"}, ErrWebsiteInvalid, "relative website"},
+		{AuthorProfile{Bio: string(make([]byte, 501))}, ErrBioInvalid, "bio too long"},
+	}
+
+	for _, tc := range cases {
+		got := validateProfile(tc.profile)
+		assert.Equal(t, tc.want, got, tc.test)
+	}
+}
+
+func TestUpdateProfile(t *testing.T) {
+	authors := newTestAuthorService()
+
+	profile, err := authors.UpdateProfile("Author 1", AuthorProfile{Name: "Someone Else", Bio: "Hello", Disabled: true})
+	assert.NoError(t, err)
+	// The name and the disabled flag cannot be changed, an empty display name falls back to the name
+	assert.Equal(t, "Author 1", profile.Name)
+	assert.Equal(t, "Author 1", profile.DisplayName)
+	assert.False(t, profile.Disabled)
+	assert.Equal(t, "Hello", profile.Bio)
+
+	_, err = authors.UpdateProfile("Author 9", AuthorProfile{})
+	assert.Equal(t, ErrAuthorNotFound, err)
+}
+
+func TestDisabledAuthorCannotLogIn(t *testing.T) {
+	authors := newTestAuthorService()
+
+	valid, _ := authors.ValidAuthor("Author 1", "password1")
+	assert.True(t, valid)
+
+	assert.NoError(t, authors.SetAuthorDisabled("Author 1", true))
+	valid, _ = authors.ValidAuthor("Author 1", "password1")
+	assert.False(t, valid)
+
+	assert.Equal(t, ErrAuthorAdmin, authors.SetAuthorDisabled("admin", true))
+	assert.Equal(t, ErrAuthorDisabled, authors.CheckCredentials("Author 1", time.Now()))
+	assert.Equal(t, ErrAuthorNotFound, authors.CheckCredentials("Author 2", time.Now()))
+}
+
+func TestDeleteAuthor(t *testing.T) {
+	authors := newTestAuthorService()
+	noPosts := func() error { return nil }
+
+	assert.Equal(t, ErrAuthorAdmin, authors.DeleteAuthor("admin", noPosts))
+
+	// The author is disabled while the posts are handled and enabled again when that fails
+	err := authors.DeleteAuthor("Author 1", func() error {
+		assert.Equal(t, ErrAuthorDisabled, authors.CheckCredentials("Author 1", time.Now()))
+		return ErrUniqueTitle
+	})
+	assert.Equal(t, ErrUniqueTitle, err)
+	assert.NoError(t, authors.CheckCredentials("Author 1", time.Now()))
+
+	assert.NoError(t, authors.DeleteAuthor("Author 1", noPosts))
+	assert.Equal(t, ErrAuthorNotFound, authors.CheckCredentials("Author 1", time.Now()))
+	assert.Equal(t, ErrAuthorNotFound, authors.DeleteAuthor("Author 1", noPosts))
+
+	// A new author of the same name does not take over the credentials of the deleted one
+	issued := time.Now().Add(-time.Minute)
+	assert.NoError(t, authors.CreateAuthor(AuthorProfile{Name: "Author 1"}))
+	assert.Equal(t, ErrCredentialsRevoked, authors.CheckCredentials("Author 1", issued))
+	assert.NoError(t, authors.CheckCredentials("Author 1", time.Now().Add(time.Second)))
+}
+
+func TestAuthorRoles(t *testing.T) {
+	authors := newTestAuthorService()
+
+	assert.False(t, authors.IsEditor("Author 1"))
+	assert.True(t, authors.IsEditor("admin"))
+
+	assert.Equal(t, ErrRoleInvalid, authors.SetAuthorRole("Author 1", "boss"))
+	assert.Equal(t, ErrAuthorAdmin, authors.SetAuthorRole("admin", RoleContributor))
+	assert.Equal(t, ErrAuthorNotFound, authors.SetAuthorRole("Author 9", RoleEditor))
+
+	assert.NoError(t, authors.SetAuthorRole("Author 1", RoleContributor))
+	assert.True(t, authors.NeedsReview("Author 1"))
+	assert.NoError(t, authors.SetAuthorRole("Author 1", RoleEditor))
+	assert.False(t, authors.NeedsReview("Author 1"))
+	assert.True(t, authors.IsEditor("Author 1"))
+
+	// Disabled editors cannot review
+	assert.NoError(t, authors.SetAuthorDisabled("Author 1", true))
+	assert.False(t, authors.IsEditor("Author 1"))
+}
@@ -0,0 +1,121 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrBlobNotFound = fmt.Errorf("blob not found")
+
+// BlobStore keeps the contents of uploaded media by key
+type BlobStore interface {
+	Put(key string, r io.Reader) error
+	Open(key string) (io.ReadSeekCloser, error)
+	Delete(key string) error
+	Keys() ([]string, error)
+}
+
+// DiskBlobStore keeps blobs as files in a directory, spread over subdirectories by the first two characters of the key
+type DiskBlobStore struct {
+	dir string
+}
+
+// NewDiskBlobStore creates a new blob store in dir, the directory is created when it does not exist
+func NewDiskBlobStore(dir string) (*DiskBlobStore, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("blob store needs a directory")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &DiskBlobStore{dir: dir}, nil
+}
+
+// path returns the file of the key, keys are hex hashes so they cannot leave the directory
+func (d *DiskBlobStore) path(key string) string {
+	if len(key) < 2 {
+		return filepath.Join(d.dir, key)
+	}
+	return filepath.Join(d.dir, key[:2], key)
+}
+
+// Put writes the blob to a temporary file and renames it, so readers never see a partial blob
+func (d *DiskBlobStore) Put(key string, r io.Reader) error {
+	path := d.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// CheckHealth fails when the directory is gone or is not a directory anymore, e.g. when a volume was unmounted
+func (d *DiskBlobStore) CheckHealth(ctx context.Context) error {
+	info, err := os.Stat(d.dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", d.dir)
+	}
+	return nil
+}
+
+// Open opens the blob for reading
+func (d *DiskBlobStore) Open(key string) (io.ReadSeekCloser, error) {
+	file, err := os.Open(d.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// Delete removes the blob, deleting a missing blob is not an error
+func (d *DiskBlobStore) Delete(key string) error {
+	err := os.Remove(d.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Keys returns the keys of all blobs, files of uploads which were not finished are skipped
+func (d *DiskBlobStore) Keys() ([]string, error) {
+	var keys []string
+	err := filepath.WalkDir(d.dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".upload-") {
+			return nil
+		}
+		keys = append(keys, entry.Name())
+		return nil
+	})
+	return keys, err
+}
@@ -0,0 +1,16 @@
+package internal
+
+import "time"
+
+// Clock tells the current time, services take one so they can be tested without sleeping
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the Clock backed by the system time
+type SystemClock struct{}
+
+// Now returns the current system time
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
@@ -0,0 +1,268 @@
+package internal
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rs/zerolog"
+)
+
+// Moderation states of a comment
+const (
+	CommentPending  = "pending"
+	CommentApproved = "approved"
+	CommentRejected = "rejected"
+)
+
+var (
+	ErrCommentNotFound        = newError("comment_not_found", http.StatusNotFound, "", "comment not found")
+	ErrCommentEmpty           = newError("comment_empty", http.StatusBadRequest, "body", "comment must not be empty")
+	ErrCommentInvalid         = newError("comment_invalid", http.StatusBadRequest, "body", "comment must not be longer than 1000 characters")
+	ErrCommentEncoding        = newError("comment_encoding", http.StatusBadRequest, "body", "comment must be valid UTF-8")
+	ErrCommentSpammy          = newError("comment_spammy", http.StatusBadRequest, "body", "comment must not contain spammy patterns or phrases")
+	ErrCommentConsecutiveChar = newError("comment_consecutive_characters", http.StatusBadRequest, "body", "comment must not have excessive consecutive identical characters")
+	ErrCommentParentNotFound  = newError("comment_parent_not_found", http.StatusNotFound, "parent_id", "comment replied to not found")
+	ErrCommentReplyDepth      = newError("comment_reply_depth", http.StatusBadRequest, "parent_id", "replies to replies are not allowed")
+	ErrCommentStatusInvalid   = newError("comment_status_invalid", http.StatusBadRequest, "status", "comment status must be pending, approved or rejected")
+	ErrCommentNotAllowed      = newError("comment_not_allowed", http.StatusForbidden, "", "not allowed to change comments of another author")
+)
+
+type Comment struct {
+	ID        int        `json:"id"`
+	PostID    int        `json:"post_id"`
+	ParentID  int        `json:"parent_id,omitempty"`
+	Author    string     `json:"author"`
+	Body      string     `json:"body"`
+	Status    string     `json:"status"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	Replies   []*Comment `json:"replies,omitempty"`
+}
+
+// Store the comments per post
+type CommentService struct {
+	comments map[int]map[int]*Comment // post ID -> comment ID -> comment
+	lastID   int
+	clock    Clock
+	mutex    sync.Mutex // Protects access to comments and lastID
+	logger   *zerolog.Logger
+}
+
+// NewCommentService creates a new comments service
+func NewCommentService(clock Clock, logger *zerolog.Logger) (*CommentService, error) {
+	if clock == nil {
+		clock = SystemClock{}
+	}
+	return &CommentService{
+		comments: make(map[int]map[int]*Comment),
+		clock:    clock,
+		logger:   logger,
+	}, nil
+}
+
+// validateComment checks the body of a comment with the same rules as the content of posts
+func validateComment(body string) error {
+	if strings.TrimSpace(body) == "" {
+		return ErrCommentEmpty
+	}
+	if !utf8.ValidString(body) {
+		return ErrCommentEncoding
+	}
+	if utf8.RuneCountInString(body) > 1000 {
+		return ErrCommentInvalid
+	}
+	if hasSpammyPhrase(body) {
+		return ErrCommentSpammy
+	}
+	if hasConsecutiveChars(body) {
+		return ErrCommentConsecutiveChar
+	}
+	return nil
+}
+
+// canModerate reports whether the author moderates the comments of the post
+func canModerate(post Post, author string) bool {
+	return post.Author == author || author == "admin"
+}
+
+// copyComment returns a copy of the comment without replies, so callers cannot change the stored comment
+func copyComment(comment *Comment) *Comment {
+	c := *comment
+	c.Replies = nil
+	return &c
+}
+
+// CreateComment adds a comment to the post, or a reply when parentID is set.
+// Comments of the post author and admin are approved right away, all others wait for moderation
+func (c *CommentService) CreateComment(post Post, parentID int, author string, body string) (*Comment, error) {
+	if err := validateAuthor(author); err != nil {
+		return nil, err
+	}
+	if err := validateComment(body); err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// Only one level of replies
+	if parentID != 0 {
+		parent, ok := c.comments[post.ID][parentID]
+		if !ok {
+			return nil, ErrCommentParentNotFound
+		}
+		if parent.ParentID != 0 {
+			return nil, ErrCommentReplyDepth
+		}
+	}
+
+	status := CommentPending
+	if canModerate(post, author) {
+		status = CommentApproved
+	}
+
+	now := c.clock.Now()
+	c.lastID++
+	comment := &Comment{
+		ID:        c.lastID,
+		PostID:    post.ID,
+		ParentID:  parentID,
+		Author:    author,
+		Body:      body,
+		Status:    status,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if c.comments[post.ID] == nil {
+		c.comments[post.ID] = make(map[int]*Comment)
+	}
+	c.comments[post.ID][comment.ID] = comment
+
+	return copyComment(comment), nil
+}
+
+// ListComments returns the comments of the post as threads, oldest first.
+// The post author and admin see every comment, everybody else sees the approved comments and their own
+func (c *CommentService) ListComments(post Post, viewer string) ([]*Comment, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	moderator := canModerate(post, viewer)
+	visible := func(comment *Comment) bool {
+		return moderator || comment.Status == CommentApproved || comment.Author == viewer
+	}
+
+	// Sort by ID so the threads are in the order they were written
+	ids := make([]int, 0, len(c.comments[post.ID]))
+	for id := range c.comments[post.ID] {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	threads := make([]*Comment, 0)
+	byID := make(map[int]*Comment)
+	for _, id := range ids {
+		comment := c.comments[post.ID][id]
+		if comment.ParentID != 0 || !visible(comment) {
+			continue
+		}
+		thread := copyComment(comment)
+		byID[id] = thread
+		threads = append(threads, thread)
+	}
+	// A reply is only shown under a visible comment
+	for _, id := range ids {
+		comment := c.comments[post.ID][id]
+		if parent, ok := byID[comment.ParentID]; ok && visible(comment) {
+			parent.Replies = append(parent.Replies, copyComment(comment))
+		}
+	}
+
+	return threads, nil
+}
+
+// UpdateComment changes the body of a comment, only the commenter can edit it.
+// An edited comment has to be approved again unless the commenter moderates the post
+func (c *CommentService) UpdateComment(post Post, id int, author string, body string) (*Comment, error) {
+	if err := validateComment(body); err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	comment, ok := c.comments[post.ID][id]
+	if !ok {
+		return nil, ErrCommentNotFound
+	}
+	if comment.Author != author {
+		return nil, ErrCommentNotAllowed
+	}
+
+	comment.Body = body
+	if !canModerate(post, author) {
+		comment.Status = CommentPending
+	}
+	comment.UpdatedAt = c.clock.Now()
+
+	return copyComment(comment), nil
+}
+
+// DeleteComment deletes a comment together with its replies.
+// The commenter, the post author and admin can delete a comment
+func (c *CommentService) DeleteComment(post Post, id int, author string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	comment, ok := c.comments[post.ID][id]
+	if !ok {
+		return ErrCommentNotFound
+	}
+	if comment.Author != author && !canModerate(post, author) {
+		return ErrCommentNotAllowed
+	}
+
+	delete(c.comments[post.ID], id)
+	for replyID, reply := range c.comments[post.ID] {
+		if reply.ParentID == id {
+			delete(c.comments[post.ID], replyID)
+		}
+	}
+	return nil
+}
+
+// ModerateComment sets the moderation state of a comment, only the post author and admin can moderate
+func (c *CommentService) ModerateComment(post Post, id int, moderator string, status string) (*Comment, error) {
+	switch status {
+	case CommentPending, CommentApproved, CommentRejected:
+	default:
+		return nil, ErrCommentStatusInvalid
+	}
+	if !canModerate(post, moderator) {
+		return nil, ErrCommentNotAllowed
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	comment, ok := c.comments[post.ID][id]
+	if !ok {
+		return nil, ErrCommentNotFound
+	}
+	comment.Status = status
+
+	c.logger.Info().Int("post", post.ID).Int("comment", id).Str("moderator", moderator).Str("status", status).Msg("comment moderated")
+	return copyComment(comment), nil
+}
+
+// DeletePostComments deletes all comments of a post, it is registered with PostService.OnDelete
+func (c *CommentService) DeletePostComments(post Post) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.comments, post.ID)
+}
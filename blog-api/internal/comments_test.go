@@ -0,0 +1,95 @@
+package internal
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateComment(t *testing.T) {
+	cases := []struct {
+		body string
+		want error
+		test string
+	}{
+		{"Nice post, thanks!", nil, "valid comment"},
+		{"   ", ErrCommentEmpty, "empty comment"},
+		{strings.Repeat("a b ", 251), ErrCommentInvalid, "too long"},
+		{"Buy now while it lasts", ErrCommentSpammy, "spammy phrase"},
+		{"Sooooo good", ErrCommentConsecutiveChar, "consecutive characters"},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.want, validateComment(c.body), c.test)
+	}
+}
+
+func TestCommentThreadsAndModeration(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	comments, _ := NewCommentService(nil, &logger)
+	post := Post{ID: 1, Author: "Author 1"}
+
+	// Comments of others wait for moderation, the post author's are approved
+	comment, err := comments.CreateComment(post, 0, "Author 2", "Great read")
+	assert.NoError(t, err)
+	assert.Equal(t, CommentPending, comment.Status)
+	reply, err := comments.CreateComment(post, comment.ID, "Author 1", "Thank you")
+	assert.NoError(t, err)
+	assert.Equal(t, CommentApproved, reply.Status)
+
+	_, err = comments.CreateComment(post, reply.ID, "Author 2", "You are welcome")
+	assert.Equal(t, ErrCommentReplyDepth, err)
+	_, err = comments.CreateComment(post, 42, "Author 2", "Hello")
+	assert.Equal(t, ErrCommentParentNotFound, err)
+
+	// Pending comments are only visible to the commenter and moderators
+	threads, _ := comments.ListComments(post, "Author 3")
+	assert.Empty(t, threads)
+	threads, _ = comments.ListComments(post, "Author 2")
+	assert.Len(t, threads, 1)
+	assert.Len(t, threads[0].Replies, 1)
+
+	_, err = comments.ModerateComment(post, comment.ID, "Author 2", CommentApproved)
+	assert.Equal(t, ErrCommentNotAllowed, err)
+	_, err = comments.ModerateComment(post, comment.ID, "Author 1", "spam")
+	assert.Equal(t, ErrCommentStatusInvalid, err)
+	_, err = comments.ModerateComment(post, comment.ID, "Author 1", CommentApproved)
+	assert.NoError(t, err)
+	threads, _ = comments.ListComments(post, "Author 3")
+	assert.Len(t, threads, 1)
+
+	// Editing sends the comment back to moderation
+	_, err = comments.UpdateComment(post, comment.ID, "Author 3", "Edited")
+	assert.Equal(t, ErrCommentNotAllowed, err)
+	edited, err := comments.UpdateComment(post, comment.ID, "Author 2", "Great read, edited")
+	assert.NoError(t, err)
+	assert.Equal(t, CommentPending, edited.Status)
+
+	// Deleting a comment deletes its replies
+	assert.Equal(t, ErrCommentNotAllowed, comments.DeleteComment(post, comment.ID, "Author 3"))
+	assert.NoError(t, comments.DeleteComment(post, comment.ID, "admin"))
+	threads, _ = comments.ListComments(post, "Author 1")
+	assert.Empty(t, threads)
+}
+
+func TestDeletePostsCascadesToComments(t *testing.T) {
+	ctx := context.Background()
+	logger := zerolog.New(os.Stdout)
+	posts, _ := NewPostsService(&map[string]map[int]Post{}, &logger)
+	posts.Posts["Author 1"] = map[int]Post{1: {ID: 1, Title: "First", Author: "Author 1"}}
+	comments, _ := NewCommentService(nil, &logger)
+	posts.OnDelete(comments.DeletePostComments)
+
+	post := posts.Posts["Author 1"][1]
+	_, err := comments.CreateComment(post, 0, "Author 1", "First comment")
+	assert.NoError(t, err)
+
+	_, err = posts.DeletePosts(ctx, 1, "Author 1")
+	assert.NoError(t, err)
+	threads, _ := comments.ListComments(post, "Author 1")
+	assert.Empty(t, threads)
+}
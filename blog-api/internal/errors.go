@@ -0,0 +1,68 @@
+package internal
+
+import (
+	"errors"
+	"strings"
+)
+
+// Error is an error clients can act on, with a stable code to match on instead of the message, the HTTP status
+// it is answered with and the field of the request it is about, if any
+type Error struct {
+	Code    string
+	Status  int
+	Field   string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// newError creates a sentinel error
+func newError(code string, status int, field string, message string) *Error {
+	return &Error{Code: code, Status: status, Field: field, Message: message}
+}
+
+// ValidationErrors lists every invalid field of a request, so they can be fixed at once. errors.Is finds each of
+// them
+type ValidationErrors []*Error
+
+func (v ValidationErrors) Error() string {
+	messages := make([]string, len(v))
+	for i, err := range v {
+		messages[i] = err.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Unwrap makes errors.Is and errors.As look at every error of the list
+func (v ValidationErrors) Unwrap() []error {
+	result := make([]error, len(v))
+	for i, err := range v {
+		result[i] = err
+	}
+	return result
+}
+
+// joinValidation returns nil when all checks passed, the failure when one failed and ValidationErrors when several
+// failed. An error which is not a validation failure is returned as it is
+func joinValidation(errs ...error) error {
+	var failures ValidationErrors
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		var failure *Error
+		if !errors.As(err, &failure) {
+			return err
+		}
+		failures = append(failures, failure)
+	}
+	switch len(failures) {
+	case 0:
+		return nil
+	case 1:
+		return failures[0]
+	}
+	return failures
+}
@@ -0,0 +1,42 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidationErrors(t *testing.T) {
+	p := map[string]map[int]Post{"Author 1": {}}
+	posts, _ := NewPostsService(&p, nil)
+	ctx := context.Background()
+
+	// Every invalid field is reported at once
+	_, err := posts.CreatePosts(ctx, Post{Title: "", Content: "short", Author: "Author 1", Tags: []string{"not a tag"}}, "Author 1")
+	var validation ValidationErrors
+	assert.True(t, errors.As(err, &validation))
+	assert.Equal(t, ValidationErrors{ErrTitleEmpty, ErrContentInvalid, ErrTagsInvalid}, validation)
+	assert.ErrorIs(t, err, ErrContentInvalid)
+	assert.Equal(t, "title must not be empty; "+ErrContentInvalid.Message+"; "+ErrTagsInvalid.Message, err.Error())
+
+	// A single failure is returned as it is
+	_, err = posts.CreatePosts(ctx, Post{Title: "Title 1", Content: "short", Author: "Author 1"}, "Author 1")
+	assert.Equal(t, ErrContentInvalid, err)
+
+	authors := newTestAuthorService()
+	_, err = authors.UpdateProfile("Author 1", AuthorProfile{Website: "ftp://example.com", Email: "not an email"})
+	assert.Equal(t, ValidationErrors{ErrWebsiteInvalid, ErrEmailInvalid}, err)
+}
+
+func TestError(t *testing.T) {
+	var domain *Error
+	assert.True(t, errors.As(&PostLockedError{}, &domain))
+	assert.Equal(t, "post_locked", domain.Code)
+	assert.Equal(t, http.StatusLocked, domain.Status)
+
+	assert.Equal(t, "title", ErrTitleEmpty.Field)
+	assert.NoError(t, joinValidation(nil, nil))
+}
@@ -0,0 +1,153 @@
+package internal
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Types of events published when posts change
+const (
+	EventPostCreated = "post.created"
+	EventPostUpdated = "post.updated"
+	EventPostDeleted = "post.deleted"
+)
+
+var ErrEventBusConfig = fmt.Errorf("event bus needs a replay buffer and a subscriber buffer of at least one event")
+
+type Event struct {
+	ID     int64     `json:"id"`
+	Type   string    `json:"type"`
+	Author string    `json:"author"`
+	PostID int       `json:"post_id"`
+	Post   *Post     `json:"post,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// EventPublisher is where services publish their events to
+type EventPublisher interface {
+	Publish(event Event)
+}
+
+// Subscription receives the events matching its filter until it is unsubscribed.
+// Events is closed when the subscriber is too slow to keep up
+type Subscription struct {
+	Events <-chan Event
+	// Reset is set when events after the last event ID were already dropped from the replay buffer, or the ID is
+	// from before a restart. The subscriber cannot catch up and has to start over from LastID
+	Reset  bool
+	LastID int64 // ID of the last event published when subscribing
+	name   string
+	events chan Event
+	filter func(Event) bool
+}
+
+// EventBus hands events to subscribers without ever waiting for them, and keeps the last
+// events so subscribers can resume where they left off
+type EventBus struct {
+	lastID      int64
+	replay      []Event // ring buffer of the last events
+	next        int     // position in replay of the next event
+	bufferSize  int
+	subscribers map[*Subscription]bool
+	clock       Clock
+	mutex       sync.Mutex // Protects access to lastID, replay, next and subscribers
+	logger      *zerolog.Logger
+}
+
+// NewEventBus creates a new event bus keeping replaySize events for resuming and buffering bufferSize
+// events per subscriber, a subscriber with a full buffer is disconnected
+func NewEventBus(replaySize int, bufferSize int, clock Clock, logger *zerolog.Logger) (*EventBus, error) {
+	if replaySize < 1 || bufferSize < 1 {
+		return nil, ErrEventBusConfig
+	}
+	if clock == nil {
+		clock = SystemClock{}
+	}
+	return &EventBus{
+		replay:      make([]Event, 0, replaySize),
+		bufferSize:  bufferSize,
+		subscribers: make(map[*Subscription]bool),
+		clock:       clock,
+		logger:      logger,
+	}, nil
+}
+
+// Publish numbers the event and hands it to the subscribers
+func (b *EventBus) Publish(event Event) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.lastID++
+	event.ID = b.lastID
+	event.Time = b.clock.Now()
+
+	// Keep the event for subscribers resuming later
+	if len(b.replay) < cap(b.replay) {
+		b.replay = append(b.replay, event)
+	} else {
+		b.replay[b.next] = event
+	}
+	b.next = (b.next + 1) % cap(b.replay)
+
+	for sub := range b.subscribers {
+		if sub.filter != nil && !sub.filter(event) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			// The subscriber is not keeping up, disconnect it rather than wait
+			b.logger.Warn().Str("subscriber", sub.name).Int64("event", event.ID).Msg("disconnecting slow event subscriber")
+			delete(b.subscribers, sub)
+			close(sub.events)
+		}
+	}
+}
+
+// Subscribe starts receiving the events matching the filter, a nil filter matches every event. The subscriber
+// names it in the log. The events after lastEventID which are still in the replay buffer are returned to be sent
+// first, the subscription is Reset when some of them are gone
+func (b *EventBus) Subscribe(subscriber string, lastEventID int64, filter func(Event) bool) (*Subscription, []Event) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	events := make(chan Event, b.bufferSize)
+	sub := &Subscription{Events: events, LastID: b.lastID, name: subscriber, events: events, filter: filter}
+	b.subscribers[sub] = true
+
+	var missed []Event
+	if lastEventID > 0 {
+		// The oldest event in the buffer has to follow the last one the subscriber received
+		oldest := b.lastID + 1
+		if len(b.replay) > 0 {
+			oldest = b.replay[b.next%len(b.replay)].ID
+		}
+		if lastEventID > b.lastID || oldest > lastEventID+1 {
+			sub.Reset = true
+			b.logger.Info().Str("subscriber", subscriber).Int64("last_event", lastEventID).Int64("oldest_event", oldest).
+				Msg("event subscriber cannot resume, events were dropped")
+		}
+		// Oldest event first
+		for i := 0; i < len(b.replay); i++ {
+			event := b.replay[(b.next+i)%len(b.replay)]
+			if event.ID > lastEventID && (filter == nil || filter(event)) {
+				missed = append(missed, event)
+			}
+		}
+	}
+	return sub, missed
+}
+
+// Unsubscribe stops the subscription, it is safe to call after a slow subscriber was disconnected
+func (b *EventBus) Unsubscribe(sub *Subscription) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.subscribers[sub] {
+		delete(b.subscribers, sub)
+		close(sub.events)
+	}
+}
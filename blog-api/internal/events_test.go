@@ -0,0 +1,107 @@
+package internal
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventBusFilterAndReplay(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	bus, err := NewEventBus(3, 10, nil, &logger)
+	assert.NoError(t, err)
+
+	sub, missed := bus.Subscribe("test", 0, func(e Event) bool { return e.Author == "Author 1" })
+	assert.Empty(t, missed)
+
+	for i := 1; i <= 5; i++ {
+		author := "Author 1"
+		if i%2 == 0 {
+			author = "Author 2"
+		}
+		bus.Publish(Event{Type: EventPostCreated, Author: author, PostID: i})
+	}
+
+	// Only the events of Author 1 are received
+	var ids []int64
+	for len(sub.Events) > 0 {
+		ids = append(ids, (<-sub.Events).ID)
+	}
+	assert.Equal(t, []int64{1, 3, 5}, ids)
+	bus.Unsubscribe(sub)
+	bus.Unsubscribe(sub)
+
+	// Resuming replays the events still in the buffer, the oldest ones are gone
+	sub, missed = bus.Subscribe("test", 2, nil)
+	ids = nil
+	for _, e := range missed {
+		ids = append(ids, e.ID)
+	}
+	assert.Equal(t, []int64{3, 4, 5}, ids)
+	assert.False(t, sub.Reset)
+	bus.Unsubscribe(sub)
+
+	// Event 2 is gone, so is an ID from before a restart, the subscriber has to start over
+	sub, missed = bus.Subscribe("test", 1, nil)
+	assert.True(t, sub.Reset)
+	assert.Equal(t, int64(5), sub.LastID)
+	assert.Len(t, missed, 3)
+	bus.Unsubscribe(sub)
+	sub, _ = bus.Subscribe("test", 9, nil)
+	assert.True(t, sub.Reset)
+	bus.Unsubscribe(sub)
+
+	_, err = NewEventBus(0, 10, nil, &logger)
+	assert.Equal(t, ErrEventBusConfig, err)
+}
+
+func TestEventBusDisconnectsSlowSubscriber(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	bus, _ := NewEventBus(10, 2, nil, &logger)
+
+	slow, _ := bus.Subscribe("slow", 0, nil)
+	fast, _ := bus.Subscribe("fast", 0, nil)
+	for i := 1; i <= 3; i++ {
+		bus.Publish(Event{Type: EventPostCreated, PostID: i})
+		<-fast.Events
+	}
+
+	// The slow subscriber gets what fit in its buffer, then its channel is closed
+	assert.Equal(t, int64(1), (<-slow.Events).ID)
+	assert.Equal(t, int64(2), (<-slow.Events).ID)
+	_, ok := <-slow.Events
+	assert.False(t, ok)
+	bus.Unsubscribe(slow)
+	bus.Unsubscribe(fast)
+}
+
+func TestPostServicePublishesEvents(t *testing.T) {
+	ctx := context.Background()
+	logger := zerolog.New(os.Stdout)
+	bus, _ := NewEventBus(10, 10, nil, &logger)
+	sub, _ := bus.Subscribe("test", 0, nil)
+
+	p := map[string]map[int]Post{"Author 1": {}}
+	posts, _ := NewPostsService(&p, &logger)
+	posts.PublishEvents(bus)
+
+	content := strings.Repeat("Lorem ipsum dolor sit amet. ", 5)
+	_, err := posts.CreatePosts(ctx, Post{Title: "Title 1", Content: content, Author: "Author 1"}, "Author 1")
+	assert.NoError(t, err)
+	_, err = posts.UpdatePosts(ctx, Post{ID: 1, Title: "Title 2", Content: content, Author: "Author 1"}, "Author 1")
+	assert.NoError(t, err)
+	_, err = posts.DeletePosts(ctx, 1, "Author 1")
+	assert.NoError(t, err)
+
+	var types []string
+	for len(sub.Events) > 0 {
+		e := <-sub.Events
+		assert.Equal(t, 1, e.PostID)
+		types = append(types, e.Type)
+	}
+	assert.Equal(t, []string{EventPostCreated, EventPostUpdated, EventPostDeleted}, types)
+}
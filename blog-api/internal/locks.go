@@ -0,0 +1,175 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+var (
+	ErrPostLocked   = newError("post_locked", http.StatusLocked, "", "post is being edited by someone else")
+	ErrLockNotHeld  = newError("lock_not_held", http.StatusConflict, "", "lock is not held by you or has expired")
+	ErrLockNotFound = newError("lock_not_found", http.StatusNotFound, "", "post is not locked")
+)
+
+// PostLockedError is returned while another author holds the edit lock of a post
+type PostLockedError struct {
+	Lock EditLock
+}
+
+func (e *PostLockedError) Error() string {
+	return ErrPostLocked.Error()
+}
+
+// Unwrap makes errors.Is(err, ErrPostLocked) work
+func (e *PostLockedError) Unwrap() error {
+	return ErrPostLocked
+}
+
+// EditLock is a lease on editing a post, it expires unless the holder renews it
+type EditLock struct {
+	PostID     int       `json:"post_id"`
+	Holder     string    `json:"holder"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// EditLocker tells the PostService whether an author may change a post
+type EditLocker interface {
+	Check(postID int, author string) error
+}
+
+// LockService hands out edit locks on posts
+type LockService struct {
+	locks  map[int]*EditLock
+	ttl    time.Duration
+	clock  Clock
+	mutex  sync.Mutex // Protects access to locks
+	logger *zerolog.Logger
+}
+
+// NewLockService creates a new lock service, locks expire ttl after they were acquired or last renewed
+func NewLockService(ttl time.Duration, clock Clock, logger *zerolog.Logger) (*LockService, error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("edit locks need a positive lifetime")
+	}
+	if clock == nil {
+		clock = SystemClock{}
+	}
+	return &LockService{
+		locks:  make(map[int]*EditLock),
+		ttl:    ttl,
+		clock:  clock,
+		logger: logger,
+	}, nil
+}
+
+// active returns the lock of the post unless there is none or it has expired, it is called while holding the mutex
+func (l *LockService) active(postID int) *EditLock {
+	lock, ok := l.locks[postID]
+	if !ok {
+		return nil
+	}
+	if !l.clock.Now().Before(lock.ExpiresAt) {
+		delete(l.locks, postID)
+		return nil
+	}
+	return lock
+}
+
+// Acquire locks the post for the author, acquiring a lock the author already holds renews it
+func (l *LockService) Acquire(postID int, author string) (*EditLock, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.clock.Now()
+	lock := l.active(postID)
+	if lock != nil && lock.Holder != author {
+		return nil, &PostLockedError{Lock: *lock}
+	}
+	if lock == nil {
+		lock = &EditLock{PostID: postID, Holder: author, AcquiredAt: now}
+		l.locks[postID] = lock
+	}
+	lock.ExpiresAt = now.Add(l.ttl)
+
+	result := *lock
+	return &result, nil
+}
+
+// Renew extends the lock of the holder, it fails once the lock has expired or was broken
+func (l *LockService) Renew(postID int, author string) (*EditLock, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	lock := l.active(postID)
+	if lock == nil || lock.Holder != author {
+		return nil, ErrLockNotHeld
+	}
+	lock.ExpiresAt = l.clock.Now().Add(l.ttl)
+
+	result := *lock
+	return &result, nil
+}
+
+// Release gives up the lock of the holder
+func (l *LockService) Release(postID int, author string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	lock := l.active(postID)
+	if lock == nil || lock.Holder != author {
+		return ErrLockNotHeld
+	}
+	delete(l.locks, postID)
+	return nil
+}
+
+// Break removes the lock whoever holds it, e.g. when its holder left without releasing it
+func (l *LockService) Break(postID int, author string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	lock := l.active(postID)
+	if lock == nil {
+		return ErrLockNotFound
+	}
+	delete(l.locks, postID)
+	l.logger.Info().Int("post", postID).Str("holder", lock.Holder).Str("by", author).Msg("edit lock broken")
+	return nil
+}
+
+// Get returns the active lock of the post
+func (l *LockService) Get(postID int) (*EditLock, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	lock := l.active(postID)
+	if lock == nil {
+		return nil, ErrLockNotFound
+	}
+	result := *lock
+	return &result, nil
+}
+
+// Check returns a PostLockedError when somebody else than the author holds the lock of the post
+func (l *LockService) Check(postID int, author string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if lock := l.active(postID); lock != nil && lock.Holder != author {
+		return &PostLockedError{Lock: *lock}
+	}
+	return nil
+}
+
+// DeletePostLock removes the lock of a deleted post
+func (l *LockService) DeletePostLock(post Post) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	delete(l.locks, post.ID)
+}
@@ -0,0 +1,77 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEditLocks(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	locks, _ := NewLockService(time.Minute, clock, &logger)
+
+	lock, err := locks.Acquire(1, "Author 1")
+	assert.NoError(t, err)
+	assert.Equal(t, clock.now.Add(time.Minute), lock.ExpiresAt)
+
+	// Others are told who holds the lock
+	_, err = locks.Acquire(1, "Author 2")
+	var locked *PostLockedError
+	assert.True(t, errors.As(err, &locked))
+	assert.Equal(t, "Author 1", locked.Lock.Holder)
+	assert.True(t, errors.Is(locks.Check(1, "Author 2"), ErrPostLocked))
+	assert.NoError(t, locks.Check(1, "Author 1"))
+	assert.Equal(t, ErrLockNotHeld, locks.Release(1, "Author 2"))
+
+	// Heartbeats keep the lock
+	clock.Advance(time.Second * 50)
+	lock, err = locks.Renew(1, "Author 1")
+	assert.NoError(t, err)
+	assert.Equal(t, clock.now.Add(time.Minute), lock.ExpiresAt)
+	clock.Advance(time.Second * 50)
+	assert.Error(t, locks.Check(1, "Author 2"))
+
+	// Without them it expires and the renewal fails
+	clock.Advance(time.Minute)
+	assert.NoError(t, locks.Check(1, "Author 2"))
+	_, err = locks.Renew(1, "Author 1")
+	assert.Equal(t, ErrLockNotHeld, err)
+	_, err = locks.Get(1)
+	assert.Equal(t, ErrLockNotFound, err)
+
+	// Released and broken locks are gone
+	locks.Acquire(1, "Author 2")
+	assert.NoError(t, locks.Release(1, "Author 2"))
+	locks.Acquire(1, "Author 2")
+	assert.NoError(t, locks.Break(1, "admin"))
+	assert.Equal(t, ErrLockNotFound, locks.Break(1, "admin"))
+}
+
+func TestUpdatePostsWithEditLock(t *testing.T) {
+	ctx := context.Background()
+	logger := zerolog.New(os.Stdout)
+	p := map[string]map[int]Post{"Author 1": {}}
+	posts, _ := NewPostsService(&p, &logger)
+	locks, _ := NewLockService(time.Minute, nil, &logger)
+	posts.UseLocks(locks)
+	content := strings.Repeat("Lorem ipsum dolor sit amet. ", 5)
+	posts.CreatePosts(ctx, Post{Title: "Title 1", Content: content, Author: "Author 1"}, "Author 1")
+
+	// Admin holds the lock, so the author has to wait
+	locks.Acquire(1, "admin")
+	_, err := posts.UpdatePosts(ctx, Post{ID: 1, Title: "Title 2", Content: content, Author: "Author 1"}, "Author 1")
+	assert.True(t, errors.Is(err, ErrPostLocked))
+	_, err = posts.UpdatePosts(ctx, Post{ID: 1, Title: "Title 2", Content: content, Author: "Author 1"}, "admin")
+	assert.NoError(t, err)
+
+	locks.Release(1, "admin")
+	_, err = posts.UpdatePosts(ctx, Post{ID: 1, Title: "Title 3", Content: content, Author: "Author 1"}, "Author 1")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,208 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+var ErrLoginLocked = newError("login_locked", http.StatusTooManyRequests, "", "too many failed login attempts, try again later")
+
+// LockoutError is returned while an account or IP address is not allowed to attempt a login
+type LockoutError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockoutError) Error() string {
+	return ErrLoginLocked.Error()
+}
+
+// Unwrap makes errors.Is(err, ErrLoginLocked) work
+func (e *LockoutError) Unwrap() error {
+	return ErrLoginLocked
+}
+
+// LoginGuardConfig sets how quickly failed logins are slowed down and locked out
+type LoginGuardConfig struct {
+	// Failures per account before it is locked out
+	MaxAccountAttempts int
+	// Failures per IP address before it is locked out, higher since many authors can share an IP
+	MaxIPAttempts int
+	// Delay after the first failure, doubled on every following failure
+	BaseDelay time.Duration
+	// Upper bound of the delay between two attempts
+	MaxDelay time.Duration
+	// How long a lockout lasts, failures older than this are forgotten
+	LockoutPeriod time.Duration
+}
+
+// DefaultLoginGuardConfig is the configuration used by the server
+var DefaultLoginGuardConfig = LoginGuardConfig{
+	MaxAccountAttempts: 5,
+	MaxIPAttempts:      20,
+	BaseDelay:          time.Second,
+	MaxDelay:           time.Minute,
+	LockoutPeriod:      time.Minute * 15,
+}
+
+// loginAttempts tracks the failed logins of one account or IP address
+type loginAttempts struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+// Keep the failed logins per account and per IP address
+type LoginGuard struct {
+	config    LoginGuardConfig
+	accounts  map[string]*loginAttempts
+	ips       map[string]*loginAttempts
+	lastSweep time.Time
+	clock     Clock
+	mutex     sync.Mutex // Protects access to accounts, ips and lastSweep
+	logger    *zerolog.Logger
+}
+
+// NewLoginGuard creates a new login guard
+func NewLoginGuard(config LoginGuardConfig, clock Clock, logger *zerolog.Logger) (*LoginGuard, error) {
+	if config.MaxAccountAttempts < 1 || config.MaxIPAttempts < 1 {
+		return nil, fmt.Errorf("login guard needs at least one attempt per account and IP address")
+	}
+	if clock == nil {
+		clock = SystemClock{}
+	}
+	return &LoginGuard{
+		config:   config,
+		accounts: make(map[string]*loginAttempts),
+		ips:      make(map[string]*loginAttempts),
+		clock:    clock,
+		logger:   logger,
+	}, nil
+}
+
+// backoff returns the delay before the next attempt is allowed after the given number of failures
+func (g *LoginGuard) backoff(failures int) time.Duration {
+	delay := g.config.BaseDelay
+	for i := 1; i < failures; i++ {
+		delay *= 2
+		if delay >= g.config.MaxDelay {
+			return g.config.MaxDelay
+		}
+	}
+	return delay
+}
+
+// blocked returns how long the attempts are still blocked for
+func blocked(attempts *loginAttempts, now time.Time) time.Duration {
+	if attempts == nil || !attempts.blockedUntil.After(now) {
+		return 0
+	}
+	return attempts.blockedUntil.Sub(now)
+}
+
+// expired returns whether the attempts are forgotten, they are not blocked and their failures are too old to count
+func (g *LoginGuard) expired(attempts *loginAttempts, now time.Time) bool {
+	return !attempts.blockedUntil.After(now) && now.Sub(attempts.lastFailure) > g.config.LockoutPeriod
+}
+
+// sweep forgets the expired attempts once in a while, so every new author name or IP address does not stay in
+// memory forever. It is called while holding the mutex
+func (g *LoginGuard) sweep(now time.Time) {
+	if now.Sub(g.lastSweep) < time.Minute {
+		return
+	}
+	for _, m := range []map[string]*loginAttempts{g.accounts, g.ips} {
+		for key, attempts := range m {
+			if g.expired(attempts, now) {
+				delete(m, key)
+			}
+		}
+	}
+	g.lastSweep = now
+}
+
+// Check returns a LockoutError if the account or the IP address may not attempt a login right now, an empty
+// author only checks the IP address
+func (g *LoginGuard) Check(author string, ip string) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	now := g.clock.Now()
+	var wait time.Duration
+	if author != "" {
+		wait = blocked(g.accounts[author], now)
+	}
+	if ipWait := blocked(g.ips[ip], now); ipWait > wait {
+		wait = ipWait
+	}
+	if wait > 0 {
+		return &LockoutError{RetryAfter: wait}
+	}
+	return nil
+}
+
+// fail records a failure and blocks further attempts with exponential backoff or a lockout
+func (g *LoginGuard) fail(m map[string]*loginAttempts, key string, max int, now time.Time) *loginAttempts {
+	attempts, ok := m[key]
+	if !ok {
+		attempts = &loginAttempts{}
+		m[key] = attempts
+	}
+	// Forget failures which are older than the lockout period
+	if g.expired(attempts, now) {
+		attempts.failures = 0
+	}
+	attempts.failures++
+	attempts.lastFailure = now
+	if attempts.failures >= max {
+		attempts.blockedUntil = now.Add(g.config.LockoutPeriod)
+	} else {
+		attempts.blockedUntil = now.Add(g.backoff(attempts.failures))
+	}
+	return attempts
+}
+
+// RecordFailure records a failed login for the account and the IP address, an empty author is a failed login
+// which never got as far as naming an account and only counts against the IP address
+func (g *LoginGuard) RecordFailure(author string, ip string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	now := g.clock.Now()
+	g.sweep(now)
+	if author != "" {
+		account := g.fail(g.accounts, author, g.config.MaxAccountAttempts, now)
+		if account.failures == g.config.MaxAccountAttempts {
+			g.logger.Warn().Str("author", author).Msg("account locked after too many failed logins")
+		}
+	}
+	address := g.fail(g.ips, ip, g.config.MaxIPAttempts, now)
+	if address.failures == g.config.MaxIPAttempts {
+		g.logger.Warn().Str("ip", ip).Msg("ip address locked after too many failed logins")
+	}
+}
+
+// RecordSuccess forgets the failed logins of the account, the IP address keeps its count so one
+// valid account cannot be used to keep guessing the passwords of others
+func (g *LoginGuard) RecordSuccess(author string, ip string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	delete(g.accounts, author)
+}
+
+// Unlock lifts the lockout of an account and an IP address, empty values are ignored
+func (g *LoginGuard) Unlock(author string, ip string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if author != "" {
+		delete(g.accounts, author)
+	}
+	if ip != "" {
+		delete(g.ips, ip)
+	}
+}
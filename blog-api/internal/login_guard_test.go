@@ -0,0 +1,127 @@
+package internal
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock is a Clock that only moves when told to
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestLoginGuard(t *testing.T) (*LoginGuard, *fakeClock) {
+	logger := zerolog.New(os.Stdout)
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	guard, err := NewLoginGuard(LoginGuardConfig{
+		MaxAccountAttempts: 3,
+		MaxIPAttempts:      5,
+		BaseDelay:          time.Second,
+		MaxDelay:           time.Second * 4,
+		LockoutPeriod:      time.Minute * 10,
+	}, clock, &logger)
+	assert.NoError(t, err)
+	return guard, clock
+}
+
+func TestLoginGuardBackoff(t *testing.T) {
+	guard, clock := newTestLoginGuard(t)
+
+	assert.NoError(t, guard.Check("Author 1", "10.0.0.1"))
+
+	// First failure blocks for the base delay
+	guard.RecordFailure("Author 1", "10.0.0.1")
+	err := guard.Check("Author 1", "10.0.0.1")
+	var lockout *LockoutError
+	assert.True(t, errors.As(err, &lockout))
+	assert.Equal(t, time.Second, lockout.RetryAfter)
+	assert.True(t, errors.Is(err, ErrLoginLocked))
+
+	// Second failure doubles the delay
+	clock.Advance(time.Second)
+	assert.NoError(t, guard.Check("Author 1", "10.0.0.1"))
+	guard.RecordFailure("Author 1", "10.0.0.1")
+	err = guard.Check("Author 1", "10.0.0.1")
+	assert.True(t, errors.As(err, &lockout))
+	assert.Equal(t, time.Second*2, lockout.RetryAfter)
+}
+
+func TestLoginGuardLockout(t *testing.T) {
+	guard, clock := newTestLoginGuard(t)
+
+	for i := 0; i < 3; i++ {
+		guard.RecordFailure("Author 1", "10.0.0.1")
+	}
+
+	// The account is locked for the lockout period from any IP address
+	err := guard.Check("Author 1", "10.0.0.2")
+	var lockout *LockoutError
+	assert.True(t, errors.As(err, &lockout))
+	assert.Equal(t, time.Minute*10, lockout.RetryAfter)
+
+	// Other accounts can still log in after the IP backoff has passed
+	clock.Advance(time.Second * 4)
+	assert.NoError(t, guard.Check("Author 2", "10.0.0.1"))
+
+	// The lockout ends after the lockout period
+	clock.Advance(time.Minute * 10)
+	assert.NoError(t, guard.Check("Author 1", "10.0.0.2"))
+}
+
+func TestLoginGuardSuccessAndUnlock(t *testing.T) {
+	guard, clock := newTestLoginGuard(t)
+
+	guard.RecordFailure("Author 1", "10.0.0.1")
+	guard.RecordFailure("Author 1", "10.0.0.1")
+	clock.Advance(time.Second * 2)
+	guard.RecordSuccess("Author 1", "10.0.0.1")
+
+	// The account counter is reset, a new failure starts from the base delay again
+	guard.RecordFailure("Author 1", "10.0.0.3")
+	err := guard.Check("Author 1", "10.0.0.3")
+	var lockout *LockoutError
+	assert.True(t, errors.As(err, &lockout))
+	assert.Equal(t, time.Second, lockout.RetryAfter)
+
+	// Admin can lift the lockout
+	for i := 0; i < 5; i++ {
+		guard.RecordFailure("Author 1", "10.0.0.1")
+	}
+	assert.Error(t, guard.Check("Author 3", "10.0.0.1"))
+	guard.Unlock("Author 1", "10.0.0.1")
+	assert.NoError(t, guard.Check("Author 1", "10.0.0.1"))
+}
+
+func TestLoginGuardForgetsExpiredAttempts(t *testing.T) {
+	guard, clock := newTestLoginGuard(t)
+
+	for i := 0; i < 3; i++ {
+		guard.RecordFailure("Author 1", "10.0.0.1")
+	}
+	guard.RecordFailure("Author 2", "10.0.0.2")
+
+	// Still locked, nothing is forgotten yet
+	clock.Advance(time.Minute * 5)
+	guard.RecordFailure("Author 3", "10.0.0.3")
+	assert.Len(t, guard.accounts, 3)
+
+	// The lockout and the failures of the first authors expired, only the last failure is kept
+	clock.Advance(time.Minute * 6)
+	guard.RecordFailure("Author 3", "10.0.0.3")
+	assert.Len(t, guard.accounts, 1)
+	assert.Len(t, guard.ips, 1)
+	assert.NoError(t, guard.Check("Author 1", "10.0.0.1"))
+}
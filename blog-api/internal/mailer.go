@@ -0,0 +1,120 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is an email sent to an author
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers emails to authors
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// SMTPMailer sends emails through an SMTP server, with PLAIN authentication when a username is set
+type SMTPMailer struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+// NewSMTPMailer creates a new SMTP mailer
+func NewSMTPMailer(addr string, from string, username string, password string) (*SMTPMailer, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, fmt.Errorf("invalid smtp address: %w", err)
+	}
+	if from == "" {
+		return nil, fmt.Errorf("smtp sender must not be empty")
+	}
+	return &SMTPMailer{
+		Addr:     addr,
+		From:     from,
+		Username: username,
+		Password: password,
+	}, nil
+}
+
+// formatMessage returns the message with the headers needed for delivery
+func formatMessage(from string, msg Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
+
+// Send delivers the message, smtp.SendMail does not take a context so it is only checked before sending
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if m.Username != "" {
+		host, _, _ := net.SplitHostPort(m.Addr)
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+	return smtp.SendMail(m.Addr, auth, m.From, []string{msg.To}, formatMessage(m.From, msg))
+}
+
+// LogMailer writes emails to a writer instead of sending them and keeps them in memory,
+// for tests and local development
+type LogMailer struct {
+	w        io.Writer
+	messages []Message
+	mutex    sync.Mutex // Protects access to w and messages
+}
+
+// NewLogMailer creates a new mailer writing to w
+func NewLogMailer(w io.Writer) *LogMailer {
+	return &LogMailer{w: w}
+}
+
+// NewFileMailer creates a new mailer appending to the file at path
+func NewFileMailer(path string) (*LogMailer, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening mail file: %w", err)
+	}
+	return NewLogMailer(file), nil
+}
+
+// Send writes the message
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.messages = append(m.messages, msg)
+	if m.w == nil {
+		return nil
+	}
+	_, err := fmt.Fprintf(m.w, "%s\n", formatMessage("blog-api", msg))
+	return err
+}
+
+// Messages returns the messages sent so far
+func (m *LogMailer) Messages() []Message {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return append([]Message(nil), m.messages...)
+}
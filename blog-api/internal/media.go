@@ -0,0 +1,538 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+var (
+	ErrMediaNotFound = newError("media_not_found", http.StatusNotFound, "", "media not found")
+	ErrMediaEmpty    = newError("media_empty", http.StatusBadRequest, "file", "media must not be empty")
+	ErrMediaTooLarge = newError("media_too_large", http.StatusRequestEntityTooLarge, "file", "media is too large")
+	ErrMediaType     = newError("media_type", http.StatusUnsupportedMediaType, "file", "media must be a JPEG, PNG, GIF or WebP image")
+	ErrMediaInvalid  = newError("media_invalid", http.StatusBadRequest, "media", "a post can reference at most 20 media by their SHA-256 hash")
+)
+
+// Media is referenced by the hex SHA-256 hash of its content
+var mediaHashPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Content types which can be uploaded, the type is sniffed from the content and not taken from the client
+var mediaTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// Media is an uploaded file, posts reference it by its hash
+type Media struct {
+	Hash        string    `json:"hash"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	Uploader    string    `json:"uploader"`
+	CreatedAt   time.Time `json:"created_at"`
+	Variants    []Variant `json:"variants,omitempty"`
+}
+
+// mediaEntry tracks which posts reference the media
+type mediaEntry struct {
+	Media
+	posts             map[int]bool
+	unreferencedSince time.Time
+}
+
+// MediaLinker tells the PostService whether the media of a post exist, and keeps track of which posts use them
+type MediaLinker interface {
+	Link(postID int, hashes []string) error
+}
+
+// MediaService stores uploads once per content and deletes them when no post uses them anymore.
+// The media are kept in the index file at path, which posts reference them is not, posts link their media again
+// when they are loaded
+type MediaService struct {
+	store     BlobStore
+	maxSize   int64
+	path      string
+	media     map[string]*mediaEntry
+	postMedia map[int][]string // media referenced by each post
+	onUpload  []func(media Media)
+	clock     Clock
+	mutex     sync.Mutex // Protects access to media and postMedia
+	logger    *zerolog.Logger
+}
+
+// NewMediaService creates a new media service storing uploads of at most maxSize bytes in the store and their
+// index at path, with an empty path the index is only kept in memory
+func NewMediaService(store BlobStore, maxSize int64, path string, clock Clock, logger *zerolog.Logger) (*MediaService, error) {
+	if store == nil || maxSize < 1 {
+		return nil, fmt.Errorf("media service needs a blob store and a maximum size")
+	}
+	if clock == nil {
+		clock = SystemClock{}
+	}
+	m := &MediaService{
+		store:     store,
+		maxSize:   maxSize,
+		path:      path,
+		media:     make(map[string]*mediaEntry),
+		postMedia: make(map[int][]string),
+		clock:     clock,
+		logger:    logger,
+	}
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// load reads the index and adds the blobs missing from it, e.g. when the server stopped between storing an upload
+// and writing the index. Nothing references the media yet, so they are collected after the grace period unless
+// a post links them
+func (m *MediaService) load() error {
+	var media []Media
+	if m.path != "" {
+		data, err := os.ReadFile(m.path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if err == nil {
+			if err := json.Unmarshal(data, &media); err != nil {
+				return fmt.Errorf("reading media index: %w", err)
+			}
+		}
+	}
+	now := m.clock.Now()
+	for _, item := range media {
+		m.media[item.Hash] = &mediaEntry{Media: item, posts: make(map[int]bool), unreferencedSince: now}
+	}
+
+	keys, err := m.store.Keys()
+	if err != nil {
+		return err
+	}
+	variants := make(map[string]bool)
+	for _, entry := range m.media {
+		for _, variant := range entry.Variants {
+			variants[variantKey(entry.Hash, variant.Width)] = true
+		}
+	}
+	changed := false
+	for _, key := range keys {
+		if m.media[key] != nil || variants[key] {
+			continue
+		}
+		// Thumbnails without media are deleted, media without an entry get one
+		if !mediaHashPattern.MatchString(key) {
+			if err := m.store.Delete(key); err != nil {
+				return err
+			}
+			continue
+		}
+		entry, err := m.rebuild(key, now)
+		if err != nil {
+			return err
+		}
+		m.media[key] = entry
+		changed = true
+	}
+	if changed {
+		m.save()
+	}
+	return nil
+}
+
+// rebuild makes an entry for a blob which is missing from the index
+func (m *MediaService) rebuild(hash string, now time.Time) (*mediaEntry, error) {
+	content, err := m.store.Open(hash)
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+	size, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	head := make([]byte, 512)
+	n, err := io.ReadFull(content, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return &mediaEntry{
+		Media: Media{
+			Hash:        hash,
+			ContentType: http.DetectContentType(head[:n]),
+			Size:        size,
+			CreatedAt:   now,
+		},
+		posts:             make(map[int]bool),
+		unreferencedSince: now,
+	}, nil
+}
+
+// save writes the index, it must be called while holding the mutex. A failed write is only logged, the blobs
+// missing from the index are added again when the server starts
+func (m *MediaService) save() {
+	if m.path == "" {
+		return
+	}
+	media := make([]Media, 0, len(m.media))
+	for _, entry := range m.media {
+		media = append(media, entry.Media)
+	}
+	sort.Slice(media, func(i, j int) bool {
+		return media[i].Hash < media[j].Hash
+	})
+	data, err := json.Marshal(media)
+	if err == nil {
+		err = writeFile(m.path, data)
+	}
+	if err != nil {
+		m.logger.Error().Err(err).Msg("error saving media index")
+	}
+}
+
+// MaxSize returns the size of the largest upload in bytes
+func (m *MediaService) MaxSize() int64 {
+	return m.maxSize
+}
+
+// normalizeMedia lowercases the hashes of the media of a post, removes duplicates and validates them
+func normalizeMedia(hashes []string) ([]string, error) {
+	if len(hashes) == 0 {
+		return nil, nil
+	}
+	seen := make(map[string]bool)
+	result := make([]string, 0, len(hashes))
+	for _, hash := range hashes {
+		hash = strings.ToLower(strings.TrimSpace(hash))
+		if !mediaHashPattern.MatchString(hash) {
+			return nil, ErrMediaInvalid
+		}
+		if !seen[hash] {
+			seen[hash] = true
+			result = append(result, hash)
+		}
+	}
+	if len(result) > 20 {
+		return nil, ErrMediaInvalid
+	}
+	return result, nil
+}
+
+// Upload stores the content unless the same content was uploaded before, created is false for a duplicate.
+// A duplicate without variants runs the upload hooks again, so thumbnails which were not made are retried
+func (m *MediaService) Upload(r io.Reader, uploader string) (*Media, bool, error) {
+	data, err := io.ReadAll(io.LimitReader(r, m.maxSize+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if len(data) == 0 {
+		return nil, false, ErrMediaEmpty
+	}
+	if int64(len(data)) > m.maxSize {
+		return nil, false, ErrMediaTooLarge
+	}
+	contentType := http.DetectContentType(data)
+	if !mediaTypes[contentType] {
+		return nil, false, ErrMediaType
+	}
+	// The hash is of the stored content, so the same photo with other metadata is stored once
+	data = stripMetadata(contentType, data)
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	m.mutex.Lock()
+	if entry, ok := m.media[hash]; ok {
+		result := m.reuse(entry)
+		m.mutex.Unlock()
+		m.uploadedAgain(result)
+		return &result, false, nil
+	}
+	m.mutex.Unlock()
+	// The blob is stored without holding the mutex, an upload of the same content at the same time writes the
+	// same blob and the first one to add its entry wins
+	if err := m.store.Put(hash, bytes.NewReader(data)); err != nil {
+		return nil, false, err
+	}
+
+	m.mutex.Lock()
+	if entry, ok := m.media[hash]; ok {
+		result := m.reuse(entry)
+		m.mutex.Unlock()
+		m.uploadedAgain(result)
+		return &result, false, nil
+	}
+	now := m.clock.Now()
+	entry := &mediaEntry{
+		Media: Media{
+			Hash:        hash,
+			ContentType: contentType,
+			Size:        int64(len(data)),
+			Uploader:    uploader,
+			CreatedAt:   now,
+		},
+		posts: make(map[int]bool),
+		// Not used by a post yet, it is collected when it is not referenced in time
+		unreferencedSince: now,
+	}
+	m.media[hash] = entry
+	m.save()
+	hooks := m.onUpload
+	// The thumbnailer adds variants to the entry as soon as the mutex is released
+	result := entry.copy()
+	m.mutex.Unlock()
+
+	for _, hook := range hooks {
+		hook(result)
+	}
+	return &result, true, nil
+}
+
+// reuse returns the media of a duplicate upload. Media no post references yet gets the whole grace period again,
+// so it is not collected right after it was uploaded again. It is called while holding the mutex
+func (m *MediaService) reuse(entry *mediaEntry) Media {
+	if len(entry.posts) == 0 {
+		entry.unreferencedSince = m.clock.Now()
+		m.save()
+	}
+	return entry.copy()
+}
+
+// uploadedAgain runs the upload hooks for a duplicate upload of media without variants
+func (m *MediaService) uploadedAgain(media Media) {
+	if len(media.Variants) > 0 {
+		return
+	}
+	m.mutex.Lock()
+	hooks := m.onUpload
+	m.mutex.Unlock()
+
+	for _, hook := range hooks {
+		hook(media)
+	}
+}
+
+// copy returns the media with its own slice of variants
+func (e *mediaEntry) copy() Media {
+	result := e.Media
+	result.Variants = append([]Variant(nil), e.Variants...)
+	return result
+}
+
+// variantKey is the key a variant is stored under
+func variantKey(hash string, width int) string {
+	return fmt.Sprintf("%s-w%d", hash, width)
+}
+
+// OnUpload registers a function which is called after new media is uploaded, e.g. to make thumbnails, and again
+// when media without variants is uploaded again
+func (m *MediaService) OnUpload(fn func(media Media)) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.onUpload = append(m.onUpload, fn)
+}
+
+// AddVariant stores a smaller version of the media
+func (m *MediaService) AddVariant(hash string, variant Variant, r io.Reader) error {
+	key := variantKey(hash, variant.Width)
+	if err := m.store.Put(key, r); err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	entry, ok := m.media[hash]
+	if !ok {
+		// Collected while the variant was made
+		return m.store.Delete(key)
+	}
+	for i := range entry.Variants {
+		if entry.Variants[i].Width == variant.Width {
+			entry.Variants[i] = variant
+			m.save()
+			return nil
+		}
+	}
+	entry.Variants = append(entry.Variants, variant)
+	sort.Slice(entry.Variants, func(i, j int) bool {
+		return entry.Variants[i].Width < entry.Variants[j].Width
+	})
+	m.save()
+	return nil
+}
+
+// Get returns the media with its variants
+func (m *MediaService) Get(hash string) (*Media, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	entry, ok := m.media[hash]
+	if !ok {
+		return nil, ErrMediaNotFound
+	}
+	result := entry.copy()
+	return &result, nil
+}
+
+// Open returns the media and its content, the caller closes the content
+func (m *MediaService) Open(hash string) (*Media, io.ReadSeekCloser, error) {
+	m.mutex.Lock()
+	entry, ok := m.media[hash]
+	m.mutex.Unlock()
+	if !ok {
+		return nil, nil, ErrMediaNotFound
+	}
+
+	content, err := m.store.Open(hash)
+	if err == ErrBlobNotFound {
+		return nil, nil, ErrMediaNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	result := entry.copy()
+	return &result, content, nil
+}
+
+// OpenVariant returns a variant of the media and its content, the caller closes the content
+func (m *MediaService) OpenVariant(hash string, width int) (*Variant, io.ReadSeekCloser, error) {
+	m.mutex.Lock()
+	var variant *Variant
+	if entry, ok := m.media[hash]; ok {
+		for i := range entry.Variants {
+			if entry.Variants[i].Width == width {
+				v := entry.Variants[i]
+				variant = &v
+			}
+		}
+	}
+	m.mutex.Unlock()
+	if variant == nil {
+		return nil, nil, ErrMediaNotFound
+	}
+
+	content, err := m.store.Open(variantKey(hash, width))
+	if err == ErrBlobNotFound {
+		return nil, nil, ErrMediaNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return variant, content, nil
+}
+
+// Link replaces the media referenced by the post, it fails without changing anything when a media does not exist
+func (m *MediaService) Link(postID int, hashes []string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, hash := range hashes {
+		if _, ok := m.media[hash]; !ok {
+			return ErrMediaNotFound
+		}
+	}
+	m.unlink(postID)
+	for _, hash := range hashes {
+		m.media[hash].posts[postID] = true
+	}
+	if len(hashes) > 0 {
+		m.postMedia[postID] = hashes
+	}
+	return nil
+}
+
+// unlink removes the references of the post, it is called while holding the mutex
+func (m *MediaService) unlink(postID int) {
+	now := m.clock.Now()
+	for _, hash := range m.postMedia[postID] {
+		entry, ok := m.media[hash]
+		if !ok {
+			continue
+		}
+		delete(entry.posts, postID)
+		if len(entry.posts) == 0 {
+			entry.unreferencedSince = now
+		}
+	}
+	delete(m.postMedia, postID)
+}
+
+// DeletePostMedia removes the references of a deleted post, it is registered with PostService.OnDelete
+func (m *MediaService) DeletePostMedia(post Post) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.unlink(post.ID)
+}
+
+// CollectGarbage deletes the media no post has referenced for longer than grace, and returns how many were deleted.
+// The grace period leaves time to reference new uploads
+func (m *MediaService) CollectGarbage(grace time.Duration) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	deleted := 0
+	defer func() {
+		if deleted > 0 {
+			m.save()
+		}
+	}()
+	cutoff := m.clock.Now().Add(-grace)
+	for hash, entry := range m.media {
+		if len(entry.posts) > 0 || entry.unreferencedSince.After(cutoff) {
+			continue
+		}
+		for _, variant := range entry.Variants {
+			if err := m.store.Delete(variantKey(hash, variant.Width)); err != nil {
+				return deleted, err
+			}
+		}
+		if err := m.store.Delete(hash); err != nil {
+			return deleted, err
+		}
+		delete(m.media, hash)
+		deleted++
+	}
+	return deleted, nil
+}
+
+// Run collects garbage every interval until the context is done
+func (m *MediaService) Run(ctx context.Context, interval time.Duration, grace time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			deleted, err := m.CollectGarbage(grace)
+			if err != nil {
+				m.logger.Error().Err(err).Msg("error collecting unreferenced media")
+			}
+			if deleted > 0 {
+				m.logger.Info().Int("deleted", deleted).Msg("collected unreferenced media")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
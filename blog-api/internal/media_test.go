@@ -0,0 +1,175 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+// testPNG starts with the PNG signature, which is all content type sniffing looks at
+var testPNG = append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{1}, 100)...)
+
+func TestDiskBlobStore(t *testing.T) {
+	store, err := NewDiskBlobStore(t.TempDir())
+	assert.NoError(t, err)
+
+	assert.NoError(t, store.Put("abcdef", strings.NewReader("content")))
+	blob, err := store.Open("abcdef")
+	assert.NoError(t, err)
+	data, _ := io.ReadAll(blob)
+	blob.Close()
+	assert.Equal(t, "content", string(data))
+
+	assert.NoError(t, store.Delete("abcdef"))
+	assert.NoError(t, store.Delete("abcdef"))
+	_, err = store.Open("abcdef")
+	assert.Equal(t, ErrBlobNotFound, err)
+}
+
+func TestMediaUpload(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	store, _ := NewDiskBlobStore(t.TempDir())
+	media, _ := NewMediaService(store, 200, "", nil, &logger)
+
+	uploaded, created, err := media.Upload(bytes.NewReader(testPNG), "Author 1")
+	assert.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, "image/png", uploaded.ContentType)
+	assert.Len(t, uploaded.Hash, 64)
+
+	// The same content is stored once
+	duplicate, created, err := media.Upload(bytes.NewReader(testPNG), "Author 2")
+	assert.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, uploaded.Hash, duplicate.Hash)
+	assert.Equal(t, "Author 1", duplicate.Uploader)
+
+	_, _, err = media.Upload(strings.NewReader("<html><script>alert(1)</script></html>"), "Author 1")
+	assert.Equal(t, ErrMediaType, err)
+	_, _, err = media.Upload(bytes.NewReader(append(testPNG, make([]byte, 200)...)), "Author 1")
+	assert.Equal(t, ErrMediaTooLarge, err)
+	_, _, err = media.Upload(strings.NewReader(""), "Author 1")
+	assert.Equal(t, ErrMediaEmpty, err)
+
+	_, content, err := media.Open(uploaded.Hash)
+	assert.NoError(t, err)
+	data, _ := io.ReadAll(content)
+	content.Close()
+	assert.Equal(t, testPNG, data)
+}
+
+func TestMediaIndex(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	dir := t.TempDir()
+	store, _ := NewDiskBlobStore(filepath.Join(dir, "media"))
+	index := filepath.Join(dir, "media.json")
+	media, err := NewMediaService(store, 1<<20, index, nil, &logger)
+	assert.NoError(t, err)
+	uploaded, _, _ := media.Upload(bytes.NewReader(testPNG), "Author 1")
+	assert.NoError(t, media.AddVariant(uploaded.Hash, Variant{Width: 10, ContentType: "image/png"}, bytes.NewReader(testPNG)))
+
+	// A blob stored without an entry in the index, and a thumbnail without media
+	other := strings.Repeat("a", 64)
+	assert.NoError(t, store.Put(other, bytes.NewReader(testPNG)))
+	assert.NoError(t, store.Put(variantKey(strings.Repeat("b", 64), 10), bytes.NewReader(testPNG)))
+
+	// The index survives a restart, the blobs missing from it are added and the thumbnail is deleted
+	media, err = NewMediaService(store, 1<<20, index, nil, &logger)
+	assert.NoError(t, err)
+	restored, err := media.Get(uploaded.Hash)
+	assert.NoError(t, err)
+	assert.Equal(t, "Author 1", restored.Uploader)
+	assert.Len(t, restored.Variants, 1)
+	rebuilt, err := media.Get(other)
+	assert.NoError(t, err)
+	assert.Equal(t, "image/png", rebuilt.ContentType)
+	assert.Equal(t, int64(len(testPNG)), rebuilt.Size)
+	keys, _ := store.Keys()
+	assert.Len(t, keys, 3)
+
+	// Posts which are there before the media service link their media
+	p := map[string]map[int]Post{"Author 1": {1: {ID: 1, Title: "Title 1", Author: "Author 1", Media: []string{uploaded.Hash}}}}
+	posts, _ := NewPostsService(&p, &logger)
+	posts.UseMedia(media)
+	deleted, err := media.CollectGarbage(0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	_, err = media.Get(uploaded.Hash)
+	assert.NoError(t, err)
+	_, err = media.Get(other)
+	assert.Equal(t, ErrMediaNotFound, err)
+}
+
+func TestMediaGarbageCollection(t *testing.T) {
+	ctx := context.Background()
+	logger := zerolog.New(os.Stdout)
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	store, _ := NewDiskBlobStore(t.TempDir())
+	media, _ := NewMediaService(store, 1<<20, "", clock, &logger)
+	uploaded, _, _ := media.Upload(bytes.NewReader(testPNG), "Author 1")
+
+	p := map[string]map[int]Post{"Author 1": {}}
+	posts, _ := NewPostsService(&p, &logger)
+	posts.UseMedia(media)
+	posts.OnDelete(media.DeletePostMedia)
+	content := strings.Repeat("Lorem ipsum dolor sit amet. ", 5)
+
+	// Posts only reference media which exist
+	_, err := posts.CreatePosts(ctx, Post{Title: "Title 1", Content: content, Author: "Author 1", Media: []string{strings.Repeat("0", 64)}}, "Author 1")
+	assert.Equal(t, ErrMediaNotFound, err)
+	_, err = posts.CreatePosts(ctx, Post{Title: "Title 1", Content: content, Author: "Author 1", Media: []string{"not a hash"}}, "Author 1")
+	assert.Equal(t, ErrMediaInvalid, err)
+	_, err = posts.CreatePosts(ctx, Post{Title: "Title 1", Content: content, Author: "Author 1", Media: []string{strings.ToUpper(uploaded.Hash)}}, "Author 1")
+	assert.NoError(t, err)
+	_, err = posts.CreatePosts(ctx, Post{Title: "Title 2", Content: content, Author: "Author 1", Media: []string{uploaded.Hash}}, "Author 1")
+	assert.NoError(t, err)
+
+	// Referenced media are kept
+	clock.Advance(time.Hour * 48)
+	deleted, _ := media.CollectGarbage(time.Hour * 24)
+	assert.Equal(t, 0, deleted)
+
+	// Once the last post using it is gone it is deleted after the grace period
+	_, err = posts.DeletePosts(ctx, 1, "Author 1")
+	assert.NoError(t, err)
+	_, err = posts.UpdatePosts(ctx, Post{ID: 2, Title: "Title 2", Content: content, Author: "Author 1"}, "Author 1")
+	assert.NoError(t, err)
+	clock.Advance(time.Hour)
+	deleted, _ = media.CollectGarbage(time.Hour * 24)
+	assert.Equal(t, 0, deleted)
+	clock.Advance(time.Hour * 24)
+	deleted, _ = media.CollectGarbage(time.Hour * 24)
+	assert.Equal(t, 1, deleted)
+	_, _, err = media.Open(uploaded.Hash)
+	assert.Equal(t, ErrMediaNotFound, err)
+}
+
+func TestMediaUploadedAgainIsKept(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	store, _ := NewDiskBlobStore(t.TempDir())
+	media, _ := NewMediaService(store, 1<<20, "", clock, &logger)
+	uploaded, _, _ := media.Upload(bytes.NewReader(testPNG), "Author 1")
+
+	// Uploading unreferenced media again starts its grace period again
+	clock.Advance(time.Hour * 23)
+	again, created, err := media.Upload(bytes.NewReader(testPNG), "Author 2")
+	assert.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, uploaded.Hash, again.Hash)
+	clock.Advance(time.Hour * 2)
+	deleted, _ := media.CollectGarbage(time.Hour * 24)
+	assert.Equal(t, 0, deleted)
+
+	clock.Advance(time.Hour * 23)
+	deleted, _ = media.CollectGarbage(time.Hour * 24)
+	assert.Equal(t, 1, deleted)
+}
@@ -0,0 +1,411 @@
+package internal
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/rs/zerolog"
+)
+
+var (
+	ErrOIDCTokenInvalid = newError("oidc_token_invalid", http.StatusUnauthorized, "", "id token is invalid")
+	ErrOIDCNoAuthor     = newError("oidc_no_author", http.StatusForbidden, "", "no author is linked to this identity")
+)
+
+// OIDCConfig is the configuration of the identity provider login
+type OIDCConfig struct {
+	// Issuer URL, the discovery document is read from Issuer + "/.well-known/openid-configuration"
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// Scopes requested besides openid
+	Scopes []string
+	// Create an author for identities which cannot be matched to an existing one
+	AutoProvision bool
+}
+
+// oidcDiscovery is the part of the discovery document used for the login
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// audience is the aud claim, which is either a string or an array of strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*a = multiple
+	return nil
+}
+
+// IDTokenClaims are the claims of an ID token used to find the author
+type IDTokenClaims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	ExpiresAt         int64    `json:"exp"`
+	IssuedAt          int64    `json:"iat"`
+	Nonce             string   `json:"nonce"`
+	Email             string   `json:"email"`
+	EmailVerified     bool     `json:"email_verified"`
+	Name              string   `json:"name"`
+	PreferredUsername string   `json:"preferred_username"`
+}
+
+// Valid is called by the jwt package after the signature is checked
+func (c *IDTokenClaims) Valid() error {
+	// Allow for a minute of clock skew with the identity provider
+	if time.Now().Add(-time.Minute).Unix() > c.ExpiresAt {
+		return fmt.Errorf("id token has expired")
+	}
+	if c.Subject == "" {
+		return fmt.Errorf("id token has no subject")
+	}
+	return nil
+}
+
+// NewPKCE returns a code verifier and its S256 code challenge (RFC 7636)
+func NewPKCE() (string, string, error) {
+	b, err := randomBytes(32)
+	if err != nil {
+		return "", "", err
+	}
+	verifier := base64.RawURLEncoding.EncodeToString(b)
+	return verifier, PKCEChallenge(verifier), nil
+}
+
+// PKCEChallenge returns the S256 code challenge of the verifier
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Log authors in through an OpenID Connect identity provider with the authorization code flow
+type OIDCProvider struct {
+	config     OIDCConfig
+	discovery  oidcDiscovery
+	client     *http.Client
+	authors    *AuthorService
+	keys       map[string]*rsa.PublicKey
+	identities map[string]string // subject -> author
+	path       string            // File the identities are kept in, only in memory when empty
+	mutex      sync.Mutex        // Protects access to keys and identities
+	logger     *zerolog.Logger
+}
+
+// NewOIDCProvider creates a new identity provider login, it reads the discovery document of the issuer and the
+// identities linked to authors from the file at path
+func NewOIDCProvider(ctx context.Context, config OIDCConfig, path string, authors *AuthorService, client *http.Client, logger *zerolog.Logger) (*OIDCProvider, error) {
+	if config.Issuer == "" || config.ClientID == "" || config.RedirectURL == "" {
+		return nil, fmt.Errorf("oidc login needs an issuer, a client id and a redirect url")
+	}
+	if authors == nil {
+		return nil, fmt.Errorf("oidc login needs an author service")
+	}
+	if client == nil {
+		client = &http.Client{Timeout: time.Second * 10}
+	}
+	p := &OIDCProvider{
+		config:     config,
+		client:     client,
+		authors:    authors,
+		keys:       make(map[string]*rsa.PublicKey),
+		identities: make(map[string]string),
+		path:       path,
+		logger:     logger,
+	}
+	if err := p.load(); err != nil {
+		return nil, err
+	}
+
+	var discovery oidcDiscovery
+	if err := p.getJSON(ctx, strings.TrimSuffix(config.Issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, fmt.Errorf("error reading oidc discovery document: %w", err)
+	}
+	if discovery.Issuer != config.Issuer {
+		return nil, fmt.Errorf("oidc discovery document is for issuer %q, expected %q", discovery.Issuer, config.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc discovery document is missing endpoints")
+	}
+	p.discovery = discovery
+	return p, nil
+}
+
+// load reads the identities file, a missing file means no identity is linked yet
+func (p *OIDCProvider) load() error {
+	if p.path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(p.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &p.identities); err != nil {
+		return fmt.Errorf("reading oidc identities: %w", err)
+	}
+	return nil
+}
+
+// link connects the subject to the author and writes the identities file, it must be called while holding the mutex.
+// A failed write is only logged, the author is logged in and linked again by email on the next login
+func (p *OIDCProvider) link(subject string, author string) {
+	p.identities[subject] = author
+	p.save()
+}
+
+// unlink forgets the subject, it must be called while holding the mutex
+func (p *OIDCProvider) unlink(subject string) {
+	delete(p.identities, subject)
+	p.save()
+}
+
+// save writes the identities file, it must be called while holding the mutex
+func (p *OIDCProvider) save() {
+	if p.path == "" {
+		return
+	}
+	data, err := json.Marshal(p.identities)
+	if err == nil {
+		err = writeFile(p.path, data)
+	}
+	if err != nil {
+		p.logger.Error().Err(err).Msg("error saving oidc identities")
+	}
+}
+
+// getJSON decodes the JSON response of a GET request
+func (p *OIDCProvider) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, url)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// AuthCodeURL returns the URL of the identity provider the author is sent to
+func (p *OIDCProvider) AuthCodeURL(state string, nonce string, challenge string) string {
+	values := url.Values{}
+	values.Set("response_type", "code")
+	values.Set("client_id", p.config.ClientID)
+	values.Set("redirect_uri", p.config.RedirectURL)
+	values.Set("scope", strings.Join(append([]string{"openid"}, p.config.Scopes...), " "))
+	values.Set("state", state)
+	values.Set("nonce", nonce)
+	values.Set("code_challenge", challenge)
+	values.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(p.discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return p.discovery.AuthorizationEndpoint + separator + values.Encode()
+}
+
+// Exchange trades the authorization code and the PKCE verifier for an ID token and returns its verified claims
+func (p *OIDCProvider) Exchange(ctx context.Context, code string, verifier string) (*IDTokenClaims, error) {
+	values := url.Values{}
+	values.Set("grant_type", "authorization_code")
+	values.Set("code", code)
+	values.Set("redirect_uri", p.config.RedirectURL)
+	values.Set("code_verifier", verifier)
+	if p.config.ClientSecret == "" {
+		values.Set("client_id", p.config.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.discovery.TokenEndpoint, strings.NewReader(values.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint responded with status %d: %s", resp.StatusCode, body)
+	}
+
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return nil, err
+	}
+	if token.IDToken == "" {
+		return nil, fmt.Errorf("token endpoint did not return an id token")
+	}
+	return p.verify(ctx, token.IDToken)
+}
+
+// verify checks the signature, issuer and audience of the ID token
+func (p *OIDCProvider) verify(ctx context.Context, idToken string) (*IDTokenClaims, error) {
+	claims := &IDTokenClaims{}
+	token, err := jwt.ParseWithClaims(idToken, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		kid, _ := t.Header["kid"].(string)
+		return p.key(ctx, kid)
+	})
+	if err != nil || !token.Valid {
+		p.logger.Error().Err(err).Msg("invalid id token")
+		return nil, ErrOIDCTokenInvalid
+	}
+	if claims.Issuer != p.discovery.Issuer {
+		return nil, ErrOIDCTokenInvalid
+	}
+	for _, aud := range claims.Audience {
+		if aud == p.config.ClientID {
+			return claims, nil
+		}
+	}
+	return nil, ErrOIDCTokenInvalid
+}
+
+// key returns the signing key with the ID, the key set is read again when the ID is unknown
+// so keys rotated by the identity provider are picked up
+func (p *OIDCProvider) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	p.mutex.Lock()
+	key, ok := p.keys[kid]
+	p.mutex.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(ctx, p.discovery.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("error reading oidc key set: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+
+	p.mutex.Lock()
+	p.keys = keys
+	p.mutex.Unlock()
+
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// ResolveAuthor maps the identity to an author: by a previous login with the same subject, then by
+// verified email address, and finally by creating a new author when auto provisioning is on
+func (p *OIDCProvider) ResolveAuthor(claims *IDTokenClaims) (string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if author, ok := p.identities[claims.Subject]; ok {
+		if profile, err := p.authors.GetAuthor(author); err == nil {
+			if profile.Disabled {
+				return "", ErrAuthorDisabled
+			}
+			return author, nil
+		}
+		// The author was deleted since the last login
+		p.unlink(claims.Subject)
+	}
+
+	if claims.Email != "" && claims.EmailVerified {
+		if profile, err := p.authors.FindAuthorByEmail(claims.Email); err == nil {
+			if profile.Disabled {
+				return "", ErrAuthorDisabled
+			}
+			p.link(claims.Subject, profile.Name)
+			p.logger.Info().Str("author", profile.Name).Str("subject", claims.Subject).Msg("linked oidc identity by email")
+			return profile.Name, nil
+		}
+	}
+
+	if !p.config.AutoProvision {
+		return "", ErrOIDCNoAuthor
+	}
+
+	// Name the new author after the username the identity provider suggests
+	name := claims.PreferredUsername
+	if name == "" {
+		name = claims.Name
+	}
+	if name == "" && claims.Email != "" {
+		name = strings.SplitN(claims.Email, "@", 2)[0]
+	}
+	profile := AuthorProfile{Name: name, DisplayName: claims.Name}
+	if claims.EmailVerified {
+		profile.Email = claims.Email
+	}
+	if err := p.authors.CreateAuthor(profile); err != nil {
+		return "", err
+	}
+	p.link(claims.Subject, name)
+	p.logger.Info().Str("author", name).Str("subject", claims.Subject).Msg("provisioned author for oidc identity")
+	return name, nil
+}
@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPKCEChallenge(t *testing.T) {
+	// Example from RFC 7636 appendix B
+	assert.Equal(t, "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", PKCEChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"))
+
+	verifier, challenge, err := NewPKCE()
+	assert.NoError(t, err)
+	assert.Equal(t, PKCEChallenge(verifier), challenge)
+}
+
+func newTestOIDCProvider(autoProvision bool) (*OIDCProvider, *AuthorService) {
+	logger := zerolog.New(os.Stdout)
+	authors := newTestAuthorService()
+	authors.UpdateProfile("Author 1", AuthorProfile{Email: "author1@example.com"})
+	return &OIDCProvider{
+		config:     OIDCConfig{AutoProvision: autoProvision},
+		authors:    authors,
+		identities: make(map[string]string),
+		logger:     &logger,
+	}, authors
+}
+
+func TestResolveAuthorByEmail(t *testing.T) {
+	provider, authors := newTestOIDCProvider(false)
+
+	// Unverified email addresses are not trusted
+	_, err := provider.ResolveAuthor(&IDTokenClaims{Subject: "abc", Email: "author1@example.com"})
+	assert.Equal(t, ErrOIDCNoAuthor, err)
+
+	author, err := provider.ResolveAuthor(&IDTokenClaims{Subject: "abc", Email: "Author1@example.com", EmailVerified: true})
+	assert.NoError(t, err)
+	assert.Equal(t, "Author 1", author)
+
+	// The subject stays linked when the email changes at the identity provider
+	author, err = provider.ResolveAuthor(&IDTokenClaims{Subject: "abc", Email: "new@example.com", EmailVerified: true})
+	assert.NoError(t, err)
+	assert.Equal(t, "Author 1", author)
+
+	assert.NoError(t, authors.SetAuthorDisabled("Author 1", true))
+	_, err = provider.ResolveAuthor(&IDTokenClaims{Subject: "abc"})
+	assert.Equal(t, ErrAuthorDisabled, err)
+}
+
+func TestResolveAuthorLinksAreKept(t *testing.T) {
+	provider, authors := newTestOIDCProvider(false)
+	provider.path = filepath.Join(t.TempDir(), "oidc_links.json")
+
+	author, err := provider.ResolveAuthor(&IDTokenClaims{Subject: "abc", Email: "author1@example.com", EmailVerified: true})
+	assert.NoError(t, err)
+	assert.Equal(t, "Author 1", author)
+
+	// After a restart the subject is still linked, even though the email no longer matches
+	restarted := &OIDCProvider{authors: authors, identities: make(map[string]string), path: provider.path, logger: provider.logger}
+	assert.NoError(t, restarted.load())
+	author, err = restarted.ResolveAuthor(&IDTokenClaims{Subject: "abc", Email: "new@example.com", EmailVerified: true})
+	assert.NoError(t, err)
+	assert.Equal(t, "Author 1", author)
+}
+
+func TestResolveAuthorAutoProvision(t *testing.T) {
+	provider, authors := newTestOIDCProvider(true)
+
+	author, err := provider.ResolveAuthor(&IDTokenClaims{Subject: "xyz", Email: "jane@example.com", EmailVerified: true, Name: "Jane Doe"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Jane Doe", author)
+
+	profile, err := authors.GetAuthor("Jane Doe")
+	assert.NoError(t, err)
+	assert.Equal(t, "jane@example.com", profile.Email)
+
+	// Provisioned authors have no password to log in with
+	valid, _ := authors.ValidAuthor("Jane Doe", "")
+	assert.False(t, valid)
+
+	// Another identity cannot take over an existing name
+	_, err = provider.ResolveAuthor(&IDTokenClaims{Subject: "other", PreferredUsername: "Author 1"})
+	assert.Equal(t, ErrAuthorExists, err)
+}
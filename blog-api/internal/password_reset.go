@@ -0,0 +1,140 @@
+package internal
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+var ErrResetTokenInvalid = newError("reset_token_invalid", http.StatusBadRequest, "token", "password reset token is invalid or has expired")
+
+// passwordReset is an outstanding password reset, keyed by the hash of its token
+type passwordReset struct {
+	author    string
+	expiresAt time.Time
+}
+
+// Store the outstanding password resets and deliver their tokens by mail
+type PasswordResetService struct {
+	authors  *AuthorService
+	mailer   Mailer
+	clock    Clock
+	ttl      time.Duration
+	resetURL string
+	resets   map[string]passwordReset
+	mails    chan Message // Sent by Run, so a request takes as long for authors who exist as for others
+	mutex    sync.Mutex   // Protects access to resets
+	logger   *zerolog.Logger
+}
+
+// NewPasswordResetService creates a new password reset service, tokens are valid for ttl and
+// resetURL is the page the token is appended to in the mail, it may be empty
+func NewPasswordResetService(authors *AuthorService, mailer Mailer, clock Clock, ttl time.Duration, resetURL string, logger *zerolog.Logger) (*PasswordResetService, error) {
+	if authors == nil || mailer == nil {
+		return nil, fmt.Errorf("password reset service needs an author service and a mailer")
+	}
+	if ttl <= 0 {
+		return nil, fmt.Errorf("password reset tokens must be valid for a positive duration")
+	}
+	if clock == nil {
+		clock = SystemClock{}
+	}
+	return &PasswordResetService{
+		authors:  authors,
+		mailer:   mailer,
+		clock:    clock,
+		ttl:      ttl,
+		resetURL: resetURL,
+		resets:   make(map[string]passwordReset),
+		mails:    make(chan Message, 100),
+		logger:   logger,
+	}, nil
+}
+
+// RequestReset queues a mail with a single-use reset token to the author, unknown authors and authors without
+// an email address are only logged so the response does not reveal who exists
+func (p *PasswordResetService) RequestReset(ctx context.Context, author string) error {
+	profile, err := p.authors.GetAuthor(author)
+	if err != nil || profile.Disabled || profile.Email == "" {
+		p.logger.Warn().Str("author", author).Msg("password reset requested for an author who cannot receive it")
+		return nil
+	}
+
+	secret, err := randomBytes(32)
+	if err != nil {
+		return err
+	}
+	token := base64.RawURLEncoding.EncodeToString(secret)
+
+	p.mutex.Lock()
+	p.resets[hashToken(token)] = passwordReset{author: author, expiresAt: p.clock.Now().Add(p.ttl)}
+	p.mutex.Unlock()
+
+	body := fmt.Sprintf("Hello %s,\n\nsomeone asked to reset the password of your blog account. ", profile.DisplayName)
+	if p.resetURL != "" {
+		body += fmt.Sprintf("Open the link below to choose a new password:\n\n%s?token=%s\n\n", p.resetURL, url.QueryEscape(token))
+	} else {
+		body += fmt.Sprintf("Use this token to choose a new password:\n\n%s\n\n", token)
+	}
+	body += fmt.Sprintf("It can be used once and expires in %s. If it was not you, you can ignore this mail.\n", p.ttl)
+
+	select {
+	case p.mails <- Message{To: profile.Email, Subject: "Reset your blog password", Body: body}:
+		return nil
+	default:
+		return fmt.Errorf("password reset mail queue is full")
+	}
+}
+
+// Run sends the queued reset mails until the context is done
+func (p *PasswordResetService) Run(ctx context.Context) {
+	for {
+		select {
+		case msg := <-p.mails:
+			p.send(ctx, msg)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// send sends a queued reset mail, failures are logged since the author was already answered
+func (p *PasswordResetService) send(ctx context.Context, msg Message) {
+	if err := p.mailer.Send(ctx, msg); err != nil {
+		p.logger.Error().Err(err).Str("to", msg.To).Msg("error sending password reset mail")
+	}
+}
+
+// ResetPassword sets a new password with a reset token, all outstanding tokens of the author are used up
+func (p *PasswordResetService) ResetPassword(token string, password string) error {
+	if err := validatePassword(password); err != nil {
+		return err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	reset, ok := p.resets[hashToken(token)]
+	if !ok || !reset.expiresAt.After(p.clock.Now()) {
+		return ErrResetTokenInvalid
+	}
+	if err := p.authors.SetPassword(reset.author, password); err != nil {
+		return err
+	}
+
+	// Forget every token of the author and the expired ones of everybody
+	now := p.clock.Now()
+	for hash, r := range p.resets {
+		if r.author == reset.author || !r.expiresAt.After(now) {
+			delete(p.resets, hash)
+		}
+	}
+	p.logger.Info().Str("author", reset.author).Msg("password reset")
+	return nil
+}
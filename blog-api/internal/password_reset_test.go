@@ -0,0 +1,108 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestPasswordResetService(t *testing.T) (*PasswordResetService, *AuthorService, *LogMailer, *fakeClock) {
+	logger := zerolog.New(os.Stdout)
+	authors := newTestAuthorService()
+	_, err := authors.UpdateProfile("Author 1", AuthorProfile{Email: "author1@example.com"})
+	assert.NoError(t, err)
+
+	mailer := NewLogMailer(&bytes.Buffer{})
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	resets, err := NewPasswordResetService(authors, mailer, clock, time.Hour, "https://blog.example.com/reset", &logger)
+	assert.NoError(t, err)
+	return resets, authors, mailer, clock
+}
+
+// requestReset requests a reset and sends the queued mails like Run does
+func requestReset(t *testing.T, resets *PasswordResetService, author string) {
+	assert.NoError(t, resets.RequestReset(context.Background(), author))
+	for len(resets.mails) > 0 {
+		resets.send(context.Background(), <-resets.mails)
+	}
+}
+
+// resetToken returns the token from the last mail
+func resetToken(t *testing.T, mailer *LogMailer) string {
+	messages := mailer.Messages()
+	assert.NotEmpty(t, messages)
+	match := regexp.MustCompile(`token=([A-Za-z0-9_-]+)`).FindStringSubmatch(messages[len(messages)-1].Body)
+	assert.Len(t, match, 2)
+	return match[1]
+}
+
+func TestPasswordReset(t *testing.T) {
+	resets, authors, mailer, _ := newTestPasswordResetService(t)
+
+	requestReset(t, resets, "Author 1")
+	assert.Equal(t, "author1@example.com", mailer.Messages()[0].To)
+	token := resetToken(t, mailer)
+
+	assert.Equal(t, ErrPasswordInvalid, resets.ResetPassword(token, "short"))
+	assert.NoError(t, resets.ResetPassword(token, "new password"))
+
+	valid, _ := authors.ValidAuthor("Author 1", "new password")
+	assert.True(t, valid)
+
+	// Sessions and tokens from before the reset are not accepted anymore, new ones from the next second on are
+	assert.Equal(t, ErrCredentialsRevoked, authors.CheckCredentials("Author 1", time.Now().Add(-time.Minute)))
+	assert.NoError(t, authors.CheckCredentials("Author 1", time.Now().Add(time.Second)))
+
+	// The token can only be used once
+	assert.Equal(t, ErrResetTokenInvalid, resets.ResetPassword(token, "another password"))
+}
+
+func TestPasswordResetExpires(t *testing.T) {
+	resets, _, mailer, clock := newTestPasswordResetService(t)
+
+	requestReset(t, resets, "Author 1")
+	clock.Advance(time.Hour)
+	assert.Equal(t, ErrResetTokenInvalid, resets.ResetPassword(resetToken(t, mailer), "new password"))
+}
+
+func TestPasswordResetUnknownAuthor(t *testing.T) {
+	resets, _, mailer, _ := newTestPasswordResetService(t)
+
+	// Nothing is sent, but no error tells the caller the author does not exist
+	requestReset(t, resets, "Author 9")
+	assert.Empty(t, mailer.Messages())
+}
+
+func TestChangePassword(t *testing.T) {
+	authors := newTestAuthorService()
+
+	assert.Equal(t, ErrPasswordIncorrect, authors.ChangePassword("Author 1", "wrong", "new password"))
+	assert.Equal(t, ErrPasswordInvalid, authors.ChangePassword("Author 1", "password1", "short"))
+	assert.NoError(t, authors.ChangePassword("Author 1", "password1", "new password"))
+
+	valid, _ := authors.ValidAuthor("Author 1", "password1")
+	assert.False(t, valid)
+	assert.Equal(t, ErrCredentialsRevoked, authors.CheckCredentials("Author 1", time.Now().Add(-time.Minute)))
+}
+
+func TestChangePasswordRevokesSessionsOfTheSameSecond(t *testing.T) {
+	authors := newTestAuthorService()
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	authors.clock = clock
+
+	// A JWT issued at 12:00:00.2 says 12:00:00, the password is changed at 12:00:00.7
+	issued := time.Unix(clock.Now().Add(200*time.Millisecond).Unix(), 0)
+	clock.Advance(700 * time.Millisecond)
+	assert.NoError(t, authors.ChangePassword("Author 1", "password1", "new password"))
+	assert.Equal(t, ErrCredentialsRevoked, authors.CheckCredentials("Author 1", issued))
+	assert.Equal(t, ErrCredentialsRevoked, authors.CheckCredentials("Author 1", clock.Now().Add(-time.Millisecond)))
+
+	// Sessions from the next second on are accepted
+	assert.NoError(t, authors.CheckCredentials("Author 1", issued.Add(time.Second)))
+}
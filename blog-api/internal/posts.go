@@ -1,44 +1,80 @@
 package internal
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
 	"regexp"
 	"sort"
 	"strings"
 	"sync"
+	"time"
 	"unicode"
 	"unicode/utf8"
 
 	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 var (
-	ErrPostNotFound           = fmt.Errorf("post not found")
-	ErrContentEmpty           = fmt.Errorf("content must not be empty")
-	ErrContentInvalid         = fmt.Errorf("content must not be longer than 1600 characters or shorter than 100 and cannot have too many special characters")
-	ErrContentEncoding        = fmt.Errorf("content must be valid UTF-8")
-	ErrUniqueTitle            = fmt.Errorf("title must be unique")
-	ErrTitleEmpty             = fmt.Errorf("title must not be empty")
-	ErrTitleInvalid           = fmt.Errorf("title must not be longer than 60 characters or shorter than 5 characters")
-	ErrTitleInvalidChars      = fmt.Errorf("title must not contain too many special characters")
-	ErrAuthorEmpty            = fmt.Errorf("author must not be empty")
-	ErrTitleFormat            = fmt.Errorf("title must not have excessive whitespace or multiple consecutive spaces")
-	ErrTitleSpammy            = fmt.Errorf("title must not contain spammy patterns or phrases")
-	ErrTitleCapitalization    = fmt.Errorf("title must follow capitalization rules")
-	ErrContentConsecutiveChar = fmt.Errorf("content must not have excessive consecutive identical characters")
-	ErrAuthorNotFound         = fmt.Errorf("author not found")
-	ErrAuthorNameInvalid      = fmt.Errorf("author name must not be longer than 70 characters or shorter than 2 characters")
-	ErrAuthorNotAllowed       = fmt.Errorf("not allowed to update posts for another author")
+	ErrPostNotFound           = newError("post_not_found", http.StatusNotFound, "", "post not found")
+	ErrContentEmpty           = newError("content_empty", http.StatusBadRequest, "content", "content must not be empty")
+	ErrContentInvalid         = newError("content_invalid", http.StatusBadRequest, "content", "content must not be longer than 1600 characters or shorter than 100 and cannot have too many special characters")
+	ErrContentEncoding        = newError("content_encoding", http.StatusBadRequest, "content", "content must be valid UTF-8")
+	ErrUniqueTitle            = newError("title_not_unique", http.StatusBadRequest, "title", "title must be unique")
+	ErrReassignConflict       = newError("reassign_title_conflict", http.StatusConflict, "", "the author the posts are reassigned to has a post with the same title")
+	ErrTitleEmpty             = newError("title_empty", http.StatusBadRequest, "title", "title must not be empty")
+	ErrTitleInvalid           = newError("title_invalid", http.StatusBadRequest, "title", "title must not be longer than 60 characters or shorter than 5 characters")
+	ErrTitleInvalidChars      = newError("title_special_characters", http.StatusBadRequest, "title", "title must not contain too many special characters")
+	ErrAuthorEmpty            = newError("author_empty", http.StatusBadRequest, "author", "author must not be empty")
+	ErrTitleFormat            = newError("title_whitespace", http.StatusBadRequest, "title", "title must not have excessive whitespace or multiple consecutive spaces")
+	ErrTitleSpammy            = newError("title_spammy", http.StatusBadRequest, "title", "title must not contain spammy patterns or phrases")
+	ErrTitleCapitalization    = newError("title_capitalization", http.StatusBadRequest, "title", "title must follow capitalization rules")
+	ErrContentConsecutiveChar = newError("content_consecutive_characters", http.StatusBadRequest, "content", "content must not have excessive consecutive identical characters")
+	ErrAuthorNotFound         = newError("author_not_found", http.StatusNotFound, "", "author not found")
+	ErrAuthorNameInvalid      = newError("author_invalid", http.StatusBadRequest, "author", "author name must not be longer than 70 characters or shorter than 2 characters")
+	ErrAuthorNotAllowed       = newError("author_not_allowed", http.StatusForbidden, "", "not allowed to update posts for another author")
+	ErrAuthorMismatch         = newError("author_mismatch", http.StatusBadRequest, "author", "author must be the logged in author")
+	ErrPostStatusInvalid      = newError("status_invalid", http.StatusBadRequest, "status", "status must be draft or published")
+	ErrTagsInvalid            = newError("tags_invalid", http.StatusBadRequest, "tags", "a post can have at most 10 tags of 1 to 30 letters, numbers or dashes")
+	ErrNotSeeded              = fmt.Errorf("posts are not seeded yet")
+)
+
+// Tags are lowercase letters, numbers and dashes
+var tagPattern = regexp.MustCompile(`^[\p{Ll}\p{N}-]{1,30}$`)
+
+// States of a post, only published posts are shown to readers who are not logged in.
+// Drafts are submitted for review and approved to be published, or rejected back to draft
+const (
+	PostDraft     = "draft"
+	PostInReview  = "in_review"
+	PostPublished = "published"
 )
 
 type Post struct {
-	ID      int    `json:"id"`
-	Title   string `json:"title"`
-	Content string `json:"content"`
-	Author  string `json:"author"`
+	ID        int       `json:"id"`
+	Title     string    `json:"title"`
+	Content   string    `json:"content"`
+	Author    string    `json:"author"`
+	Status    string    `json:"status,omitempty"`
+	Tags      []string  `json:"tags,omitempty"`
+	Media     []string  `json:"media,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// PostChange is a post before and after a change, taken while the change is made so it can be audited
+type PostChange struct {
+	Before *Post
+	After  *Post
+}
+
+// Published reports whether the post is published, posts without a status are published
+func (p Post) Published() bool {
+	return p.Status == "" || p.Status == PostPublished
 }
 
 type PostData struct {
@@ -49,10 +85,20 @@ type AuthorPostsMap map[string]map[int]Post
 
 // Store the blogposts from the json file per author
 type PostService struct {
-	Posts  map[string]map[int]Post
-	LastID int
-	mutex  sync.Mutex // Protects access to lastID and Posts
-	logger *zerolog.Logger
+	Posts       map[string]map[int]Post
+	LastID      int
+	mutex       sync.Mutex // Protects access to lastID, Posts, views, transitions, changed and seeded
+	seeded      bool
+	views       map[int]int64
+	transitions map[int][]Transition
+	needsReview func(author string) bool
+	locks       EditLocker
+	media       MediaLinker
+	deleteHooks []func(post Post)
+	events      EventPublisher
+	changed     time.Time // When a post was last created, updated or deleted
+	clock       Clock
+	logger      *zerolog.Logger
 }
 
 // NewPostsService creates a new blogposts service
@@ -60,14 +106,15 @@ func NewPostsService(ap *map[string]map[int]Post, logger *zerolog.Logger) (*Post
 	return &PostService{
 		Posts:  *ap,
 		LastID: 0,
+		clock:  SystemClock{},
 		logger: logger,
 	}, nil
 }
 
-// Add the blogposts from the json file in the resources folder to the posts slice
-func (p *PostService) Seed() error {
+// Seed adds the blogposts from the json file at path, e.g. FILEPATH, to the posts slice
+func (p *PostService) Seed(path string) error {
 	// Open the JSON file
-	jsonFile, err := os.Open(FILEPATH)
+	jsonFile, err := os.Open(path)
 	if err != nil {
 		return err
 	}
@@ -88,24 +135,49 @@ func (p *PostService) Seed() error {
 
 	// Add the posts to the posts slice
 	for _, post := range data.Posts {
-		if validateContent(post.Content) != nil {
-			return err
+		if err := validateContent(post.Content); err != nil {
+			return fmt.Errorf("post %d: %w", post.ID, err)
 		}
-		if validateTitle(post.Title) != nil {
-			return err
+		if err := validateTitle(post.Title); err != nil {
+			return fmt.Errorf("post %d: %w", post.ID, err)
 		}
-		if validateAuthor(post.Author) != nil {
-			return err
+		if err := validateAuthor(post.Author); err != nil {
+			return fmt.Errorf("post %d: %w", post.ID, err)
 		}
 		// If the author is not in the map, add it
 		if _, ok := p.Posts[post.Author]; !ok {
 			p.Posts[post.Author] = make(map[int]Post)
 		}
+		// The json file has no dates or status, seeded posts are published now
+		if post.Status == "" {
+			post.Status = PostPublished
+		}
+		if post.CreatedAt.IsZero() {
+			post.CreatedAt = p.clock.Now()
+		}
+		if post.UpdatedAt.IsZero() {
+			post.UpdatedAt = post.CreatedAt
+		}
 		// Add to the map with the ID as the key
 		p.Posts[post.Author][post.ID] = post
 	}
 	// Update the lastID
 	p.LastID = len(data.Posts)
+
+	p.mutex.Lock()
+	p.seeded = true
+	p.mutex.Unlock()
+	return nil
+}
+
+// CheckHealth fails until the posts are seeded
+func (p *PostService) CheckHealth(ctx context.Context) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !p.seeded {
+		return ErrNotSeeded
+	}
 	return nil
 }
 
@@ -162,11 +234,8 @@ func validateTitle(title string) error {
 	}
 
 	// Validate against common spammy patterns or phrases.
-	spammyPatterns := []string{"buy now", "discount"} // Example patterns, extend as needed
-	for _, pattern := range spammyPatterns {
-		if strings.Contains(strings.ToLower(title), pattern) {
-			return ErrTitleSpammy
-		}
+	if hasSpammyPhrase(title) {
+		return ErrTitleSpammy
 	}
 
 	// Implement a check for word capitalization rules.
@@ -200,19 +269,70 @@ func validateContent(content string) error {
 	}
 
 	// Check for excessive consecutive identical characters.
-	for _, r := range content {
+	if hasConsecutiveChars(content) {
+		return ErrContentConsecutiveChar
+	}
+
+	return nil
+}
+
+// hasSpammyPhrase checks if the text contains common spammy patterns or phrases
+func hasSpammyPhrase(text string) bool {
+	spammyPatterns := []string{"buy now", "discount"} // Example patterns, extend as needed
+	for _, pattern := range spammyPatterns {
+		if strings.Contains(strings.ToLower(text), pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasConsecutiveChars checks if the text has 4 or more consecutive identical letters or numbers
+func hasConsecutiveChars(text string) bool {
+	for _, r := range text {
 		if unicode.IsLetter(r) || unicode.IsNumber(r) {
 			escapedR := regexp.QuoteMeta(string(r))
 
 			// Match 4 or more consecutive identical characters
 			pattern := regexp.MustCompile(escapedR + `{4,}`)
-			if pattern.FindString(content) != "" {
-				return ErrContentConsecutiveChar
+			if pattern.FindString(text) != "" {
+				return true
 			}
 		}
 	}
+	return false
+}
 
-	return nil
+// validateStatus checks the status of a post, an empty status is left to the caller
+func validateStatus(status string) error {
+	switch status {
+	case "", PostDraft, PostPublished:
+		return nil
+	}
+	return ErrPostStatusInvalid
+}
+
+// normalizeTags lowercases the tags, removes duplicates and validates them
+func normalizeTags(tags []string) ([]string, error) {
+	if len(tags) == 0 {
+		return nil, nil
+	}
+	seen := make(map[string]bool)
+	result := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if !tagPattern.MatchString(tag) {
+			return nil, ErrTagsInvalid
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			result = append(result, tag)
+		}
+	}
+	if len(result) > 10 {
+		return nil, ErrTagsInvalid
+	}
+	return result, nil
 }
 
 // validateAuthor checks if the author is empty
@@ -227,12 +347,45 @@ func validateAuthor(author string) error {
 	return nil
 }
 
-// CreatePosts creates a new blogpost
-func (p *PostService) CreatePosts(post Post, author string) error {
+// validatePost checks every field of the post and normalizes its tags and media, all failures are returned
+// together so they can be fixed at once
+func validatePost(post *Post) error {
+	tags, tagsErr := normalizeTags(post.Tags)
+	media, mediaErr := normalizeMedia(post.Media)
+	err := joinValidation(
+		validateTitle(post.Title),
+		validateContent(post.Content),
+		validateAuthor(post.Author),
+		tagsErr,
+		mediaErr,
+		validateStatus(post.Status),
+	)
+	if err != nil {
+		return err
+	}
+	post.Tags = tags
+	post.Media = media
+	return nil
+}
+
+// CreatePosts creates a new blogpost and returns it with its ID
+func (p *PostService) CreatePosts(ctx context.Context, post Post, author string) (created *Post, err error) {
+	_, span := startSpan(ctx, "PostService.CreatePosts", attribute.String("post.author", post.Author), attribute.String("author", author))
+	defer func() {
+		if created != nil {
+			span.SetAttributes(attribute.Int("post.id", created.ID))
+		}
+		endSpan(span, err)
+	}()
+
 	// mutex.Lock() and mutex.Unlock() ensure that only one goroutine can access the map at a time
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 
+	// Validation, before an empty or invalid author is looked up
+	if err := validatePost(&post); err != nil {
+		return nil, err
+	}
 	// If admin is the author, add any posts for any author
 	if author == "admin" {
 		if _, ok := p.Posts[post.Author]; !ok {
@@ -241,42 +394,53 @@ func (p *PostService) CreatePosts(post Post, author string) error {
 	} else {
 		// Make sure the author is in the map
 		if _, ok := p.Posts[post.Author]; !ok {
-			return ErrAuthorNotFound
+			return nil, ErrAuthorNotFound
 		}
 	}
 	// Check if the title is unique for the author
 	for _, existingPost := range p.Posts[post.Author] {
 		if existingPost.Title == post.Title {
-			return ErrUniqueTitle
+			return nil, ErrUniqueTitle
 		}
 	}
 
-	// Validation
-	if err := validateTitle(post.Title); err != nil {
-		return err
-	}
-	if err := validateContent(post.Content); err != nil {
-		return err
-	}
-	if err := validateAuthor(post.Author); err != nil {
-		return err
+	// New posts are published unless they are saved as a draft or need a review first
+	status := post.Status
+	if status == "" {
+		status = PostPublished
+		if p.reviewRequired(post.Author, author) {
+			status = PostDraft
+		}
+	} else if status == PostPublished && p.reviewRequired(post.Author, author) {
+		return nil, ErrReviewRequired
 	}
+	post.Status = ""
+	post.CreatedAt = p.clock.Now()
+	post.UpdatedAt = post.CreatedAt
 	// Add ID, must be unique
 	post.ID = p.LastID + 1
+	if p.media != nil {
+		if err := p.media.Link(post.ID, post.Media); err != nil {
+			return nil, err
+		}
+	}
 	// Increment the lastID, so the next post will have a unique ID
 	p.LastID = post.ID
+	p.transition(&post, status, author, "")
 
 	// Add the post
 	p.Posts[post.Author][post.ID] = post
+	p.publish(EventPostCreated, post)
 
-	return nil
+	return &post, nil
 }
 
 // Get all posts for the author
-func (p *PostService) GetAllPosts() ([]*Post, error) {
-	// Create a slice of pointers to the posts
-	var result []*Post
+func (p *PostService) GetAllPosts(ctx context.Context) (result []*Post, err error) {
+	_, span := startSpan(ctx, "PostService.GetAllPosts")
+	defer func() { endSpan(span, err) }()
 
+	// Create a slice of pointers to the posts
 	for _, posts := range p.Posts {
 		// Add all posts to the postPointers slice
 		for id := range posts {
@@ -288,11 +452,15 @@ func (p *PostService) GetAllPosts() ([]*Post, error) {
 	sort.Slice(result, func(i, j int) bool {
 		return result[i].ID < result[j].ID
 	})
+	span.SetAttributes(attribute.Int("posts", len(result)))
 	return result, nil
 }
 
 // GetPosts gets a blogpost by id
-func (p *PostService) GetPostByID(id int) (*Post, error) {
+func (p *PostService) GetPostByID(ctx context.Context, id int) (found *Post, err error) {
+	_, span := startSpan(ctx, "PostService.GetPostByID", attribute.Int("post.id", id))
+	defer func() { endSpan(span, err) }()
+
 	// Return post by ID, from any author
 	for _, authorPosts := range p.Posts {
 		if post, exists := authorPosts[id]; exists {
@@ -304,55 +472,294 @@ func (p *PostService) GetPostByID(id int) (*Post, error) {
 	return nil, ErrPostNotFound
 }
 
-// UpdatePosts updates a blogpost
-func (p *PostService) UpdatePosts(post Post, author string) error {
+// UpdatePosts updates a blogpost and returns it before and after the update
+func (p *PostService) UpdatePosts(ctx context.Context, post Post, author string) (change *PostChange, err error) {
+	_, span := startSpan(ctx, "PostService.UpdatePosts", attribute.Int("post.id", post.ID), attribute.String("author", author))
+	defer func() { endSpan(span, err) }()
+
 	// mutex.Lock() and mutex.Unlock() ensure that only one goroutine can access the map at a time
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 
 	// Validation
-	if err := validateTitle(post.Title); err != nil {
-		return err
-	}
-	if err := validateContent(post.Content); err != nil {
-		return err
-	}
-	if err := validateAuthor(post.Author); err != nil {
-		return err
+	if err := validatePost(&post); err != nil {
+		return nil, err
 	}
 
 	// If admin is the author, update any posts
 
 	// Update any post if ID exists
 	for _, posts := range p.Posts {
-		if _, ok := posts[post.ID]; ok {
+		if existing, ok := posts[post.ID]; ok {
 			// If the author in the request matches the author in token
-			if posts[post.ID].Author != author && author != "admin" {
-				return ErrAuthorNotAllowed
+			if existing.Author != author && author != "admin" {
+				return nil, ErrAuthorNotAllowed
+			}
+			// Nobody but the holder of the edit lock can change the post
+			if p.locks != nil {
+				if err := p.locks.Check(post.ID, author); err != nil {
+					return nil, err
+				}
+			}
+			// Posts in review are changed by approving or rejecting them
+			if existing.Status == PostInReview {
+				return nil, ErrPostInReview
+			}
+			// The status stays the same unless it is changed
+			status := post.Status
+			post.Status = existing.Status
+			changed := status != "" && status != existing.Status
+			if changed && status == PostPublished && p.reviewRequired(existing.Author, author) {
+				return nil, ErrReviewRequired
+			}
+			// Edits to a published post which need a review take it back to draft until it is approved again
+			if !changed && existing.Published() && p.reviewRequired(existing.Author, author) && edited(existing, post) {
+				status, changed = PostDraft, true
+			}
+			// The media must exist before anything is changed
+			if p.media != nil {
+				if err := p.media.Link(post.ID, post.Media); err != nil {
+					return nil, err
+				}
 			}
+			if changed {
+				p.transition(&post, status, author, "")
+			}
+			post.CreatedAt = existing.CreatedAt
+			post.UpdatedAt = p.clock.Now()
 			posts[post.ID] = post
-			return nil
+			p.publish(EventPostUpdated, post)
+			return &PostChange{Before: &existing, After: &post}, nil
 		}
 	}
 
-	return nil
+	return nil, ErrPostNotFound
 }
 
-// DeletePosts deletes a blogpost
-func (p *PostService) DeletePosts(id int, author string) error {
-	// mutex.Lock() and mutex.Unlock() ensure that only one goroutine can access the map at a time
+// UseLocks makes UpdatePosts and the review actions reject changes from anyone but the holder of the edit lock of a post
+func (p *PostService) UseLocks(locks EditLocker) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.locks = locks
+}
+
+// UseMedia checks the media referenced by posts exist and keeps track of them, so unused media can be deleted.
+// The media of the posts already there, e.g. seeded ones, are linked right away
+func (p *PostService) UseMedia(media MediaLinker) {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 
+	p.media = media
 	for _, posts := range p.Posts {
-		if _, ok := posts[id]; ok {
-			if posts[id].Author != author && author != "admin" {
-				return ErrAuthorNotAllowed
+		for _, post := range posts {
+			if len(post.Media) == 0 {
+				continue
+			}
+			if err := media.Link(post.ID, post.Media); err != nil {
+				p.logger.Error().Err(err).Int("post_id", post.ID).Msg("error linking media of post")
+			}
+		}
+	}
+}
+
+// PublishEvents publishes an event to the publisher whenever a post is created, updated or deleted
+func (p *PostService) PublishEvents(publisher EventPublisher) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.events = publisher
+}
+
+// publish records the change and publishes an event about the post, it is called while holding the mutex so events
+// are in order
+func (p *PostService) publish(eventType string, post Post) {
+	p.changed = p.clock.Now()
+	if p.events == nil {
+		return
+	}
+	p.events.Publish(Event{Type: eventType, Author: post.Author, PostID: post.ID, Post: &post})
+}
+
+// OnDelete registers a function which is called after a post is deleted, e.g. to delete its comments
+func (p *PostService) OnDelete(fn func(post Post)) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.deleteHooks = append(p.deleteHooks, fn)
+}
+
+// deleted calls the delete hooks, it must be called without holding the mutex
+func (p *PostService) deleted(posts []Post, hooks []func(post Post)) {
+	for _, post := range posts {
+		for _, hook := range hooks {
+			hook(post)
+		}
+	}
+}
+
+// DeletePosts deletes a blogpost and returns it
+func (p *PostService) DeletePosts(ctx context.Context, id int, author string) (deleted *Post, err error) {
+	_, span := startSpan(ctx, "PostService.DeletePosts", attribute.Int("post.id", id), attribute.String("author", author))
+	defer func() { endSpan(span, err) }()
+
+	// mutex.Lock() and mutex.Unlock() ensure that only one goroutine can access the map at a time
+	p.mutex.Lock()
+
+	for _, posts := range p.Posts {
+		if post, ok := posts[id]; ok {
+			if post.Author != author && author != "admin" {
+				p.mutex.Unlock()
+				return nil, ErrAuthorNotAllowed
 			}
 			delete(posts, id)
-			return nil
+			delete(p.views, id)
+			delete(p.transitions, id)
+			p.publish(EventPostDeleted, post)
+			hooks := p.deleteHooks
+			p.mutex.Unlock()
+
+			p.deleted([]Post{post}, hooks)
+			return &post, nil
+		}
+	}
+
+	p.mutex.Unlock()
+	return nil, ErrPostNotFound
+}
+
+// ReassignPosts moves all posts of an author to another author and returns every moved post before and after
+func (p *PostService) ReassignPosts(ctx context.Context, from string, to string) (changes []PostChange, err error) {
+	_, span := startSpan(ctx, "PostService.ReassignPosts", attribute.String("from", from), attribute.String("to", to))
+	defer func() { endSpan(span, err) }()
+
+	if err := validateAuthor(to); err != nil {
+		return nil, err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	posts, ok := p.Posts[from]
+	if !ok || len(posts) == 0 {
+		return nil, nil
+	}
+	if _, ok := p.Posts[to]; !ok {
+		p.Posts[to] = make(map[int]Post)
+	}
+	// Check that the titles stay unique for the new author before moving anything
+	for _, post := range posts {
+		for _, existingPost := range p.Posts[to] {
+			if existingPost.Title == post.Title {
+				return nil, ErrReassignConflict
+			}
+		}
+	}
+	for id, post := range posts {
+		before := post
+		post.Author = to
+		p.Posts[to][id] = post
+		p.publish(EventPostUpdated, post)
+		changes = append(changes, PostChange{Before: &before, After: &post})
+	}
+	delete(p.Posts, from)
+	sortChanges(changes)
+	return changes, nil
+}
+
+// sortChanges orders the changes by the ID of the post
+func sortChanges(changes []PostChange) {
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].After.ID < changes[j].After.ID
+	})
+}
+
+// DeleteAuthorPosts deletes all posts of an author and returns them
+func (p *PostService) DeleteAuthorPosts(ctx context.Context, author string) (deleted []Post, err error) {
+	_, span := startSpan(ctx, "PostService.DeleteAuthorPosts", attribute.String("post.author", author))
+	defer func() { endSpan(span, err) }()
+
+	p.mutex.Lock()
+
+	for _, post := range p.Posts[author] {
+		deleted = append(deleted, post)
+		delete(p.views, post.ID)
+		delete(p.transitions, post.ID)
+		p.publish(EventPostDeleted, post)
+	}
+	delete(p.Posts, author)
+	hooks := p.deleteHooks
+	p.mutex.Unlock()
+
+	sort.Slice(deleted, func(i, j int) bool {
+		return deleted[i].ID < deleted[j].ID
+	})
+	p.deleted(deleted, hooks)
+	span.SetAttributes(attribute.Int("posts", len(deleted)))
+	return deleted, nil
+}
+
+// AddViews adds a batch of views from the ViewCounter, views of deleted posts are dropped
+func (p *PostService) AddViews(ctx context.Context, views map[int]int64) (err error) {
+	_, span := startSpan(ctx, "PostService.AddViews", attribute.Int("posts", len(views)))
+	defer func() { endSpan(span, err) }()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.views == nil {
+		p.views = make(map[int]int64)
+	}
+	for id, n := range views {
+		for _, posts := range p.Posts {
+			if _, ok := posts[id]; ok {
+				p.views[id] += n
+				break
+			}
 		}
 	}
+	return nil
+}
+
+// Views returns the stored views of all posts
+func (p *PostService) Views(ctx context.Context) (views map[int]int64, err error) {
+	_, span := startSpan(ctx, "PostService.Views")
+	defer func() { endSpan(span, err) }()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
 
-	return ErrPostNotFound
+	views = make(map[int]int64, len(p.views))
+	for id, n := range p.views {
+		views[id] = n
+	}
+	return views, nil
+}
+
+// LastChange returns when a post was last created, updated or deleted, e.g. for the Last-Modified of the feeds
+func (p *PostService) LastChange(ctx context.Context) time.Time {
+	_, span := startSpan(ctx, "PostService.LastChange")
+	defer span.End()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.changed
+}
+
+// Count returns the number of posts and of authors with posts, e.g. for metrics
+func (p *PostService) Count(ctx context.Context) (posts int, authors int) {
+	_, span := startSpan(ctx, "PostService.Count")
+	defer span.End()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, authorPosts := range p.Posts {
+		if len(authorPosts) > 0 {
+			authors++
+			posts += len(authorPosts)
+		}
+	}
+	return posts, authors
 }
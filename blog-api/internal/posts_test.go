@@ -1,7 +1,14 @@
 package internal
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -67,3 +74,146 @@ func TestValidateAuthor(t *testing.T) {
 	}
 
 }
+
+func TestPostsHealth(t *testing.T) {
+	p := map[string]map[int]Post{}
+	posts, _ := NewPostsService(&p, nil)
+	assert.Equal(t, ErrNotSeeded, posts.CheckHealth(context.Background()))
+}
+
+func TestSeedRejectsInvalidPosts(t *testing.T) {
+	content := strings.Repeat("Lorem ipsum dolor sit amet. ", 5)
+	cases := []struct {
+		post Post
+		want error
+	}{
+		{Post{ID: 1, Title: "Title 1", Content: "", Author: "Author 1"}, ErrContentEmpty},
+		{Post{ID: 1, Title: "", Content: content, Author: "Author 1"}, ErrTitleEmpty},
+		{Post{ID: 1, Title: "Title 1", Content: content, Author: ""}, ErrAuthorEmpty},
+	}
+
+	for _, tc := range cases {
+		path := filepath.Join(t.TempDir(), "seed.json")
+		data, _ := json.Marshal(PostData{Posts: []Post{tc.post}})
+		assert.NoError(t, os.WriteFile(path, data, 0o600))
+
+		p := map[string]map[int]Post{}
+		posts, _ := NewPostsService(&p, nil)
+		err := posts.Seed(path)
+		assert.True(t, errors.Is(err, tc.want), err)
+		assert.Equal(t, ErrNotSeeded, posts.CheckHealth(context.Background()))
+	}
+}
+
+func TestCreatePostsWithoutAuthor(t *testing.T) {
+	content := strings.Repeat("Lorem ipsum dolor sit amet. ", 5)
+	p := map[string]map[int]Post{"Author 1": {}}
+	posts, _ := NewPostsService(&p, nil)
+
+	// The validation reports the empty author, admin does not add posts for nobody
+	for _, author := range []string{"Author 1", "admin"} {
+		_, err := posts.CreatePosts(context.Background(), Post{Title: "Title 1", Content: content}, author)
+		assert.True(t, errors.Is(err, ErrAuthorEmpty), err)
+	}
+	assert.NotContains(t, p, "")
+}
+
+func TestReassignPosts(t *testing.T) {
+	ctx := context.Background()
+	p := map[string]map[int]Post{
+		"Author 1": {1: {ID: 1, Title: "Title 1", Author: "Author 1"}, 2: {ID: 2, Title: "Title 2", Author: "Author 1"}},
+		"Author 2": {3: {ID: 3, Title: "Title 2", Author: "Author 2"}},
+	}
+	posts, _ := NewPostsService(&p, nil)
+
+	// Titles must stay unique for the new author
+	_, err := posts.ReassignPosts(ctx, "Author 1", "Author 2")
+	assert.Equal(t, ErrReassignConflict, err)
+
+	changes, err := posts.ReassignPosts(ctx, "Author 1", "Author 3")
+	assert.NoError(t, err)
+	assert.Len(t, changes, 2)
+	assert.Equal(t, "Author 1", changes[0].Before.Author)
+	assert.Equal(t, "Author 3", changes[0].After.Author)
+	count, authors := posts.Count(ctx)
+	assert.Equal(t, 3, count)
+	assert.Equal(t, 2, authors)
+	post, err := posts.GetPostByID(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "Author 3", post.Author)
+	assert.NotContains(t, posts.Posts, "Author 1")
+
+	deleted, err := posts.DeleteAuthorPosts(ctx, "Author 3")
+	assert.NoError(t, err)
+	assert.Len(t, deleted, 2)
+	_, err = posts.GetPostByID(ctx, 2)
+	assert.Equal(t, ErrPostNotFound, err)
+}
+
+func TestNormalizeTags(t *testing.T) {
+	tags, err := normalizeTags([]string{" Go ", "go", "web-dev"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"go", "web-dev"}, tags)
+
+	_, err = normalizeTags([]string{"no spaces"})
+	assert.Equal(t, ErrTagsInvalid, err)
+	_, err = normalizeTags([]string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k"})
+	assert.Equal(t, ErrTagsInvalid, err)
+}
+
+func TestPostTimestamps(t *testing.T) {
+	ctx := context.Background()
+	p := map[string]map[int]Post{"Author 1": {}}
+	posts, _ := NewPostsService(&p, nil)
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	posts.clock = clock
+
+	content := strings.Repeat("Lorem ipsum dolor sit amet. ", 5)
+	created, err := posts.CreatePosts(ctx, Post{Title: "Title 1", Content: content, Author: "Author 1", Tags: []string{"Go"}}, "Author 1")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, created.ID)
+	post, _ := posts.GetPostByID(ctx, 1)
+	assert.Equal(t, clock.now, post.CreatedAt)
+	assert.Equal(t, clock.now, post.UpdatedAt)
+	assert.Equal(t, []string{"go"}, post.Tags)
+
+	clock.Advance(time.Hour)
+	_, err = posts.UpdatePosts(ctx, Post{ID: 1, Title: "Title 1", Content: content, Author: "Author 1"}, "Author 1")
+	assert.NoError(t, err)
+	post, _ = posts.GetPostByID(ctx, 1)
+	assert.Equal(t, clock.now.Add(-time.Hour), post.CreatedAt)
+	assert.Equal(t, clock.now, post.UpdatedAt)
+	assert.Equal(t, clock.now, posts.LastChange(ctx))
+
+	// Deleting a post is a change too
+	clock.Advance(time.Hour)
+	_, err = posts.DeletePosts(ctx, 1, "Author 1")
+	assert.NoError(t, err)
+	assert.Equal(t, clock.now, posts.LastChange(ctx))
+}
+
+func TestPostStatus(t *testing.T) {
+	ctx := context.Background()
+	p := map[string]map[int]Post{"Author 1": {}}
+	posts, _ := NewPostsService(&p, nil)
+	content := strings.Repeat("Lorem ipsum dolor sit amet. ", 5)
+
+	_, err := posts.CreatePosts(ctx, Post{Title: "Title 1", Content: content, Author: "Author 1", Status: "hidden"}, "Author 1")
+	assert.Equal(t, ErrPostStatusInvalid, err)
+
+	_, err = posts.CreatePosts(ctx, Post{Title: "Title 1", Content: content, Author: "Author 1", Status: PostDraft}, "Author 1")
+	assert.NoError(t, err)
+	post, _ := posts.GetPostByID(ctx, 1)
+	assert.False(t, post.Published())
+
+	// Updates keep the status unless it is changed
+	_, err = posts.UpdatePosts(ctx, Post{ID: 1, Title: "Title 1", Content: content, Author: "Author 1"}, "Author 1")
+	assert.NoError(t, err)
+	post, _ = posts.GetPostByID(ctx, 1)
+	assert.Equal(t, PostDraft, post.Status)
+
+	_, err = posts.UpdatePosts(ctx, Post{ID: 1, Title: "Title 1", Content: content, Author: "Author 1", Status: PostPublished}, "Author 1")
+	assert.NoError(t, err)
+	post, _ = posts.GetPostByID(ctx, 1)
+	assert.True(t, post.Published())
+}
@@ -0,0 +1,155 @@
+package internal
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimit is the outcome of counting a request against a limit
+type RateLimit struct {
+	Allowed    bool
+	Limit      int           // Requests which can be made in a burst
+	Remaining  int           // Requests which can be made right now
+	Reset      time.Duration // Until all requests of a burst can be made again
+	RetryAfter time.Duration // Until the next request can be made, zero when the request was allowed
+}
+
+// RateLimitStore keeps the token buckets of the rate limiters. MemoryRateLimitStore keeps them in the process, a
+// store shared by several servers, e.g. in Redis, makes them enforce one limit together
+type RateLimitStore interface {
+	Take(key string, limit int, period time.Duration, now time.Time) (RateLimit, error)
+}
+
+// tokenBucket holds the tokens of a key at the time it was last updated
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	limit   int
+	period  time.Duration
+}
+
+// refill adds the tokens which came in since the last update, at limit tokens per period
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = math.Min(float64(b.limit), b.tokens+elapsed.Seconds()*float64(b.limit)/b.period.Seconds())
+		b.updated = now
+	}
+}
+
+// MemoryRateLimitStore keeps token buckets in memory
+type MemoryRateLimitStore struct {
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	mutex     sync.Mutex // Protects access to buckets and lastSweep
+}
+
+// NewMemoryRateLimitStore creates a new in-memory store
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: make(map[string]*tokenBucket)}
+}
+
+// Take takes a token from the bucket of the key, the bucket holds up to limit tokens and refills in period
+func (m *MemoryRateLimitStore) Take(key string, limit int, period time.Duration, now time.Time) (RateLimit, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Forget the full buckets once in a while, so the map does not keep growing
+	if now.Sub(m.lastSweep) >= time.Minute {
+		for k, b := range m.buckets {
+			b.refill(now)
+			if b.tokens >= float64(b.limit) {
+				delete(m.buckets, k)
+			}
+		}
+		m.lastSweep = now
+	}
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(limit), updated: now}
+		m.buckets[key] = b
+	}
+	b.limit, b.period = limit, period
+	b.refill(now)
+
+	perToken := period.Seconds() / float64(limit)
+	result := RateLimit{Limit: limit}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - b.tokens) * perToken * float64(time.Second))
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = time.Duration((float64(limit) - b.tokens) * perToken * float64(time.Second))
+	return result, nil
+}
+
+// RateLimiter allows limit requests per period for each key, e.g. an author or an IP address. It is a token
+// bucket, so a burst of limit requests is allowed and after that one request every period/limit
+type RateLimiter struct {
+	name   string
+	limit  int
+	period time.Duration
+	store  RateLimitStore
+	clock  Clock
+}
+
+// NewRateLimiter creates a new rate limiter, the name keeps its buckets apart from other limiters in the store.
+// Without a store the buckets are kept in memory
+func NewRateLimiter(name string, limit int, period time.Duration, store RateLimitStore, clock Clock) (*RateLimiter, error) {
+	if limit < 1 || period <= 0 {
+		return nil, fmt.Errorf("rate limiter needs at least one request per period")
+	}
+	if store == nil {
+		store = NewMemoryRateLimitStore()
+	}
+	if clock == nil {
+		clock = SystemClock{}
+	}
+	return &RateLimiter{
+		name:   name,
+		limit:  limit,
+		period: period,
+		store:  store,
+		clock:  clock,
+	}, nil
+}
+
+// Allow counts a request of the key
+func (l *RateLimiter) Allow(key string) (RateLimit, error) {
+	return l.store.Take(l.name+":"+key, l.limit, l.period, l.clock.Now())
+}
+
+// ParseTrustedProxies parses a comma separated list of IP addresses and CIDR ranges of reverse proxies
+func ParseTrustedProxies(list string) ([]*net.IPNet, error) {
+	var result []*net.IPNet
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			result = append(result, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+		}
+		result = append(result, network)
+	}
+	return result, nil
+}
@@ -0,0 +1,75 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	limiter, err := NewRateLimiter("test", 2, time.Minute, nil, clock)
+	assert.NoError(t, err)
+
+	// A burst of the limit is allowed
+	limit, _ := limiter.Allow("10.0.0.1")
+	assert.True(t, limit.Allowed)
+	assert.Equal(t, 2, limit.Limit)
+	assert.Equal(t, 1, limit.Remaining)
+	limit, _ = limiter.Allow("10.0.0.1")
+	assert.True(t, limit.Allowed)
+	assert.Equal(t, 0, limit.Remaining)
+	assert.Equal(t, time.Minute, limit.Reset)
+
+	// A token comes back every 30 seconds
+	clock.Advance(time.Second * 20)
+	limit, _ = limiter.Allow("10.0.0.1")
+	assert.False(t, limit.Allowed)
+	assert.Equal(t, time.Second*10, limit.RetryAfter)
+
+	// Other keys have their own limit
+	limit, _ = limiter.Allow("10.0.0.2")
+	assert.True(t, limit.Allowed)
+
+	clock.Advance(time.Second * 10)
+	limit, _ = limiter.Allow("10.0.0.1")
+	assert.True(t, limit.Allowed)
+	limit, _ = limiter.Allow("10.0.0.1")
+	assert.False(t, limit.Allowed)
+
+	_, err = NewRateLimiter("test", 0, time.Minute, nil, nil)
+	assert.Error(t, err)
+}
+
+func TestRateLimitStoreShared(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	store := NewMemoryRateLimitStore()
+	login, _ := NewRateLimiter("login", 1, time.Minute, store, clock)
+	writes, _ := NewRateLimiter("write", 1, time.Minute, store, clock)
+
+	// Limiters sharing a store count separately
+	limit, _ := login.Allow("Author 1")
+	assert.True(t, limit.Allowed)
+	limit, _ = writes.Allow("Author 1")
+	assert.True(t, limit.Allowed)
+	limit, _ = login.Allow("Author 1")
+	assert.False(t, limit.Allowed)
+
+	// Full buckets are forgotten
+	clock.Advance(time.Minute * 2)
+	login.Allow("Author 2")
+	assert.Len(t, store.buckets, 1)
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8, 192.0.2.1, ::1,")
+	assert.NoError(t, err)
+	assert.Len(t, proxies, 3)
+	assert.Equal(t, "192.0.2.1/32", proxies[1].String())
+
+	_, err = ParseTrustedProxies("10.0.0.0/33")
+	assert.Error(t, err)
+	_, err = ParseTrustedProxies("proxy.example.com")
+	assert.Error(t, err)
+}
@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/rs/zerolog"
+)
+
+// Reactions authors can leave on a post
+const (
+	ReactionLike       = "like"
+	ReactionLove       = "love"
+	ReactionLaugh      = "laugh"
+	ReactionInsightful = "insightful"
+)
+
+// ReactionTypes are all reactions, in the order they are shown
+var ReactionTypes = []string{ReactionLike, ReactionLove, ReactionLaugh, ReactionInsightful}
+
+var (
+	ErrReactionInvalid  = newError("reaction_invalid", http.StatusBadRequest, "type", "reaction must be one of like, love, laugh or insightful")
+	ErrReactionExists   = newError("reaction_exists", http.StatusConflict, "", "already reacted to this post with this reaction")
+	ErrReactionNotFound = newError("reaction_not_found", http.StatusNotFound, "", "reaction not found")
+)
+
+// Store the reactions per post, each author can react once with each reaction
+type ReactionService struct {
+	reactions map[int]map[string]map[string]bool // post ID -> reaction -> authors
+	mutex     sync.Mutex                         // Protects access to reactions
+	logger    *zerolog.Logger
+}
+
+// NewReactionService creates a new reactions service
+func NewReactionService(logger *zerolog.Logger) (*ReactionService, error) {
+	return &ReactionService{
+		reactions: make(map[int]map[string]map[string]bool),
+		logger:    logger,
+	}, nil
+}
+
+// validateReaction checks the reaction is one of ReactionTypes
+func validateReaction(reaction string) error {
+	for _, r := range ReactionTypes {
+		if r == reaction {
+			return nil
+		}
+	}
+	return ErrReactionInvalid
+}
+
+// React adds the reaction of the author to the post
+func (r *ReactionService) React(postID int, author string, reaction string) error {
+	if err := validateAuthor(author); err != nil {
+		return err
+	}
+	if err := validateReaction(reaction); err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.reactions[postID] == nil {
+		r.reactions[postID] = make(map[string]map[string]bool)
+	}
+	if r.reactions[postID][reaction] == nil {
+		r.reactions[postID][reaction] = make(map[string]bool)
+	}
+	if r.reactions[postID][reaction][author] {
+		return ErrReactionExists
+	}
+	r.reactions[postID][reaction][author] = true
+	return nil
+}
+
+// Unreact removes the reaction of the author from the post
+func (r *ReactionService) Unreact(postID int, author string, reaction string) error {
+	if err := validateReaction(reaction); err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if !r.reactions[postID][reaction][author] {
+		return ErrReactionNotFound
+	}
+	delete(r.reactions[postID][reaction], author)
+	return nil
+}
+
+// Counts returns the number of each reaction on the post, reactions nobody left are left out
+func (r *ReactionService) Counts(postID int) map[string]int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	counts := make(map[string]int)
+	for reaction, authors := range r.reactions[postID] {
+		if len(authors) > 0 {
+			counts[reaction] = len(authors)
+		}
+	}
+	return counts
+}
+
+// DeletePostReactions deletes all reactions on a post, it is registered with PostService.OnDelete
+func (r *ReactionService) DeletePostReactions(post Post) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.reactions, post.ID)
+}
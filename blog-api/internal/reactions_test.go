@@ -0,0 +1,28 @@
+package internal
+
+import (
+	"os"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReactions(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	reactions, _ := NewReactionService(&logger)
+
+	assert.NoError(t, reactions.React(1, "Author 1", ReactionLike))
+	assert.NoError(t, reactions.React(1, "Author 2", ReactionLike))
+	assert.NoError(t, reactions.React(1, "Author 1", ReactionLove))
+	assert.Equal(t, ErrReactionExists, reactions.React(1, "Author 1", ReactionLike))
+	assert.Equal(t, ErrReactionInvalid, reactions.React(1, "Author 1", "angry"))
+	assert.Equal(t, map[string]int{ReactionLike: 2, ReactionLove: 1}, reactions.Counts(1))
+
+	assert.NoError(t, reactions.Unreact(1, "Author 1", ReactionLove))
+	assert.Equal(t, ErrReactionNotFound, reactions.Unreact(1, "Author 1", ReactionLove))
+	assert.Equal(t, map[string]int{ReactionLike: 2}, reactions.Counts(1))
+
+	reactions.DeletePostReactions(Post{ID: 1})
+	assert.Empty(t, reactions.Counts(1))
+}
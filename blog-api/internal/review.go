@@ -0,0 +1,209 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	ErrReviewRequired     = newError("review_required", http.StatusForbidden, "status", "posts of contributors must be submitted for review before they are published")
+	ErrPostInReview       = newError("post_in_review", http.StatusConflict, "", "post is in review, it must be approved or rejected first")
+	ErrReviewTransition   = newError("review_transition", http.StatusConflict, "", "only drafts can be submitted and only posts in review can be approved or rejected")
+	ErrReviewOwnPost      = newError("review_own_post", http.StatusForbidden, "", "editors cannot review their own posts")
+	ErrReviewCommentEmpty = newError("review_comment_empty", http.StatusBadRequest, "comment", "a rejected post needs a comment")
+	ErrReviewComment      = newError("review_comment_invalid", http.StatusBadRequest, "comment", "review comment must not be longer than 1000 characters and must be valid UTF-8")
+)
+
+// Transition is a change of the status of a post
+type Transition struct {
+	From    string    `json:"from,omitempty"`
+	To      string    `json:"to"`
+	Actor   string    `json:"actor"`
+	Comment string    `json:"comment,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// Submission is a post waiting in the review queue
+type Submission struct {
+	Post        *Post     `json:"post"`
+	SubmittedBy string    `json:"submitted_by"`
+	SubmittedAt time.Time `json:"submitted_at"`
+}
+
+// RequireReview sets which authors have their posts reviewed before they are published, e.g. contributors
+func (p *PostService) RequireReview(fn func(author string) bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.needsReview = fn
+}
+
+// reviewRequired reports whether the actor has to submit the post of the author for review to publish it
+func (p *PostService) reviewRequired(author string, actor string) bool {
+	return p.needsReview != nil && actor != "admin" && p.needsReview(author)
+}
+
+// transition changes the status of the post and records it, it is called while holding the mutex
+func (p *PostService) transition(post *Post, to string, actor string, comment string) {
+	if p.transitions == nil {
+		p.transitions = make(map[int][]Transition)
+	}
+	p.transitions[post.ID] = append(p.transitions[post.ID], Transition{
+		From:    post.Status,
+		To:      to,
+		Actor:   actor,
+		Comment: comment,
+		Time:    p.clock.Now(),
+	})
+	post.Status = to
+}
+
+// edited reports whether the update changes what readers see of the post
+func edited(existing Post, post Post) bool {
+	return existing.Title != post.Title || existing.Content != post.Content ||
+		strings.Join(existing.Tags, "\x00") != strings.Join(post.Tags, "\x00") ||
+		strings.Join(existing.Media, "\x00") != strings.Join(post.Media, "\x00")
+}
+
+// findPost returns the post with the ID, it is called while holding the mutex
+func (p *PostService) findPost(id int) (Post, bool) {
+	for _, posts := range p.Posts {
+		if post, ok := posts[id]; ok {
+			return post, true
+		}
+	}
+	return Post{}, false
+}
+
+// review moves a post from one status to another, it checks the post is in the from status
+func (p *PostService) review(id int, from string, to string, actor string, comment string, check func(Post) error) (*PostChange, error) {
+	if !utf8.ValidString(comment) || utf8.RuneCountInString(comment) > 1000 {
+		return nil, ErrReviewComment
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	post, ok := p.findPost(id)
+	if !ok {
+		return nil, ErrPostNotFound
+	}
+	if err := check(post); err != nil {
+		return nil, err
+	}
+	// Nobody but the holder of the edit lock can change the status of the post
+	if p.locks != nil {
+		if err := p.locks.Check(id, actor); err != nil {
+			return nil, err
+		}
+	}
+	if post.Status != from {
+		return nil, ErrReviewTransition
+	}
+
+	before := post
+	p.transition(&post, to, actor, comment)
+	post.UpdatedAt = p.clock.Now()
+	p.Posts[post.Author][post.ID] = post
+	p.publish(EventPostUpdated, post)
+	return &PostChange{Before: &before, After: &post}, nil
+}
+
+// SubmitPost submits a draft for review, only its author and admin can submit it
+func (p *PostService) SubmitPost(ctx context.Context, id int, author string) (change *PostChange, err error) {
+	_, span := startSpan(ctx, "PostService.SubmitPost", attribute.Int("post.id", id), attribute.String("author", author))
+	defer func() { endSpan(span, err) }()
+
+	return p.review(id, PostDraft, PostInReview, author, "", func(post Post) error {
+		if post.Author != author && author != "admin" {
+			return ErrAuthorNotAllowed
+		}
+		return nil
+	})
+}
+
+// ApprovePost publishes a post in review, editors cannot approve their own posts
+func (p *PostService) ApprovePost(ctx context.Context, id int, reviewer string, comment string) (change *PostChange, err error) {
+	_, span := startSpan(ctx, "PostService.ApprovePost", attribute.Int("post.id", id), attribute.String("reviewer", reviewer))
+	defer func() { endSpan(span, err) }()
+
+	return p.review(id, PostInReview, PostPublished, reviewer, strings.TrimSpace(comment), func(post Post) error {
+		if post.Author == reviewer && reviewer != "admin" {
+			return ErrReviewOwnPost
+		}
+		return nil
+	})
+}
+
+// RejectPost returns a post in review to draft, the comment tells the author what to change
+func (p *PostService) RejectPost(ctx context.Context, id int, reviewer string, comment string) (change *PostChange, err error) {
+	_, span := startSpan(ctx, "PostService.RejectPost", attribute.Int("post.id", id), attribute.String("reviewer", reviewer))
+	defer func() { endSpan(span, err) }()
+
+	comment = strings.TrimSpace(comment)
+	if comment == "" {
+		return nil, ErrReviewCommentEmpty
+	}
+	return p.review(id, PostInReview, PostDraft, reviewer, comment, func(post Post) error {
+		if post.Author == reviewer && reviewer != "admin" {
+			return ErrReviewOwnPost
+		}
+		return nil
+	})
+}
+
+// Transitions returns the status changes of a post, oldest first
+func (p *PostService) Transitions(ctx context.Context, id int) (transitions []Transition, err error) {
+	_, span := startSpan(ctx, "PostService.Transitions", attribute.Int("post.id", id))
+	defer func() { endSpan(span, err) }()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if _, ok := p.findPost(id); !ok {
+		return nil, ErrPostNotFound
+	}
+	transitions = make([]Transition, len(p.transitions[id]))
+	copy(transitions, p.transitions[id])
+	return transitions, nil
+}
+
+// ReviewQueue returns the posts waiting for review, the longest waiting first
+func (p *PostService) ReviewQueue(ctx context.Context) (queue []*Submission, err error) {
+	_, span := startSpan(ctx, "PostService.ReviewQueue")
+	defer func() { endSpan(span, err) }()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	result := []*Submission{}
+	for _, posts := range p.Posts {
+		for id := range posts {
+			post := posts[id]
+			if post.Status != PostInReview {
+				continue
+			}
+			submission := &Submission{Post: &post}
+			// The last transition is the submission
+			if transitions := p.transitions[id]; len(transitions) > 0 {
+				last := transitions[len(transitions)-1]
+				submission.SubmittedBy = last.Actor
+				submission.SubmittedAt = last.Time
+			}
+			result = append(result, submission)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].SubmittedAt.Equal(result[j].SubmittedAt) {
+			return result[i].SubmittedAt.Before(result[j].SubmittedAt)
+		}
+		return result[i].Post.ID < result[j].Post.ID
+	})
+	return result, nil
+}
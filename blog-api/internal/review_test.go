@@ -0,0 +1,140 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReviewWorkflow(t *testing.T) {
+	ctx := context.Background()
+	p := map[string]map[int]Post{"Author 1": {}, "Author 2": {}}
+	posts, _ := NewPostsService(&p, nil)
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	posts.clock = clock
+	posts.RequireReview(func(author string) bool { return author == "Author 1" })
+	content := strings.Repeat("Lorem ipsum dolor sit amet. ", 5)
+
+	// Contributors cannot publish themselves, their posts start as drafts
+	_, err := posts.CreatePosts(ctx, Post{Title: "Title 1", Content: content, Author: "Author 1", Status: PostPublished}, "Author 1")
+	assert.Equal(t, ErrReviewRequired, err)
+	created, err := posts.CreatePosts(ctx, Post{Title: "Title 1", Content: content, Author: "Author 1"}, "Author 1")
+	assert.NoError(t, err)
+	assert.Equal(t, PostDraft, created.Status)
+	_, err = posts.UpdatePosts(ctx, Post{ID: 1, Title: "Title 1", Content: content, Author: "Author 1", Status: PostPublished}, "Author 1")
+	assert.Equal(t, ErrReviewRequired, err)
+
+	// Only drafts can be submitted, only by their author
+	_, err = posts.SubmitPost(ctx, 1, "Author 2")
+	assert.Equal(t, ErrAuthorNotAllowed, err)
+	_, err = posts.ApprovePost(ctx, 1, "Author 2", "")
+	assert.Equal(t, ErrReviewTransition, err)
+	clock.Advance(time.Minute)
+	change, err := posts.SubmitPost(ctx, 1, "Author 1")
+	assert.NoError(t, err)
+	assert.Equal(t, PostDraft, change.Before.Status)
+	assert.Equal(t, PostInReview, change.After.Status)
+	assert.False(t, change.After.Published())
+	_, err = posts.SubmitPost(ctx, 1, "Author 1")
+	assert.Equal(t, ErrReviewTransition, err)
+
+	// Posts in review are not edited until they are reviewed
+	_, err = posts.UpdatePosts(ctx, Post{ID: 1, Title: "Title 2", Content: content, Author: "Author 1"}, "Author 1")
+	assert.Equal(t, ErrPostInReview, err)
+
+	queue, _ := posts.ReviewQueue(ctx)
+	assert.Len(t, queue, 1)
+	assert.Equal(t, "Author 1", queue[0].SubmittedBy)
+	assert.Equal(t, clock.now, queue[0].SubmittedAt)
+
+	// Rejecting needs a comment and returns the post to draft
+	_, err = posts.RejectPost(ctx, 1, "Author 2", " ")
+	assert.Equal(t, ErrReviewCommentEmpty, err)
+	_, err = posts.RejectPost(ctx, 1, "Author 1", "Please shorten the intro")
+	assert.Equal(t, ErrReviewOwnPost, err)
+	change, err = posts.RejectPost(ctx, 1, "Author 2", "Please shorten the intro")
+	assert.NoError(t, err)
+	assert.Equal(t, PostDraft, change.After.Status)
+	queue, _ = posts.ReviewQueue(ctx)
+	assert.Empty(t, queue)
+
+	// Submitted again and approved
+	_, err = posts.SubmitPost(ctx, 1, "Author 1")
+	assert.NoError(t, err)
+	change, err = posts.ApprovePost(ctx, 1, "Author 2", "")
+	assert.NoError(t, err)
+	assert.True(t, change.After.Published())
+
+	transitions, _ := posts.Transitions(ctx, 1)
+	var steps []string
+	for _, transition := range transitions {
+		steps = append(steps, transition.From+">"+transition.To+":"+transition.Actor)
+	}
+	assert.Equal(t, []string{
+		">draft:Author 1",
+		"draft>in_review:Author 1",
+		"in_review>draft:Author 2",
+		"draft>in_review:Author 1",
+		"in_review>published:Author 2",
+	}, steps)
+	assert.Equal(t, "Please shorten the intro", transitions[2].Comment)
+
+	// Editing the published post takes it back to draft, unless admin edits it
+	change, err = posts.UpdatePosts(ctx, Post{ID: 1, Title: "Title 1", Content: content, Author: "Author 1", Status: PostPublished}, "Author 1")
+	assert.NoError(t, err)
+	assert.True(t, change.After.Published())
+	change, err = posts.UpdatePosts(ctx, Post{ID: 1, Title: "Title 3", Content: content, Author: "Author 1", Status: PostPublished}, "Author 1")
+	assert.NoError(t, err)
+	assert.Equal(t, "Title 1", change.Before.Title)
+	assert.Equal(t, PostDraft, change.After.Status)
+	assert.Equal(t, "Title 3", change.After.Title)
+	_, err = posts.SubmitPost(ctx, 1, "Author 1")
+	assert.NoError(t, err)
+	_, err = posts.ApprovePost(ctx, 1, "Author 2", "")
+	assert.NoError(t, err)
+	change, err = posts.UpdatePosts(ctx, Post{ID: 1, Title: "Title 4", Content: content, Author: "Author 1"}, "admin")
+	assert.NoError(t, err)
+	assert.True(t, change.After.Published())
+
+	// Authors without review publish directly
+	created, err = posts.CreatePosts(ctx, Post{Title: "Title 2", Content: content, Author: "Author 2"}, "Author 2")
+	assert.NoError(t, err)
+	assert.Equal(t, PostPublished, created.Status)
+
+	_, err = posts.DeletePosts(ctx, 1, "Author 1")
+	assert.NoError(t, err)
+	_, err = posts.Transitions(ctx, 1)
+	assert.Equal(t, ErrPostNotFound, err)
+}
+
+func TestReviewWithEditLock(t *testing.T) {
+	ctx := context.Background()
+	p := map[string]map[int]Post{"Author 1": {}, "Author 2": {}}
+	posts, _ := NewPostsService(&p, nil)
+	locks, _ := NewLockService(time.Minute, nil, nil)
+	posts.UseLocks(locks)
+	posts.RequireReview(func(author string) bool { return author == "Author 1" })
+	content := strings.Repeat("Lorem ipsum dolor sit amet. ", 5)
+	_, err := posts.CreatePosts(ctx, Post{Title: "Title 1", Content: content, Author: "Author 1"}, "Author 1")
+	assert.NoError(t, err)
+
+	// The status only changes for the holder of the edit lock
+	_, err = locks.Acquire(1, "Author 2")
+	assert.NoError(t, err)
+	_, err = posts.SubmitPost(ctx, 1, "Author 1")
+	assert.True(t, errors.Is(err, ErrPostLocked))
+	assert.NoError(t, locks.Release(1, "Author 2"))
+	_, err = posts.SubmitPost(ctx, 1, "Author 1")
+	assert.NoError(t, err)
+
+	_, err = locks.Acquire(1, "Author 1")
+	assert.NoError(t, err)
+	_, err = posts.ApprovePost(ctx, 1, "Author 2", "")
+	assert.True(t, errors.Is(err, ErrPostLocked))
+	_, err = posts.RejectPost(ctx, 1, "Author 2", "Please shorten the intro")
+	assert.True(t, errors.Is(err, ErrPostLocked))
+}
@@ -0,0 +1,467 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif" // Registers the GIF decoder with image.Decode
+	"image/jpeg"
+	"image/png"
+	"sync"
+
+	"github.com/rs/zerolog"
+)
+
+var ErrImageTooLarge = fmt.Errorf("image has too many pixels to make thumbnails of")
+
+// Widths of the thumbnails, only widths smaller than the image are made
+var DefaultThumbnailWidths = []int{320, 640, 1280}
+
+// Largest image thumbnails are made of, so a small file cannot decode to gigabytes of pixels
+const thumbnailMaxPixels = 24_000_000
+
+// Number of images decoded at the same time, whatever the number of workers. Decoding takes up to 8 bytes per
+// pixel, so this bounds the memory of the thumbnailer to about 400 MB
+const thumbnailDecoders = 2
+
+// PNG chunks which hold metadata, EXIF and text such as the author, the camera or comments
+var pngMetadataChunks = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"iTXt": true,
+	"zTXt": true,
+}
+
+// WebP chunks which hold metadata, and the flags of the VP8X chunk which announce them
+var webpMetadataChunks = map[string]byte{
+	"EXIF": 0x08,
+	"XMP ": 0x04,
+}
+
+// Variant is a smaller version of an image
+type Variant struct {
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+}
+
+// Thumbnailer makes thumbnails of uploaded images in a fixed number of background workers
+type Thumbnailer struct {
+	media    *MediaService
+	widths   []int
+	jobs     chan Media
+	decoding chan struct{}    // Taken while an image is decoded
+	dropped  map[string]Media // Media which did not fit in the queue
+	mutex    sync.Mutex       // Protects access to dropped
+	logger   *zerolog.Logger
+}
+
+// NewThumbnailer creates a new thumbnailer queueing up to queueSize images, uploads which do not fit in the
+// queue are queued once there is room
+func NewThumbnailer(media *MediaService, widths []int, queueSize int, logger *zerolog.Logger) (*Thumbnailer, error) {
+	if media == nil || len(widths) == 0 || queueSize < 1 {
+		return nil, fmt.Errorf("thumbnailer needs a media service, widths and a queue")
+	}
+	return &Thumbnailer{
+		media:    media,
+		widths:   widths,
+		jobs:     make(chan Media, queueSize),
+		decoding: make(chan struct{}, thumbnailDecoders),
+		dropped:  make(map[string]Media),
+		logger:   logger,
+	}, nil
+}
+
+// Enqueue queues thumbnails of the media without waiting, it is registered with MediaService.OnUpload
+func (t *Thumbnailer) Enqueue(media Media) {
+	switch media.ContentType {
+	case "image/jpeg", "image/png", "image/gif":
+	default:
+		return
+	}
+	select {
+	case t.jobs <- media:
+	default:
+		t.mutex.Lock()
+		t.dropped[media.Hash] = media
+		t.mutex.Unlock()
+		t.logger.Warn().Str("media", media.Hash).Msg("thumbnail queue is full, thumbnails are made once there is room")
+	}
+}
+
+// requeue moves the media which did not fit in the queue to it, as far as there is room
+func (t *Thumbnailer) requeue() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for hash, media := range t.dropped {
+		select {
+		case t.jobs <- media:
+			delete(t.dropped, hash)
+		default:
+			return
+		}
+	}
+}
+
+// Run makes thumbnails in workers goroutines until the context is done
+func (t *Thumbnailer) Run(ctx context.Context, workers int) {
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case media := <-t.jobs:
+					if err := t.Generate(media.Hash); err != nil {
+						t.logger.Error().Err(err).Str("media", media.Hash).Msg("error making thumbnails")
+					}
+					t.requeue()
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Generate makes the thumbnails of the media and adds them as variants
+func (t *Thumbnailer) Generate(hash string) error {
+	media, content, err := t.media.Open(hash)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	var data bytes.Buffer
+	if _, err := data.ReadFrom(content); err != nil {
+		return err
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data.Bytes()))
+	if err != nil {
+		return err
+	}
+	if config.Width*config.Height > thumbnailMaxPixels {
+		return ErrImageTooLarge
+	}
+
+	// Photos taken sideways are stored as they were taken, the width is the one they are shown with
+	orientation := 1
+	if media.ContentType == "image/jpeg" {
+		orientation = jpegOrientation(data.Bytes())
+	}
+	shownWidth, shownHeight := config.Width, config.Height
+	if orientation >= 5 {
+		shownWidth, shownHeight = shownHeight, shownWidth
+	}
+	var widths []int
+	for _, width := range t.widths {
+		if width < shownWidth {
+			widths = append(widths, width)
+		}
+	}
+	if len(widths) == 0 {
+		return nil
+	}
+
+	t.decoding <- struct{}{}
+	defer func() { <-t.decoding }()
+	img, _, err := image.Decode(bytes.NewReader(data.Bytes()))
+	if err != nil {
+		return err
+	}
+	src := toRGBA(img)
+
+	for _, width := range widths {
+		height := shownHeight * width / shownWidth
+		if height < 1 {
+			height = 1
+		}
+		var thumbnail *image.RGBA
+		if orientation >= 5 {
+			thumbnail = orient(resize(src, height, width), orientation)
+		} else {
+			thumbnail = orient(resize(src, width, height), orientation)
+		}
+
+		// Encoding writes no metadata, so none of the original is carried over
+		var out bytes.Buffer
+		variant := Variant{Width: width, Height: height}
+		if media.ContentType == "image/jpeg" {
+			variant.ContentType = "image/jpeg"
+			err = jpeg.Encode(&out, thumbnail, &jpeg.Options{Quality: 85})
+		} else {
+			variant.ContentType = "image/png"
+			err = png.Encode(&out, thumbnail)
+		}
+		if err != nil {
+			return err
+		}
+		variant.Size = int64(out.Len())
+		if err := t.media.AddVariant(hash, variant, &out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// toRGBA converts the image to RGBA so its pixels can be read directly
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Bounds().Min == (image.Point{}) {
+		return rgba
+	}
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+	return rgba
+}
+
+// resize scales the image down by averaging the source pixels covered by each thumbnail pixel
+func resize(src *image.RGBA, width int, height int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	srcWidth, srcHeight := src.Bounds().Dx(), src.Bounds().Dy()
+	for y := 0; y < height; y++ {
+		y0, y1 := y*srcHeight/height, (y+1)*srcHeight/height
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0, x1 := x*srcWidth/width, (x+1)*srcWidth/width
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+			var r, g, b, a, n int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r += int(p[0])
+					g += int(p[1])
+					b += int(p[2])
+					a += int(p[3])
+					n++
+				}
+			}
+			d := dst.Pix[y*dst.Stride+x*4 : y*dst.Stride+x*4+4]
+			d[0], d[1], d[2], d[3] = uint8(r/n), uint8(g/n), uint8(b/n), uint8(a/n)
+		}
+	}
+	return dst
+}
+
+// stripMetadata removes EXIF metadata, which can hold the location a photo was taken at, from JPEG, PNG and WebP
+// images. Images it cannot parse are returned unchanged
+func stripMetadata(contentType string, data []byte) []byte {
+	switch contentType {
+	case "image/jpeg":
+		return stripJPEGMetadata(data)
+	case "image/png":
+		return stripPNGMetadata(data)
+	case "image/webp":
+		return stripWebPMetadata(data)
+	}
+	return data
+}
+
+// orient turns the image the way the EXIF orientation says it is shown
+func orient(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+	dstWidth, dstHeight := width, height
+	if orientation >= 5 {
+		dstWidth, dstHeight = height, width
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	for y := 0; y < dstHeight; y++ {
+		for x := 0; x < dstWidth; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // Mirrored
+				sx, sy = width-1-x, y
+			case 3: // Upside down
+				sx, sy = width-1-x, height-1-y
+			case 4: // Mirrored upside down
+				sx, sy = x, height-1-y
+			case 5: // Mirrored and turned left
+				sx, sy = y, x
+			case 6: // Turned right
+				sx, sy = y, height-1-x
+			case 7: // Mirrored and turned right
+				sx, sy = width-1-y, height-1-x
+			case 8: // Turned left
+				sx, sy = width-1-y, x
+			}
+			copy(dst.Pix[y*dst.Stride+x*4:y*dst.Stride+x*4+4], src.Pix[sy*src.Stride+sx*4:sy*src.Stride+sx*4+4])
+		}
+	}
+	return dst
+}
+
+// jpegOrientation returns the EXIF orientation of a JPEG image from 1 to 8, 1 when it has none
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data) && data[i] == 0xFF && data[i+1] != 0xDA; {
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			break
+		}
+		if data[i+1] == 0xE1 {
+			if orientation := exifOrientation(data[i+4 : i+2+length]); orientation != 1 {
+				return orientation
+			}
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+// exifOrientation reads the orientation tag from the first directory of an EXIF APP1 segment, 1 when it has none
+func exifOrientation(payload []byte) int {
+	if len(payload) < 14 || string(payload[:6]) != "Exif\x00\x00" {
+		return 1
+	}
+	tiff := payload[6:]
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	offset := int(order.Uint32(tiff[4:]))
+	if offset < 8 || offset+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < count; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if orientation := int(order.Uint16(tiff[entry+8:])); orientation >= 1 && orientation <= 8 {
+				return orientation
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// orientationSegment returns an APP1 segment with EXIF metadata holding nothing but the orientation
+func orientationSegment(orientation int) []byte {
+	payload := []byte{
+		'E', 'x', 'i', 'f', 0, 0,
+		'M', 'M', 0, 42, 0, 0, 0, 8, // Big endian TIFF header, the directory follows it
+		0, 1, // One entry
+		0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, byte(orientation), 0, 0, // Orientation, one short
+		0, 0, 0, 0, // No next directory
+	}
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// stripJPEGMetadata drops the APP1 segments holding EXIF or XMP metadata. The orientation is kept, otherwise
+// photos taken sideways would be shown turned
+func stripJPEGMetadata(data []byte) []byte {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return data
+	}
+	orientation := jpegOrientation(data)
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:2]...)
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xFF {
+			return data
+		}
+		marker := data[i+1]
+		// The image data follows the start of scan segment, keep the rest as it is
+		if marker == 0xDA {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return data
+		}
+		if marker != 0xE1 {
+			out = append(out, data[i:i+2+length]...)
+		}
+		i += 2 + length
+	}
+	out = append(out, data[i:]...)
+	if orientation == 1 {
+		return out
+	}
+	return append(append(out[:2:2], orientationSegment(orientation)...), out[2:]...)
+}
+
+// stripPNGMetadata drops the chunks holding metadata, the other chunks keep their checksums
+func stripPNGMetadata(data []byte) []byte {
+	const signature = "\x89PNG\r\n\x1a\n"
+	if len(data) < len(signature) || string(data[:len(signature)]) != signature {
+		return data
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:len(signature)]...)
+	i := len(signature)
+	for i+12 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + length
+		if length < 0 || end > len(data) {
+			return data
+		}
+		if !pngMetadataChunks[string(data[i+4:i+8])] {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	return append(out, data[i:]...)
+}
+
+// stripWebPMetadata drops the EXIF and XMP chunks, clears their flags in the VP8X chunk and fixes the RIFF size
+func stripWebPMetadata(data []byte) []byte {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return data
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:12]...)
+	var flags byte
+	i := 12
+	for i+8 <= len(data) {
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		// Chunks are padded to an even size
+		end := i + 8 + size + size%2
+		if size < 0 || end > len(data) {
+			return data
+		}
+		if flag, ok := webpMetadataChunks[string(data[i:i+4])]; ok {
+			flags |= flag
+		} else {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	if i != len(data) {
+		return data
+	}
+	// The VP8X chunk, when there is one, is the first and its first byte holds the flags
+	if flags != 0 && len(out) > 20 && string(out[12:16]) == "VP8X" {
+		out[20] &^= flags
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out
+}
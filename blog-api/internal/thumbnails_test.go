@@ -0,0 +1,217 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+// testImage encodes a gradient of the size as PNG or JPEG
+func testImage(t *testing.T, format string, width int, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	var out bytes.Buffer
+	if format == "jpeg" {
+		assert.NoError(t, jpeg.Encode(&out, img, nil))
+	} else {
+		assert.NoError(t, png.Encode(&out, img))
+	}
+	return out.Bytes()
+}
+
+func TestThumbnails(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	store, _ := NewDiskBlobStore(t.TempDir())
+	media, _ := NewMediaService(store, 1<<20, "", nil, &logger)
+	thumbnailer, err := NewThumbnailer(media, []int{100, 200, 800}, 1, &logger)
+	assert.NoError(t, err)
+
+	uploaded, _, err := media.Upload(bytes.NewReader(testImage(t, "png", 400, 300)), "Author 1")
+	assert.NoError(t, err)
+	assert.NoError(t, thumbnailer.Generate(uploaded.Hash))
+
+	// Only widths smaller than the image are made, keeping the aspect ratio
+	got, err := media.Get(uploaded.Hash)
+	assert.NoError(t, err)
+	assert.Equal(t, []Variant{
+		{Width: 100, Height: 75, ContentType: "image/png", Size: got.Variants[0].Size},
+		{Width: 200, Height: 150, ContentType: "image/png", Size: got.Variants[1].Size},
+	}, got.Variants)
+
+	variant, content, err := media.OpenVariant(uploaded.Hash, 200)
+	assert.NoError(t, err)
+	config, format, err := image.DecodeConfig(content)
+	content.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, "png", format)
+	assert.Equal(t, 200, config.Width)
+	assert.Equal(t, 150, config.Height)
+	assert.Equal(t, 200, variant.Width)
+	_, _, err = media.OpenVariant(uploaded.Hash, 800)
+	assert.Equal(t, ErrMediaNotFound, err)
+
+	// JPEGs get JPEG thumbnails
+	uploaded, _, _ = media.Upload(bytes.NewReader(testImage(t, "jpeg", 300, 300)), "Author 1")
+	assert.NoError(t, thumbnailer.Generate(uploaded.Hash))
+	got, _ = media.Get(uploaded.Hash)
+	assert.Len(t, got.Variants, 2)
+	assert.Equal(t, "image/jpeg", got.Variants[0].ContentType)
+
+	// Uploads are queued without waiting, the rest is queued once there is room
+	thumbnailer.Enqueue(Media{Hash: "a", ContentType: "image/png"})
+	thumbnailer.Enqueue(Media{Hash: "b", ContentType: "image/png"})
+	thumbnailer.Enqueue(Media{Hash: "c", ContentType: "image/webp"})
+	assert.Len(t, thumbnailer.jobs, 1)
+	assert.Equal(t, "a", (<-thumbnailer.jobs).Hash)
+	thumbnailer.requeue()
+	assert.Equal(t, "b", (<-thumbnailer.jobs).Hash)
+	assert.Empty(t, thumbnailer.dropped)
+
+	// Uploading media without thumbnails again retries them
+	media.OnUpload(thumbnailer.Enqueue)
+	small, _, _ := media.Upload(bytes.NewReader(testImage(t, "png", 50, 50)), "Author 1")
+	assert.Equal(t, small.Hash, (<-thumbnailer.jobs).Hash)
+	media.Upload(bytes.NewReader(testImage(t, "png", 50, 50)), "Author 2")
+	assert.Equal(t, small.Hash, (<-thumbnailer.jobs).Hash)
+	media.Upload(bytes.NewReader(testImage(t, "png", 400, 300)), "Author 2")
+	assert.Empty(t, thumbnailer.jobs)
+
+	// Variants are deleted together with the media
+	_, err = media.CollectGarbage(0)
+	assert.NoError(t, err)
+	_, err = store.Open(variantKey(uploaded.Hash, 100))
+	assert.Equal(t, ErrBlobNotFound, err)
+}
+
+func TestStripMetadata(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	store, _ := NewDiskBlobStore(t.TempDir())
+	media, _ := NewMediaService(store, 1<<20, "", nil, &logger)
+
+	original := testImage(t, "jpeg", 16, 16)
+	exif := append([]byte{0xFF, 0xE1, 0x00, 0x10}, []byte("Exif\x00\x00GPS 52.5")...)
+	withExif := append(append(append([]byte{}, original[:2]...), exif...), original[2:]...)
+
+	uploaded, _, err := media.Upload(bytes.NewReader(withExif), "Author 1")
+	assert.NoError(t, err)
+	_, content, _ := media.Open(uploaded.Hash)
+	data, _ := io.ReadAll(content)
+	content.Close()
+	assert.Equal(t, original, data)
+	assert.NotContains(t, string(data), "GPS")
+
+	// The same photo with other metadata is the same media
+	_, created, _ := media.Upload(bytes.NewReader(original), "Author 2")
+	assert.False(t, created)
+
+	// The orientation is kept, as the only EXIF tag
+	rotated := append(append(append([]byte{}, withExif[:2+len(exif)]...), orientationSegment(6)...), original[2:]...)
+	assert.Equal(t, 6, jpegOrientation(rotated))
+	stripped := stripMetadata("image/jpeg", rotated)
+	assert.Equal(t, 6, jpegOrientation(stripped))
+	assert.NotContains(t, string(stripped), "GPS")
+	assert.Equal(t, append(append(append([]byte{}, original[:2]...), orientationSegment(6)...), original[2:]...), stripped)
+
+	// Text chunks of PNGs are dropped as well
+	png := testImage(t, "png", 16, 16)
+	text := []byte("\x00\x00\x00\x0btEXtAuthor\x00Jane\x00\x00\x00\x00")
+	withText := append(append(append([]byte{}, png[:33]...), text...), png[33:]...)
+	assert.Equal(t, png, stripMetadata("image/png", withText))
+}
+
+// webpChunk encodes a RIFF chunk, padded to an even size
+func webpChunk(fourCC string, payload []byte) []byte {
+	chunk := []byte(fourCC)
+	chunk = binary.LittleEndian.AppendUint32(chunk, uint32(len(payload)))
+	chunk = append(chunk, payload...)
+	if len(payload)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+// webpFile encodes a WebP file of the chunks
+func webpFile(chunks ...[]byte) []byte {
+	body := []byte("WEBP")
+	for _, chunk := range chunks {
+		body = append(body, chunk...)
+	}
+	return append(binary.LittleEndian.AppendUint32([]byte("RIFF"), uint32(len(body))), body...)
+}
+
+func TestStripWebPMetadata(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	store, _ := NewDiskBlobStore(t.TempDir())
+	media, _ := NewMediaService(store, 1<<20, "", nil, &logger)
+
+	// A 1x1 lossless image, the extended header announces EXIF and XMP metadata
+	pixels := webpChunk("VP8L", []byte("\x2f\x00\x00\x00\x10\x07\x10\x11\x11\x88\x88\xfe\x07\x00"))
+	header := func(flags byte) []byte {
+		return webpChunk("VP8X", []byte{flags, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+	}
+	withGPS := webpFile(
+		header(0x0C),
+		pixels,
+		webpChunk("EXIF", []byte("Exif\x00\x00GPS 52.5")),
+		webpChunk("XMP ", []byte("<x:xmpmeta>GPS 13.4</x:xmpmeta>")),
+	)
+
+	uploaded, _, err := media.Upload(bytes.NewReader(withGPS), "Author 1")
+	assert.NoError(t, err)
+	assert.Equal(t, "image/webp", uploaded.ContentType)
+	_, content, _ := media.Open(uploaded.Hash)
+	data, _ := io.ReadAll(content)
+	content.Close()
+	assert.Equal(t, webpFile(header(0), pixels), data)
+	assert.NotContains(t, string(data), "GPS")
+
+	// Files which cannot be parsed are kept as they are
+	truncated := withGPS[:len(withGPS)-3]
+	assert.Equal(t, truncated, stripMetadata("image/webp", truncated))
+}
+
+func TestOrientedThumbnails(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	store, _ := NewDiskBlobStore(t.TempDir())
+	media, _ := NewMediaService(store, 1<<20, "", nil, &logger)
+	thumbnailer, _ := NewThumbnailer(media, []int{100, 200, 400}, 1, &logger)
+
+	// A photo taken sideways is 400 pixels wide when stored and 300 when shown
+	original := testImage(t, "jpeg", 400, 300)
+	rotated := append(append(append([]byte{}, original[:2]...), orientationSegment(6)...), original[2:]...)
+	uploaded, _, err := media.Upload(bytes.NewReader(rotated), "Author 1")
+	assert.NoError(t, err)
+	assert.NoError(t, thumbnailer.Generate(uploaded.Hash))
+
+	got, _ := media.Get(uploaded.Hash)
+	assert.Len(t, got.Variants, 2)
+	assert.Equal(t, 200, got.Variants[1].Width)
+	assert.Equal(t, 266, got.Variants[1].Height)
+	_, content, _ := media.OpenVariant(uploaded.Hash, 200)
+	config, _, err := image.DecodeConfig(content)
+	content.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, 200, config.Width)
+	assert.Equal(t, 266, config.Height)
+
+	// Turned right the left end of a row is on top, turned left it is at the bottom
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	src.Pix = []byte{1, 1, 1, 255, 2, 2, 2, 255}
+	turned := orient(src, 6)
+	assert.Equal(t, image.Rect(0, 0, 1, 2), turned.Bounds())
+	assert.Equal(t, []byte{1, 1, 1, 255, 2, 2, 2, 255}, turned.Pix)
+	assert.Equal(t, []byte{2, 2, 2, 255, 1, 1, 1, 255}, orient(src, 8).Pix)
+}
@@ -0,0 +1,333 @@
+package internal
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// TokenPrefix marks personal access tokens so they can be told apart from JWTs
+const TokenPrefix = "blog_pat_"
+
+// Scopes that can be granted to a personal access token
+const (
+	ScopePostsRead   = "posts:read"
+	ScopePostsWrite  = "posts:write"
+	ScopePostsDelete = "posts:delete"
+)
+
+// AllScopes are the scopes implicitly granted to an author logged in with a password
+var AllScopes = []string{ScopePostsRead, ScopePostsWrite, ScopePostsDelete}
+
+// The last use of a token is recorded to the minute, so a token used on every request does not rewrite the state
+// file every time
+const tokenUseInterval = time.Minute
+
+var (
+	ErrTokenNotFound     = newError("token_not_found", http.StatusNotFound, "", "token not found")
+	ErrTokenInvalid      = newError("token_invalid", http.StatusUnauthorized, "", "token is invalid")
+	ErrTokenExpired      = newError("token_expired", http.StatusUnauthorized, "", "token has expired")
+	ErrTokenNameInvalid  = newError("token_name_invalid", http.StatusBadRequest, "name", "token name must not be longer than 70 characters or shorter than 1 character")
+	ErrTokenScopeEmpty   = newError("token_scope_empty", http.StatusBadRequest, "scopes", "token must have at least one scope")
+	ErrTokenScopeInvalid = newError("token_scope_invalid", http.StatusBadRequest, "scopes", "token scope is not supported")
+	ErrTokenExpiryPast   = newError("token_expiry_past", http.StatusBadRequest, "expires_at", "token expiry must be in the future")
+	ErrTokenNotAllowed   = newError("token_not_allowed", http.StatusForbidden, "", "not allowed to revoke tokens of another author")
+)
+
+// Token is a long-lived personal access token, the secret itself is never stored
+type Token struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Author     string     `json:"author"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	hash       string
+}
+
+// HasScope reports whether the token was granted the scope
+func (t *Token) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenRecord is what is written to the state file, only the hash of the secret is kept
+type tokenRecord struct {
+	Token
+	Hash string `json:"hash"`
+}
+
+// Store the personal access tokens by ID and by hash of the secret, they are saved to a file so the tokens handed
+// to integrations keep working after a restart
+type TokenService struct {
+	path   string
+	tokens map[string]*Token
+	hashes map[string]string
+	mutex  sync.Mutex // Protects access to tokens and hashes
+	logger *zerolog.Logger
+}
+
+// NewTokenService creates a new personal access token service, the tokens are loaded from and saved to path unless
+// it is empty
+func NewTokenService(path string, logger *zerolog.Logger) (*TokenService, error) {
+	t := &TokenService{
+		path:   path,
+		tokens: make(map[string]*Token),
+		hashes: make(map[string]string),
+		logger: logger,
+	}
+	if err := t.load(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// load reads the state file, a missing file means there are no tokens yet
+func (t *TokenService) load() error {
+	if t.path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(t.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var records []tokenRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return fmt.Errorf("reading tokens: %w", err)
+	}
+	for _, record := range records {
+		token := record.Token
+		token.hash = record.Hash
+		t.tokens[token.ID] = &token
+		t.hashes[token.hash] = token.ID
+	}
+	return nil
+}
+
+// save writes the state file, it must be called while holding the mutex
+func (t *TokenService) save() error {
+	if t.path == "" {
+		return nil
+	}
+	records := make([]tokenRecord, 0, len(t.tokens))
+	for _, token := range t.tokens {
+		records = append(records, tokenRecord{Token: *token, Hash: token.hash})
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ID < records[j].ID
+	})
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+
+	return writeFile(t.path, data)
+}
+
+// hashToken returns the hex encoded SHA-256 of the token secret
+func hashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomBytes returns n bytes read from the secure random source
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// validateScopes checks that there is at least one scope and all of them are known, duplicates are removed
+func validateScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, ErrTokenScopeEmpty
+	}
+	seen := make(map[string]bool)
+	var result []string
+	for _, scope := range scopes {
+		known := false
+		for _, s := range AllScopes {
+			if s == scope {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, ErrTokenScopeInvalid
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			result = append(result, scope)
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// copyToken returns a copy of the token that is safe to hand out
+func copyToken(t *Token) *Token {
+	result := *t
+	result.Scopes = append([]string(nil), t.Scopes...)
+	return &result
+}
+
+// CreateToken creates a new token for the author, the secret is only returned here
+func (t *TokenService) CreateToken(author string, name string, scopes []string, expiresAt *time.Time) (*Token, string, error) {
+	name = strings.TrimSpace(name)
+	if len(name) < 1 || len(name) > 70 {
+		return nil, "", ErrTokenNameInvalid
+	}
+	if err := validateAuthor(author); err != nil {
+		return nil, "", err
+	}
+	scopes, err := validateScopes(scopes)
+	if err != nil {
+		return nil, "", err
+	}
+	now := time.Now().UTC()
+	if expiresAt != nil && !expiresAt.After(now) {
+		return nil, "", ErrTokenExpiryPast
+	}
+
+	// Generate the ID and the secret
+	id, err := randomBytes(8)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := randomBytes(32)
+	if err != nil {
+		return nil, "", err
+	}
+	raw := TokenPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	token := &Token{
+		ID:        hex.EncodeToString(id),
+		Name:      name,
+		Author:    author,
+		Scopes:    scopes,
+		CreatedAt: now,
+		ExpiresAt: expiresAt,
+		hash:      hashToken(raw),
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.tokens[token.ID] = token
+	t.hashes[token.hash] = token.ID
+	if err := t.save(); err != nil {
+		delete(t.hashes, token.hash)
+		delete(t.tokens, token.ID)
+		return nil, "", err
+	}
+
+	return copyToken(token), raw, nil
+}
+
+// ListTokens lists the tokens of the author, admin gets every token
+func (t *TokenService) ListTokens(author string) ([]*Token, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	result := []*Token{}
+	for _, token := range t.tokens {
+		if token.Author == author || author == "admin" {
+			result = append(result, copyToken(token))
+		}
+	}
+	// Order the tokens by creation time
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].CreatedAt.Equal(result[j].CreatedAt) {
+			return result[i].ID < result[j].ID
+		}
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+	return result, nil
+}
+
+// RevokeToken deletes a token, only its author or admin may revoke it
+func (t *TokenService) RevokeToken(id string, author string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	token, ok := t.tokens[id]
+	if !ok {
+		return ErrTokenNotFound
+	}
+	if token.Author != author && author != "admin" {
+		return ErrTokenNotAllowed
+	}
+	delete(t.hashes, token.hash)
+	delete(t.tokens, id)
+	return t.save()
+}
+
+// RevokeAll deletes every token of the author, e.g. when the author is deleted, and returns how many there were
+func (t *TokenService) RevokeAll(author string) int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	revoked := 0
+	for id, token := range t.tokens {
+		if token.Author == author {
+			delete(t.hashes, token.hash)
+			delete(t.tokens, id)
+			revoked++
+		}
+	}
+	if revoked > 0 {
+		if err := t.save(); err != nil {
+			t.logger.Error().Err(err).Str("author", author).Msg("error saving revoked tokens")
+		}
+	}
+	return revoked
+}
+
+// ValidateToken looks up the token by its secret and records when it was last used
+func (t *TokenService) ValidateToken(raw string) (*Token, error) {
+	if !strings.HasPrefix(raw, TokenPrefix) {
+		return nil, ErrTokenInvalid
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	id, ok := t.hashes[hashToken(raw)]
+	if !ok {
+		return nil, ErrTokenInvalid
+	}
+	token := t.tokens[id]
+	now := time.Now().UTC()
+	if token.ExpiresAt != nil && !token.ExpiresAt.After(now) {
+		return nil, ErrTokenExpired
+	}
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= tokenUseInterval {
+		token.LastUsedAt = &now
+		if err := t.save(); err != nil {
+			t.logger.Error().Err(err).Str("token", token.ID).Msg("error saving last use of token")
+		}
+	}
+	return copyToken(token), nil
+}
@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateAndValidateToken(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	tokens, _ := NewTokenService("", &logger)
+
+	token, raw, err := tokens.CreateToken("Author 1", "ci", []string{ScopePostsWrite, ScopePostsRead, ScopePostsWrite}, nil)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(raw, TokenPrefix))
+	assert.Equal(t, []string{ScopePostsRead, ScopePostsWrite}, token.Scopes)
+	assert.Nil(t, token.LastUsedAt)
+
+	validated, err := tokens.ValidateToken(raw)
+	assert.NoError(t, err)
+	assert.Equal(t, token.ID, validated.ID)
+	assert.NotNil(t, validated.LastUsedAt)
+	assert.False(t, validated.HasScope(ScopePostsDelete))
+
+	_, err = tokens.ValidateToken(raw + "x")
+	assert.Equal(t, ErrTokenInvalid, err)
+}
+
+func TestCreateTokenValidation(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	tokens, _ := NewTokenService("", &logger)
+	past := time.Now().Add(-time.Hour)
+
+	cases := []struct {
+		name      string
+		scopes    []string
+		expiresAt *time.Time
+		want      error
+		test      string
+	}{
+		{"", []string{ScopePostsRead}, nil, ErrTokenNameInvalid, "empty name"},
+		{"ci", nil, nil, ErrTokenScopeEmpty, "no scopes"},
+		{"ci", []string{"posts:admin"}, nil, ErrTokenScopeInvalid, "unknown scope"},
+		{"ci", []string{ScopePostsRead}, &past, ErrTokenExpiryPast, "expiry in the past"},
+	}
+
+	for _, tc := range cases {
+		_, _, err := tokens.CreateToken("Author 1", tc.name, tc.scopes, tc.expiresAt)
+		assert.Equal(t, tc.want, err, tc.test)
+	}
+}
+
+func TestExpiredToken(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	tokens, _ := NewTokenService("", &logger)
+
+	expiresAt := time.Now().Add(time.Hour)
+	token, raw, err := tokens.CreateToken("Author 1", "ci", []string{ScopePostsRead}, &expiresAt)
+	assert.NoError(t, err)
+
+	// Move the expiry into the past
+	past := time.Now().Add(-time.Minute)
+	tokens.tokens[token.ID].ExpiresAt = &past
+
+	_, err = tokens.ValidateToken(raw)
+	assert.Equal(t, ErrTokenExpired, err)
+}
+
+func TestRevokeToken(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	tokens, _ := NewTokenService("", &logger)
+
+	token, raw, _ := tokens.CreateToken("Author 1", "ci", []string{ScopePostsRead}, nil)
+
+	assert.Equal(t, ErrTokenNotAllowed, tokens.RevokeToken(token.ID, "Author 2"))
+	assert.NoError(t, tokens.RevokeToken(token.ID, "Author 1"))
+	assert.Equal(t, ErrTokenNotFound, tokens.RevokeToken(token.ID, "Author 1"))
+
+	_, err := tokens.ValidateToken(raw)
+	assert.Equal(t, ErrTokenInvalid, err)
+
+	list, _ := tokens.ListTokens("Author 1")
+	assert.Empty(t, list)
+
+	// Every token of an author is revoked at once, those of others are kept
+	_, first, _ := tokens.CreateToken("Author 1", "ci", []string{ScopePostsRead}, nil)
+	_, second, _ := tokens.CreateToken("Author 1", "deploy", []string{ScopePostsRead}, nil)
+	_, other, _ := tokens.CreateToken("Author 2", "ci", []string{ScopePostsRead}, nil)
+	assert.Equal(t, 2, tokens.RevokeAll("Author 1"))
+	for _, raw := range []string{first, second} {
+		_, err = tokens.ValidateToken(raw)
+		assert.Equal(t, ErrTokenInvalid, err)
+	}
+	_, err = tokens.ValidateToken(other)
+	assert.NoError(t, err)
+}
+
+func TestTokensSurviveRestart(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	tokens, err := NewTokenService(path, &logger)
+	assert.NoError(t, err)
+
+	kept, raw, _ := tokens.CreateToken("Author 1", "ci", []string{ScopePostsRead}, nil)
+	revoked, _, _ := tokens.CreateToken("Author 1", "deploy", []string{ScopePostsRead}, nil)
+	assert.NoError(t, tokens.RevokeToken(revoked.ID, "Author 1"))
+	_, err = tokens.ValidateToken(raw)
+	assert.NoError(t, err)
+
+	// Only the hash of the secret is written
+	data, _ := os.ReadFile(path)
+	assert.NotContains(t, string(data), raw)
+
+	tokens, err = NewTokenService(path, &logger)
+	assert.NoError(t, err)
+	validated, err := tokens.ValidateToken(raw)
+	assert.NoError(t, err)
+	assert.Equal(t, kept.ID, validated.ID)
+	assert.Equal(t, []string{ScopePostsRead}, validated.Scopes)
+	assert.NotNil(t, validated.LastUsedAt)
+	list, _ := tokens.ListTokens("Author 1")
+	assert.Len(t, list, 1)
+
+	// A broken state file stops the start instead of losing the tokens
+	assert.NoError(t, os.WriteFile(path, []byte("{"), 0o600))
+	_, err = NewTokenService(path, &logger)
+	assert.Error(t, err)
+}
@@ -0,0 +1,366 @@
+package internal
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// Issuer shown in the authenticator app
+	TOTPIssuer = "Blog API"
+	// Number of digits in a code
+	TOTPDigits = 6
+	// Seconds a code is valid for
+	TOTPPeriod = 30
+	// Number of recovery codes handed out when two-factor authentication is confirmed
+	RecoveryCodeCount = 10
+)
+
+var (
+	ErrTwoFactorNotEnabled  = newError("two_factor_not_enabled", http.StatusConflict, "", "two-factor authentication is not enabled")
+	ErrTwoFactorEnabled     = newError("two_factor_enabled", http.StatusConflict, "", "two-factor authentication is already enabled")
+	ErrTwoFactorNotEnrolled = newError("two_factor_not_enrolled", http.StatusConflict, "", "two-factor authentication enrollment has not been started")
+	ErrTwoFactorCodeInvalid = newError("two_factor_code_invalid", http.StatusUnauthorized, "code", "two-factor authentication code is invalid")
+)
+
+// TwoFactorEnrollment is returned when an author starts enrolling an authenticator app
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// twoFactor holds the second factor of one author
+type twoFactor struct {
+	secret        []byte
+	confirmed     bool
+	recoveryCodes map[string]bool // hashes of the unused recovery codes
+	lastStep      int64           // last accepted time step, a code cannot be used twice
+}
+
+// twoFactorRecord is what is written to the state file, the secret is needed to check the codes
+type twoFactorRecord struct {
+	Author        string   `json:"author"`
+	Secret        []byte   `json:"secret"`
+	Confirmed     bool     `json:"confirmed"`
+	RecoveryCodes []string `json:"recovery_codes"`
+	LastStep      int64    `json:"last_step"`
+}
+
+// Store the second factors per author, they are saved to a file so a restart does not turn them off
+type TwoFactorService struct {
+	path    string
+	authors map[string]*twoFactor
+	clock   Clock
+	mutex   sync.Mutex // Protects access to authors
+	logger  *zerolog.Logger
+}
+
+// NewTwoFactorService creates a new two-factor authentication service, the second factors are loaded from and
+// saved to path unless it is empty
+func NewTwoFactorService(path string, clock Clock, logger *zerolog.Logger) (*TwoFactorService, error) {
+	if clock == nil {
+		clock = SystemClock{}
+	}
+	t := &TwoFactorService{
+		path:    path,
+		authors: make(map[string]*twoFactor),
+		clock:   clock,
+		logger:  logger,
+	}
+	// The server does not start without the second factors, authors would be let in with their password only
+	if err := t.load(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// load reads the state file, a missing file means nobody enrolled yet
+func (t *TwoFactorService) load() error {
+	if t.path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(t.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var records []twoFactorRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return fmt.Errorf("reading two-factor authentication: %w", err)
+	}
+	for _, record := range records {
+		factor := &twoFactor{
+			secret:        record.Secret,
+			confirmed:     record.Confirmed,
+			recoveryCodes: make(map[string]bool),
+			lastStep:      record.LastStep,
+		}
+		for _, hash := range record.RecoveryCodes {
+			factor.recoveryCodes[hash] = true
+		}
+		t.authors[record.Author] = factor
+	}
+	return nil
+}
+
+// save writes the state file, it must be called while holding the mutex
+func (t *TwoFactorService) save() error {
+	if t.path == "" {
+		return nil
+	}
+	records := make([]twoFactorRecord, 0, len(t.authors))
+	for author, factor := range t.authors {
+		record := twoFactorRecord{
+			Author:        author,
+			Secret:        factor.secret,
+			Confirmed:     factor.confirmed,
+			RecoveryCodes: []string{},
+			LastStep:      factor.lastStep,
+		}
+		for hash := range factor.recoveryCodes {
+			record.RecoveryCodes = append(record.RecoveryCodes, hash)
+		}
+		sort.Strings(record.RecoveryCodes)
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Author < records[j].Author
+	})
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+
+	return writeFile(t.path, data)
+}
+
+// hotp returns the RFC 4226 code for the counter
+func hotp(secret []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < TOTPDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTPDigits, value%mod)
+}
+
+// TOTP returns the RFC 6238 code of the secret at the given time
+func TOTP(secret []byte, t time.Time) string {
+	return hotp(secret, uint64(t.Unix()/TOTPPeriod))
+}
+
+// totpURI returns the otpauth:// URI understood by authenticator apps
+func totpURI(author string, secret string) string {
+	label := url.PathEscape(TOTPIssuer + ":" + author)
+	values := url.Values{}
+	values.Set("secret", secret)
+	values.Set("issuer", TOTPIssuer)
+	values.Set("algorithm", "SHA1")
+	values.Set("digits", fmt.Sprint(TOTPDigits))
+	values.Set("period", fmt.Sprint(TOTPPeriod))
+	return "otpauth://totp/" + label + "?" + values.Encode()
+}
+
+// normalizeCode removes the spaces and dashes people type into codes
+func normalizeCode(code string) string {
+	code = strings.ReplaceAll(code, " ", "")
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ToLower(code)
+}
+
+// verifyTOTP accepts the code of the current time step and one step either side to allow for clock drift,
+// it returns the matching step
+func (t *TwoFactorService) verifyTOTP(factor *twoFactor, code string) (int64, bool) {
+	step := t.clock.Now().Unix() / TOTPPeriod
+	for _, s := range []int64{step - 1, step, step + 1} {
+		if s <= factor.lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(hotp(factor.secret, uint64(s))), []byte(code)) == 1 {
+			return s, true
+		}
+	}
+	return 0, false
+}
+
+// Enroll starts enrolling an authenticator app, a pending enrollment is replaced
+func (t *TwoFactorService) Enroll(author string) (*TwoFactorEnrollment, error) {
+	if err := validateAuthor(author); err != nil {
+		return nil, err
+	}
+
+	secret, err := randomBytes(20)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	previous, ok := t.authors[author]
+	if ok && previous.confirmed {
+		return nil, ErrTwoFactorEnabled
+	}
+	t.authors[author] = &twoFactor{secret: secret}
+	if err := t.save(); err != nil {
+		if ok {
+			t.authors[author] = previous
+		} else {
+			delete(t.authors, author)
+		}
+		return nil, err
+	}
+
+	encoded := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret)
+	return &TwoFactorEnrollment{
+		Secret: encoded,
+		URI:    totpURI(author, encoded),
+	}, nil
+}
+
+// Confirm finishes the enrollment with a first code and returns the recovery codes
+func (t *TwoFactorService) Confirm(author string, code string) ([]string, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	factor, ok := t.authors[author]
+	if !ok {
+		return nil, ErrTwoFactorNotEnrolled
+	}
+	if factor.confirmed {
+		return nil, ErrTwoFactorEnabled
+	}
+	step, ok := t.verifyTOTP(factor, normalizeCode(code))
+	if !ok {
+		return nil, ErrTwoFactorCodeInvalid
+	}
+
+	// Generate the recovery codes, only their hashes are kept
+	codes := make([]string, 0, RecoveryCodeCount)
+	recoveryCodes := make(map[string]bool)
+	for i := 0; i < RecoveryCodeCount; i++ {
+		b, err := randomBytes(5)
+		if err != nil {
+			return nil, err
+		}
+		c := hex.EncodeToString(b)
+		codes = append(codes, c[:5]+"-"+c[5:])
+		recoveryCodes[hashToken(c)] = true
+	}
+	factor.recoveryCodes = recoveryCodes
+	factor.confirmed = true
+	factor.lastStep = step
+	if err := t.save(); err != nil {
+		factor.recoveryCodes = nil
+		factor.confirmed = false
+		factor.lastStep = 0
+		return nil, err
+	}
+
+	t.logger.Info().Str("author", author).Msg("two-factor authentication enabled")
+	return codes, nil
+}
+
+// Enabled reports whether the author has confirmed two-factor authentication
+func (t *TwoFactorService) Enabled(author string) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	factor, ok := t.authors[author]
+	return ok && factor.confirmed
+}
+
+// Verify checks a code from the authenticator app, or a recovery code which can only be used once
+func (t *TwoFactorService) Verify(author string, code string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	factor, ok := t.authors[author]
+	if !ok || !factor.confirmed {
+		return ErrTwoFactorNotEnabled
+	}
+	// A used code is only accepted once it is saved, otherwise it could be used again after a restart
+	code = normalizeCode(code)
+	if step, ok := t.verifyTOTP(factor, code); ok {
+		lastStep := factor.lastStep
+		factor.lastStep = step
+		if err := t.save(); err != nil {
+			factor.lastStep = lastStep
+			return err
+		}
+		return nil
+	}
+	if hash := hashToken(code); factor.recoveryCodes[hash] {
+		delete(factor.recoveryCodes, hash)
+		if err := t.save(); err != nil {
+			factor.recoveryCodes[hash] = true
+			return err
+		}
+		t.logger.Info().Str("author", author).Int("remaining", len(factor.recoveryCodes)).Msg("recovery code used")
+		return nil
+	}
+	return ErrTwoFactorCodeInvalid
+}
+
+// Disable turns two-factor authentication off, it needs a valid code
+func (t *TwoFactorService) Disable(author string, code string) error {
+	if err := t.Verify(author, code); err != nil {
+		return err
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	factor, ok := t.authors[author]
+	if !ok {
+		return ErrTwoFactorNotEnabled
+	}
+	delete(t.authors, author)
+	if err := t.save(); err != nil {
+		t.authors[author] = factor
+		return err
+	}
+	t.logger.Info().Str("author", author).Msg("two-factor authentication disabled")
+	return nil
+}
+
+// Reset removes the second factor of the author without a code, e.g. when the author is deleted
+func (t *TwoFactorService) Reset(author string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if _, ok := t.authors[author]; ok {
+		delete(t.authors, author)
+		if err := t.save(); err != nil {
+			t.logger.Error().Err(err).Str("author", author).Msg("error saving two-factor authentication")
+		}
+		t.logger.Info().Str("author", author).Msg("two-factor authentication reset")
+	}
+}
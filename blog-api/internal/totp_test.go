@@ -0,0 +1,117 @@
+package internal
+
+import (
+	"encoding/base32"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTOTP(t *testing.T) {
+	// Test vectors from RFC 6238, truncated to 6 digits
+	secret := []byte("12345678901234567890")
+	cases := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tc := range cases {
+		assert.Equal(t, tc.want, TOTP(secret, time.Unix(tc.unix, 0)))
+	}
+}
+
+func enrollTestAuthor(t *testing.T, clock *fakeClock) (*TwoFactorService, []byte, []string) {
+	logger := zerolog.New(os.Stdout)
+	twoFactor, _ := NewTwoFactorService("", clock, &logger)
+
+	enrollment, err := twoFactor.Enroll("Author 1")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(enrollment.URI, "otpauth://totp/"))
+	uri, err := url.Parse(enrollment.URI)
+	assert.NoError(t, err)
+	assert.Equal(t, enrollment.Secret, uri.Query().Get("secret"))
+
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(enrollment.Secret)
+	assert.NoError(t, err)
+	assert.False(t, twoFactor.Enabled("Author 1"))
+
+	codes, err := twoFactor.Confirm("Author 1", TOTP(secret, clock.Now()))
+	assert.NoError(t, err)
+	assert.Len(t, codes, RecoveryCodeCount)
+	assert.True(t, twoFactor.Enabled("Author 1"))
+	return twoFactor, secret, codes
+}
+
+func TestTwoFactorVerify(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	twoFactor, secret, _ := enrollTestAuthor(t, clock)
+
+	// The code used to confirm cannot be used again
+	assert.Equal(t, ErrTwoFactorCodeInvalid, twoFactor.Verify("Author 1", TOTP(secret, clock.Now())))
+
+	// The next code is accepted, also when the clocks drift by one period
+	clock.Advance(time.Second * TOTPPeriod)
+	assert.NoError(t, twoFactor.Verify("Author 1", TOTP(secret, clock.Now().Add(time.Second*TOTPPeriod))))
+
+	// Codes too far away are rejected
+	clock.Advance(time.Second * TOTPPeriod * 5)
+	assert.Equal(t, ErrTwoFactorCodeInvalid, twoFactor.Verify("Author 1", TOTP(secret, clock.Now().Add(-time.Second*TOTPPeriod*2))))
+
+	assert.Equal(t, ErrTwoFactorNotEnabled, twoFactor.Verify("Author 2", "123456"))
+}
+
+func TestTwoFactorRecoveryCodes(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	twoFactor, _, codes := enrollTestAuthor(t, clock)
+
+	// A recovery code can be used once
+	assert.NoError(t, twoFactor.Verify("Author 1", strings.ToUpper(codes[0])))
+	assert.Equal(t, ErrTwoFactorCodeInvalid, twoFactor.Verify("Author 1", codes[0]))
+
+	// Enrolling again is not possible while enabled
+	_, err := twoFactor.Enroll("Author 1")
+	assert.Equal(t, ErrTwoFactorEnabled, err)
+
+	assert.NoError(t, twoFactor.Disable("Author 1", codes[1]))
+	assert.False(t, twoFactor.Enabled("Author 1"))
+}
+
+func TestTwoFactorSurvivesRestart(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	path := filepath.Join(t.TempDir(), "two_factor.json")
+	twoFactor, err := NewTwoFactorService(path, clock, &logger)
+	assert.NoError(t, err)
+
+	enrollment, _ := twoFactor.Enroll("Author 1")
+	secret, _ := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(enrollment.Secret)
+	codes, err := twoFactor.Confirm("Author 1", TOTP(secret, clock.Now()))
+	assert.NoError(t, err)
+	assert.NoError(t, twoFactor.Verify("Author 1", codes[0]))
+
+	// After a restart the author still needs the second factor, and used codes stay used
+	twoFactor, err = NewTwoFactorService(path, clock, &logger)
+	assert.NoError(t, err)
+	assert.True(t, twoFactor.Enabled("Author 1"))
+	assert.Equal(t, ErrTwoFactorCodeInvalid, twoFactor.Verify("Author 1", codes[0]))
+	assert.Equal(t, ErrTwoFactorCodeInvalid, twoFactor.Verify("Author 1", TOTP(secret, clock.Now())))
+	assert.NoError(t, twoFactor.Verify("Author 1", codes[1]))
+	clock.Advance(time.Second * TOTPPeriod)
+	assert.NoError(t, twoFactor.Verify("Author 1", TOTP(secret, clock.Now())))
+
+	// A broken state file stops the start instead of turning two-factor authentication off
+	assert.NoError(t, os.WriteFile(path, []byte("["), 0o600))
+	_, err = NewTwoFactorService(path, clock, &logger)
+	assert.Error(t, err)
+}
@@ -0,0 +1,111 @@
+package internal
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// ViewStore keeps the view counts of posts
+type ViewStore interface {
+	AddViews(ctx context.Context, views map[int]int64) error
+	Views(ctx context.Context) (map[int]int64, error)
+}
+
+// ViewCounter counts post views in memory and flushes them to the store in batches,
+// so reading a post does not wait for the store
+type ViewCounter struct {
+	store   ViewStore
+	pending map[int]int64 // views which are not in the store yet
+	totals  map[int]int64 // views in the store and pending
+	mutex   sync.Mutex    // Protects access to pending and totals
+	logger  *zerolog.Logger
+}
+
+// NewViewCounter creates a new view counter starting from the views in the store
+func NewViewCounter(ctx context.Context, store ViewStore, logger *zerolog.Logger) (*ViewCounter, error) {
+	totals, err := store.Views(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if totals == nil {
+		totals = make(map[int]int64)
+	}
+	return &ViewCounter{
+		store:   store,
+		pending: make(map[int]int64),
+		totals:  totals,
+		logger:  logger,
+	}, nil
+}
+
+// Increment counts a view of the post
+func (v *ViewCounter) Increment(postID int) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.pending[postID]++
+	v.totals[postID]++
+}
+
+// Views returns the number of views of the post, including the ones not flushed yet
+func (v *ViewCounter) Views(postID int) int64 {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	return v.totals[postID]
+}
+
+// Flush writes the pending views to the store, they are kept for the next flush when it fails
+func (v *ViewCounter) Flush(ctx context.Context) error {
+	v.mutex.Lock()
+	if len(v.pending) == 0 {
+		v.mutex.Unlock()
+		return nil
+	}
+	batch := v.pending
+	v.pending = make(map[int]int64)
+	v.mutex.Unlock()
+
+	if err := v.store.AddViews(ctx, batch); err != nil {
+		v.mutex.Lock()
+		for id, n := range batch {
+			v.pending[id] += n
+		}
+		v.mutex.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Run flushes the views every interval until the context is done, then flushes one last time
+func (v *ViewCounter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := v.Flush(ctx); err != nil {
+				v.logger.Error().Err(err).Msg("error flushing post views")
+			}
+		case <-ctx.Done():
+			// The context is done, the last flush gets one of its own
+			if err := v.Flush(context.Background()); err != nil {
+				v.logger.Error().Err(err).Msg("error flushing post views")
+			}
+			return
+		}
+	}
+}
+
+// DeletePostViews forgets the views of a post, it is registered with PostService.OnDelete
+func (v *ViewCounter) DeletePostViews(post Post) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	delete(v.pending, post.ID)
+	delete(v.totals, post.ID)
+}
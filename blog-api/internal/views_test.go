@@ -0,0 +1,85 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+// failingViewStore is a ViewStore which cannot be written to
+type failingViewStore struct{}
+
+func (failingViewStore) AddViews(ctx context.Context, views map[int]int64) error {
+	return fmt.Errorf("store unavailable")
+}
+
+func (failingViewStore) Views(ctx context.Context) (map[int]int64, error) {
+	return nil, nil
+}
+
+func TestViewCounterFlush(t *testing.T) {
+	ctx := context.Background()
+	logger := zerolog.New(os.Stdout)
+	posts, _ := NewPostsService(&map[string]map[int]Post{}, &logger)
+	posts.Posts["Author 1"] = map[int]Post{1: {ID: 1, Title: "First", Author: "Author 1"}}
+	views, _ := NewViewCounter(ctx, posts, &logger)
+
+	views.Increment(1)
+	views.Increment(1)
+	views.Increment(2) // not a post, dropped by the store
+	assert.Equal(t, int64(2), views.Views(1))
+
+	// Nothing reaches the store before a flush
+	stored, _ := posts.Views(ctx)
+	assert.Empty(t, stored)
+
+	assert.NoError(t, views.Flush(ctx))
+	stored, _ = posts.Views(ctx)
+	assert.Equal(t, map[int]int64{1: 2}, stored)
+
+	// A new counter starts from the store
+	restarted, _ := NewViewCounter(ctx, posts, &logger)
+	assert.Equal(t, int64(2), restarted.Views(1))
+
+	// Deleting the post drops its views
+	_, err := posts.DeletePosts(ctx, 1, "Author 1")
+	assert.NoError(t, err)
+	stored, _ = posts.Views(ctx)
+	assert.Empty(t, stored)
+}
+
+func TestViewCounterKeepsViewsWhenFlushFails(t *testing.T) {
+	ctx := context.Background()
+	logger := zerolog.New(os.Stdout)
+	views, _ := NewViewCounter(ctx, failingViewStore{}, &logger)
+
+	views.Increment(1)
+	assert.Error(t, views.Flush(ctx))
+	assert.Equal(t, map[int]int64{1: 1}, views.pending)
+}
+
+func TestViewCounterRunFlushesOnStop(t *testing.T) {
+	ctx := context.Background()
+	logger := zerolog.New(os.Stdout)
+	posts, _ := NewPostsService(&map[string]map[int]Post{}, &logger)
+	posts.Posts["Author 1"] = map[int]Post{1: {ID: 1, Title: "First", Author: "Author 1"}}
+	views, _ := NewViewCounter(ctx, posts, &logger)
+	views.Increment(1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		views.Run(ctx, time.Hour)
+		close(done)
+	}()
+	cancel()
+	<-done
+
+	stored, _ := posts.Views(ctx)
+	assert.Equal(t, map[int]int64{1: 1}, stored)
+}
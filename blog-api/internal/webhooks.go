@@ -0,0 +1,570 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Delivery states
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// Headers sent with every delivery
+const (
+	WebhookEventHeader     = "X-Blog-Event"
+	WebhookDeliveryHeader  = "X-Blog-Delivery"
+	WebhookTimestampHeader = "X-Blog-Timestamp"
+	WebhookSignatureHeader = "X-Blog-Signature"
+)
+
+var (
+	ErrWebhookURLInvalid   = newError("webhook_url_invalid", http.StatusBadRequest, "url", "webhook url must be an absolute http or https URL")
+	ErrWebhookEventInvalid = newError("webhook_event_invalid", http.StatusBadRequest, "events", "webhook events must be post.created, post.updated or post.deleted")
+	ErrWebhookNotFound     = newError("webhook_not_found", http.StatusNotFound, "", "webhook not found")
+	ErrDeliveryNotFound    = newError("delivery_not_found", http.StatusNotFound, "", "delivery not found")
+)
+
+// WebhookConfig sets how deliveries are retried
+type WebhookConfig struct {
+	// Attempts before a delivery is given up
+	MaxAttempts int
+	// Delay after the first failed attempt, doubled on every following one
+	BaseDelay time.Duration
+	// Upper bound of the delay between two attempts
+	MaxDelay time.Duration
+	// How long the receiver has to answer
+	Timeout time.Duration
+	// How long succeeded and failed deliveries are kept for their history, forever when zero
+	Retention time.Duration
+}
+
+// DefaultWebhookConfig is the configuration used by the server
+var DefaultWebhookConfig = WebhookConfig{
+	MaxAttempts: 8,
+	BaseDelay:   time.Second * 30,
+	MaxDelay:    time.Hour,
+	Timeout:     time.Second * 10,
+	Retention:   time.Hour * 24 * 7,
+}
+
+type Webhook struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"-"` // signs the payloads, only shown when the webhook is created
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type DeliveryAttempt struct {
+	Time       time.Time `json:"time"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+type Delivery struct {
+	ID            string            `json:"id"`
+	WebhookID     string            `json:"webhook_id"`
+	Event         string            `json:"event"`
+	Payload       json.RawMessage   `json:"payload"`
+	Status        string            `json:"status"`
+	Attempts      []DeliveryAttempt `json:"attempts"`
+	NextAttemptAt time.Time         `json:"next_attempt_at"`
+	CreatedAt     time.Time         `json:"created_at"`
+}
+
+// webhookState is what is written to the state file, the secrets have to survive a restart too
+type webhookState struct {
+	Webhooks   []webhookRecord `json:"webhooks"`
+	Deliveries []*Delivery     `json:"deliveries"`
+}
+
+type webhookRecord struct {
+	Webhook
+	Secret string `json:"secret"`
+}
+
+// Store the webhooks and the queue of deliveries, which is saved to a file so no delivery is lost on restart
+type WebhookService struct {
+	config     WebhookConfig
+	path       string
+	webhooks   map[string]*Webhook
+	deliveries map[string]*Delivery
+	busy       map[string]bool // Webhooks whose worker is sending deliveries
+	client     *http.Client
+	clock      Clock
+	mutex      sync.Mutex // Protects access to webhooks, deliveries and busy
+	logger     *zerolog.Logger
+}
+
+// NewWebhookService creates a new webhook service, the queue is loaded from and saved to path unless it is empty
+func NewWebhookService(config WebhookConfig, path string, client *http.Client, clock Clock, logger *zerolog.Logger) (*WebhookService, error) {
+	if config.MaxAttempts < 1 {
+		return nil, fmt.Errorf("webhooks need at least one delivery attempt")
+	}
+	if client == nil {
+		client = &http.Client{Timeout: config.Timeout}
+	}
+	if clock == nil {
+		clock = SystemClock{}
+	}
+	w := &WebhookService{
+		config:     config,
+		path:       path,
+		webhooks:   make(map[string]*Webhook),
+		deliveries: make(map[string]*Delivery),
+		busy:       make(map[string]bool),
+		client:     client,
+		clock:      clock,
+		logger:     logger,
+	}
+	if err := w.load(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// load reads the state file, a missing file is an empty queue
+func (w *WebhookService) load() error {
+	if w.path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(w.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var state webhookState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("reading webhook state: %w", err)
+	}
+	for _, record := range state.Webhooks {
+		webhook := record.Webhook
+		webhook.Secret = record.Secret
+		w.webhooks[webhook.ID] = &webhook
+	}
+	for _, delivery := range state.Deliveries {
+		w.deliveries[delivery.ID] = delivery
+	}
+	return nil
+}
+
+// save writes the state file, it must be called while holding the mutex
+func (w *WebhookService) save() error {
+	if w.path == "" {
+		return nil
+	}
+	state := webhookState{Webhooks: []webhookRecord{}, Deliveries: []*Delivery{}}
+	for _, webhook := range w.webhooks {
+		state.Webhooks = append(state.Webhooks, webhookRecord{Webhook: *webhook, Secret: webhook.Secret})
+	}
+	for _, delivery := range w.deliveries {
+		state.Deliveries = append(state.Deliveries, delivery)
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	return writeFile(w.path, data)
+}
+
+// validateWebhook checks the URL and the events of a webhook
+func validateWebhook(rawURL string, events []string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrWebhookURLInvalid
+	}
+	for _, event := range events {
+		switch event {
+		case EventPostCreated, EventPostUpdated, EventPostDeleted:
+		default:
+			return ErrWebhookEventInvalid
+		}
+	}
+	return nil
+}
+
+// CreateWebhook adds a webhook for the events, no events means all events
+func (w *WebhookService) CreateWebhook(rawURL string, events []string) (*Webhook, error) {
+	if err := validateWebhook(rawURL, events); err != nil {
+		return nil, err
+	}
+	if len(events) == 0 {
+		events = []string{EventPostCreated, EventPostUpdated, EventPostDeleted}
+	}
+	id, err := randomBytes(8)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := randomBytes(32)
+	if err != nil {
+		return nil, err
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	webhook := &Webhook{
+		ID:        hex.EncodeToString(id),
+		URL:       rawURL,
+		Events:    events,
+		Secret:    hex.EncodeToString(secret),
+		CreatedAt: w.clock.Now(),
+	}
+	w.webhooks[webhook.ID] = webhook
+	if err := w.save(); err != nil {
+		delete(w.webhooks, webhook.ID)
+		return nil, err
+	}
+
+	result := *webhook
+	return &result, nil
+}
+
+// ListWebhooks returns all webhooks, oldest first
+func (w *WebhookService) ListWebhooks() ([]*Webhook, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	result := make([]*Webhook, 0, len(w.webhooks))
+	for _, webhook := range w.webhooks {
+		copied := *webhook
+		result = append(result, &copied)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+	return result, nil
+}
+
+// DeleteWebhook deletes a webhook and its deliveries
+func (w *WebhookService) DeleteWebhook(id string) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if _, ok := w.webhooks[id]; !ok {
+		return ErrWebhookNotFound
+	}
+	delete(w.webhooks, id)
+	for deliveryID, delivery := range w.deliveries {
+		if delivery.WebhookID == id {
+			delete(w.deliveries, deliveryID)
+		}
+	}
+	return w.save()
+}
+
+// ListDeliveries returns the deliveries of a webhook with their attempts, newest first
+func (w *WebhookService) ListDeliveries(webhookID string) ([]*Delivery, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if _, ok := w.webhooks[webhookID]; !ok {
+		return nil, ErrWebhookNotFound
+	}
+	result := make([]*Delivery, 0)
+	for _, delivery := range w.deliveries {
+		if delivery.WebhookID == webhookID {
+			copied := *delivery
+			copied.Attempts = append([]DeliveryAttempt{}, delivery.Attempts...)
+			result = append(result, &copied)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.After(result[j].CreatedAt)
+	})
+	return result, nil
+}
+
+// Redeliver queues a delivery to be sent again right away, its earlier attempts are kept
+func (w *WebhookService) Redeliver(webhookID string, deliveryID string) (*Delivery, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	delivery, ok := w.deliveries[deliveryID]
+	if !ok || delivery.WebhookID != webhookID {
+		return nil, ErrDeliveryNotFound
+	}
+	delivery.Status = DeliveryPending
+	delivery.NextAttemptAt = w.clock.Now()
+	if err := w.save(); err != nil {
+		return nil, err
+	}
+
+	copied := *delivery
+	return &copied, nil
+}
+
+// Enqueue queues a delivery of the event for every webhook subscribed to it. Receivers outside the blog do not
+// see drafts, for posts which are not published they only learn that the post changed
+func (w *WebhookService) Enqueue(event Event) error {
+	if event.Post != nil && !event.Post.Published() {
+		event.Post = nil
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	now := w.clock.Now()
+	queued := 0
+	for _, webhook := range w.webhooks {
+		subscribed := false
+		for _, e := range webhook.Events {
+			subscribed = subscribed || e == event.Type
+		}
+		if !subscribed {
+			continue
+		}
+		id, err := randomBytes(8)
+		if err != nil {
+			return err
+		}
+		delivery := &Delivery{
+			ID:            hex.EncodeToString(id),
+			WebhookID:     webhook.ID,
+			Event:         event.Type,
+			Payload:       payload,
+			Status:        DeliveryPending,
+			Attempts:      []DeliveryAttempt{},
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		}
+		w.deliveries[delivery.ID] = delivery
+		queued++
+	}
+	if queued == 0 {
+		return nil
+	}
+	return w.save()
+}
+
+// Listen queues deliveries for the events on the bus until the context is done. When the service falls behind
+// and is disconnected by the bus it subscribes again and picks up the missed events from the replay buffer,
+// events already dropped from it are not delivered
+func (w *WebhookService) Listen(ctx context.Context, bus *EventBus) {
+	var lastEventID int64
+	for {
+		sub, missed := bus.Subscribe("webhooks", lastEventID, nil)
+		if sub.Reset {
+			w.logger.Error().Int64("last_event", lastEventID).Int64("resumed_event", sub.LastID).
+				Msg("events were dropped before webhook deliveries were queued")
+		}
+		for _, event := range missed {
+			if err := w.Enqueue(event); err != nil {
+				w.logger.Error().Err(err).Int64("event", event.ID).Msg("error queueing webhook deliveries")
+			}
+			lastEventID = event.ID
+		}
+
+	receive:
+		for {
+			select {
+			case <-ctx.Done():
+				bus.Unsubscribe(sub)
+				return
+			case event, ok := <-sub.Events:
+				if !ok {
+					w.logger.Warn().Int64("last_event", lastEventID).Msg("webhooks fell behind the events, resuming")
+					break receive
+				}
+				if err := w.Enqueue(event); err != nil {
+					w.logger.Error().Err(err).Int64("event", event.ID).Msg("error queueing webhook deliveries")
+				}
+				lastEventID = event.ID
+			}
+		}
+	}
+}
+
+// Sign returns the signature of a payload sent at the timestamp, receivers compute it with the webhook secret
+// and compare it to the X-Blog-Signature header
+func Sign(secret string, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// retryDelay returns how long to wait after the given number of failed attempts
+func (w *WebhookService) retryDelay(attempts int) time.Duration {
+	delay := w.config.BaseDelay
+	for i := 1; i < attempts && delay < w.config.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > w.config.MaxDelay {
+		delay = w.config.MaxDelay
+	}
+	return delay
+}
+
+// send posts the payload to the webhook and returns the attempt
+func (w *WebhookService) send(ctx context.Context, webhook Webhook, delivery Delivery) DeliveryAttempt {
+	now := w.clock.Now()
+	attempt := DeliveryAttempt{Time: now}
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "blog-api-webhooks")
+	req.Header.Set(WebhookEventHeader, delivery.Event)
+	req.Header.Set(WebhookDeliveryHeader, delivery.ID)
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	req.Header.Set(WebhookSignatureHeader, Sign(webhook.Secret, timestamp, delivery.Payload))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	attempt.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		attempt.Error = fmt.Sprintf("receiver responded with %s", resp.Status)
+	}
+	return attempt
+}
+
+// prune forgets the succeeded and failed deliveries whose last attempt is older than the retention, so the
+// state file does not grow forever. It is called while holding the mutex
+func (w *WebhookService) prune(now time.Time) bool {
+	if w.config.Retention <= 0 {
+		return false
+	}
+	pruned := false
+	for id, delivery := range w.deliveries {
+		if delivery.Status == DeliveryPending {
+			continue
+		}
+		last := delivery.CreatedAt
+		if len(delivery.Attempts) > 0 {
+			last = delivery.Attempts[len(delivery.Attempts)-1].Time
+		}
+		if now.Sub(last) > w.config.Retention {
+			delete(w.deliveries, id)
+			pruned = true
+		}
+	}
+	return pruned
+}
+
+// DeliverDue sends the deliveries which are due and waits until they are sent. Every webhook has its own worker,
+// so a slow receiver does not hold up the others, and webhooks whose worker is still busy are skipped. The
+// deliveries of a webhook are sent oldest first, but a failed delivery is retried later while newer ones are
+// sent, so receivers which care about the order use the id of the event
+func (w *WebhookService) DeliverDue(ctx context.Context) {
+	w.mutex.Lock()
+	now := w.clock.Now()
+	due := make(map[string][]*Delivery)
+	for _, delivery := range w.deliveries {
+		if delivery.Status == DeliveryPending && !delivery.NextAttemptAt.After(now) && !w.busy[delivery.WebhookID] {
+			due[delivery.WebhookID] = append(due[delivery.WebhookID], delivery)
+		}
+	}
+	for webhookID := range due {
+		w.busy[webhookID] = true
+	}
+	if w.prune(now) && len(due) == 0 {
+		if err := w.save(); err != nil {
+			w.logger.Error().Err(err).Msg("error saving webhook deliveries")
+		}
+	}
+	w.mutex.Unlock()
+
+	var wg sync.WaitGroup
+	for webhookID, deliveries := range due {
+		sort.Slice(deliveries, func(i, j int) bool {
+			return deliveries[i].CreatedAt.Before(deliveries[j].CreatedAt)
+		})
+		wg.Add(1)
+		go func(webhookID string, deliveries []*Delivery) {
+			defer wg.Done()
+			w.deliver(ctx, webhookID, deliveries)
+		}(webhookID, deliveries)
+	}
+	wg.Wait()
+}
+
+// deliver sends the deliveries of one webhook one after the other and records their attempts
+func (w *WebhookService) deliver(ctx context.Context, webhookID string, deliveries []*Delivery) {
+	for _, d := range deliveries {
+		w.mutex.Lock()
+		webhook, ok := w.webhooks[webhookID]
+		if !ok || d.Status != DeliveryPending {
+			w.mutex.Unlock()
+			continue
+		}
+		hook, delivery := *webhook, *d
+		w.mutex.Unlock()
+
+		attempt := w.send(ctx, hook, delivery)
+
+		w.mutex.Lock()
+		d.Attempts = append(d.Attempts, attempt)
+		switch {
+		case attempt.Error == "":
+			d.Status = DeliverySucceeded
+		case len(d.Attempts) >= w.config.MaxAttempts:
+			d.Status = DeliveryFailed
+			w.logger.Warn().Str("webhook", d.WebhookID).Str("delivery", d.ID).Msg("webhook delivery failed, giving up")
+		default:
+			d.NextAttemptAt = w.clock.Now().Add(w.retryDelay(len(d.Attempts)))
+		}
+		w.mutex.Unlock()
+	}
+
+	// The attempts are saved once for all the deliveries, after a crash in between a delivery is sent again
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	delete(w.busy, webhookID)
+	if err := w.save(); err != nil {
+		w.logger.Error().Err(err).Msg("error saving webhook deliveries")
+	}
+}
+
+// Run sends the due deliveries every interval until the context is done
+func (w *WebhookService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			// Rounds overlap, a webhook whose worker is still busy is left to it
+			go w.DeliverDue(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
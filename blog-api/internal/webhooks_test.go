@@ -0,0 +1,255 @@
+package internal
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+// webhookReceiver is an httptest server recording the deliveries, it fails the first failures requests
+type webhookReceiver struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+	failures int
+}
+
+func newWebhookReceiver(failures int) *webhookReceiver {
+	receiver := &webhookReceiver{failures: failures}
+	receiver.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		receiver.mutex.Lock()
+		defer receiver.mutex.Unlock()
+		receiver.requests = append(receiver.requests, r)
+		receiver.bodies = append(receiver.bodies, body)
+		if receiver.failures > 0 {
+			receiver.failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	return receiver
+}
+
+func newTestWebhookService(t *testing.T, path string) (*WebhookService, *fakeClock) {
+	logger := zerolog.New(os.Stdout)
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	config := WebhookConfig{MaxAttempts: 3, BaseDelay: time.Second, MaxDelay: time.Minute, Timeout: time.Second}
+	webhooks, err := NewWebhookService(config, path, nil, clock, &logger)
+	assert.NoError(t, err)
+	return webhooks, clock
+}
+
+func TestWebhookSignedDelivery(t *testing.T) {
+	receiver := newWebhookReceiver(0)
+	defer receiver.Close()
+	webhooks, clock := newTestWebhookService(t, "")
+
+	webhook, err := webhooks.CreateWebhook(receiver.URL, []string{EventPostCreated})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, webhook.Secret)
+
+	assert.NoError(t, webhooks.Enqueue(Event{ID: 1, Type: EventPostCreated, Author: "Author 1", PostID: 1}))
+	assert.NoError(t, webhooks.Enqueue(Event{ID: 2, Type: EventPostDeleted, Author: "Author 1", PostID: 1}))
+	webhooks.DeliverDue(context.Background())
+
+	// Only the subscribed event is delivered, signed with the secret of the webhook
+	assert.Len(t, receiver.requests, 1)
+	req := receiver.requests[0]
+	assert.Equal(t, EventPostCreated, req.Header.Get(WebhookEventHeader))
+	assert.Equal(t, "1704110400", req.Header.Get(WebhookTimestampHeader))
+	assert.Equal(t, Sign(webhook.Secret, "1704110400", receiver.bodies[0]), req.Header.Get(WebhookSignatureHeader))
+	assert.JSONEq(t, `{"id":1,"type":"post.created","author":"Author 1","post_id":1,"time":"0001-01-01T00:00:00Z"}`, string(receiver.bodies[0]))
+
+	deliveries, err := webhooks.ListDeliveries(webhook.ID)
+	assert.NoError(t, err)
+	assert.Len(t, deliveries, 1)
+	assert.Equal(t, DeliverySucceeded, deliveries[0].Status)
+	assert.Equal(t, http.StatusNoContent, deliveries[0].Attempts[0].StatusCode)
+
+	// Delivered deliveries are not sent again until redelivered
+	clock.Advance(time.Hour)
+	webhooks.DeliverDue(context.Background())
+	assert.Len(t, receiver.requests, 1)
+	_, err = webhooks.Redeliver(webhook.ID, deliveries[0].ID)
+	assert.NoError(t, err)
+	webhooks.DeliverDue(context.Background())
+	assert.Len(t, receiver.requests, 2)
+
+	_, err = webhooks.Redeliver(webhook.ID, "unknown")
+	assert.Equal(t, ErrDeliveryNotFound, err)
+}
+
+func TestWebhookRetriesWithBackoff(t *testing.T) {
+	receiver := newWebhookReceiver(10)
+	defer receiver.Close()
+	webhooks, clock := newTestWebhookService(t, "")
+
+	webhook, _ := webhooks.CreateWebhook(receiver.URL, nil)
+	assert.NoError(t, webhooks.Enqueue(Event{ID: 1, Type: EventPostUpdated, PostID: 1}))
+
+	webhooks.DeliverDue(context.Background())
+	deliveries, _ := webhooks.ListDeliveries(webhook.ID)
+	assert.Equal(t, DeliveryPending, deliveries[0].Status)
+	assert.Equal(t, clock.now.Add(time.Second), deliveries[0].NextAttemptAt)
+
+	// Not due yet
+	webhooks.DeliverDue(context.Background())
+	assert.Len(t, receiver.requests, 1)
+
+	// The delay doubles
+	clock.Advance(time.Second)
+	webhooks.DeliverDue(context.Background())
+	deliveries, _ = webhooks.ListDeliveries(webhook.ID)
+	assert.Equal(t, clock.now.Add(time.Second*2), deliveries[0].NextAttemptAt)
+
+	// After the last attempt the delivery is given up
+	clock.Advance(time.Second * 2)
+	webhooks.DeliverDue(context.Background())
+	deliveries, _ = webhooks.ListDeliveries(webhook.ID)
+	assert.Equal(t, DeliveryFailed, deliveries[0].Status)
+	assert.Len(t, deliveries[0].Attempts, 3)
+	assert.Equal(t, "receiver responded with 503 Service Unavailable", deliveries[0].Attempts[2].Error)
+}
+
+func TestWebhookQueueSurvivesRestart(t *testing.T) {
+	receiver := newWebhookReceiver(0)
+	defer receiver.Close()
+	path := filepath.Join(t.TempDir(), "webhooks.json")
+
+	webhooks, _ := newTestWebhookService(t, path)
+	webhook, _ := webhooks.CreateWebhook(receiver.URL, nil)
+	assert.NoError(t, webhooks.Enqueue(Event{ID: 1, Type: EventPostCreated, PostID: 1}))
+
+	// The queued delivery is sent by the next process, signed with the same secret
+	restarted, _ := newTestWebhookService(t, path)
+	restarted.DeliverDue(context.Background())
+	assert.Len(t, receiver.requests, 1)
+	assert.Equal(t, Sign(webhook.Secret, "1704110400", receiver.bodies[0]), receiver.requests[0].Header.Get(WebhookSignatureHeader))
+}
+
+func TestWebhookValidation(t *testing.T) {
+	webhooks, _ := newTestWebhookService(t, "")
+
+	_, err := webhooks.CreateWebhook("ftp://example.com", nil)
+	assert.Equal(t, ErrWebhookURLInvalid, err)
+	_, err = webhooks.CreateWebhook("/hooks", nil)
+	assert.Equal(t, ErrWebhookURLInvalid, err)
+	_, err = webhooks.CreateWebhook("https://example.com", []string{"comment.created"})
+	assert.Equal(t, ErrWebhookEventInvalid, err)
+	assert.Equal(t, ErrWebhookNotFound, webhooks.DeleteWebhook("unknown"))
+}
+
+func TestWebhookListensToEventBus(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	bus, _ := NewEventBus(10, 1, nil, &logger)
+	webhooks, _ := newTestWebhookService(t, "")
+	webhook, _ := webhooks.CreateWebhook("https://example.com/hook", nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		webhooks.Listen(ctx, bus)
+		close(done)
+	}()
+
+	// Events are queued even when the listener falls behind and is disconnected
+	assert.Eventually(t, func() bool {
+		bus.mutex.Lock()
+		defer bus.mutex.Unlock()
+		return len(bus.subscribers) == 1
+	}, time.Second, time.Millisecond)
+	for i := 1; i <= 5; i++ {
+		bus.Publish(Event{Type: EventPostCreated, PostID: i})
+	}
+	assert.Eventually(t, func() bool {
+		deliveries, _ := webhooks.ListDeliveries(webhook.ID)
+		return len(deliveries) == 5
+	}, time.Second, time.Millisecond)
+
+	cancel()
+	<-done
+}
+
+func TestWebhookDeliveriesArePruned(t *testing.T) {
+	receiver := newWebhookReceiver(0)
+	defer receiver.Close()
+	webhooks, clock := newTestWebhookService(t, "")
+	webhooks.config.Retention = time.Hour
+
+	webhook, _ := webhooks.CreateWebhook(receiver.URL, nil)
+	assert.NoError(t, webhooks.Enqueue(Event{ID: 1, Type: EventPostCreated, PostID: 1}))
+	webhooks.DeliverDue(context.Background())
+
+	// Pending deliveries are kept, sent ones only for the retention
+	clock.Advance(time.Minute * 90)
+	assert.NoError(t, webhooks.Enqueue(Event{ID: 2, Type: EventPostUpdated, PostID: 1}))
+	clock.Advance(-time.Minute)
+	webhooks.DeliverDue(context.Background())
+	deliveries, _ := webhooks.ListDeliveries(webhook.ID)
+	assert.Len(t, deliveries, 1)
+	assert.Equal(t, EventPostUpdated, deliveries[0].Event)
+	assert.Equal(t, DeliveryPending, deliveries[0].Status)
+}
+
+func TestWebhookDraftsAreNotSent(t *testing.T) {
+	receiver := newWebhookReceiver(0)
+	defer receiver.Close()
+	webhooks, _ := newTestWebhookService(t, "")
+	webhooks.CreateWebhook(receiver.URL, nil)
+
+	draft := &Post{ID: 1, Title: "Secret plans", Author: "Author 1", Status: PostDraft}
+	assert.NoError(t, webhooks.Enqueue(Event{ID: 1, Type: EventPostUpdated, Author: "Author 1", PostID: 1, Post: draft}))
+	webhooks.DeliverDue(context.Background())
+
+	assert.Len(t, receiver.bodies, 1)
+	assert.NotContains(t, string(receiver.bodies[0]), "Secret plans")
+	assert.Contains(t, string(receiver.bodies[0]), `"post_id":1`)
+}
+
+func TestWebhookWorkers(t *testing.T) {
+	// The slow receiver answers once it is released
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	fast := newWebhookReceiver(0)
+	defer fast.Close()
+	webhooks, _ := newTestWebhookService(t, "")
+	webhooks.CreateWebhook(slow.URL, nil)
+	webhooks.CreateWebhook(fast.URL, nil)
+	assert.NoError(t, webhooks.Enqueue(Event{ID: 1, Type: EventPostCreated, PostID: 1}))
+
+	done := make(chan struct{})
+	go func() {
+		webhooks.DeliverDue(context.Background())
+		close(done)
+	}()
+
+	// The fast receiver does not wait for the slow one, which is skipped while its worker is busy
+	assert.Eventually(t, func() bool {
+		fast.mutex.Lock()
+		defer fast.mutex.Unlock()
+		webhooks.mutex.Lock()
+		defer webhooks.mutex.Unlock()
+		return len(fast.requests) == 1 && len(webhooks.busy) == 1
+	}, time.Second, time.Millisecond)
+	assert.NoError(t, webhooks.Enqueue(Event{ID: 2, Type: EventPostUpdated, PostID: 1}))
+	webhooks.DeliverDue(context.Background())
+	assert.Len(t, fast.requests, 2)
+
+	close(release)
+	<-done
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"rakia.ai/blog-api/v2/internal"
+)
+
+// audit records an entry in the audit log for the request. The change is already made when it is recorded,
+// so failing to record does not fail the request, it is logged and fails the audit health check until
+// the audit log can be written again
+func (s *Server) audit(r *http.Request, entry internal.AuditEntry) {
+	if s.AuditLog == nil {
+		return
+	}
+	entry.IP = clientIP(r)
+	entry.RequestID = requestID(r)
+	if err := s.AuditLog.Record(entry); err != nil {
+		s.log(r).Error().Err(err).Str("action", entry.Action).Msg("error recording audit entry")
+	}
+}
+
+// auditChange records a change of a post with the post before and after it
+func (s *Server) auditChange(r *http.Request, actor string, action string, change *internal.PostChange) {
+	entry := internal.AuditEntry{Actor: actor, Action: action, BeforeHash: internal.HashPost(change.Before), AfterHash: internal.HashPost(change.After)}
+	if change.After != nil {
+		entry.PostID = change.After.ID
+	} else if change.Before != nil {
+		entry.PostID = change.Before.ID
+	}
+	s.audit(r, entry)
+}
+
+// auditLogin writes an audit log entry for a login attempt
+func (s *Server) auditLogin(r *http.Request, action string, author string) {
+	if s.Logger != nil {
+		s.log(r).Info().Str("audit", action).Str("author", author).Str("ip", clientIP(r)).Msg("login attempt")
+	}
+	if s.Metrics != nil {
+		s.Metrics.Login(action)
+	}
+	s.audit(r, internal.AuditEntry{Actor: author, Action: action})
+}
+
+// auditFilter reads the filter of the audit log from the query string
+func auditFilter(r *http.Request) (internal.AuditFilter, error) {
+	query := r.URL.Query()
+	filter := internal.AuditFilter{
+		Actor:  query.Get("actor"),
+		Action: query.Get("action"),
+	}
+	var err error
+	if v := query.Get("post_id"); v != "" {
+		if filter.PostID, err = strconv.Atoi(v); err != nil {
+			return filter, err
+		}
+	}
+	if v := query.Get("since"); v != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			return filter, err
+		}
+	}
+	if v := query.Get("until"); v != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, v); err != nil {
+			return filter, err
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil {
+			return filter, err
+		}
+	}
+	return filter, nil
+}
+
+// GetAuditHandler lists the audit log newest first, filtered by ?actor=, ?action=, ?post_id=,
+// ?since= and ?until= (RFC 3339) and ?limit=
+func (s *Server) GetAuditHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := auditFilter(r)
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("invalid audit filter")
+			writeJSONError(w, "invalid audit filter", http.StatusBadRequest)
+			return
+		}
+
+		entries, err := s.AuditLog.Query(filter)
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("error getting audit log")
+			writeJSONError(w, "error getting audit log", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, entries, http.StatusOK)
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"rakia.ai/blog-api/v2/internal"
+)
+
+func TestAuditHandlers(t *testing.T) {
+	audit, _ := internal.NewAuditLog("", nil, &logger)
+	mockPostsService := new(MockPostsService)
+	server := NewServer(mux.NewRouter(), mockPostsService, nil, &logger)
+	server.AuditLog = audit
+	server.Routes()
+
+	// The request ID is recorded with the entries
+	request := func(method string, path string, body string, author string) *httptest.ResponseRecorder {
+		req := newAuthorRequest(method, path, body, author)
+		req.Header.Set("X-Request-ID", "req-1")
+		return serve(server.Router, req)
+	}
+
+	// Updates record the post before and after
+	before := &internal.Post{ID: 1, Title: "Title 1", Author: "Author 1"}
+	after := &internal.Post{ID: 1, Title: "Title 2", Author: "Author 1"}
+	mockPostsService.On("UpdatePosts", internal.Post{ID: 1, Title: "Title 2", Author: "Author 1"}, "Author 1").Return(&internal.PostChange{Before: before, After: after}, nil)
+	rr := request("PUT", "/api/posts/1", `{"title":"Title 2","author":"Author 1"}`, "Author 1")
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+
+	// Only admin reads the audit log
+	rr = request("GET", "/api/audit", "", "Author 1")
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr = request("GET", "/api/audit?post_id=1&action=post.update", "", "admin")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var entries []internal.AuditEntry
+	json.Unmarshal(rr.Body.Bytes(), &entries)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "Author 1", entries[0].Actor)
+	assert.Equal(t, internal.HashPost(before), entries[0].BeforeHash)
+	assert.Equal(t, internal.HashPost(after), entries[0].AfterHash)
+	assert.Equal(t, "req-1", entries[0].RequestID)
+
+	rr = request("GET", "/api/audit?since=yesterday", "", "admin")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestAuditDeleteAuthorPosts(t *testing.T) {
+	audit, _ := internal.NewAuditLog("", nil, &logger)
+	mockPostsService := new(MockPostsService)
+	deleted := []internal.Post{{ID: 1, Title: "Title 1", Author: "Author 1"}, {ID: 2, Title: "Title 2", Author: "Author 1"}}
+	mockPostsService.On("DeleteAuthorPosts", "Author 1").Return(deleted, nil)
+	server := &Server{PostsService: mockPostsService, AuthorsService: &MockAuthorService{profiles: testProfiles()}, AuditLog: audit, Logger: &logger}
+
+	req, _ := http.NewRequest("DELETE", "/api/authors/Author%201?posts=delete", nil)
+	req = mux.SetURLVars(req, map[string]string{"name": "Author 1"})
+	req = req.WithContext(context.WithValue(req.Context(), ContextAuthor, "admin"))
+	rr := serve(server.DeleteAuthorHandler(), req)
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+
+	// Every post deleted along with the author is audited
+	entries, _ := audit.Query(internal.AuditFilter{Action: internal.AuditPostDelete})
+	assert.Len(t, entries, 2)
+	assert.Equal(t, 2, entries[0].PostID)
+	assert.Equal(t, internal.HashPost(&deleted[1]), entries[0].BeforeHash)
+	assert.Equal(t, "admin", entries[1].Actor)
+	assert.Equal(t, 1, entries[1].PostID)
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"rakia.ai/blog-api/v2/internal"
+)
+
+var (
+	ErrLoginStateInvalid   = fmt.Errorf("login state is invalid or has expired")
+	ErrLoginPayloadInvalid = fmt.Errorf("invalid request payload")
+	ErrCredentialsInvalid  = fmt.Errorf("invalid credentials")
+)
+
+// Name of the password authenticator, the only one whose success clears the failed logins of the account
+const PasswordLogin = "password"
+
+// Authenticator finds out which author is logging in, the server checks lockouts, asks for the second factor and
+// issues the JWT afterwards. When it fails it still returns the author if it is known, so the failure counts
+// against the account. The password authenticator answers /login, every other one gets /login/{name}/callback
+type Authenticator interface {
+	Authenticate(r *http.Request) (string, error)
+}
+
+// PasswordAuthenticator checks the author and password posted as JSON
+type PasswordAuthenticator struct {
+	Authors AuthorsService
+}
+
+// Authenticate decodes the credentials and validates them with the author service
+func (a *PasswordAuthenticator) Authenticate(r *http.Request) (string, error) {
+	var credentials internal.Author
+	if err := json.NewDecoder(r.Body).Decode(&credentials); err != nil {
+		return "", ErrLoginPayloadInvalid
+	}
+	valid, err := a.Authors.ValidAuthor(credentials.Author, credentials.Password)
+	if err != nil {
+		return credentials.Author, err
+	}
+	if !valid {
+		return credentials.Author, ErrCredentialsInvalid
+	}
+	return credentials.Author, nil
+}
+
+// Redirector is implemented by authenticators which start the login at an identity provider
+type Redirector interface {
+	Redirect(w http.ResponseWriter, r *http.Request)
+}
+
+// randomString returns a random URL safe string
+func randomString() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+type OIDCProvider interface {
+	AuthCodeURL(state string, nonce string, challenge string) string
+	Exchange(ctx context.Context, code string, verifier string) (*internal.IDTokenClaims, error)
+	ResolveAuthor(claims *internal.IDTokenClaims) (string, error)
+}
+
+// oidcLogin is a login which was sent to the identity provider and has not come back yet
+type oidcLogin struct {
+	nonce     string
+	verifier  string
+	expiresAt time.Time
+}
+
+// How long the author has to log in at the identity provider
+const oidcLoginExpirationTime = time.Minute * 10
+
+// Name of the cookie binding the login state to the browser which started it
+const oidcStateCookie = "blog_oidc_state"
+
+// OIDCAuthenticator logs authors in with the OpenID Connect authorization code flow and PKCE
+type OIDCAuthenticator struct {
+	Provider OIDCProvider
+	logins   map[string]oidcLogin // state -> login
+	mutex    sync.Mutex           // Protects access to logins
+}
+
+// NewOIDCAuthenticator creates a new OpenID Connect authenticator
+func NewOIDCAuthenticator(provider OIDCProvider) *OIDCAuthenticator {
+	return &OIDCAuthenticator{
+		Provider: provider,
+		logins:   make(map[string]oidcLogin),
+	}
+}
+
+// Redirect sends the author to the identity provider
+func (a *OIDCAuthenticator) Redirect(w http.ResponseWriter, r *http.Request) {
+	state, err := randomString()
+	if err != nil {
+		writeJSONError(w, "failed to start login", http.StatusInternalServerError)
+		return
+	}
+	nonce, err := randomString()
+	if err != nil {
+		writeJSONError(w, "failed to start login", http.StatusInternalServerError)
+		return
+	}
+	verifier, challenge, err := internal.NewPKCE()
+	if err != nil {
+		writeJSONError(w, "failed to start login", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	a.mutex.Lock()
+	// Forget logins which were never finished
+	for s, login := range a.logins {
+		if !login.expiresAt.After(now) {
+			delete(a.logins, s)
+		}
+	}
+	a.logins[state] = oidcLogin{nonce: nonce, verifier: verifier, expiresAt: now.Add(oidcLoginExpirationTime)}
+	a.mutex.Unlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/login",
+		MaxAge:   int(oidcLoginExpirationTime.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, a.Provider.AuthCodeURL(state, nonce, challenge), http.StatusFound)
+}
+
+// Authenticate finishes the login when the identity provider redirects back with a code
+func (a *OIDCAuthenticator) Authenticate(r *http.Request) (string, error) {
+	query := r.URL.Query()
+	if e := query.Get("error"); e != "" {
+		return "", fmt.Errorf("identity provider returned %s: %s", e, query.Get("error_description"))
+	}
+
+	// The state must belong to this browser and to a login which has not expired
+	state := query.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || state == "" || cookie.Value != state {
+		return "", ErrLoginStateInvalid
+	}
+	a.mutex.Lock()
+	login, ok := a.logins[state]
+	delete(a.logins, state)
+	a.mutex.Unlock()
+	if !ok || !login.expiresAt.After(time.Now()) {
+		return "", ErrLoginStateInvalid
+	}
+
+	claims, err := a.Provider.Exchange(r.Context(), query.Get("code"), login.verifier)
+	if err != nil {
+		return "", err
+	}
+	if claims.Nonce != login.nonce {
+		return "", internal.ErrOIDCTokenInvalid
+	}
+	return a.Provider.ResolveAuthor(claims)
+}
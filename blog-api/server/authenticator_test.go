@@ -0,0 +1,180 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"rakia.ai/blog-api/v2/internal"
+)
+
+// fakeOIDCProvider is an in-process identity provider which logs everybody in as the configured identity
+type fakeOIDCProvider struct {
+	server   *httptest.Server
+	key      *rsa.PrivateKey
+	clientID string
+	identity jwt.MapClaims
+	codes    map[string]url.Values // code -> authorization request
+	mutex    sync.Mutex
+}
+
+func newFakeOIDCProvider(t *testing.T, clientID string) *fakeOIDCProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	p := &fakeOIDCProvider{key: key, clientID: clientID, codes: make(map[string]url.Values)}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.server.URL,
+			"authorization_endpoint": p.server.URL + "/authorize",
+			"token_endpoint":         p.server.URL + "/token",
+			"jwks_uri":               p.server.URL + "/jwks",
+		})
+	})
+	router.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test",
+				"n":   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+			}},
+		})
+	})
+	// The author is logged in right away and sent back with a code
+	router.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		code, _ := randomString()
+		p.mutex.Lock()
+		p.codes[code] = query
+		p.mutex.Unlock()
+		http.Redirect(w, r, query.Get("redirect_uri")+"?code="+code+"&state="+url.QueryEscape(query.Get("state")), http.StatusFound)
+	})
+	router.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		p.mutex.Lock()
+		authorization, ok := p.codes[r.PostForm.Get("code")]
+		delete(p.codes, r.PostForm.Get("code"))
+		p.mutex.Unlock()
+		if !ok || internal.PKCEChallenge(r.PostForm.Get("code_verifier")) != authorization.Get("code_challenge") {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+
+		claims := jwt.MapClaims{
+			"iss":   p.server.URL,
+			"aud":   []string{p.clientID},
+			"exp":   time.Now().Add(time.Minute).Unix(),
+			"iat":   time.Now().Unix(),
+			"nonce": authorization.Get("nonce"),
+		}
+		for k, v := range p.identity {
+			claims[k] = v
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "test"
+		idToken, _ := token.SignedString(key)
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "x", "token_type": "Bearer", "id_token": idToken})
+	})
+	p.server = httptest.NewServer(router)
+	return p
+}
+
+func TestOIDCLogin(t *testing.T) {
+	idp := newFakeOIDCProvider(t, "blog")
+	defer idp.server.Close()
+
+	// The blog server URL is needed for the redirect URL before the handler exists
+	var handler http.Handler
+	blog := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r)
+	}))
+	defer blog.Close()
+
+	a := make(internal.AuthorPassword)
+	authors, _ := internal.NewAuthorService(&a, &logger)
+	assert.NoError(t, authors.CreateAuthor(internal.AuthorProfile{Name: "Author 1", Email: "author1@example.com"}))
+
+	provider, err := internal.NewOIDCProvider(context.Background(), internal.OIDCConfig{
+		Issuer:      idp.server.URL,
+		ClientID:    "blog",
+		RedirectURL: blog.URL + "/login/oidc/callback",
+	}, "", authors, nil, &logger)
+	assert.NoError(t, err)
+
+	guard := &MockLoginGuard{}
+	twoFactor := new(MockTwoFactorService)
+	twoFactor.On("Enabled", "Author 1").Return(false).Once()
+	twoFactor.On("Enabled", "Author 1").Return(true)
+	s := NewServer(mux.NewRouter(), new(MockPostsService), authors, &logger)
+	s.Authenticators = map[string]Authenticator{"oidc": NewOIDCAuthenticator(provider)}
+	s.LoginGuard = guard
+	s.TwoFactorService = twoFactor
+	s.Routes()
+	handler = s.Router
+
+	login := func() *http.Response {
+		jar, _ := cookiejar.New(nil)
+		client := &http.Client{Jar: jar}
+		resp, err := client.Get(blog.URL + "/login/oidc")
+		assert.NoError(t, err)
+		return resp
+	}
+
+	// The verified email address matches the author
+	idp.identity = jwt.MapClaims{"sub": "abc", "email": "author1@example.com", "email_verified": true}
+	resp := login()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var response LoginResponse
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&response))
+	resp.Body.Close()
+	claims, err := parseToken(response.Token)
+	assert.NoError(t, err)
+	assert.Equal(t, "Author 1", claims.Username)
+	// Logging in at the identity provider does not clear the failed password logins
+	assert.Equal(t, 0, guard.successes)
+
+	// With two-factor authentication the identity provider only yields a challenge, like the password
+	resp = login()
+	var challenge LoginChallengeResponse
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&challenge))
+	resp.Body.Close()
+	assert.True(t, challenge.TwoFactorRequired)
+	claims, err = parseToken(challenge.ChallengeToken)
+	assert.NoError(t, err)
+	assert.Equal(t, "oidc", claims.Login)
+
+	// Unknown identities are rejected without auto provisioning
+	idp.identity = jwt.MapClaims{"sub": "xyz", "email": "someone@example.com", "email_verified": true}
+	resp = login()
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, 1, guard.failures)
+
+	// Locked out accounts and addresses cannot log in at the identity provider either
+	guard.err = &internal.LockoutError{RetryAfter: time.Minute}
+	resp = login()
+	resp.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	guard.err = nil
+
+	// The callback only works from the browser which started the login
+	resp, err = http.Get(blog.URL + "/login/oidc/callback?code=abc&state=forged")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
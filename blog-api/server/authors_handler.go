@@ -0,0 +1,268 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"rakia.ai/blog-api/v2/internal"
+)
+
+type ProfileUpdate struct {
+	DisplayName string `json:"display_name"`
+	Bio         string `json:"bio"`
+	AvatarURL   string `json:"avatar_url"`
+	Website     string `json:"website"`
+	Email       string `json:"email"`
+}
+
+type RoleUpdate struct {
+	Role string `json:"role"`
+}
+
+// What happens to the posts of a deleted author
+const (
+	PostsReassign = "reassign"
+	PostsDelete   = "delete"
+)
+
+// visibleProfile hides the email address from everybody but the author and admin
+func visibleProfile(profile *internal.AuthorProfile, viewer string) *internal.AuthorProfile {
+	if viewer == profile.Name || viewer == "admin" {
+		return profile
+	}
+	result := *profile
+	result.Email = ""
+	return &result
+}
+
+// GetAuthorsHandler lists all authors, disabled authors are only shown to admin
+func (s *Server) GetAuthorsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		author, _ := r.Context().Value(ContextAuthor).(string)
+
+		authors, err := s.AuthorsService.ListAuthors()
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("error getting authors")
+			writeError(w, err, "error getting authors")
+			return
+		}
+
+		result := []*internal.AuthorProfile{}
+		for _, profile := range authors {
+			if profile.Disabled && author != "admin" {
+				continue
+			}
+			result = append(result, visibleProfile(profile, author))
+		}
+
+		writeJSON(w, result, http.StatusOK)
+	}
+}
+
+// GetAuthorHandler gets the profile of an author
+func (s *Server) GetAuthorHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		author, _ := r.Context().Value(ContextAuthor).(string)
+
+		// Get the author name from the URL
+		name, ok := mux.Vars(r)["name"]
+		if !ok {
+			s.log(r).Error().Msg("missing author name")
+			writeJSONError(w, "missing author name", http.StatusBadRequest)
+			return
+		}
+
+		profile, err := s.AuthorsService.GetAuthor(name)
+		if err != nil || (profile.Disabled && author != "admin" && author != name) {
+			s.log(r).Debug().Err(err).Msg("author not found")
+			writeError(w, internal.ErrAuthorNotFound, "")
+			return
+		}
+
+		writeJSON(w, visibleProfile(profile, author), http.StatusOK)
+	}
+}
+
+// UpdateProfileHandler updates the profile of the logged in author
+func (s *Server) UpdateProfileHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get the context from the request
+		author, ok := r.Context().Value(ContextAuthor).(string)
+		if !ok {
+			s.log(r).Error().Msg("error getting author from context")
+			writeJSONError(w, ErrInvalidRequest, http.StatusBadRequest)
+			return
+		}
+
+		// Validate incoming profile
+		var update ProfileUpdate
+		err := json.NewDecoder(r.Body).Decode(&update)
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("invalid request payload")
+			writeJSONError(w, "invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		profile, err := s.AuthorsService.UpdateProfile(author, internal.AuthorProfile{
+			DisplayName: update.DisplayName,
+			Bio:         update.Bio,
+			AvatarURL:   update.AvatarURL,
+			Website:     update.Website,
+			Email:       update.Email,
+		})
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("error updating profile")
+			writeError(w, err, "error updating profile")
+			return
+		}
+
+		writeJSON(w, profile, http.StatusOK)
+	}
+}
+
+// SetAuthorDisabledHandler disables or enables an author, only admin may do this
+func (s *Server) SetAuthorDisabledHandler(disabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get the context from the request
+		author, ok := r.Context().Value(ContextAuthor).(string)
+		if !ok {
+			s.log(r).Error().Msg("error getting author from context")
+			writeJSONError(w, ErrInvalidRequest, http.StatusBadRequest)
+			return
+		}
+		if author != "admin" {
+			s.log(r).Error().Msg("only admin can disable authors")
+			writeError(w, internal.ErrAdminRequired, "")
+			return
+		}
+
+		// Get the author name from the URL
+		name, ok := mux.Vars(r)["name"]
+		if !ok {
+			s.log(r).Error().Msg("missing author name")
+			writeJSONError(w, "missing author name", http.StatusBadRequest)
+			return
+		}
+
+		err := s.AuthorsService.SetAuthorDisabled(name, disabled)
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("error disabling author")
+			writeError(w, err, "error disabling author")
+			return
+		}
+
+		// Status accepted
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// SetAuthorRoleHandler changes the role of an author, only admin may do this
+func (s *Server) SetAuthorRoleHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var update RoleUpdate
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			s.log(r).Error().Err(err).Msg("invalid request payload")
+			writeJSONError(w, "invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		err := s.AuthorsService.SetAuthorRole(mux.Vars(r)["name"], update.Role)
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("error changing role")
+			writeError(w, err, "error changing role")
+			return
+		}
+
+		// Status accepted
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// DeleteAuthorHandler deletes an author, ?posts=reassign&to=name moves their posts to another author
+// and ?posts=delete deletes them, only admin may do this
+func (s *Server) DeleteAuthorHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get the context from the request
+		author, ok := r.Context().Value(ContextAuthor).(string)
+		if !ok {
+			s.log(r).Error().Msg("error getting author from context")
+			writeJSONError(w, ErrInvalidRequest, http.StatusBadRequest)
+			return
+		}
+		if author != "admin" {
+			s.log(r).Error().Msg("only admin can delete authors")
+			writeError(w, internal.ErrAdminRequired, "")
+			return
+		}
+
+		// Get the author name from the URL
+		name, ok := mux.Vars(r)["name"]
+		if !ok {
+			s.log(r).Error().Msg("missing author name")
+			writeJSONError(w, "missing author name", http.StatusBadRequest)
+			return
+		}
+		if name == "admin" {
+			writeError(w, internal.ErrAuthorAdmin, "")
+			return
+		}
+		if _, err := s.AuthorsService.GetAuthor(name); err != nil {
+			s.log(r).Debug().Err(err).Msg("author not found")
+			writeError(w, internal.ErrAuthorNotFound, "")
+			return
+		}
+
+		// Reassign or delete the posts, the author is only removed when that worked.
+		// Every post is audited as it is changed along with the author
+		var removePosts func() error
+		switch r.URL.Query().Get("posts") {
+		case PostsReassign:
+			to := r.URL.Query().Get("to")
+			if to == "" || to == name {
+				writeJSONError(w, "posts must be reassigned to another author", http.StatusBadRequest)
+				return
+			}
+			if _, err := s.AuthorsService.GetAuthor(to); err != nil {
+				writeError(w, internal.ErrReassignAuthor, "")
+				return
+			}
+			removePosts = func() error {
+				changes, err := s.PostsService.ReassignPosts(r.Context(), name, to)
+				for i := range changes {
+					s.auditChange(r, author, internal.AuditPostUpdate, &changes[i])
+				}
+				return err
+			}
+		case PostsDelete:
+			removePosts = func() error {
+				deleted, err := s.PostsService.DeleteAuthorPosts(r.Context(), name)
+				for i := range deleted {
+					s.auditChange(r, author, internal.AuditPostDelete, &internal.PostChange{Before: &deleted[i]})
+				}
+				return err
+			}
+		default:
+			writeJSONError(w, "posts must be either reassign or delete", http.StatusBadRequest)
+			return
+		}
+
+		if err := s.AuthorsService.DeleteAuthor(name, removePosts); err != nil {
+			s.log(r).Error().Err(err).Msg("error deleting author")
+			writeError(w, err, "error deleting author")
+			return
+		}
+		// The tokens and the second factor must not be taken over by a new author of the same name
+		if s.TokensService != nil {
+			revoked := s.TokensService.RevokeAll(name)
+			s.log(r).Info().Str("author", name).Int("tokens", revoked).Msg("tokens of deleted author revoked")
+		}
+		if s.TwoFactorService != nil {
+			s.TwoFactorService.Reset(name)
+		}
+		s.audit(r, internal.AuditEntry{Actor: author, Action: internal.AuditAuthorDelete, Target: name})
+
+		// Status accepted
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"rakia.ai/blog-api/v2/internal"
+)
+
+func testProfiles() map[string]*internal.AuthorProfile {
+	return map[string]*internal.AuthorProfile{
+		"Author 1": {Name: "Author 1", DisplayName: "First Author", AvatarURL: "https://example.com/1.png"},
+		"Author 2": {Name: "Author 2", DisplayName: "Second Author", Disabled: true},
+	}
+}
+
+func TestGetAuthorsHandlerHidesDisabled(t *testing.T) {
+	server := &Server{AuthorsService: &MockAuthorService{profiles: testProfiles()}, Logger: &logger}
+
+	req, _ := http.NewRequest("GET", "/api/authors", nil)
+	req = req.WithContext(context.WithValue(req.Context(), ContextAuthor, "Author 1"))
+	rr := httptest.NewRecorder()
+	server.GetAuthorsHandler().ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var authors []internal.AuthorProfile
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &authors))
+	assert.Len(t, authors, 1)
+	assert.Equal(t, "Author 1", authors[0].Name)
+}
+
+func TestGetPostsHandlerEmbedsAuthor(t *testing.T) {
+	mockPostsService := new(MockPostsService)
+	mockPostsService.On("GetPostByID", 1).Return(&internal.Post{ID: 1, Title: "Title 1", Author: "Author 1"}, nil)
+
+	server := &Server{PostsService: mockPostsService, AuthorsService: &MockAuthorService{profiles: testProfiles()}, Logger: &logger}
+
+	req, _ := http.NewRequest("GET", "/api/posts/1", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	rr := httptest.NewRecorder()
+	server.GetPostsHandler().ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var post PostResponse
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &post))
+	assert.Equal(t, "First Author", post.AuthorProfile.DisplayName)
+	assert.Equal(t, "https://example.com/1.png", post.AuthorProfile.AvatarURL)
+}
+
+func TestDeleteAuthorHandler(t *testing.T) {
+	cases := []struct {
+		author string
+		query  string
+		want   int
+		code   string
+		test   string
+	}{
+		{"Author 1", "posts=delete", http.StatusForbidden, "admin_required", "only admin"},
+		{"admin", "", http.StatusBadRequest, "bad_request", "missing posts choice"},
+		{"admin", "posts=reassign", http.StatusBadRequest, "bad_request", "missing reassign target"},
+		{"admin", "posts=reassign&to=Author+3", http.StatusBadRequest, "reassign_author_not_found", "unknown reassign target"},
+		{"admin", "posts=reassign&to=Author+2", http.StatusAccepted, "", "reassign posts"},
+		{"admin", "posts=delete", http.StatusAccepted, "", "delete posts"},
+	}
+
+	for _, tc := range cases {
+		mockPostsService := new(MockPostsService)
+		mockPostsService.On("ReassignPosts", "Author 1", "Author 2").Return(nil, nil)
+		mockPostsService.On("DeleteAuthorPosts", "Author 1").Return(nil, nil)
+		authors := &MockAuthorService{profiles: testProfiles()}
+
+		server := &Server{PostsService: mockPostsService, AuthorsService: authors, Logger: &logger}
+
+		req, _ := http.NewRequest("DELETE", "/api/authors/Author%201?"+tc.query, nil)
+		req = mux.SetURLVars(req, map[string]string{"name": "Author 1"})
+		req = req.WithContext(context.WithValue(req.Context(), ContextAuthor, tc.author))
+		rr := httptest.NewRecorder()
+		server.DeleteAuthorHandler().ServeHTTP(rr, req)
+
+		assert.Equal(t, tc.want, rr.Code, tc.test)
+		if tc.code != "" {
+			assert.Equal(t, tc.code, responseCode(rr), tc.test)
+		}
+		if tc.want == http.StatusAccepted {
+			assert.Equal(t, []string{"Author 1"}, authors.deleted, tc.test)
+		} else {
+			assert.Empty(t, authors.deleted, tc.test)
+		}
+	}
+}
+
+func TestDeleteAuthorHandlerKeepsAuthorWhenPostsFail(t *testing.T) {
+	mockPostsService := new(MockPostsService)
+	mockPostsService.On("ReassignPosts", "Author 1", "Author 2").Return(nil, internal.ErrReassignConflict)
+	authors := &MockAuthorService{profiles: testProfiles()}
+	server := &Server{PostsService: mockPostsService, AuthorsService: authors, Logger: &logger}
+
+	req, _ := http.NewRequest("DELETE", "/api/authors/Author%201?posts=reassign&to=Author+2", nil)
+	req = mux.SetURLVars(req, map[string]string{"name": "Author 1"})
+	req = req.WithContext(context.WithValue(req.Context(), ContextAuthor, "admin"))
+	rr := serve(server.DeleteAuthorHandler(), req)
+
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Equal(t, "reassign_title_conflict", responseCode(rr))
+	assert.Empty(t, authors.deleted)
+}
+
+func TestDeleteAuthorRevokesCredentials(t *testing.T) {
+	mockPostsService := new(MockPostsService)
+	mockPostsService.On("DeleteAuthorPosts", "Author 1").Return(nil, nil)
+	mockPostsService.On("GetPostByID", 1).Return(&internal.Post{ID: 1, Title: "Title 1", Author: "Author 1"}, nil)
+	authors := &MockAuthorService{profiles: testProfiles()}
+	tokens, _ := internal.NewTokenService("", &logger)
+	twoFactor, _ := internal.NewTwoFactorService("", nil, &logger)
+	server := NewServer(mux.NewRouter(), mockPostsService, authors, &logger)
+	server.TokensService = tokens
+	server.TwoFactorService = twoFactor
+	server.Routes()
+
+	_, raw, _ := tokens.CreateToken("Author 1", "ci", internal.AllScopes, nil)
+	_, err := twoFactor.Enroll("Author 1")
+	assert.NoError(t, err)
+	pat, _ := http.NewRequest("GET", "/api/posts/1", nil)
+	pat.Header.Set("Authorization", "Bearer "+raw)
+	assert.Equal(t, http.StatusOK, serve(server.Router, pat).Code)
+
+	rr := serve(server.Router, newAuthorRequest("DELETE", "/api/authors/Author%201?posts=delete", "", "admin"))
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+	assert.Equal(t, http.StatusUnauthorized, serve(server.Router, pat).Code)
+
+	// A new author of the same name does not get the token
+	authors.deleted = nil
+	assert.Equal(t, http.StatusUnauthorized, serve(server.Router, pat).Code)
+	_, err = tokens.ValidateToken(raw)
+	assert.Equal(t, internal.ErrTokenInvalid, err)
+	_, err = twoFactor.Confirm("Author 1", "000000")
+	assert.Equal(t, internal.ErrTwoFactorNotEnrolled, err)
+}
+
+func TestInactiveAuthorCredentialsRejected(t *testing.T) {
+	mockPostsService := new(MockPostsService)
+	mockPostsService.On("GetPostByID", 1).Return(&internal.Post{ID: 1, Title: "Title 1", Author: "Author 1"}, nil)
+	authors := &MockAuthorService{profiles: testProfiles()}
+	tokens := new(MockTokensService)
+	tokens.On("ValidateToken", internal.TokenPrefix+"valid").Return(&internal.Token{ID: "abc", Author: "Author 1", Scopes: internal.AllScopes}, nil)
+	server := NewServer(mux.NewRouter(), mockPostsService, authors, &logger)
+	server.TokensService = tokens
+	server.Routes()
+
+	token := newAuthorRequest("GET", "/api/posts/1", "", "Author 1")
+	pat, _ := http.NewRequest("GET", "/api/posts/1", nil)
+	pat.Header.Set("Authorization", "Bearer "+internal.TokenPrefix+"valid")
+	assert.Equal(t, http.StatusOK, serve(server.Router, token).Code)
+	assert.Equal(t, http.StatusOK, serve(server.Router, pat).Code)
+
+	// The session and the personal access token of a disabled author stop working right away
+	assert.NoError(t, authors.SetAuthorDisabled("Author 1", true))
+	assert.Equal(t, http.StatusUnauthorized, serve(server.Router, token).Code)
+	assert.Equal(t, http.StatusUnauthorized, serve(server.Router, pat).Code)
+
+	// And those of a deleted author
+	assert.NoError(t, authors.SetAuthorDisabled("Author 1", false))
+	assert.NoError(t, authors.DeleteAuthor("Author 1", func() error { return nil }))
+	assert.Equal(t, http.StatusUnauthorized, serve(server.Router, token).Code)
+	assert.Equal(t, http.StatusUnauthorized, serve(server.Router, pat).Code)
+}
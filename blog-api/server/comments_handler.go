@@ -0,0 +1,164 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+type CommentCreate struct {
+	Body     string `json:"body"`
+	ParentID int    `json:"parent_id"`
+}
+
+type CommentUpdate struct {
+	Body string `json:"body"`
+}
+
+type CommentModeration struct {
+	Status string `json:"status"`
+}
+
+// commentID gets the comment ID from the URL
+func (s *Server) commentID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["commentID"])
+	if err != nil {
+		s.log(r).Error().Err(err).Msg("invalid comment id")
+		writeJSONError(w, "invalid comment id", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+// CreateCommentHandler adds a comment or a reply to a post
+func (s *Server) CreateCommentHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		author, post, ok := s.postContext(w, r)
+		if !ok {
+			return
+		}
+
+		var commentRequest CommentCreate
+		err := json.NewDecoder(r.Body).Decode(&commentRequest)
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("invalid request payload")
+			writeJSONError(w, "invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		comment, err := s.CommentsService.CreateComment(*post, commentRequest.ParentID, author, commentRequest.Body)
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("error creating comment")
+			writeError(w, err, "error creating comment")
+			return
+		}
+
+		writeJSON(w, comment, http.StatusCreated)
+	}
+}
+
+// GetCommentsHandler lists the comments of a post as threads
+func (s *Server) GetCommentsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		author, post, ok := s.postContext(w, r)
+		if !ok {
+			return
+		}
+
+		comments, err := s.CommentsService.ListComments(*post, author)
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("error getting comments")
+			writeError(w, err, "error getting comments")
+			return
+		}
+
+		writeJSON(w, comments, http.StatusOK)
+	}
+}
+
+// UpdateCommentHandler changes the body of a comment of the author
+func (s *Server) UpdateCommentHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		author, post, ok := s.postContext(w, r)
+		if !ok {
+			return
+		}
+		id, ok := s.commentID(w, r)
+		if !ok {
+			return
+		}
+
+		var commentRequest CommentUpdate
+		err := json.NewDecoder(r.Body).Decode(&commentRequest)
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("invalid request payload")
+			writeJSONError(w, "invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		comment, err := s.CommentsService.UpdateComment(*post, id, author, commentRequest.Body)
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("error updating comment")
+			writeError(w, err, "error updating comment")
+			return
+		}
+
+		writeJSON(w, comment, http.StatusAccepted)
+	}
+}
+
+// DeleteCommentHandler deletes a comment and its replies
+func (s *Server) DeleteCommentHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		author, post, ok := s.postContext(w, r)
+		if !ok {
+			return
+		}
+		id, ok := s.commentID(w, r)
+		if !ok {
+			return
+		}
+
+		if err := s.CommentsService.DeleteComment(*post, id, author); err != nil {
+			s.log(r).Error().Err(err).Msg("error deleting comment")
+			writeError(w, err, "error deleting comment")
+			return
+		}
+
+		// Status accepted
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// ModerateCommentHandler approves or rejects a comment, only the post author and admin
+func (s *Server) ModerateCommentHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		author, post, ok := s.postContext(w, r)
+		if !ok {
+			return
+		}
+		id, ok := s.commentID(w, r)
+		if !ok {
+			return
+		}
+
+		var moderation CommentModeration
+		err := json.NewDecoder(r.Body).Decode(&moderation)
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("invalid request payload")
+			writeJSONError(w, "invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		comment, err := s.CommentsService.ModerateComment(*post, id, author, moderation.Status)
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("error moderating comment")
+			writeError(w, err, "error moderating comment")
+			return
+		}
+
+		writeJSON(w, comment, http.StatusAccepted)
+	}
+}
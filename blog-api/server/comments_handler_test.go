@@ -0,0 +1,86 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"rakia.ai/blog-api/v2/internal"
+)
+
+// commentRequest builds a request for the comment handlers as the author
+func commentRequest(method string, body string, author string, vars map[string]string) *http.Request {
+	req, _ := http.NewRequest(method, "/api/posts/1/comments", bytes.NewBufferString(body))
+	req = req.WithContext(context.WithValue(req.Context(), ContextAuthor, author))
+	return mux.SetURLVars(req, vars)
+}
+
+func TestCommentHandlers(t *testing.T) {
+	post := &internal.Post{ID: 1, Title: "First", Author: "Author 1"}
+	mockPostsService := new(MockPostsService)
+	mockPostsService.On("GetPostByID", 1).Return(post, nil)
+	mockPostsService.On("GetPostByID", 2).Return((*internal.Post)(nil), internal.ErrPostNotFound)
+
+	comments, _ := internal.NewCommentService(nil, &logger)
+	server := &Server{PostsService: mockPostsService, CommentsService: comments, Logger: &logger}
+
+	// Comment as another author, it waits for moderation
+	rr := httptest.NewRecorder()
+	server.CreateCommentHandler().ServeHTTP(rr, commentRequest("POST", `{"body":"Great read"}`, "Author 2", map[string]string{"id": "1"}))
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	var comment internal.Comment
+	json.Unmarshal(rr.Body.Bytes(), &comment)
+	assert.Equal(t, internal.CommentPending, comment.Status)
+
+	rr = httptest.NewRecorder()
+	server.CreateCommentHandler().ServeHTTP(rr, commentRequest("POST", `{"body":"Buy now"}`, "Author 2", map[string]string{"id": "1"}))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = httptest.NewRecorder()
+	server.CreateCommentHandler().ServeHTTP(rr, commentRequest("POST", `{"body":"Great read"}`, "Author 2", map[string]string{"id": "2"}))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	// Other authors do not see it yet
+	rr = httptest.NewRecorder()
+	server.GetCommentsHandler().ServeHTTP(rr, commentRequest("GET", "", "Author 3", map[string]string{"id": "1"}))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `[]`, rr.Body.String())
+
+	// Only the post author can approve it
+	vars := map[string]string{"id": "1", "commentID": "1"}
+	rr = httptest.NewRecorder()
+	server.ModerateCommentHandler().ServeHTTP(rr, commentRequest("POST", `{"status":"approved"}`, "Author 2", vars))
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr = httptest.NewRecorder()
+	server.ModerateCommentHandler().ServeHTTP(rr, commentRequest("POST", `{"status":"approved"}`, "Author 1", vars))
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+
+	rr = httptest.NewRecorder()
+	server.GetCommentsHandler().ServeHTTP(rr, commentRequest("GET", "", "Author 3", map[string]string{"id": "1"}))
+	var threads []internal.Comment
+	json.Unmarshal(rr.Body.Bytes(), &threads)
+	assert.Len(t, threads, 1)
+
+	// Only the commenter can edit it
+	rr = httptest.NewRecorder()
+	server.UpdateCommentHandler().ServeHTTP(rr, commentRequest("PUT", `{"body":"Edited"}`, "Author 3", vars))
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr = httptest.NewRecorder()
+	server.UpdateCommentHandler().ServeHTTP(rr, commentRequest("PUT", `{"body":"Edited"}`, "Author 2", vars))
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+
+	rr = httptest.NewRecorder()
+	server.DeleteCommentHandler().ServeHTTP(rr, commentRequest("DELETE", "", "Author 2", vars))
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+
+	rr = httptest.NewRecorder()
+	server.DeleteCommentHandler().ServeHTTP(rr, commentRequest("DELETE", "", "Author 2", vars))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Methods the routes of the API are registered with, preflights answer with those the route has
+var corsMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+
+// Response headers browsers let scripts of other origins read besides the simple ones
+var corsExposedHeaders = []string{
+	"ETag", "Last-Modified", "Retry-After", "X-Request-ID",
+	"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset",
+}
+
+// CORSConfig sets which other origins browsers let call the API, e.g. an editor served from another domain
+type CORSConfig struct {
+	Origins     []string      // e.g. https://editor.example.com, https://*.example.com for its subdomains or * for any
+	Methods     []string      // Methods other origins may use, all methods of the route when empty
+	Headers     []string      // Request headers other origins may send, * for any
+	Credentials bool          // Whether browsers send cookies and client certificates along
+	MaxAge      time.Duration // How long browsers cache a preflight
+}
+
+// subdomainOrigin allows the subdomains of a domain, e.g. https:// and .example.com for https://*.example.com
+type subdomainOrigin struct {
+	scheme string
+	parent string
+}
+
+// CORS answers preflights and adds the CORS headers to responses for allowed origins
+type CORS struct {
+	origins     map[string]bool
+	subdomains  []subdomainOrigin
+	anyOrigin   bool
+	methods     map[string]bool
+	headers     string
+	anyHeader   bool
+	credentials bool
+	maxAge      time.Duration
+}
+
+// NewCORS creates the CORS handling of the config, origins are a scheme and a host with an optional port
+func NewCORS(config CORSConfig) (*CORS, error) {
+	if len(config.Origins) == 0 {
+		return nil, fmt.Errorf("cors needs at least one allowed origin")
+	}
+	c := &CORS{
+		origins:     make(map[string]bool),
+		credentials: config.Credentials,
+		maxAge:      config.MaxAge,
+	}
+	for _, origin := range config.Origins {
+		origin = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(origin), "/"))
+		if origin == "*" {
+			c.anyOrigin = true
+			continue
+		}
+		u, err := url.Parse(strings.Replace(origin, "://*.", "://", 1))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || strings.Contains(u.Host, "*") {
+			return nil, fmt.Errorf("invalid cors origin %q", origin)
+		}
+		if strings.Contains(origin, "://*.") {
+			c.subdomains = append(c.subdomains, subdomainOrigin{scheme: u.Scheme + "://", parent: "." + u.Host})
+			continue
+		}
+		c.origins[origin] = true
+	}
+	// Any site the author visits could act with their cookies, so credentials need the origins to be listed
+	if c.anyOrigin && c.credentials {
+		return nil, fmt.Errorf("cors cannot allow any origin with credentials, list the origins instead")
+	}
+	if len(config.Methods) > 0 {
+		c.methods = make(map[string]bool)
+		for _, method := range config.Methods {
+			c.methods[strings.ToUpper(strings.TrimSpace(method))] = true
+		}
+	}
+	headers := make([]string, 0, len(config.Headers))
+	for _, header := range config.Headers {
+		header = strings.TrimSpace(header)
+		if header == "*" {
+			c.anyHeader = true
+		} else if header != "" {
+			headers = append(headers, header)
+		}
+	}
+	c.headers = strings.Join(headers, ", ")
+	return c, nil
+}
+
+// allowed reports whether the origin may call the API
+func (c *CORS) allowed(origin string) bool {
+	if origin == "" {
+		return false
+	}
+	if c.anyOrigin {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if c.origins[origin] {
+		return true
+	}
+	for _, sub := range c.subdomains {
+		if strings.HasPrefix(origin, sub.scheme) && strings.HasSuffix(origin, sub.parent) &&
+			len(origin) > len(sub.scheme)+len(sub.parent) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowOrigin adds the headers every response to an allowed origin needs. The origin is echoed instead of *, so
+// credentials work, and caches keep the responses of each origin apart
+func (c *CORS) allowOrigin(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Add("Vary", "Origin")
+	origin := r.Header.Get("Origin")
+	if !c.allowed(origin) {
+		return false
+	}
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	if c.credentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+	return true
+}
+
+// Middleware adds the CORS headers to the responses of allowed origins, preflights are answered by the
+// PreflightHandler
+func (c *CORS) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodOptions && c.allowOrigin(w, r) {
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// routeMethods returns the methods the router has a route for at the path of the request
+func routeMethods(router *mux.Router, r *http.Request) []string {
+	var methods []string
+	for _, method := range corsMethods {
+		probe := r.Clone(r.Context())
+		probe.Method = method
+		var match mux.RouteMatch
+		if router.Match(probe, &match) && match.MatchErr == nil && !isPreflightRoute(match.Route) {
+			methods = append(methods, method)
+		}
+	}
+	return methods
+}
+
+// Name of the route answering preflights, so it is not taken for a route of the API
+const preflightRoute = "cors-preflight"
+
+func isPreflightRoute(route *mux.Route) bool {
+	return route != nil && route.GetName() == preflightRoute
+}
+
+// PreflightHandler answers the OPTIONS requests browsers send before calling the API from another origin, with
+// the methods the route at the path has. The router only has routes for the methods of the API, so it is
+// registered for OPTIONS on every path
+func (s *Server) PreflightHandler() http.HandlerFunc {
+	c := s.CORS
+	return func(w http.ResponseWriter, r *http.Request) {
+		methods := routeMethods(s.Router, r)
+		if len(methods) == 0 {
+			writeJSONError(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Allow", strings.Join(append(methods, "OPTIONS"), ", "))
+
+		requested := r.Header.Get("Access-Control-Request-Method")
+		if requested == "" || !c.allowOrigin(w, r) {
+			// Not a preflight or not an allowed origin, the browser refuses without the CORS headers
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		allowed := make([]string, 0, len(methods))
+		for _, method := range methods {
+			if c.methods == nil || c.methods[method] {
+				allowed = append(allowed, method)
+			}
+		}
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(allowed, ", "))
+		if c.anyHeader {
+			if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+				w.Header().Set("Access-Control-Allow-Headers", headers)
+			}
+		} else if c.headers != "" {
+			w.Header().Set("Access-Control-Allow-Headers", c.headers)
+		}
+		if c.maxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.maxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
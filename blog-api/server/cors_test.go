@@ -0,0 +1,118 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"rakia.ai/blog-api/v2/internal"
+)
+
+func corsTestServer(t *testing.T, config CORSConfig) *Server {
+	cors, err := NewCORS(config)
+	assert.NoError(t, err)
+
+	mockPostsService := new(MockPostsService)
+	mockPostsService.On("GetPostByID", 1).Return(&internal.Post{ID: 1, Author: "Author 1", Status: internal.PostPublished}, nil)
+
+	server := NewServer(mux.NewRouter(), mockPostsService, nil, &logger)
+	server.CORS = cors
+	server.Routes()
+	return server
+}
+
+func preflight(server *Server, path string, origin string, method string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("OPTIONS", path, nil)
+	req.Header.Set("Origin", origin)
+	req.Header.Set("Access-Control-Request-Method", method)
+	req.Header.Set("Access-Control-Request-Headers", "authorization, content-type")
+	rr := httptest.NewRecorder()
+	server.Router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestCORSPreflight(t *testing.T) {
+	server := corsTestServer(t, CORSConfig{
+		Origins:     []string{"https://editor.example.com", "https://*.example.org"},
+		Headers:     []string{"Authorization", "Content-Type", "Last-Event-ID", "X-Request-ID"},
+		Credentials: true,
+		MaxAge:      time.Minute * 10,
+	})
+
+	// The methods of the routes at the path are allowed
+	rr := preflight(server, "/api/posts/1", "https://editor.example.com", "PUT")
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, "https://editor.example.com", rr.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", rr.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "GET, PUT, DELETE", rr.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "Authorization, Content-Type, Last-Event-ID, X-Request-ID", rr.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "600", rr.Header().Get("Access-Control-Max-Age"))
+	assert.Contains(t, rr.Header().Values("Vary"), "Origin")
+
+	rr = preflight(server, "/login", "https://editor.example.com", "POST")
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, "POST", rr.Header().Get("Access-Control-Allow-Methods"))
+
+	// Subdomains of a wildcard origin, but not the domain itself or another scheme
+	rr = preflight(server, "/api/posts", "https://a.b.example.org", "POST")
+	assert.Equal(t, "https://a.b.example.org", rr.Header().Get("Access-Control-Allow-Origin"))
+	for _, origin := range []string{"https://example.org", "http://editor.example.org", "https://evil-example.org", "https://editor.example.com.evil.com"} {
+		rr = preflight(server, "/api/posts", origin, "POST")
+		assert.Equal(t, http.StatusNoContent, rr.Code)
+		assert.Empty(t, rr.Header().Get("Access-Control-Allow-Origin"), origin)
+		assert.Empty(t, rr.Header().Get("Access-Control-Allow-Methods"), origin)
+	}
+
+	// Paths without routes are not found
+	rr = preflight(server, "/unknown", "https://editor.example.com", "GET")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestCORSResponses(t *testing.T) {
+	server := corsTestServer(t, CORSConfig{Origins: []string{"*"}, Methods: []string{"GET"}, Headers: []string{"*"}})
+
+	rr := preflight(server, "/api/posts/1", "https://anywhere.example.net", "DELETE")
+	assert.Equal(t, "GET", rr.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "authorization, content-type", rr.Header().Get("Access-Control-Allow-Headers"))
+	assert.Empty(t, rr.Header().Get("Access-Control-Allow-Credentials"))
+
+	// Actual requests get the origin and the headers scripts may read
+	req, _ := http.NewRequest("GET", "/api/posts/1", nil)
+	req.Header.Set("Origin", "https://anywhere.example.net")
+	rr = httptest.NewRecorder()
+	server.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "https://anywhere.example.net", rr.Header().Get("Access-Control-Allow-Origin"))
+	assert.Contains(t, rr.Header().Get("Access-Control-Expose-Headers"), "RateLimit-Remaining")
+	assert.ElementsMatch(t, []string{"Origin", "Authorization"}, rr.Header().Values("Vary"))
+
+	// Requests without an origin are left alone
+	req.Header.Del("Origin")
+	rr = httptest.NewRecorder()
+	server.Router.ServeHTTP(rr, req)
+	assert.Empty(t, rr.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestNewCORS(t *testing.T) {
+	for _, origin := range []string{"editor.example.com", "ftp://example.com", "https://example.com/path", "https://*", "https://a.*.example.com"} {
+		_, err := NewCORS(CORSConfig{Origins: []string{origin}})
+		assert.Error(t, err, origin)
+	}
+	_, err := NewCORS(CORSConfig{})
+	assert.Error(t, err)
+	_, err = NewCORS(CORSConfig{Origins: []string{"http://localhost:5173/"}})
+	assert.NoError(t, err)
+
+	// Any origin only without credentials
+	_, err = NewCORS(CORSConfig{Origins: []string{"*"}, Credentials: true})
+	assert.Error(t, err)
+	_, err = NewCORS(CORSConfig{Origins: []string{"https://editor.example.com", "*"}, Credentials: true})
+	assert.Error(t, err)
+	_, err = NewCORS(CORSConfig{Origins: []string{"*"}})
+	assert.NoError(t, err)
+	_, err = NewCORS(CORSConfig{Origins: []string{"https://*.example.com"}, Credentials: true})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"rakia.ai/blog-api/v2/internal"
+)
+
+const (
+	// How often a comment is sent on an idle stream so proxies keep the connection open
+	eventsHeartbeat = time.Second * 15
+	// How long writing one event to a client may take before it is disconnected
+	eventsWriteTimeout = time.Second * 10
+)
+
+// writeEvent writes the event in the Server-Sent Events format
+func writeEvent(w http.ResponseWriter, event internal.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
+
+// writeReset tells the client that events after its Last-Event-ID are gone, so it refetches what it shows.
+// The id lets it resume from here
+func writeReset(w http.ResponseWriter, lastID int64) error {
+	_, err := fmt.Fprintf(w, "id: %d\nevent: reset\ndata: {\"id\":%d}\n\n", lastID, lastID)
+	return err
+}
+
+// EventsHandler streams post changes as Server-Sent Events. ?author= only streams the changes of one author,
+// and a Last-Event-ID header resumes after the last event the client received
+func (s *Server) EventsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get the context from the request
+		viewer, ok := r.Context().Value(ContextAuthor).(string)
+		if !ok {
+			s.log(r).Error().Msg("error getting author from context")
+			writeJSONError(w, ErrInvalidRequest, http.StatusBadRequest)
+			return
+		}
+
+		var lastEventID int64
+		if id := r.Header.Get("Last-Event-ID"); id != "" {
+			parsed, err := strconv.ParseInt(id, 10, 64)
+			if err != nil {
+				writeJSONError(w, "invalid Last-Event-ID", http.StatusBadRequest)
+				return
+			}
+			lastEventID = parsed
+		}
+
+		// Only events of posts the viewer can see, drafts of others are left out
+		author := r.URL.Query().Get("author")
+		filter := func(event internal.Event) bool {
+			if author != "" && event.Author != author {
+				return false
+			}
+			return event.Post == nil || canView(event.Post, viewer)
+		}
+
+		sub, missed := s.Events.Subscribe("sse "+viewer, lastEventID, filter)
+		defer s.Events.Unsubscribe(sub)
+
+		// The stream outlives the write timeout of the server, each write gets its own deadline instead
+		rc := http.NewResponseController(w)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		send := func(write func() error) bool {
+			rc.SetWriteDeadline(time.Now().Add(eventsWriteTimeout))
+			if err := write(); err != nil {
+				return false
+			}
+			return rc.Flush() == nil
+		}
+
+		// Send the events the client missed first, after a reset when some of them are gone
+		if sub.Reset {
+			s.log(r).Info().Str("author", viewer).Int64("last_event", lastEventID).Msg("event stream reset, events were dropped")
+			missed = nil
+			if !send(func() error { return writeReset(w, sub.LastID) }) {
+				return
+			}
+		}
+		for _, event := range missed {
+			event := event
+			if !send(func() error { return writeEvent(w, event) }) {
+				return
+			}
+		}
+		if !send(func() error { _, err := fmt.Fprint(w, ": connected\n\n"); return err }) {
+			return
+		}
+
+		heartbeat := time.NewTicker(eventsHeartbeat)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-heartbeat.C:
+				if !send(func() error { _, err := fmt.Fprint(w, ": heartbeat\n\n"); return err }) {
+					return
+				}
+			case event, ok := <-sub.Events:
+				if !ok {
+					// Too slow to keep up, the client reconnects with Last-Event-ID
+					s.log(r).Warn().Str("author", viewer).Msg("event stream disconnected, client too slow")
+					return
+				}
+				if !send(func() error { return writeEvent(w, event) }) {
+					return
+				}
+			}
+		}
+	}
+}
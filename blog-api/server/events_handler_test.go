@@ -0,0 +1,123 @@
+package server
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"rakia.ai/blog-api/v2/internal"
+)
+
+// readEvent reads the next event from a Server-Sent Events stream, skipping comments
+func readEvent(t *testing.T, reader *bufio.Reader) map[string]string {
+	event := make(map[string]string)
+	for {
+		line, err := reader.ReadString('\n')
+		if !assert.NoError(t, err) {
+			return event
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			if len(event) > 0 {
+				return event
+			}
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		field, value, _ := strings.Cut(line, ": ")
+		event[field] = value
+	}
+}
+
+func TestEventsHandler(t *testing.T) {
+	bus, _ := internal.NewEventBus(10, 10, nil, &logger)
+	bus.Publish(internal.Event{Type: internal.EventPostCreated, Author: "Author 1", PostID: 1, Post: &internal.Post{ID: 1, Author: "Author 1"}})
+	bus.Publish(internal.Event{Type: internal.EventPostCreated, Author: "Author 2", PostID: 2, Post: &internal.Post{ID: 2, Author: "Author 2"}})
+
+	server := NewServer(mux.NewRouter(), new(MockPostsService), nil, &logger)
+	server.Events = bus
+	server.Routes()
+	ts := httptest.NewServer(server.Router)
+	defer ts.Close()
+
+	token, _ := createToken("Author 3", "", time.Minute)
+	req, _ := http.NewRequest("GET", ts.URL+"/api/events?author=Author%201", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Last-Event-ID", "0")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	reader := bufio.NewReader(resp.Body)
+
+	// Live events of Author 1 only, drafts of Author 1 are not shown to Author 3
+	bus.Publish(internal.Event{Type: internal.EventPostCreated, Author: "Author 2", PostID: 3, Post: &internal.Post{ID: 3, Author: "Author 2"}})
+	bus.Publish(internal.Event{Type: internal.EventPostCreated, Author: "Author 1", PostID: 4, Post: &internal.Post{ID: 4, Author: "Author 1", Status: internal.PostDraft}})
+	bus.Publish(internal.Event{Type: internal.EventPostUpdated, Author: "Author 1", PostID: 1, Post: &internal.Post{ID: 1, Author: "Author 1"}})
+
+	event := readEvent(t, reader)
+	assert.Equal(t, "5", event["id"])
+	assert.Equal(t, internal.EventPostUpdated, event["event"])
+	assert.Contains(t, event["data"], `"post_id":1`)
+}
+
+func TestEventsHandlerResumes(t *testing.T) {
+	bus, _ := internal.NewEventBus(10, 10, nil, &logger)
+	for i := 1; i <= 3; i++ {
+		bus.Publish(internal.Event{Type: internal.EventPostCreated, Author: "Author 1", PostID: i})
+	}
+
+	server := NewServer(mux.NewRouter(), new(MockPostsService), nil, &logger)
+	server.Events = bus
+	server.Routes()
+	ts := httptest.NewServer(server.Router)
+	defer ts.Close()
+
+	token, _ := createToken("Author 1", "", time.Minute)
+	req, _ := http.NewRequest("GET", ts.URL+"/api/events", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	reader := bufio.NewReader(resp.Body)
+
+	assert.Equal(t, "2", readEvent(t, reader)["id"])
+	assert.Equal(t, "3", readEvent(t, reader)["id"])
+}
+
+func TestEventsHandlerResets(t *testing.T) {
+	bus, _ := internal.NewEventBus(2, 10, nil, &logger)
+	for i := 1; i <= 4; i++ {
+		bus.Publish(internal.Event{Type: internal.EventPostCreated, Author: "Author 1", PostID: i})
+	}
+
+	server := NewServer(mux.NewRouter(), new(MockPostsService), nil, &logger)
+	server.Events = bus
+	server.Routes()
+	ts := httptest.NewServer(server.Router)
+	defer ts.Close()
+
+	// Event 2 was evicted from the replay buffer, the client is told to refetch and resumes from the last event
+	token, _ := createToken("Author 1", "", time.Minute)
+	req, _ := http.NewRequest("GET", ts.URL+"/api/events", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	reader := bufio.NewReader(resp.Body)
+
+	event := readEvent(t, reader)
+	assert.Equal(t, "reset", event["event"])
+	assert.Equal(t, "4", event["id"])
+	bus.Publish(internal.Event{Type: internal.EventPostCreated, Author: "Author 1", PostID: 5})
+	assert.Equal(t, "5", readEvent(t, reader)["id"])
+}
@@ -0,0 +1,313 @@
+package server
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"rakia.ai/blog-api/v2/internal"
+)
+
+// Feed formats
+const (
+	FeedRSS  = "rss"
+	FeedAtom = "atom"
+)
+
+const (
+	// Title of the feeds
+	feedTitle = "Blog API"
+	// Number of posts in a feed unless ?limit= asks for fewer or more
+	feedDefaultLimit = 20
+	feedMaxLimit     = 100
+)
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Atom    string     `xml:"xmlns:atom,attr"`
+	DC      string     `xml:"xmlns:dc,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string      `xml:"title"`
+	Link          string      `xml:"link"`
+	Description   string      `xml:"description"`
+	LastBuildDate string      `xml:"lastBuildDate,omitempty"`
+	Self          rssAtomLink `xml:"atom:link"`
+	Items         []rssItem   `xml:"item"`
+}
+
+type rssAtomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	Description string   `xml:"description"`
+	Creator     string   `xml:"dc:creator"`
+	Categories  []string `xml:"category"`
+	GUID        rssGUID  `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+}
+
+type rssGUID struct {
+	Value       string `xml:",chardata"`
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Published  string         `xml:"published"`
+	Updated    string         `xml:"updated"`
+	Author     atomPerson     `xml:"author"`
+	Links      []atomLink     `xml:"link"`
+	Categories []atomCategory `xml:"category"`
+	Content    atomContent    `xml:"content"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomContent struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+// baseURL returns the configured base URL. Only in development it is taken from the request, the Host header
+// is chosen by the client and would end up in cached feeds. Without either links are relative to the server
+func (s *Server) baseURL(r *http.Request) string {
+	if s.BaseURL != "" {
+		return s.BaseURL
+	}
+	if !s.Development {
+		return ""
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// feedPosts returns the newest posts matching the author and tag, and when the feed last changed
+func (s *Server) feedPosts(ctx context.Context, author string, tag string, limit int) ([]*internal.Post, time.Time, error) {
+	posts, err := s.PostsService.GetAllPosts(ctx)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	var lastModified time.Time
+	result := make([]*internal.Post, 0, len(posts))
+	for _, post := range posts {
+		// Feeds are public, drafts are left out
+		if !post.Published() {
+			continue
+		}
+		if author != "" && post.Author != author {
+			continue
+		}
+		if tag != "" && !hasTag(post, tag) {
+			continue
+		}
+		if post.UpdatedAt.After(lastModified) {
+			lastModified = post.UpdatedAt
+		}
+		result = append(result, post)
+	}
+	// Deleted and unpublished posts are not in the feed anymore, but removing them changes it too
+	if s.Changes != nil {
+		if changed := s.Changes.LastChange(ctx); changed.After(lastModified) {
+			lastModified = changed
+		}
+	}
+
+	// Newest first
+	sort.SliceStable(result, func(i, j int) bool {
+		if !result[i].CreatedAt.Equal(result[j].CreatedAt) {
+			return result[i].CreatedAt.After(result[j].CreatedAt)
+		}
+		return result[i].ID > result[j].ID
+	})
+	if len(result) > limit {
+		result = result[:limit]
+	}
+	return result, lastModified, nil
+}
+
+// hasTag reports whether the post is tagged with the tag
+func hasTag(post *internal.Post, tag string) bool {
+	for _, t := range post.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// FeedHandler serves the RSS or Atom feed of all posts, or of the posts of an author or with a tag
+func (s *Server) FeedHandler(format string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		author := mux.Vars(r)["name"]
+		tag := mux.Vars(r)["tag"]
+
+		// Unknown authors have no feed
+		if author != "" && s.AuthorsService != nil {
+			if _, err := s.AuthorsService.GetAuthor(author); err != nil {
+				writeJSONError(w, "author not found", http.StatusNotFound)
+				return
+			}
+		}
+
+		limit := feedDefaultLimit
+		if l := r.URL.Query().Get("limit"); l != "" {
+			n, err := strconv.Atoi(l)
+			if err != nil || n < 1 || n > feedMaxLimit {
+				writeJSONError(w, "limit must be a number from 1 to 100", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+
+		posts, lastModified, err := s.feedPosts(r.Context(), author, tag, limit)
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("error getting posts for feed")
+			writeJSONError(w, "error getting feed", http.StatusInternalServerError)
+			return
+		}
+
+		// Conditional GET, HTTP dates only have seconds
+		lastModified = lastModified.UTC().Truncate(time.Second)
+		if !lastModified.IsZero() {
+			if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !lastModified.After(since) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+		}
+
+		title := feedTitle
+		switch {
+		case author != "":
+			title += " - posts by " + author
+		case tag != "":
+			title += " - posts tagged " + tag
+		}
+
+		base := s.baseURL(r)
+		var feed interface{}
+		contentType := "application/rss+xml; charset=utf-8"
+		if format == FeedAtom {
+			feed = atomFeedOf(base, r.URL.Path, title, posts, lastModified)
+			contentType = "application/atom+xml; charset=utf-8"
+		} else {
+			feed = rssFeedOf(base, r.URL.Path, title, posts, lastModified)
+		}
+
+		output, err := xml.MarshalIndent(feed, "", "  ")
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("error marshalling feed")
+			writeJSONError(w, "error getting feed", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Write([]byte(xml.Header))
+		w.Write(output)
+	}
+}
+
+// rssFeedOf builds an RSS 2.0 feed of the posts
+func rssFeedOf(base string, path string, title string, posts []*internal.Post, lastModified time.Time) rssFeed {
+	feed := rssFeed{
+		Version: "2.0",
+		Atom:    "http://www.w3.org/2005/Atom",
+		DC:      "http://purl.org/dc/elements/1.1/",
+		Channel: rssChannel{
+			Title:       title,
+			Link:        base + "/",
+			Description: title,
+			Self:        rssAtomLink{Href: base + path, Rel: "self", Type: "application/rss+xml"},
+			Items:       make([]rssItem, 0, len(posts)),
+		},
+	}
+	if !lastModified.IsZero() {
+		feed.Channel.LastBuildDate = lastModified.Format(time.RFC1123Z)
+	}
+	for _, post := range posts {
+		link := base + "/api/posts/" + strconv.Itoa(post.ID)
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:       post.Title,
+			Link:        link,
+			Description: post.Content,
+			Creator:     post.Author,
+			Categories:  post.Tags,
+			GUID:        rssGUID{Value: link, IsPermaLink: true},
+			PubDate:     post.CreatedAt.UTC().Format(time.RFC1123Z),
+		})
+	}
+	return feed
+}
+
+// atomFeedOf builds an Atom feed of the posts
+func atomFeedOf(base string, path string, title string, posts []*internal.Post, lastModified time.Time) atomFeed {
+	// Atom requires a date, an empty feed has none of its own
+	if lastModified.IsZero() {
+		lastModified = time.Now().UTC().Truncate(time.Second)
+	}
+	feed := atomFeed{
+		Title:   title,
+		ID:      base + path,
+		Updated: lastModified.Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: base + path, Rel: "self"},
+			{Href: base + "/"},
+		},
+		Entries: make([]atomEntry, 0, len(posts)),
+	}
+	for _, post := range posts {
+		link := base + "/api/posts/" + strconv.Itoa(post.ID)
+		entry := atomEntry{
+			Title:     post.Title,
+			ID:        link,
+			Published: post.CreatedAt.UTC().Format(time.RFC3339),
+			Updated:   post.UpdatedAt.UTC().Format(time.RFC3339),
+			Author:    atomPerson{Name: post.Author},
+			Links:     []atomLink{{Href: link, Rel: "alternate"}},
+			Content:   atomContent{Type: "text", Value: post.Content},
+		}
+		for _, tag := range post.Tags {
+			entry.Categories = append(entry.Categories, atomCategory{Term: tag})
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	return feed
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"rakia.ai/blog-api/v2/internal"
+)
+
+func feedTestServer() *Server {
+	created := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	posts := []*internal.Post{
+		{ID: 1, Title: "First", Content: "Fish & <chips>", Author: "Author 1", Tags: []string{"food"}, CreatedAt: created, UpdatedAt: created},
+		{ID: 2, Title: "Second", Content: "More", Author: "Author 2", CreatedAt: created.Add(time.Hour), UpdatedAt: created.Add(2 * time.Hour)},
+		{ID: 3, Title: "Third", Content: "Even more", Author: "Author 1", CreatedAt: created.Add(2 * time.Hour), UpdatedAt: created.Add(2 * time.Hour)},
+	}
+	mockPostsService := new(MockPostsService)
+	mockPostsService.On("GetAllPosts").Return(posts, nil)
+
+	server := &Server{Router: mux.NewRouter(), PostsService: mockPostsService, BaseURL: "https://blog.example.com", Logger: &logger}
+	server.Routes()
+	return server
+}
+
+func TestRSSFeed(t *testing.T) {
+	server := feedTestServer()
+
+	req, _ := http.NewRequest("GET", "/feed.xml?limit=2", nil)
+	rr := httptest.NewRecorder()
+	server.Router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/rss+xml; charset=utf-8", rr.Header().Get("Content-Type"))
+	assert.Equal(t, "Mon, 01 Jan 2024 14:00:00 GMT", rr.Header().Get("Last-Modified"))
+
+	var feed rssFeed
+	assert.NoError(t, xml.Unmarshal(rr.Body.Bytes(), &feed))
+	assert.Equal(t, "Mon, 01 Jan 2024 14:00:00 +0000", feed.Channel.LastBuildDate)
+	assert.Len(t, feed.Channel.Items, 2)
+	assert.Equal(t, "Third", feed.Channel.Items[0].Title)
+	assert.Equal(t, "https://blog.example.com/api/posts/3", feed.Channel.Items[0].Link)
+
+	// Content is escaped
+	req, _ = http.NewRequest("GET", "/tags/food/feed.xml", nil)
+	rr = httptest.NewRecorder()
+	server.Router.ServeHTTP(rr, req)
+	assert.Contains(t, rr.Body.String(), "Fish &amp; &lt;chips&gt;")
+	feed = rssFeed{}
+	assert.NoError(t, xml.Unmarshal(rr.Body.Bytes(), &feed))
+	assert.Len(t, feed.Channel.Items, 1)
+	assert.Equal(t, "Fish & <chips>", feed.Channel.Items[0].Description)
+
+	req, _ = http.NewRequest("GET", "/feed.xml?limit=0", nil)
+	rr = httptest.NewRecorder()
+	server.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestAtomFeedPerAuthor(t *testing.T) {
+	server := feedTestServer()
+
+	req, _ := http.NewRequest("GET", "/authors/Author 1/atom.xml", nil)
+	rr := httptest.NewRecorder()
+	server.Router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, strings.HasPrefix(rr.Header().Get("Content-Type"), "application/atom+xml"))
+
+	var feed atomFeed
+	assert.NoError(t, xml.Unmarshal(rr.Body.Bytes(), &feed))
+	assert.Equal(t, "2024-01-01T14:00:00Z", feed.Updated)
+	assert.Len(t, feed.Entries, 2)
+	for _, entry := range feed.Entries {
+		assert.Equal(t, "Author 1", entry.Author.Name)
+	}
+}
+
+func TestFeedConditionalGet(t *testing.T) {
+	server := feedTestServer()
+
+	req, _ := http.NewRequest("GET", "/atom.xml", nil)
+	req.Header.Set("If-Modified-Since", "Mon, 01 Jan 2024 14:00:00 GMT")
+	rr := httptest.NewRecorder()
+	server.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotModified, rr.Code)
+	assert.Empty(t, rr.Body.String())
+
+	req, _ = http.NewRequest("GET", "/atom.xml", nil)
+	req.Header.Set("If-Modified-Since", "Mon, 01 Jan 2024 13:59:59 GMT")
+	rr = httptest.NewRecorder()
+	server.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+// changedAt is a ChangeTracker whose posts last changed at the time
+type changedAt time.Time
+
+func (c changedAt) LastChange(ctx context.Context) time.Time {
+	return time.Time(c)
+}
+
+func TestFeedChangedByDelete(t *testing.T) {
+	server := feedTestServer()
+	server.Changes = changedAt(time.Date(2024, 1, 1, 15, 0, 0, 0, time.UTC))
+
+	// A post was deleted after the newest post in the feed was updated
+	req, _ := http.NewRequest("GET", "/atom.xml", nil)
+	req.Header.Set("If-Modified-Since", "Mon, 01 Jan 2024 14:00:00 GMT")
+	rr := httptest.NewRecorder()
+	server.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "Mon, 01 Jan 2024 15:00:00 GMT", rr.Header().Get("Last-Modified"))
+}
+
+func TestEmptyAtomFeed(t *testing.T) {
+	server := feedTestServer()
+
+	req, _ := http.NewRequest("GET", "/tags/none/atom.xml", nil)
+	rr := httptest.NewRecorder()
+	server.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, rr.Header().Get("Last-Modified"))
+
+	var feed atomFeed
+	assert.NoError(t, xml.Unmarshal(rr.Body.Bytes(), &feed))
+	assert.Empty(t, feed.Entries)
+	updated, err := time.Parse(time.RFC3339, feed.Updated)
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), updated, time.Minute)
+}
+
+func TestFeedLinksIgnoreHost(t *testing.T) {
+	server := feedTestServer()
+
+	// A forged Host header does not end up in the links
+	req, _ := http.NewRequest("GET", "/feed.xml", nil)
+	req.Host = "evil.example.com"
+	rr := httptest.NewRecorder()
+	server.Router.ServeHTTP(rr, req)
+	var feed rssFeed
+	assert.NoError(t, xml.Unmarshal(rr.Body.Bytes(), &feed))
+	assert.Equal(t, "https://blog.example.com/api/posts/3", feed.Channel.Items[0].Link)
+
+	// Without a base URL only development takes it from the request
+	server.BaseURL = ""
+	assert.Equal(t, "", server.baseURL(req))
+	server.Development = true
+	assert.Equal(t, "http://evil.example.com", server.baseURL(req))
+}
+
+func TestFeedRateLimited(t *testing.T) {
+	server := feedTestServer()
+	server.Router = mux.NewRouter()
+	server.PublicLimiter, _ = internal.NewRateLimiter("public", 1, time.Minute, nil, nil)
+	server.Routes()
+
+	req, _ := http.NewRequest("GET", "/atom.xml", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	rr := httptest.NewRecorder()
+	server.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = httptest.NewRecorder()
+	server.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+}
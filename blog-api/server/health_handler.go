@@ -0,0 +1,146 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// How long all readiness checks together may take
+const readinessTimeout = 2 * time.Second
+
+// Health keeps the readiness checks of the subsystems and whether the server is shutting down
+type Health struct {
+	checks       map[string]HealthChecker
+	shuttingDown atomic.Bool
+	mutex        sync.Mutex // Protects access to checks
+}
+
+// NewHealth creates a new health without checks, the server is ready until a check fails
+func NewHealth() *Health {
+	return &Health{checks: make(map[string]HealthChecker)}
+}
+
+// Register adds the readiness check of a subsystem
+func (h *Health) Register(name string, checker HealthChecker) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.checks[name] = checker
+}
+
+// ShuttingDown makes the server unready, so no new requests are sent while the open ones finish
+func (h *Health) ShuttingDown() {
+	h.shuttingDown.Store(true)
+}
+
+type ReadinessResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// check runs the checks concurrently and returns the result of each, ok when it passed
+func (h *Health) check(ctx context.Context) (map[string]string, bool) {
+	h.mutex.Lock()
+	names := make([]string, 0, len(h.checks))
+	for name := range h.checks {
+		names = append(names, name)
+	}
+	checks := h.checks
+	h.mutex.Unlock()
+	sort.Strings(names)
+
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+
+	errs := make([]error, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, checker HealthChecker) {
+			defer wg.Done()
+			errs[i] = checker.CheckHealth(ctx)
+		}(i, checks[name])
+	}
+	wg.Wait()
+
+	results := make(map[string]string, len(names))
+	ready := true
+	for i, name := range names {
+		results[name] = "ok"
+		if errs[i] != nil {
+			results[name] = errs[i].Error()
+			ready = false
+		}
+	}
+	return results, ready
+}
+
+// LivenessHandler answers as long as the process serves requests
+func (s *Server) LivenessHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, map[string]string{"status": "ok"}, http.StatusOK)
+	}
+}
+
+// ReadinessHandler answers 503 while a check fails or the server is shutting down
+func (s *Server) ReadinessHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		if s.Health.shuttingDown.Load() {
+			writeJSON(w, ReadinessResponse{Status: "shutting down", Checks: map[string]string{}}, http.StatusServiceUnavailable)
+			return
+		}
+
+		checks, ready := s.Health.check(r.Context())
+		if !ready {
+			s.log(r).Warn().Interface("checks", checks).Msg("not ready")
+			writeJSON(w, ReadinessResponse{Status: "unready", Checks: checks}, http.StatusServiceUnavailable)
+			return
+		}
+		writeJSON(w, ReadinessResponse{Status: "ready", Checks: checks}, http.StatusOK)
+	}
+}
+
+// BuildInfo describes the running binary, the commit and build time are set with -ldflags at build time
+type BuildInfo struct {
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+}
+
+// NewBuildInfo fills in the Go version, and the commit from the version control information Go embeds when it
+// was not set at build time
+func NewBuildInfo(commit string, buildTime string) BuildInfo {
+	info := BuildInfo{Commit: commit, BuildTime: buildTime, GoVersion: runtime.Version()}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range build.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = setting.Value
+			case setting.Key == "vcs.time" && info.BuildTime == "":
+				info.BuildTime = setting.Value
+			}
+		}
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.BuildTime == "" {
+		info.BuildTime = "unknown"
+	}
+	return info
+}
+
+// VersionHandler returns the build info of the binary
+func (s *Server) VersionHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, s.Version, http.StatusOK)
+	}
+}
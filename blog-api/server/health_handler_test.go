@@ -0,0 +1,63 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"rakia.ai/blog-api/v2/internal"
+)
+
+func TestHealthHandlers(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "media")
+	store, _ := internal.NewDiskBlobStore(dir)
+	server := NewServer(mux.NewRouter(), new(MockPostsService), nil, &logger)
+	server.Health = NewHealth()
+	server.Health.Register("media", store)
+	server.Version = NewBuildInfo("abc123", "2024-01-01T00:00:00Z")
+	server.Routes()
+
+	get := func(path string) (*httptest.ResponseRecorder, ReadinessResponse) {
+		req, _ := http.NewRequest("GET", path, nil)
+		rr := httptest.NewRecorder()
+		server.Router.ServeHTTP(rr, req)
+		var response ReadinessResponse
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr, response
+	}
+
+	rr, _ := get("/healthz")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rr, ready := get("/readyz")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, map[string]string{"media": "ok"}, ready.Checks)
+
+	// A failing check makes the server unready, but it is still alive
+	os.RemoveAll(dir)
+	rr, ready = get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Equal(t, "unready", ready.Status)
+	assert.NotEqual(t, "ok", ready.Checks["media"])
+	rr, _ = get("/healthz")
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	os.MkdirAll(dir, 0o755)
+	server.Health.ShuttingDown()
+	rr, ready = get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Equal(t, "shutting down", ready.Status)
+
+	req, _ := http.NewRequest("GET", "/version", nil)
+	rr = httptest.NewRecorder()
+	server.Router.ServeHTTP(rr, req)
+	var version BuildInfo
+	json.Unmarshal(rr.Body.Bytes(), &version)
+	assert.Equal(t, "abc123", version.Commit)
+	assert.Equal(t, "2024-01-01T00:00:00Z", version.BuildTime)
+	assert.NotEmpty(t, version.GoVersion)
+}
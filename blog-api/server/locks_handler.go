@@ -0,0 +1,105 @@
+package server
+
+import (
+	"net/http"
+
+	"rakia.ai/blog-api/v2/internal"
+)
+
+// lockContext gets the post from the URL, it writes the error when the author cannot edit it
+func (s *Server) lockContext(w http.ResponseWriter, r *http.Request) (string, *internal.Post, bool) {
+	author, post, ok := s.postContext(w, r)
+	if !ok {
+		return "", nil, false
+	}
+	if post.Author != author && author != "admin" {
+		writeJSONError(w, internal.ErrAuthorNotAllowed.Error(), http.StatusForbidden)
+		return "", nil, false
+	}
+	return author, post, true
+}
+
+// AcquireLockHandler locks a post for editing by the author, acquiring it again renews it
+func (s *Server) AcquireLockHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		author, post, ok := s.lockContext(w, r)
+		if !ok {
+			return
+		}
+
+		lock, err := s.Locks.Acquire(post.ID, author)
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("error acquiring edit lock")
+			writeError(w, err, "error acquiring edit lock")
+			return
+		}
+
+		writeJSON(w, lock, http.StatusCreated)
+	}
+}
+
+// RenewLockHandler extends the edit lock of the author, editors call it as a heartbeat while editing
+func (s *Server) RenewLockHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		author, post, ok := s.lockContext(w, r)
+		if !ok {
+			return
+		}
+
+		lock, err := s.Locks.Renew(post.ID, author)
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("error renewing edit lock")
+			writeError(w, err, "error renewing edit lock")
+			return
+		}
+
+		writeJSON(w, lock, http.StatusAccepted)
+	}
+}
+
+// ReleaseLockHandler releases the edit lock of the author, with ?force=true admin breaks anyone's lock
+func (s *Server) ReleaseLockHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		author, post, ok := s.lockContext(w, r)
+		if !ok {
+			return
+		}
+
+		var err error
+		if r.URL.Query().Get("force") == "true" {
+			if author != "admin" {
+				writeJSONError(w, "only admin can break edit locks", http.StatusForbidden)
+				return
+			}
+			err = s.Locks.Break(post.ID, author)
+		} else {
+			err = s.Locks.Release(post.ID, author)
+		}
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("error releasing edit lock")
+			writeError(w, err, "error releasing edit lock")
+			return
+		}
+
+		// Status accepted
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// GetLockHandler shows who holds the edit lock of a post
+func (s *Server) GetLockHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, post, ok := s.postContext(w, r)
+		if !ok {
+			return
+		}
+
+		lock, err := s.Locks.Get(post.ID)
+		if err != nil {
+			writeError(w, err, "error getting edit lock")
+			return
+		}
+
+		writeJSON(w, lock, http.StatusOK)
+	}
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"rakia.ai/blog-api/v2/internal"
+)
+
+func TestLockHandlers(t *testing.T) {
+	ctx := context.Background()
+	p := map[string]map[int]internal.Post{"Author 1": {}}
+	posts, _ := internal.NewPostsService(&p, &logger)
+	locks, _ := internal.NewLockService(time.Minute, nil, &logger)
+	posts.UseLocks(locks)
+	content := strings.Repeat("Lorem ipsum dolor sit amet. ", 5)
+	posts.CreatePosts(ctx, internal.Post{Title: "Title 1", Content: content, Author: "Author 1"}, "Author 1")
+
+	server := NewServer(mux.NewRouter(), posts, nil, &logger)
+	server.Locks = locks
+	server.Routes()
+
+	rr := authorRequest(server.Router, "POST", "/api/posts/1/lock", "", "Author 2")
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	rr = authorRequest(server.Router, "POST", "/api/posts/1/lock", "", "admin")
+	assert.Equal(t, http.StatusCreated, rr.Code)
+
+	// The author sees who is editing and cannot save
+	rr = authorRequest(server.Router, "POST", "/api/posts/1/lock", "", "Author 1")
+	assert.Equal(t, http.StatusLocked, rr.Code)
+	assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+	var locked Problem
+	json.Unmarshal(rr.Body.Bytes(), &locked)
+	assert.Equal(t, "post_locked", locked.Code)
+	assert.Equal(t, "admin", locked.Lock.Holder)
+
+	update := `{"title":"Title 2","content":"` + content + `","author":"Author 1"}`
+	rr = authorRequest(server.Router, "PUT", "/api/posts/1", update, "Author 1")
+	assert.Equal(t, http.StatusLocked, rr.Code)
+
+	rr = authorRequest(server.Router, "PUT", "/api/posts/1/lock", "", "Author 1")
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	rr = authorRequest(server.Router, "PUT", "/api/posts/1/lock", "", "admin")
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+
+	rr = authorRequest(server.Router, "GET", "/api/posts/1/lock", "", "Author 1")
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// Only admin breaks locks
+	rr = authorRequest(server.Router, "DELETE", "/api/posts/1/lock?force=true", "", "Author 1")
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	rr = authorRequest(server.Router, "DELETE", "/api/posts/1/lock?force=true", "", "admin")
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+
+	rr = authorRequest(server.Router, "PUT", "/api/posts/1", update, "Author 1")
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+	rr = authorRequest(server.Router, "GET", "/api/posts/1/lock", "", "Author 1")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
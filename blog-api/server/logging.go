@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ContextRequestID is the key for the ID of the request, taken from X-Request-ID or generated
+const ContextRequestID contextKey = "request_id"
+
+// Request IDs sent by clients are only used when they cannot break the log lines they end up in
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// NewLogger creates the logger of the server, format is console or json and level a zerolog level such as info
+func NewLogger(format string, level string) (*zerolog.Logger, error) {
+	lvl, err := zerolog.ParseLevel(level)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+
+	var logger zerolog.Logger
+	switch format {
+	case "console", "":
+		logger = zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr})
+	case "json":
+		logger = zerolog.New(os.Stderr)
+	default:
+		return nil, fmt.Errorf("invalid log format %q, use console or json", format)
+	}
+	logger = logger.Level(lvl).With().Timestamp().Logger()
+	return &logger, nil
+}
+
+// log returns the logger of the request, its lines carry the request ID and the author once authenticated
+func (s *Server) log(r *http.Request) *zerolog.Logger {
+	if logger := zerolog.Ctx(r.Context()); logger.GetLevel() != zerolog.Disabled {
+		return logger
+	}
+	return s.Logger
+}
+
+// requestID returns the ID of the request, so audit entries and spans can be matched to the access log
+func requestID(r *http.Request) string {
+	if id, ok := r.Context().Value(ContextRequestID).(string); ok {
+		return id
+	}
+	return r.Header.Get("X-Request-ID")
+}
+
+// newRequestID generates a random request ID
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// routeTemplate returns the template of the route matching the request, e.g. /api/posts/{id}, or "" without one
+func routeTemplate(r *http.Request) string {
+	if current := mux.CurrentRoute(r); current != nil {
+		if template, err := current.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return ""
+}
+
+// AccessLog takes the request ID from X-Request-ID or generates one, sends it back in the response and puts a
+// logger with it in the context. After the request it writes one access log line
+func AccessLog(logger *zerolog.Logger) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			id := r.Header.Get("X-Request-ID")
+			if !requestIDPattern.MatchString(id) {
+				id = newRequestID()
+			}
+			w.Header().Set("X-Request-ID", id)
+
+			requestLogger := logger.With().Str("request_id", id).Logger()
+			ctx := context.WithValue(requestLogger.WithContext(r.Context()), ContextRequestID, id)
+			recorder := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(recorder, r.WithContext(ctx))
+
+			if recorder.status == 0 {
+				recorder.status = http.StatusOK
+			}
+			// The logger in the context has the author once the request was authenticated
+			zerolog.Ctx(ctx).Info().
+				Str("method", r.Method).
+				Str("route", routeTemplate(r)).
+				Str("path", r.URL.Path).
+				Int("status", recorder.status).
+				Int("bytes", recorder.bytes).
+				Dur("duration", time.Since(start)).
+				Str("remote_addr", clientIP(r)).
+				Msg("request")
+		})
+	}
+}
+
+// withAuthor adds the authenticated author to the logger and the span of the request
+func withAuthor(ctx context.Context, author string) {
+	zerolog.Ctx(ctx).UpdateContext(func(c zerolog.Context) zerolog.Context {
+		return c.Str("author", author)
+	})
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("enduser.id", author))
+}
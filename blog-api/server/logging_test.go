@@ -0,0 +1,126 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"rakia.ai/blog-api/v2/internal"
+)
+
+func TestAccessLog(t *testing.T) {
+	var out bytes.Buffer
+	accessLogger := zerolog.New(&out)
+	mockService := new(MockPostsService)
+	mockService.On("GetPostByID", mock.Anything).Return(&internal.Post{ID: 1, Author: "Author 1"}, nil)
+	server := NewServer(mux.NewRouter(), mockService, &MockAuthorService{}, &accessLogger)
+	server.Routes()
+
+	request := func(path string, id string, author string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		if id != "" {
+			req.Header.Set("X-Request-ID", id)
+		}
+		if author != "" {
+			token, _ := createToken(author, "", time.Minute)
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		server.Router.ServeHTTP(rr, req)
+		return rr
+	}
+	lines := func() []map[string]interface{} {
+		var result []map[string]interface{}
+		scanner := bufio.NewScanner(&out)
+		for scanner.Scan() {
+			var line map[string]interface{}
+			json.Unmarshal(scanner.Bytes(), &line)
+			if line["message"] == "request" {
+				result = append(result, line)
+			}
+		}
+		out.Reset()
+		return result
+	}
+
+	// The request ID of the client is kept, the author is added once authenticated
+	rr := request("/api/posts/1", "abc-123", "Author 1")
+	assert.Equal(t, "abc-123", rr.Header().Get("X-Request-ID"))
+	logged := lines()
+	if assert.Len(t, logged, 1) {
+		assert.Equal(t, "abc-123", logged[0]["request_id"])
+		assert.Equal(t, "GET", logged[0]["method"])
+		assert.Equal(t, "/api/posts/{id}", logged[0]["route"])
+		assert.Equal(t, float64(http.StatusOK), logged[0]["status"])
+		assert.Equal(t, float64(rr.Body.Len()), logged[0]["bytes"])
+		assert.Equal(t, "Author 1", logged[0]["author"])
+		assert.Contains(t, logged[0], "duration")
+		assert.Contains(t, logged[0], "remote_addr")
+	}
+
+	// IDs which could break the log are replaced
+	rr = request("/api/posts/1", "bad id\n", "")
+	assert.Len(t, rr.Header().Get("X-Request-ID"), 32)
+	logged = lines()
+	if assert.Len(t, logged, 1) {
+		assert.Equal(t, rr.Header().Get("X-Request-ID"), logged[0]["request_id"])
+		assert.NotContains(t, logged[0], "author")
+	}
+
+	// Requests which match no route are logged too
+	rr = request("/nothing/here", "", "")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	logged = lines()
+	if assert.Len(t, logged, 1) {
+		assert.Equal(t, float64(http.StatusNotFound), logged[0]["status"])
+	}
+}
+
+func TestAuthFailureLog(t *testing.T) {
+	var out bytes.Buffer
+	accessLogger := zerolog.New(&out)
+	server := NewServer(mux.NewRouter(), new(MockPostsService), &MockAuthorService{deleted: []string{"Author 9"}}, &accessLogger)
+	server.Routes()
+
+	// The session of a deleted author is refused and logged with the ID of the request
+	token, _ := createToken("Author 9", "", time.Minute)
+	req, _ := http.NewRequest("GET", "/api/posts/1", nil)
+	req.Header.Set("X-Request-ID", "abc-123")
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	server.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	var refused map[string]interface{}
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		var line map[string]interface{}
+		json.Unmarshal(scanner.Bytes(), &line)
+		if line["message"] == "session no longer accepted" {
+			refused = line
+		}
+	}
+	if assert.NotNil(t, refused) {
+		assert.Equal(t, "abc-123", refused["request_id"])
+		assert.Equal(t, "Author 9", refused["author"])
+	}
+}
+
+func TestNewLogger(t *testing.T) {
+	logger, err := NewLogger("json", "warn")
+	assert.NoError(t, err)
+	assert.Equal(t, zerolog.WarnLevel, logger.GetLevel())
+
+	_, err = NewLogger("xml", "info")
+	assert.Error(t, err)
+	_, err = NewLogger("console", "loud")
+	assert.Error(t, err)
+}
@@ -2,6 +2,8 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
@@ -9,15 +11,27 @@ import (
 	"rakia.ai/blog-api/v2/internal"
 )
 
-// JWT Secret Key (should be kept secret and not hardcoded, this is just for demo purposes)
-var jwtKey = []byte("my_secret_key")
+// Development key JWTs are signed with until ConfigureJWT is called with a secret
+var DevelopmentJWTKey = []byte("my_secret_key")
 
-// JWT Expiration Time (30 minutes)
-var expirationTime = time.Now().Add(time.Minute * 30)
+// JWT Secret Key, set with ConfigureJWT
+var jwtKey = DevelopmentJWTKey
+
+// JWT Expiration Time, set with ConfigureJWT
+var expirationTime = time.Minute * 30
+
+// Expiration time of the challenge token handed out before the second factor is checked
+const challengeExpirationTime = time.Minute * 5
+
+// Purpose of the challenge token, it cannot be used as a session
+const purposeTwoFactor = "2fa"
 
 // Claims struct for JWT
 type Claims struct {
 	Username string `json:"username"`
+	Purpose  string `json:"purpose,omitempty"`
+	// Authenticator the two-factor challenge was issued after
+	Login string `json:"login,omitempty"`
 	jwt.StandardClaims
 }
 
@@ -25,64 +39,221 @@ type LoginResponse struct {
 	Token string `json:"token"`
 }
 
-func (s *Server) LoginHandler() http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		var credentials internal.Author
+type LoginChallengeResponse struct {
+	ChallengeToken    string `json:"challenge_token"`
+	TwoFactorRequired bool   `json:"two_factor_required"`
+}
 
-		// Decode the incoming JSON payload
-		err := json.NewDecoder(r.Body).Decode(&credentials)
-		if err != nil {
-			writeJSONError(w, "invalid request payload", http.StatusBadRequest)
-			return
+// ConfigureJWT sets the key JWTs are signed with and how long the JWT from a login is valid, it is called once
+// before the server starts
+func ConfigureJWT(key []byte, lifetime time.Duration) error {
+	if len(key) == 0 || lifetime <= 0 {
+		return fmt.Errorf("jwt needs a key and a positive lifetime")
+	}
+	jwtKey = key
+	expirationTime = lifetime
+	return nil
+}
+
+// createToken signs a JWT for the author, tokens with a purpose are not accepted by Middleware
+func createToken(author string, purpose string, ttl time.Duration) (string, error) {
+	return signToken(&Claims{Username: author, Purpose: purpose}, ttl)
+}
+
+// signToken sets the issue and expiry time of the claims and signs them
+func signToken(claims *Claims, ttl time.Duration) (string, error) {
+	// Complete the JWT claims with the subject, issue and expiry time
+	now := time.Now()
+	claims.StandardClaims = jwt.StandardClaims{
+		ExpiresAt: now.Add(ttl).Unix(),
+		IssuedAt:  now.Unix(),
+		Subject:   claims.Username,
+	}
+
+	// Declare the token with the algorithm used for signing, and the claims
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+
+	// Create the JWT string
+	return token.SignedString(jwtKey)
+}
+
+// parseToken validates a JWT signed by this server and returns its claims
+func parseToken(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
 		}
+		return jwtKey, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+	return claims, nil
+}
+
+// writeJSON writes the value as a JSON response
+func writeJSON(w http.ResponseWriter, value interface{}, statusCode int) {
+	// Marshal the response object to JSON
+	jsonResponse, err := json.Marshal(value)
+	if err != nil {
+		writeJSONError(w, "failed to marshal response", http.StatusInternalServerError)
+		return
+	}
+
+	// Set the content-type header to json
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	// Send the response
+	w.Write(jsonResponse)
+}
+
+// lockedOut writes a 429 response if the account or IP address may not attempt a login right now
+func (s *Server) lockedOut(w http.ResponseWriter, r *http.Request, author string) bool {
+	if s.LoginGuard == nil {
+		return false
+	}
+	err := s.LoginGuard.Check(author, clientIP(r))
+	if err == nil {
+		return false
+	}
+	s.auditLogin(r, internal.AuditLoginLocked, author)
+	writeError(w, err, "too many failed login attempts")
+	return true
+}
+
+// loginFailed records the failed attempt and writes the error, e.g. invalid credentials
+func (s *Server) loginFailed(w http.ResponseWriter, r *http.Request, author string, err error) {
+	if s.LoginGuard != nil {
+		s.LoginGuard.RecordFailure(author, clientIP(r))
+	}
+	s.auditLogin(r, internal.AuditLoginFailed, author)
+	writeError(w, err, "")
+}
+
+// loginSucceeded records the successful attempt and writes the session token. Only a password login clears the
+// failed logins of the account, logging in some other way says nothing about who is guessing the password
+func (s *Server) loginSucceeded(w http.ResponseWriter, r *http.Request, author string, login string) {
+	if s.LoginGuard != nil && login == PasswordLogin {
+		s.LoginGuard.RecordSuccess(author, clientIP(r))
+	}
+	s.auditLogin(r, internal.AuditLoginSucceeded, author)
 
-		// Validate the author's credentials
-		valid, err := s.AuthorsService.ValidAuthor(credentials.Author, credentials.Password)
+	tokenString, err := createToken(author, "", expirationTime)
+	if err != nil {
+		writeJSONError(w, "failed to create token", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, LoginResponse{Token: tokenString}, http.StatusOK)
+}
+
+// login runs every way of logging in through the same checks: lockouts, failed attempts, the two-factor
+// challenge and finally the session token
+func (s *Server) login(w http.ResponseWriter, r *http.Request, name string, authenticator Authenticator) {
+	// The IP address can be locked out before the author is known
+	if s.lockedOut(w, r, "") {
+		return
+	}
+
+	author, err := authenticator.Authenticate(r)
+	if errors.Is(err, ErrLoginPayloadInvalid) {
+		writeJSONError(w, "invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	// A locked out account is refused whether the credentials were right or not
+	if author != "" && s.lockedOut(w, r, author) {
+		return
+	}
+
+	if err != nil {
+		s.log(r).Info().Err(err).Str("authenticator", name).Msg("login failed")
+		s.loginFailed(w, r, author, internal.ErrCredentialsInvalid)
+		return
+	}
+
+	// With two-factor authentication the first factor only yields a short-lived challenge
+	if s.TwoFactorService != nil && s.TwoFactorService.Enabled(author) {
+		challenge, err := signToken(&Claims{Username: author, Purpose: purposeTwoFactor, Login: name}, challengeExpirationTime)
 		if err != nil {
-			writeJSONError(w, "invalid credentials", http.StatusUnauthorized)
+			writeJSONError(w, "failed to create token", http.StatusInternalServerError)
 			return
 		}
+		s.auditLogin(r, internal.AuditLoginChallenge, author)
+		writeJSON(w, LoginChallengeResponse{ChallengeToken: challenge, TwoFactorRequired: true}, http.StatusOK)
+		return
+	}
 
-		if !valid {
-			writeJSONError(w, "invalid credentials", http.StatusUnauthorized)
-			return
-		}
+	s.loginSucceeded(w, r, author, name)
+}
 
-		// Create the JWT claims, which includes the username and expiry time
-		claims := &Claims{
-			Username: credentials.Author,
-			StandardClaims: jwt.StandardClaims{
-				ExpiresAt: expirationTime.Unix(),
-				Subject:   credentials.Author,
-			},
+// LoginHandler logs authors in with their password, through PasswordAuth or the author service
+func (s *Server) LoginHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authenticator := s.PasswordAuth
+		if authenticator == nil {
+			authenticator = &PasswordAuthenticator{Authors: s.AuthorsService}
 		}
+		s.login(w, r, PasswordLogin, authenticator)
+	}
+}
 
-		// Declare the token with the algorithm used for signing, and the claims
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+// ExternalLoginHandler finishes a login through an authenticator
+func (s *Server) ExternalLoginHandler(name string, authenticator Authenticator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.login(w, r, name, authenticator)
+	}
+}
 
-		// Create the JWT string
-		tokenString, err := token.SignedString(jwtKey)
-		if err != nil {
-			writeJSONError(w, "failed to create token", http.StatusInternalServerError)
+type LoginUnlock struct {
+	Author string `json:"author"`
+	IP     string `json:"ip"`
+}
+
+// UnlockLoginHandler lifts the lockout of an account or an IP address, only admin may do this
+func (s *Server) UnlockLoginHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get the context from the request
+		author, ok := r.Context().Value(ContextAuthor).(string)
+		if !ok {
+			s.log(r).Error().Msg("error getting author from context")
+			writeJSONError(w, ErrInvalidRequest, http.StatusBadRequest)
 			return
 		}
-
-		// Construct the response object
-		response := LoginResponse{
-			Token: tokenString,
+		if author != "admin" {
+			s.log(r).Error().Msg("only admin can unlock logins")
+			writeJSONError(w, "not allowed to unlock logins", http.StatusForbidden)
+			return
 		}
 
-		// Marshal the response object to JSON
-		jsonResponse, err := json.Marshal(response)
+		// Validate incoming unlock
+		var unlock LoginUnlock
+		err := json.NewDecoder(r.Body).Decode(&unlock)
 		if err != nil {
-			writeJSONError(w, "failed to marshal response", http.StatusInternalServerError)
+			s.log(r).Error().Err(err).Msg("invalid request payload")
+			writeJSONError(w, "invalid request payload", http.StatusBadRequest)
+			return
+		}
+		if unlock.Author == "" && unlock.IP == "" {
+			s.log(r).Error().Msg("author or ip must not be empty")
+			writeJSONError(w, "author or ip must not be empty", http.StatusBadRequest)
 			return
 		}
 
-		// Set the content-type header to json
-		w.Header().Set("Content-Type", "application/json")
+		s.LoginGuard.Unlock(unlock.Author, unlock.IP)
+		s.log(r).Info().Str("audit", "login.unlocked").Str("author", unlock.Author).Str("ip", unlock.IP).Str("by", author).Msg("login unlocked")
+		target := unlock.Author
+		if target == "" {
+			target = unlock.IP
+		}
+		s.audit(r, internal.AuditEntry{Actor: author, Action: internal.AuditLoginUnlocked, Target: target})
 
-		// Send the response
-		w.Write(jsonResponse)
+		// Status accepted
+		w.WriteHeader(http.StatusAccepted)
 	}
 }
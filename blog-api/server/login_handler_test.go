@@ -5,13 +5,17 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"rakia.ai/blog-api/v2/internal"
 )
 
 // MockAuthorService is a mock version of AuthorsService
 type MockAuthorService struct {
 	validAuthor bool
+	profiles    map[string]*internal.AuthorProfile
+	deleted     []string
 }
 
 // ValidAuthor mocks the ValidAuthor function of the AuthorsService
@@ -19,6 +23,73 @@ func (m *MockAuthorService) ValidAuthor(username, password string) (bool, error)
 	return m.validAuthor, nil
 }
 
+func (m *MockAuthorService) ListAuthors() ([]*internal.AuthorProfile, error) {
+	result := []*internal.AuthorProfile{}
+	for _, profile := range m.profiles {
+		result = append(result, profile)
+	}
+	return result, nil
+}
+
+func (m *MockAuthorService) GetAuthor(name string) (*internal.AuthorProfile, error) {
+	if profile, ok := m.profiles[name]; ok {
+		return profile, nil
+	}
+	return nil, internal.ErrAuthorNotFound
+}
+
+func (m *MockAuthorService) UpdateProfile(name string, profile internal.AuthorProfile) (*internal.AuthorProfile, error) {
+	return nil, internal.ErrAuthorNotFound
+}
+
+func (m *MockAuthorService) SetAuthorDisabled(name string, disabled bool) error {
+	if profile, ok := m.profiles[name]; ok {
+		profile.Disabled = disabled
+	}
+	return nil
+}
+
+func (m *MockAuthorService) CheckCredentials(name string, issuedAt time.Time) error {
+	for _, deleted := range m.deleted {
+		if deleted == name {
+			return internal.ErrAuthorNotFound
+		}
+	}
+	if profile, ok := m.profiles[name]; ok && profile.Disabled {
+		return internal.ErrAuthorDisabled
+	}
+	return nil
+}
+
+func (m *MockAuthorService) SetAuthorRole(name string, role string) error {
+	profile, ok := m.profiles[name]
+	if !ok {
+		return internal.ErrAuthorNotFound
+	}
+	profile.Role = role
+	return nil
+}
+
+func (m *MockAuthorService) IsEditor(name string) bool {
+	profile, ok := m.profiles[name]
+	return name == "admin" || (ok && profile.Role == internal.RoleEditor)
+}
+
+func (m *MockAuthorService) ChangePassword(name string, oldPassword string, newPassword string) error {
+	if oldPassword != "password1" {
+		return internal.ErrPasswordIncorrect
+	}
+	return nil
+}
+
+func (m *MockAuthorService) DeleteAuthor(name string, removePosts func() error) error {
+	if err := removePosts(); err != nil {
+		return err
+	}
+	m.deleted = append(m.deleted, name)
+	return nil
+}
+
 func TestLoginHandler(t *testing.T) {
 	// Create a new instance of our server with a mock AuthorsService
 	s := Server{
@@ -47,3 +118,87 @@ func TestLoginHandler(t *testing.T) {
 	assert.Contains(t, rr.Body.String(), expected, "handler returned unexpected body")
 
 }
+
+// MockLoginGuard is a mock version of LoginGuard
+type MockLoginGuard struct {
+	err       error
+	failures  int
+	successes int
+}
+
+func (m *MockLoginGuard) Check(author string, ip string) error {
+	return m.err
+}
+
+func (m *MockLoginGuard) RecordFailure(author string, ip string) {
+	m.failures++
+}
+
+func (m *MockLoginGuard) RecordSuccess(author string, ip string) {
+	m.successes++
+}
+
+func (m *MockLoginGuard) Unlock(author string, ip string) {}
+
+func TestLoginHandlerFailureIsRecorded(t *testing.T) {
+	guard := &MockLoginGuard{}
+	s := Server{
+		AuthorsService: &MockAuthorService{validAuthor: false},
+		LoginGuard:     guard,
+		Logger:         &logger,
+	}
+
+	loginData := `{"author":"testauthor","password":"wrong"}`
+	req, _ := http.NewRequest("POST", "/login", bytes.NewBufferString(loginData))
+	rr := httptest.NewRecorder()
+	s.LoginHandler().ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Equal(t, "credentials_invalid", responseCode(rr))
+	assert.Equal(t, 1, guard.failures)
+
+	// The right password clears the failures of the account
+	s.AuthorsService = &MockAuthorService{validAuthor: true}
+	req, _ = http.NewRequest("POST", "/login", bytes.NewBufferString(`{"author":"testauthor","password":"password"}`))
+	rr = httptest.NewRecorder()
+	s.LoginHandler().ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, 1, guard.successes)
+}
+
+func TestLoginHandlerLockedOut(t *testing.T) {
+	s := Server{
+		AuthorsService: &MockAuthorService{validAuthor: true},
+		LoginGuard:     &MockLoginGuard{err: &internal.LockoutError{RetryAfter: time.Millisecond * 1500}},
+	}
+
+	loginData := `{"author":"testauthor","password":"password"}`
+	req, _ := http.NewRequest("POST", "/login", bytes.NewBufferString(loginData))
+	rr := httptest.NewRecorder()
+	s.LoginHandler().ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "2", rr.Header().Get("Retry-After"))
+}
+
+func TestConfigureJWT(t *testing.T) {
+	defer ConfigureJWT(jwtKey, expirationTime)
+
+	assert.Error(t, ConfigureJWT(nil, time.Minute))
+	assert.Error(t, ConfigureJWT([]byte("key"), 0))
+
+	token, err := createToken("testauthor", "", time.Minute)
+	assert.NoError(t, err)
+
+	// Tokens signed with the old key are not accepted anymore
+	assert.NoError(t, ConfigureJWT([]byte("another key of at least 32 characters"), time.Hour))
+	_, err = parseToken(token)
+	assert.Error(t, err)
+
+	token, err = createToken("testauthor", "", time.Minute)
+	assert.NoError(t, err)
+	claims, err := parseToken(token)
+	assert.NoError(t, err)
+	assert.Equal(t, "testauthor", claims.Username)
+	assert.Equal(t, time.Hour, expirationTime)
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"rakia.ai/blog-api/v2/internal"
+)
+
+// Media never changes under its hash, so it can be cached for a year
+const mediaCacheControl = "public, max-age=31536000, immutable"
+
+// Room for the multipart headers and other form fields next to the file of an upload
+const mediaUploadOverhead = 64 << 10
+
+type MediaResponse struct {
+	*internal.Media
+	URL      string            `json:"url"`
+	Variants []VariantResponse `json:"variants,omitempty"`
+}
+
+type VariantResponse struct {
+	internal.Variant
+	URL string `json:"url"`
+}
+
+// mediaResponse adds the URLs of the media and its variants
+func (s *Server) mediaResponse(r *http.Request, media *internal.Media) MediaResponse {
+	url := s.baseURL(r) + "/media/" + media.Hash
+	result := MediaResponse{Media: media, URL: url}
+	for _, variant := range media.Variants {
+		result.Variants = append(result.Variants, VariantResponse{Variant: variant, URL: url + "/" + strconv.Itoa(variant.Width)})
+	}
+	return result
+}
+
+// UploadMediaHandler stores the "file" of a multipart upload, uploading the same content again returns the
+// existing media with 200 instead of 201
+func (s *Server) UploadMediaHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		author, _ := r.Context().Value(ContextAuthor).(string)
+
+		// The media service reads the file into memory up to the size limit to check and hash it, the body is
+		// limited to a little more so other fields cannot make the request larger
+		r.Body = http.MaxBytesReader(w, r.Body, s.MediaService.MaxSize()+mediaUploadOverhead)
+		reader, err := r.MultipartReader()
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("invalid multipart upload")
+			writeJSONError(w, "upload must be multipart/form-data with a file field", http.StatusBadRequest)
+			return
+		}
+		var file io.Reader
+		for {
+			part, err := reader.NextPart()
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeError(w, internal.ErrMediaTooLarge, "error uploading media")
+				return
+			}
+			if err != nil {
+				break
+			}
+			if part.FormName() == "file" {
+				file = part
+				break
+			}
+		}
+		if file == nil {
+			writeJSONError(w, "upload must be multipart/form-data with a file field", http.StatusBadRequest)
+			return
+		}
+
+		media, created, err := s.MediaService.Upload(file, author)
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("error uploading media")
+			writeError(w, err, "error uploading media")
+			return
+		}
+
+		status := http.StatusOK
+		if created {
+			status = http.StatusCreated
+		}
+		writeJSON(w, s.mediaResponse(r, media), status)
+	}
+}
+
+// GetMediaHandler serves media with range requests and conditional requests on its hash
+func (s *Server) GetMediaHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		media, content, err := s.MediaService.Open(mux.Vars(r)["hash"])
+		if err != nil {
+			if !errors.Is(err, internal.ErrMediaNotFound) {
+				s.log(r).Error().Err(err).Msg("error opening media")
+			}
+			writeError(w, err, "error getting media")
+			return
+		}
+		defer content.Close()
+
+		w.Header().Set("Content-Type", media.ContentType)
+		w.Header().Set("ETag", `"`+media.Hash+`"`)
+		w.Header().Set("Cache-Control", mediaCacheControl)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		http.ServeContent(w, r, "", media.CreatedAt, content)
+	}
+}
+
+// GetMediaInfoHandler returns the metadata of media with the URLs of its thumbnails, public. Thumbnails are made in
+// the background, so they are missing right after the upload
+func (s *Server) GetMediaInfoHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		media, err := s.MediaService.Get(mux.Vars(r)["hash"])
+		if err != nil {
+			writeError(w, err, "error getting media")
+			return
+		}
+		writeJSON(w, s.mediaResponse(r, media), http.StatusOK)
+	}
+}
+
+// GetMediaVariantHandler serves a thumbnail of media by its width
+func (s *Server) GetMediaVariantHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		width, _ := strconv.Atoi(vars["width"])
+		variant, content, err := s.MediaService.OpenVariant(vars["hash"], width)
+		if err != nil {
+			if !errors.Is(err, internal.ErrMediaNotFound) {
+				s.log(r).Error().Err(err).Msg("error opening media variant")
+			}
+			writeError(w, err, "error getting media")
+			return
+		}
+		defer content.Close()
+
+		w.Header().Set("Content-Type", variant.ContentType)
+		w.Header().Set("ETag", `"`+vars["hash"]+"-"+vars["width"]+`"`)
+		w.Header().Set("Cache-Control", mediaCacheControl)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		http.ServeContent(w, r, "", time.Time{}, content)
+	}
+}
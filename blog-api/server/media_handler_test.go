@@ -0,0 +1,127 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"rakia.ai/blog-api/v2/internal"
+)
+
+func TestMediaHandlers(t *testing.T) {
+	store, _ := internal.NewDiskBlobStore(t.TempDir())
+	media, _ := internal.NewMediaService(store, 1<<20, "", nil, &logger)
+	server := NewServer(mux.NewRouter(), new(MockPostsService), nil, &logger)
+	server.MediaService = media
+	server.Routes()
+
+	png := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{1}, 100)...)
+	upload := func(content []byte, fields ...string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		for _, field := range fields {
+			form.WriteField("field", field)
+		}
+		part, _ := form.CreateFormFile("file", "image.png")
+		part.Write(content)
+		form.Close()
+
+		token, _ := createToken("Author 1", "", time.Minute)
+		req, _ := http.NewRequest("POST", "/api/media", &body)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		rr := httptest.NewRecorder()
+		server.Router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := upload(png)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	var uploaded MediaResponse
+	json.Unmarshal(rr.Body.Bytes(), &uploaded)
+	assert.Equal(t, "image/png", uploaded.ContentType)
+	assert.Contains(t, uploaded.URL, "/media/"+uploaded.Hash)
+
+	rr = upload(png)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rr = upload([]byte("just some text"))
+	assert.Equal(t, http.StatusUnsupportedMediaType, rr.Code)
+
+	// Other fields do not make the request larger than the file may be
+	rr = upload(png, string(bytes.Repeat([]byte("a"), 2<<20)))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+
+	// Served without a token, with caching headers and ranges
+	req, _ := http.NewRequest("GET", "/media/"+uploaded.Hash, nil)
+	rr = httptest.NewRecorder()
+	server.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "image/png", rr.Header().Get("Content-Type"))
+	assert.Equal(t, `"`+uploaded.Hash+`"`, rr.Header().Get("ETag"))
+	assert.Contains(t, rr.Header().Get("Cache-Control"), "immutable")
+	assert.Equal(t, png, rr.Body.Bytes())
+
+	req, _ = http.NewRequest("GET", "/media/"+uploaded.Hash, nil)
+	req.Header.Set("Range", "bytes=0-7")
+	rr = httptest.NewRecorder()
+	server.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusPartialContent, rr.Code)
+	assert.Equal(t, png[:8], rr.Body.Bytes())
+
+	req, _ = http.NewRequest("GET", "/media/"+uploaded.Hash, nil)
+	req.Header.Set("If-None-Match", `"`+uploaded.Hash+`"`)
+	rr = httptest.NewRecorder()
+	server.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotModified, rr.Code)
+
+	req, _ = http.NewRequest("GET", "/media/"+string(bytes.Repeat([]byte("0"), 64)), nil)
+	rr = httptest.NewRecorder()
+	server.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestMediaVariantHandlers(t *testing.T) {
+	store, _ := internal.NewDiskBlobStore(t.TempDir())
+	media, _ := internal.NewMediaService(store, 1<<20, "", nil, &logger)
+	thumbnailer, _ := internal.NewThumbnailer(media, []int{10}, 1, &logger)
+	server := NewServer(mux.NewRouter(), new(MockPostsService), nil, &logger)
+	server.MediaService = media
+	server.Routes()
+
+	var img bytes.Buffer
+	png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 40, 20)))
+	uploaded, _, _ := media.Upload(&img, "Author 1")
+	thumbnailer.Generate(uploaded.Hash)
+
+	// Metadata is public and lists the thumbnails
+	req, _ := http.NewRequest("GET", "/api/media/"+uploaded.Hash, nil)
+	rr := httptest.NewRecorder()
+	server.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var info MediaResponse
+	json.Unmarshal(rr.Body.Bytes(), &info)
+	assert.Len(t, info.Variants, 1)
+	assert.Equal(t, 10, info.Variants[0].Width)
+	assert.Equal(t, 5, info.Variants[0].Height)
+	assert.Contains(t, info.Variants[0].URL, "/media/"+uploaded.Hash+"/10")
+
+	req, _ = http.NewRequest("GET", "/media/"+uploaded.Hash+"/10", nil)
+	rr = httptest.NewRecorder()
+	server.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "image/png", rr.Header().Get("Content-Type"))
+	assert.Contains(t, rr.Header().Get("Cache-Control"), "immutable")
+
+	req, _ = http.NewRequest("GET", "/media/"+uploaded.Hash+"/20", nil)
+	rr = httptest.NewRecorder()
+	server.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// PostCounter counts the posts and their authors for the metrics
+type PostCounter interface {
+	Count(ctx context.Context) (posts int, authors int)
+}
+
+// Metrics are exposed in the Prometheus text format on /metrics, they are kept in memory and scraped from there
+type Metrics struct {
+	registry *prometheus.Registry
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	inFlight prometheus.Gauge
+	logins   *prometheus.CounterVec
+}
+
+// NewMetrics creates the metrics of the server, the posts and authors gauges are read from posts when scraped
+func NewMetrics(posts PostCounter) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "blog_http_requests_total",
+			Help: "HTTP requests by route template, method and status code.",
+		}, []string{"route", "method", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "blog_http_request_duration_seconds",
+			Help:    "Latency of HTTP requests by route template, method and status code.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method", "code"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "blog_http_requests_in_flight",
+			Help: "HTTP requests being served.",
+		}),
+		logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "blog_logins_total",
+			Help: "Login attempts by result.",
+		}, []string{"result"}),
+	}
+	m.registry.MustRegister(
+		m.requests,
+		m.duration,
+		m.inFlight,
+		m.logins,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	if posts != nil {
+		m.registry.MustRegister(
+			prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+				Name: "blog_posts",
+				Help: "Posts, drafts included.",
+			}, func() float64 {
+				n, _ := posts.Count(context.Background())
+				return float64(n)
+			}),
+			prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+				Name: "blog_authors",
+				Help: "Authors with at least one post.",
+			}, func() float64 {
+				_, n := posts.Count(context.Background())
+				return float64(n)
+			}),
+		)
+	}
+	return m
+}
+
+// Handler serves the metrics in the Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Login counts a login attempt by its audit action, e.g. login.succeeded is counted as succeeded
+func (m *Metrics) Login(action string) {
+	m.logins.WithLabelValues(strings.TrimPrefix(action, "login.")).Inc()
+}
+
+// statusRecorder remembers the status code written by the handler and counts the bytes of the body
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController flush the stream of events through the recorder
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Middleware counts the requests and their latency by the template of the matched route, so /api/posts/1 and
+// /api/posts/2 are one series. Requests which match no route are not counted
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeTemplate(r)
+		if route == "" {
+			route = "unknown"
+		}
+
+		m.inFlight.Inc()
+		defer m.inFlight.Dec()
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		code := strconv.Itoa(recorder.status)
+		m.requests.WithLabelValues(route, r.Method, code).Inc()
+		m.duration.WithLabelValues(route, r.Method, code).Observe(time.Since(start).Seconds())
+	})
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"rakia.ai/blog-api/v2/internal"
+)
+
+type fakePostCounter struct{}
+
+func (fakePostCounter) Count(ctx context.Context) (int, int) {
+	return 5, 2
+}
+
+func TestMetrics(t *testing.T) {
+	mockService := new(MockPostsService)
+	mockService.On("GetPostByID", mock.Anything).Return(&internal.Post{ID: 1, Author: "Author 1"}, nil)
+	server := NewServer(mux.NewRouter(), mockService, &MockAuthorService{}, &logger)
+	server.Metrics = NewMetrics(fakePostCounter{})
+	server.Routes()
+
+	for _, path := range []string{"/api/posts/1", "/api/posts/2"} {
+		req, _ := http.NewRequest("GET", path, nil)
+		server.Router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	body, _ := json.Marshal(internal.Author{Author: "Author 1", Password: "wrong"})
+	req, _ := http.NewRequest("POST", "/login", bytes.NewReader(body))
+	server.Router.ServeHTTP(httptest.NewRecorder(), req)
+
+	req, _ = http.NewRequest("GET", "/metrics", nil)
+	rr := httptest.NewRecorder()
+	server.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	metrics := rr.Body.String()
+
+	// Requests are counted by the route template, not the path
+	assert.Contains(t, metrics, `blog_http_requests_total{code="200",method="GET",route="/api/posts/{id}"} 2`)
+	assert.Contains(t, metrics, `blog_http_request_duration_seconds_count{code="200",method="GET",route="/api/posts/{id}"} 2`)
+	assert.Contains(t, metrics, `blog_http_requests_total{code="401",method="POST",route="/login"} 1`)
+	// The scrape itself is in flight
+	assert.Contains(t, metrics, "blog_http_requests_in_flight 1")
+	assert.Contains(t, metrics, `blog_logins_total{result="failed"} 1`)
+	assert.Contains(t, metrics, "blog_posts 5")
+	assert.Contains(t, metrics, "blog_authors 2")
+}
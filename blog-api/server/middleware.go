@@ -3,11 +3,13 @@ package server
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"strings"
+	"time"
 
-	"github.com/golang-jwt/jwt"
 	"github.com/rs/zerolog"
+	"rakia.ai/blog-api/v2/internal"
 )
 
 type contextKey string
@@ -15,9 +17,16 @@ type contextKey string
 const (
 	// ContextAuthor is the key for the author data in the request context
 	ContextAuthor contextKey = "author"
+	// ContextScopes is the key for the scopes granted to the request credentials
+	ContextScopes contextKey = "scopes"
+	// ContextTokenID is the key for the personal access token ID, only set when one was used
+	ContextTokenID contextKey = "token_id"
 )
 
-func Middleware(logger zerolog.Logger) func(next http.Handler) http.Handler {
+// Middleware authenticates the request with either a JWT or a personal access token, the credentials of authors
+// who were disabled, deleted or changed their password after they were issued are rejected. Failures are logged with
+// the logger of the request, so they carry its request ID
+func Middleware(tokens TokensService, authors AuthorsService) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Extract the token from the Authorization header
@@ -35,25 +44,162 @@ func Middleware(logger zerolog.Logger) func(next http.Handler) http.Handler {
 
 			tokenString := bearerToken[1]
 
-			// Parse the token
-			claims := &Claims{}
-			token, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
-				if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-					return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+			// Personal access tokens are looked up in the token service
+			if strings.HasPrefix(tokenString, internal.TokenPrefix) {
+				if tokens == nil {
+					writeJSONError(w, "Invalid token", http.StatusUnauthorized)
+					return
 				}
-				return jwtKey, nil
-			})
+				token, err := tokens.ValidateToken(tokenString)
+				if err != nil {
+					zerolog.Ctx(r.Context()).Error().Err(err).Msg("invalid personal access token")
+					writeJSONError(w, "Invalid token", http.StatusUnauthorized)
+					return
+				}
+				if authors != nil {
+					if err := authors.CheckCredentials(token.Author, token.CreatedAt); err != nil {
+						zerolog.Ctx(r.Context()).Info().Err(err).Str("author", token.Author).Msg("personal access token no longer accepted")
+						writeJSONError(w, "Invalid token", http.StatusUnauthorized)
+						return
+					}
+				}
+
+				// If the token is valid, set the author and the granted scopes in the context
+				ctx := context.WithValue(r.Context(), ContextAuthor, token.Author)
+				ctx = context.WithValue(ctx, ContextScopes, token.Scopes)
+				ctx = context.WithValue(ctx, ContextTokenID, token.ID)
+				withAuthor(ctx, token.Author)
+
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
 
-			if err != nil || !token.Valid {
+			// Parse the token, tokens with a purpose such as the two-factor challenge are not sessions
+			claims, err := parseToken(tokenString)
+			if err != nil || claims.Purpose != "" {
 				writeJSONError(w, "Invalid token", http.StatusUnauthorized)
 				return
 			}
+			if authors != nil {
+				if err := authors.CheckCredentials(claims.Username, time.Unix(claims.IssuedAt, 0)); err != nil {
+					zerolog.Ctx(r.Context()).Info().Err(err).Str("author", claims.Username).Msg("session no longer accepted")
+					writeJSONError(w, "Invalid token", http.StatusUnauthorized)
+					return
+				}
+			}
 
-			// If the token is valid, set the author in the context
+			// If the token is valid, set the author in the context, a login grants every scope
 			ctx := context.WithValue(r.Context(), ContextAuthor, claims.Username)
+			ctx = context.WithValue(ctx, ContextScopes, internal.AllScopes)
+			withAuthor(ctx, claims.Username)
 
 			// Call the next handler, with the new context
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
+
+// OptionalMiddleware lets anonymous readers through, requests with an Authorization header are authenticated
+// like with Middleware and rejected when the credentials are invalid
+func OptionalMiddleware(tokens TokensService, authors AuthorsService) func(next http.Handler) http.Handler {
+	authenticate := Middleware(tokens, authors)
+	return func(next http.Handler) http.Handler {
+		authenticated := authenticate(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			authenticated.ServeHTTP(w, r)
+		})
+	}
+}
+
+// PublicMiddleware rate limits anonymous readers per IP address and marks their responses as cacheable,
+// responses for logged in authors can hold drafts and must not be cached
+func PublicMiddleware(limiter RateLimiter, maxAge time.Duration) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Authorization")
+			if _, ok := r.Context().Value(ContextAuthor).(string); ok {
+				w.Header().Set("Cache-Control", "private, no-store")
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if limiter != nil && !allowRequest(w, r, limiter, "ip:"+clientIP(r)) {
+				return
+			}
+			w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds())))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// readScope lets anonymous readers through, authenticated requests need the scope like with requireScope
+func readScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	scoped := requireScope(scope, next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value(ContextAuthor).(string); !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		scoped.ServeHTTP(w, r)
+	}
+}
+
+// requireScope only lets the request through if its credentials were granted the scope
+func requireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		scopes, _ := r.Context().Value(ContextScopes).([]string)
+		for _, s := range scopes {
+			if s == scope {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		writeJSONError(w, fmt.Sprintf("token is missing the %s scope", scope), http.StatusForbidden)
+	}
+}
+
+// requireSession rejects requests made with a personal access token, so tokens cannot manage tokens
+func requireSession(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value(ContextTokenID).(string); ok {
+			writeJSONError(w, "personal access tokens cannot be used for this endpoint", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// requireAdmin only lets admin through
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if author, _ := r.Context().Value(ContextAuthor).(string); author != "admin" {
+			writeJSONError(w, "only admin can use this endpoint", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// requireEditor only lets editors and admin through
+func (s *Server) requireEditor(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if author, _ := r.Context().Value(ContextAuthor).(string); !s.AuthorsService.IsEditor(author) {
+			writeJSONError(w, "only editors can use this endpoint", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// clientIP returns the IP address of the client that sent the request
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"rakia.ai/blog-api/v2/internal"
+)
+
+func publicTestServer(limiter RateLimiter) *Server {
+	posts := []*internal.Post{
+		{ID: 1, Title: "Published", Author: "Author 1", Status: internal.PostPublished},
+		{ID: 2, Title: "Draft", Author: "Author 1", Status: internal.PostDraft},
+	}
+	mockPostsService := new(MockPostsService)
+	mockPostsService.On("GetAllPosts").Return(posts, nil)
+	mockPostsService.On("GetPostByID", 1).Return(posts[0], nil)
+	mockPostsService.On("GetPostByID", 2).Return(posts[1], nil)
+
+	server := NewServer(mux.NewRouter(), mockPostsService, nil, &logger)
+	server.PublicLimiter = limiter
+	server.Routes()
+	return server
+}
+
+func TestPublicReadsWithoutToken(t *testing.T) {
+	server := publicTestServer(nil)
+
+	// Anonymous readers only see published posts and may cache them
+	req, _ := http.NewRequest("GET", "/api/posts", nil)
+	rr := httptest.NewRecorder()
+	server.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "public, max-age=60", rr.Header().Get("Cache-Control"))
+	var posts []PostResponse
+	json.Unmarshal(rr.Body.Bytes(), &posts)
+	assert.Len(t, posts, 1)
+	assert.Equal(t, 1, posts[0].ID)
+
+	req, _ = http.NewRequest("GET", "/api/posts/2", nil)
+	rr = httptest.NewRecorder()
+	server.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	// Writing still needs a token
+	req, _ = http.NewRequest("POST", "/api/posts", bytes.NewBufferString(`{}`))
+	rr = httptest.NewRecorder()
+	server.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	// Other reads still need a token
+	req, _ = http.NewRequest("GET", "/api/authors", nil)
+	rr = httptest.NewRecorder()
+	server.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
+
+func TestPublicReadsWithToken(t *testing.T) {
+	server := publicTestServer(nil)
+	token, _ := createToken("Author 1", "", time.Minute)
+
+	// The author sees their drafts, the response is private
+	req, _ := http.NewRequest("GET", "/api/posts/2", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	server.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "private, no-store", rr.Header().Get("Cache-Control"))
+
+	// Other authors do not
+	token, _ = createToken("Author 2", "", time.Minute)
+	req, _ = http.NewRequest("GET", "/api/posts/2", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr = httptest.NewRecorder()
+	server.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	// Invalid tokens are rejected instead of treated as anonymous
+	req, _ = http.NewRequest("GET", "/api/posts/1", nil)
+	req.Header.Set("Authorization", "Bearer invalid")
+	rr = httptest.NewRecorder()
+	server.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
+
+func TestPublicReadsRateLimited(t *testing.T) {
+	limiter, _ := internal.NewRateLimiter("public", 1, time.Minute, nil, nil)
+	server := publicTestServer(limiter)
+
+	req, _ := http.NewRequest("GET", "/api/posts/1", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	rr := httptest.NewRecorder()
+	server.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = httptest.NewRecorder()
+	server.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+
+	// Logged in authors are not limited
+	token, _ := createToken("Author 1", "", time.Minute)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr = httptest.NewRecorder()
+	server.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"rakia.ai/blog-api/v2/internal"
+)
+
+type PasswordChange struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+type PasswordForgot struct {
+	Author string `json:"author"`
+}
+
+type PasswordReset struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// ChangePasswordHandler changes the password of the logged in author, the current password is required
+func (s *Server) ChangePasswordHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get the context from the request
+		author, ok := r.Context().Value(ContextAuthor).(string)
+		if !ok {
+			s.log(r).Error().Msg("error getting author from context")
+			writeJSONError(w, ErrInvalidRequest, http.StatusBadRequest)
+			return
+		}
+
+		var change PasswordChange
+		err := json.NewDecoder(r.Body).Decode(&change)
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("invalid request payload")
+			writeJSONError(w, "invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		// Wrong current passwords count as failed logins, so this cannot be used to guess it
+		if s.lockedOut(w, r, author) {
+			return
+		}
+
+		err = s.AuthorsService.ChangePassword(author, change.OldPassword, change.NewPassword)
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("error changing password")
+			if errors.Is(err, internal.ErrPasswordIncorrect) {
+				s.loginFailed(w, r, author, err)
+				return
+			}
+			writeError(w, err, "error changing password")
+			return
+		}
+
+		s.log(r).Info().Str("audit", "password.changed").Str("author", author).Msg("password changed")
+
+		// Status accepted
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// ForgotPasswordHandler queues a mail with a password reset token, it always responds the same way and just as
+// fast so it cannot be used to find out which authors exist
+func (s *Server) ForgotPasswordHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var forgot PasswordForgot
+		err := json.NewDecoder(r.Body).Decode(&forgot)
+		if err != nil || forgot.Author == "" {
+			writeJSONError(w, "invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		if err := s.PasswordResets.RequestReset(r.Context(), forgot.Author); err != nil {
+			s.log(r).Error().Err(err).Str("author", forgot.Author).Msg("error sending password reset")
+		}
+
+		// Status accepted
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// ResetPasswordHandler sets a new password with a token from ForgotPasswordHandler
+func (s *Server) ResetPasswordHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var reset PasswordReset
+		err := json.NewDecoder(r.Body).Decode(&reset)
+		if err != nil {
+			writeJSONError(w, "invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		err = s.PasswordResets.ResetPassword(reset.Token, reset.Password)
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("error resetting password")
+			writeError(w, err, "error resetting password")
+			return
+		}
+
+		// Status accepted
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"rakia.ai/blog-api/v2/internal"
+)
+
+// MockPasswordResetService is a mock implementation of the PasswordResetService interface
+type MockPasswordResetService struct {
+	mock.Mock
+}
+
+func (m *MockPasswordResetService) RequestReset(ctx context.Context, author string) error {
+	args := m.Called(author)
+	return args.Error(0)
+}
+
+func (m *MockPasswordResetService) ResetPassword(token string, password string) error {
+	args := m.Called(token, password)
+	return args.Error(0)
+}
+
+func TestChangePasswordHandler(t *testing.T) {
+	guard := &MockLoginGuard{}
+	server := &Server{AuthorsService: &MockAuthorService{}, LoginGuard: guard, Logger: &logger}
+
+	// A wrong current password counts as a failed login
+	req, _ := http.NewRequest("POST", "/api/authors/me/password", bytes.NewBufferString(`{"old_password":"wrong","new_password":"new password"}`))
+	req = req.WithContext(context.WithValue(req.Context(), ContextAuthor, "Author 1"))
+	rr := httptest.NewRecorder()
+	server.ChangePasswordHandler().ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Equal(t, "password_incorrect", responseCode(rr))
+	assert.Equal(t, 1, guard.failures)
+
+	req, _ = http.NewRequest("POST", "/api/authors/me/password", bytes.NewBufferString(`{"old_password":"password1","new_password":"new password"}`))
+	req = req.WithContext(context.WithValue(req.Context(), ContextAuthor, "Author 1"))
+	rr = httptest.NewRecorder()
+	server.ChangePasswordHandler().ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+}
+
+func TestForgotAndResetPasswordHandler(t *testing.T) {
+	mockResets := new(MockPasswordResetService)
+	mockResets.On("RequestReset", "Author 9").Return(nil)
+	mockResets.On("ResetPassword", "expired", "new password").Return(internal.ErrResetTokenInvalid)
+
+	server := &Server{PasswordResets: mockResets, Logger: &logger}
+
+	req, _ := http.NewRequest("POST", "/password/forgot", bytes.NewBufferString(`{"author":"Author 9"}`))
+	rr := httptest.NewRecorder()
+	server.ForgotPasswordHandler().ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+
+	req, _ = http.NewRequest("POST", "/password/reset", bytes.NewBufferString(`{"token":"expired","password":"new password"}`))
+	rr = httptest.NewRecorder()
+	server.ResetPasswordHandler().ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestChangePasswordRevokesSessions(t *testing.T) {
+	a := make(internal.AuthorPassword)
+	authors, _ := internal.NewAuthorService(&a, &logger)
+	assert.NoError(t, authors.Seed("../resources/blog_data.json"))
+
+	mockPostsService := new(MockPostsService)
+	mockPostsService.On("GetAllPosts").Return([]*internal.Post{{ID: 1, Title: "Title 1", Author: "Author 1", Status: internal.PostPublished}}, nil)
+	server := NewServer(mux.NewRouter(), mockPostsService, authors, &logger)
+	server.Routes()
+
+	// A session from before the change, e.g. a stolen one
+	claims := &Claims{Username: "Author 1", StandardClaims: jwt.StandardClaims{
+		IssuedAt:  time.Now().Add(-time.Minute).Unix(),
+		ExpiresAt: time.Now().Add(time.Minute).Unix(),
+	}}
+	stolen, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtKey)
+	req, _ := http.NewRequest("GET", "/api/posts", nil)
+	req.Header.Set("Authorization", "Bearer "+stolen)
+	assert.Equal(t, http.StatusOK, serve(server.Router, req).Code)
+
+	rr := authorRequest(server.Router, "POST", "/api/authors/me/password", `{"old_password":"password1","new_password":"new password"}`, "Author 1")
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+
+	assert.Equal(t, http.StatusUnauthorized, serve(server.Router, req).Code)
+
+	// JWTs carry whole seconds, sessions are accepted again from the second after the change
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+	assert.Equal(t, http.StatusOK, authorRequest(server.Router, "GET", "/api/posts", "", "Author 1").Code)
+}
@@ -12,22 +12,82 @@ import (
 var ErrInvalidRequest = "unable to process request due to invalid information"
 
 type PostCreate struct {
-	Title   string `json:"title"`
-	Content string `json:"content"`
-	Author  string `json:"author"`
+	Title   string   `json:"title"`
+	Content string   `json:"content"`
+	Author  string   `json:"author"`
+	Status  string   `json:"status"`
+	Tags    []string `json:"tags"`
+	Media   []string `json:"media"`
 }
 
 type PostUpdate struct {
-	Title   string `json:"title"`
-	Content string `json:"content"`
-	Author  string `json:"author"`
+	Title   string   `json:"title"`
+	Content string   `json:"content"`
+	Author  string   `json:"author"`
+	Status  string   `json:"status"`
+	Tags    []string `json:"tags"`
+	Media   []string `json:"media"`
 }
 
 type PostResponse struct {
-	ID      int64  `json:"id"`
-	Title   string `json:"title"`
-	Content string `json:"content"`
-	Author  string `json:"author"`
+	internal.Post
+	AuthorProfile *internal.AuthorSummary `json:"author_profile,omitempty"`
+	Reactions     map[string]int          `json:"reactions,omitempty"`
+	Views         int64                   `json:"views,omitempty"`
+}
+
+// postResponses embeds a summary of the author profile in each post, the profiles are looked up once per author
+func (s *Server) postResponses(posts []*internal.Post) []PostResponse {
+	summaries := make(map[string]*internal.AuthorSummary)
+	result := make([]PostResponse, 0, len(posts))
+	for _, post := range posts {
+		summary, ok := summaries[post.Author]
+		if !ok && s.AuthorsService != nil {
+			if profile, err := s.AuthorsService.GetAuthor(post.Author); err == nil {
+				summary = profile.Summary()
+			}
+			summaries[post.Author] = summary
+		}
+		result = append(result, PostResponse{Post: *post, AuthorProfile: summary})
+	}
+	return result
+}
+
+// canView reports whether the viewer can see the post, drafts are only shown to their author and admin.
+// Anonymous readers have an empty viewer
+func canView(post *internal.Post, viewer string) bool {
+	return post.Published() || (viewer != "" && (post.Author == viewer || viewer == "admin"))
+}
+
+// postContext gets the author from the context and the post from the URL, it writes the error when the post
+// is missing or not visible. The author is empty for anonymous readers
+func (s *Server) postContext(w http.ResponseWriter, r *http.Request) (string, *internal.Post, bool) {
+	// Get the context from the request, public routes have no author
+	author, _ := r.Context().Value(ContextAuthor).(string)
+
+	// Convert the post ID from string to int
+	postID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		s.log(r).Error().Err(err).Msg("invalid post id")
+		writeJSONError(w, "invalid post id", http.StatusBadRequest)
+		return "", nil, false
+	}
+
+	// Get the post
+	post, err := s.PostsService.GetPostByID(r.Context(), postID)
+	if err != nil && err != internal.ErrPostNotFound && err != internal.ErrAuthorNotFound {
+		s.log(r).Error().Err(err).Msg("error getting post")
+		writeJSONError(w, "error getting post", http.StatusInternalServerError)
+		return "", nil, false
+	}
+	// A missing post is the client's mistake, it is no error of the server
+	if post == nil || !canView(post, author) {
+		s.log(r).Debug().Err(err).Msg("post not found")
+		writeJSONError(w, "post not found", http.StatusNotFound)
+		return "", nil, false
+	}
+
+	return author, post, true
 }
 
 // GetAllPostsHandler gets all posts
@@ -35,28 +95,34 @@ func (s *Server) GetAllPostsHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		// Get all posts for the author
-		posts, err := s.PostsService.GetAllPosts()
+		posts, err := s.PostsService.GetAllPosts(r.Context())
 		if err != nil {
-			if err == internal.ErrAuthorNotFound {
-				s.Logger.Error().Err(err).Msg("author not found")
-				writeJSONError(w, "author not found", http.StatusNotFound)
-				return
-			}
-			s.Logger.Error().Err(err).Msg("error getting posts")
-			writeJSONError(w, "error getting posts", http.StatusInternalServerError)
+			s.log(r).Error().Err(err).Msg("error getting posts")
+			writeError(w, err, "error getting posts")
 			return
 		}
+
+		// Only show the drafts of the logged in author
+		viewer, _ := r.Context().Value(ContextAuthor).(string)
+		visible := posts[:0:0]
+		for _, post := range posts {
+			if canView(post, viewer) {
+				visible = append(visible, post)
+			}
+		}
+		posts = visible
+
 		// Check if there are any posts
 		if len(posts) == 0 {
-			s.Logger.Error().Msg("no posts found")
+			s.log(r).Error().Msg("no posts found")
 			writeJSONError(w, "no posts found", http.StatusNotFound)
 			return
 		}
 
 		// JSON encode the posts
-		jsonResponse, err := json.Marshal(posts)
+		jsonResponse, err := json.Marshal(s.postResponses(posts))
 		if err != nil {
-			s.Logger.Error().Err(err).Msg("error marshalling posts")
+			s.log(r).Error().Err(err).Msg("error marshalling posts")
 			writeJSONError(w, "error getting posts", http.StatusInternalServerError)
 			return
 		}
@@ -76,7 +142,7 @@ func (s *Server) GetPostsHandler() http.HandlerFunc {
 		// Get the post ID from the URL
 		id, ok := mux.Vars(r)["id"]
 		if !ok {
-			s.Logger.Error().Msg("missing post id")
+			s.log(r).Error().Msg("missing post id")
 			writeJSONError(w, "missing post id", http.StatusBadRequest)
 			return
 		}
@@ -84,33 +150,42 @@ func (s *Server) GetPostsHandler() http.HandlerFunc {
 		// Convert the post ID from string to int
 		postID, err := strconv.Atoi(id)
 		if err != nil {
-			s.Logger.Error().Err(err).Msg("invalid post id")
+			s.log(r).Error().Err(err).Msg("invalid post id")
 			writeJSONError(w, "invalid post id", http.StatusBadRequest)
 			return
 		}
 
 		// Get the post
-		post, err := s.PostsService.GetPostByID(postID)
+		post, err := s.PostsService.GetPostByID(r.Context(), postID)
 		if err != nil {
-			s.Logger.Error().Err(err).Msg("error getting post")
-			if err == internal.ErrPostNotFound || err == internal.ErrAuthorNotFound {
-				writeJSONError(w, err.Error(), http.StatusNotFound)
-				return
-			}
-			writeJSONError(w, "error getting post", http.StatusInternalServerError)
+			s.log(r).Error().Err(err).Msg("error getting post")
+			writeError(w, err, "error getting post")
 			return
 		}
 
-		if post == nil {
-			s.Logger.Error().Msg("post not found")
+		// Drafts are only shown to their author and admin
+		viewer, _ := r.Context().Value(ContextAuthor).(string)
+		if post == nil || !canView(post, viewer) {
+			s.log(r).Debug().Msg("post not found")
 			writeJSONError(w, "post not found", http.StatusNotFound)
 			return
 		}
 
+		response := s.postResponses([]*internal.Post{post})[0]
+
+		// Count the view, it is flushed to the posts service in batches
+		if s.ViewCounter != nil {
+			s.ViewCounter.Increment(post.ID)
+			response.Views = s.ViewCounter.Views(post.ID)
+		}
+		if s.ReactionsService != nil {
+			response.Reactions = s.ReactionsService.Counts(post.ID)
+		}
+
 		// JSON encode the post
-		jsonResponse, err := json.Marshal(post)
+		jsonResponse, err := json.Marshal(response)
 		if err != nil {
-			s.Logger.Error().Err(err).Msg("error marshalling post")
+			s.log(r).Error().Err(err).Msg("error marshalling post")
 			writeJSONError(w, "error marshalling post", http.StatusInternalServerError)
 			return
 		}
@@ -130,7 +205,7 @@ func (s *Server) CreatePostsHandler() http.HandlerFunc {
 		// Get the context from the request
 		author, ok := r.Context().Value(ContextAuthor).(string)
 		if !ok {
-			s.Logger.Error().Msg("error getting author from context")
+			s.log(r).Error().Msg("error getting author from context")
 			writeJSONError(w, ErrInvalidRequest, http.StatusBadRequest)
 			return
 		}
@@ -139,7 +214,7 @@ func (s *Server) CreatePostsHandler() http.HandlerFunc {
 		var postRequest PostCreate
 		err := json.NewDecoder(r.Body).Decode(&postRequest)
 		if err != nil {
-			s.Logger.Error().Err(err).Msg("invalid request payload")
+			s.log(r).Error().Err(err).Msg("invalid request payload")
 			writeJSONError(w, "invalid request payload", http.StatusBadRequest)
 			return
 		}
@@ -149,43 +224,25 @@ func (s *Server) CreatePostsHandler() http.HandlerFunc {
 			Title:   postRequest.Title,
 			Content: postRequest.Content,
 			Author:  postRequest.Author,
+			Status:  postRequest.Status,
+			Tags:    postRequest.Tags,
+			Media:   postRequest.Media,
 		}
-		// Check if the author is empty
-		if post.Author == "" {
-			s.Logger.Error().Msg("author must not be empty")
-			writeJSONError(w, "author must not be empty", http.StatusBadRequest)
-			return
-		}
-		// Check if the author in the request matches the author in token
-		if post.Author != author && author != "admin" {
-			s.Logger.Error().Msg("mismatching authors in request and url")
-			writeJSONError(w, "not allowed to create posts for another author", http.StatusBadRequest)
+		// Check if the author in the request matches the author in token, an empty author is reported by the validation
+		if post.Author != "" && post.Author != author && author != "admin" {
+			s.log(r).Error().Msg("mismatching authors in request and token")
+			writeError(w, internal.ErrAuthorMismatch, "")
 			return
 		}
 
 		// Save the post
-		err = s.PostsService.CreatePosts(post, author)
+		created, err := s.PostsService.CreatePosts(r.Context(), post, author)
 		if err != nil {
-			s.Logger.Error().Err(err).Msg("error creating post")
-			// Handle validation errors
-			switch err {
-			case internal.ErrUniqueTitle,
-				internal.ErrTitleEmpty,
-				internal.ErrTitleInvalid,
-				internal.ErrContentEmpty,
-				internal.ErrContentInvalid,
-				internal.ErrAuthorEmpty,
-				internal.ErrContentEncoding,
-				internal.ErrTitleInvalidChars,
-				internal.ErrTitleSpammy,
-				internal.ErrTitleCapitalization,
-				internal.ErrAuthorNameInvalid:
-				writeJSONError(w, err.Error(), http.StatusBadRequest)
-				return
-			}
-			writeJSONError(w, err.Error(), http.StatusInternalServerError)
+			s.log(r).Error().Err(err).Msg("error creating post")
+			writeError(w, err, "error creating post")
 			return
 		}
+		s.audit(r, internal.AuditEntry{Actor: author, Action: internal.AuditPostCreate, PostID: created.ID, AfterHash: internal.HashPost(created)})
 
 		// Status accepted
 		w.WriteHeader(http.StatusCreated)
@@ -199,7 +256,7 @@ func (s *Server) UpdatePostsHandler() http.HandlerFunc {
 		// Get the context from the request
 		author, ok := r.Context().Value(ContextAuthor).(string)
 		if !ok {
-			s.Logger.Error().Msg("error getting author from context")
+			s.log(r).Error().Msg("error getting author from context")
 			writeJSONError(w, ErrInvalidRequest, http.StatusBadRequest)
 			return
 		}
@@ -207,7 +264,7 @@ func (s *Server) UpdatePostsHandler() http.HandlerFunc {
 		// Get the post ID from the URL
 		id, ok := mux.Vars(r)["id"]
 		if !ok {
-			s.Logger.Error().Msg("missing post id")
+			s.log(r).Error().Msg("missing post id")
 			writeJSONError(w, "missing post id", http.StatusBadRequest)
 			return
 		}
@@ -215,7 +272,7 @@ func (s *Server) UpdatePostsHandler() http.HandlerFunc {
 		// Convert the post ID from string to int
 		postID, err := strconv.Atoi(id)
 		if err != nil {
-			s.Logger.Error().Err(err).Msg("invalid post id")
+			s.log(r).Error().Err(err).Msg("invalid post id")
 			writeJSONError(w, "invalid post id", http.StatusBadRequest)
 			return
 		}
@@ -224,22 +281,15 @@ func (s *Server) UpdatePostsHandler() http.HandlerFunc {
 		var postRequest PostUpdate
 		err = json.NewDecoder(r.Body).Decode(&postRequest)
 		if err != nil {
-			s.Logger.Error().Err(err).Msg("invalid request payload")
+			s.log(r).Error().Err(err).Msg("invalid request payload")
 			writeJSONError(w, "invalid request payload", http.StatusBadRequest)
 			return
 		}
 
-		// Check if the author is empty
-		if postRequest.Author == "" {
-			s.Logger.Error().Msg("author must not be empty")
-			writeJSONError(w, "author must not be empty", http.StatusBadRequest)
-			return
-		}
-
-		// Check if the author in the request matches the author in token
-		if postRequest.Author != author && author != "admin" {
-			s.Logger.Error().Msg("mismatching authors in request and url")
-			writeJSONError(w, "not allowed to update posts for another author", http.StatusBadRequest)
+		// Check if the author in the request matches the author in token, an empty author is reported by the validation
+		if postRequest.Author != "" && postRequest.Author != author && author != "admin" {
+			s.log(r).Error().Msg("mismatching authors in request and token")
+			writeError(w, internal.ErrAuthorMismatch, "")
 			return
 		}
 		var post internal.Post
@@ -249,37 +299,18 @@ func (s *Server) UpdatePostsHandler() http.HandlerFunc {
 		post.Title = postRequest.Title
 		post.Content = postRequest.Content
 		post.Author = postRequest.Author
+		post.Status = postRequest.Status
+		post.Tags = postRequest.Tags
+		post.Media = postRequest.Media
 
 		// Save the updated post
-		err = s.PostsService.UpdatePosts(post, author)
+		change, err := s.PostsService.UpdatePosts(r.Context(), post, author)
 		if err != nil {
-			s.Logger.Error().Err(err).Msg("error updating post")
-			// Handle validation errors
-			switch err {
-			case internal.ErrUniqueTitle,
-				internal.ErrTitleEmpty,
-				internal.ErrTitleInvalid,
-				internal.ErrContentEmpty,
-				internal.ErrContentInvalid,
-				internal.ErrAuthorEmpty,
-				internal.ErrContentEncoding,
-				internal.ErrTitleInvalidChars,
-				internal.ErrTitleSpammy,
-				internal.ErrTitleCapitalization,
-				internal.ErrAuthorNameInvalid:
-				writeJSONError(w, err.Error(), http.StatusBadRequest)
-				return
-			case internal.ErrPostNotFound:
-				writeJSONError(w, err.Error(), http.StatusNotFound)
-				return
-			case internal.ErrAuthorNotAllowed:
-				writeJSONError(w, err.Error(), http.StatusForbidden)
-				return
-			default:
-				writeJSONError(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
+			s.log(r).Error().Err(err).Msg("error updating post")
+			writeError(w, err, "error updating post")
+			return
 		}
+		s.auditChange(r, author, internal.AuditPostUpdate, change)
 
 		// Status accepted
 		w.WriteHeader(http.StatusAccepted)
@@ -293,7 +324,7 @@ func (s *Server) DeletePostsHandler() http.HandlerFunc {
 		// Get the context from the request
 		author, ok := r.Context().Value(ContextAuthor).(string)
 		if !ok {
-			s.Logger.Error().Msg("error getting author from context")
+			s.log(r).Error().Msg("error getting author from context")
 			writeJSONError(w, ErrInvalidRequest, http.StatusBadRequest)
 			return
 		}
@@ -301,7 +332,7 @@ func (s *Server) DeletePostsHandler() http.HandlerFunc {
 		// Get the post ID from the URL
 		id, ok := mux.Vars(r)["id"]
 		if !ok {
-			s.Logger.Error().Msg("missing post id")
+			s.log(r).Error().Msg("missing post id")
 			writeJSONError(w, "missing post id", http.StatusBadRequest)
 			return
 		}
@@ -309,29 +340,19 @@ func (s *Server) DeletePostsHandler() http.HandlerFunc {
 		// Convert the post ID from string to int
 		postID, err := strconv.Atoi(id)
 		if err != nil {
-			s.Logger.Error().Err(err).Msg("invalid post id")
+			s.log(r).Error().Err(err).Msg("invalid post id")
 			writeJSONError(w, "invalid post id", http.StatusBadRequest)
 			return
 		}
 
 		// Delete the post
-		err = s.PostsService.DeletePosts(postID, author)
+		deleted, err := s.PostsService.DeletePosts(r.Context(), postID, author)
 		if err != nil {
-			switch err {
-			case internal.ErrPostNotFound:
-				s.Logger.Error().Err(err).Msg("post not found")
-				writeJSONError(w, err.Error(), http.StatusNotFound)
-				return
-			case internal.ErrAuthorNotAllowed:
-				s.Logger.Error().Err(err).Msg("not allowed to delete post")
-				writeJSONError(w, err.Error(), http.StatusForbidden)
-				return
-			default:
-				s.Logger.Error().Err(err).Msg("error deleting post")
-				writeJSONError(w, "error deleting post", http.StatusInternalServerError)
-				return
-			}
+			s.log(r).Error().Err(err).Msg("error deleting post")
+			writeError(w, err, "error deleting post")
+			return
 		}
+		s.auditChange(r, author, internal.AuditPostDelete, &internal.PostChange{Before: deleted})
 
 		// Status accepted
 		w.WriteHeader(http.StatusAccepted)
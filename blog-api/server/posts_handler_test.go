@@ -8,6 +8,7 @@ import (
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/rs/zerolog"
@@ -21,33 +22,84 @@ type MockPostsService struct {
 	mock.Mock
 }
 
-func (m *MockPostsService) CreatePosts(post internal.Post, author string) error {
+func (m *MockPostsService) CreatePosts(ctx context.Context, post internal.Post, author string) (*internal.Post, error) {
 	args := m.Called(post, author)
-	return args.Error(0)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*internal.Post), args.Error(1)
 }
 
-func (m *MockPostsService) GetAllPosts() ([]*internal.Post, error) {
+func (m *MockPostsService) GetAllPosts(ctx context.Context) ([]*internal.Post, error) {
 	args := m.Called()
 	return args.Get(0).([]*internal.Post), args.Error(1)
 }
 
-func (m *MockPostsService) UpdatePosts(post internal.Post, author string) error {
+func (m *MockPostsService) UpdatePosts(ctx context.Context, post internal.Post, author string) (*internal.PostChange, error) {
 	args := m.Called(post, author)
-	return args.Error(0)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*internal.PostChange), args.Error(1)
 }
 
-func (m *MockPostsService) GetPostByID(id int) (*internal.Post, error) {
+func (m *MockPostsService) GetPostByID(ctx context.Context, id int) (*internal.Post, error) {
 	args := m.Called(id)
 	return args.Get(0).(*internal.Post), args.Error(1)
 }
 
-func (m *MockPostsService) DeletePosts(id int, author string) error {
+func (m *MockPostsService) DeletePosts(ctx context.Context, id int, author string) (*internal.Post, error) {
 	args := m.Called(id, author)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*internal.Post), args.Error(1)
+}
+
+func (m *MockPostsService) ReassignPosts(ctx context.Context, from string, to string) ([]internal.PostChange, error) {
+	args := m.Called(from, to)
+	changes, _ := args.Get(0).([]internal.PostChange)
+	return changes, args.Error(1)
+}
+
+func (m *MockPostsService) DeleteAuthorPosts(ctx context.Context, author string) ([]internal.Post, error) {
+	args := m.Called(author)
+	deleted, _ := args.Get(0).([]internal.Post)
+	return deleted, args.Error(1)
+}
+
+func (m *MockPostsService) AddViews(ctx context.Context, views map[int]int64) error {
+	args := m.Called(views)
 	return args.Error(0)
 }
 
+func (m *MockPostsService) Views(ctx context.Context) (map[int]int64, error) {
+	args := m.Called()
+	return args.Get(0).(map[int]int64), args.Error(1)
+}
+
 var logger = zerolog.New(os.Stdout)
 
+// newAuthorRequest creates a request with a session of the author
+func newAuthorRequest(method string, path string, body string, author string) *http.Request {
+	token, _ := createToken(author, "", time.Minute)
+	req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+// serve records the response of the handler, e.g. the router of a server, to the request
+func serve(handler http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+// authorRequest sends a request with a session of the author to the handler
+func authorRequest(handler http.Handler, method string, path string, body string, author string) *httptest.ResponseRecorder {
+	return serve(handler, newAuthorRequest(method, path, body, author))
+}
+
 // TestGetAllPostsHandler tests the GetAllPostsHandler function
 func TestGetAllPostsHandler(t *testing.T) {
 
@@ -153,7 +205,7 @@ func TestCreatePostsHandler(t *testing.T) {
 
 	// Create a mock instance of the PostsService
 	mockPostsService := new(MockPostsService)
-	mockPostsService.On("CreatePosts", testPostCreate, "Author 1").Return(nil)
+	mockPostsService.On("CreatePosts", testPostCreate, "Author 1").Return(&internal.Post{ID: 1}, nil)
 
 	// Create an instance of the Server with the mock service
 	server := &Server{PostsService: mockPostsService, Logger: &logger}
@@ -196,7 +248,7 @@ func TestUpdatePostsHandler(t *testing.T) {
 	}
 	// Create a mock instance of the PostsService
 	mockPostsService := new(MockPostsService)
-	mockPostsService.On("UpdatePosts", testPostUpdate, "Author 1").Return(nil)
+	mockPostsService.On("UpdatePosts", testPostUpdate, "Author 1").Return(&internal.PostChange{Before: &testPostUpdate, After: &testPostUpdate}, nil)
 
 	// Create an instance of the Server with the mock service
 	server := &Server{PostsService: mockPostsService, Logger: &logger}
@@ -235,7 +287,7 @@ func TestDeletePostsHandler(t *testing.T) {
 
 	// Create a mock instance of the PostsService
 	mockPostsService := new(MockPostsService)
-	mockPostsService.On("DeletePosts", 1, "Author 1").Return(nil)
+	mockPostsService.On("DeletePosts", 1, "Author 1").Return(&internal.Post{ID: 1, Author: "Author 1"}, nil)
 
 	// Create an instance of the Server with the mock service
 	server := &Server{PostsService: mockPostsService, Logger: &logger}
@@ -286,7 +338,7 @@ func TestGetPostNotDeletedPostFoundHandler(t *testing.T) {
 
 func TestForbiddenDeletedPostFoundHandler(t *testing.T) {
 	mockPostsService := new(MockPostsService)
-	mockPostsService.On("DeletePosts", 1, "Author 3").Return(internal.ErrAuthorNotAllowed)
+	mockPostsService.On("DeletePosts", 1, "Author 3").Return(nil, internal.ErrAuthorNotAllowed)
 
 	server := &Server{PostsService: mockPostsService, Logger: &logger}
 
@@ -335,7 +387,7 @@ func TestUpdateInvalidPostsHandler(t *testing.T) {
 	}
 
 	mockPostsService := new(MockPostsService)
-	mockPostsService.On("UpdatePosts", invalidPostUpdate, "Author 1").Return(internal.ErrContentEmpty)
+	mockPostsService.On("UpdatePosts", invalidPostUpdate, "Author 1").Return(nil, internal.ErrContentEmpty)
 
 	server := &Server{PostsService: mockPostsService, Logger: &logger}
 
@@ -361,7 +413,7 @@ func TestCreateInvalidPostsHandler(t *testing.T) {
 	}
 
 	mockPostsService := new(MockPostsService)
-	mockPostsService.On("CreatePosts", invalidPost, "Author 1").Return(internal.ErrTitleEmpty)
+	mockPostsService.On("CreatePosts", invalidPost, "Author 1").Return(nil, internal.ErrTitleEmpty)
 
 	server := &Server{PostsService: mockPostsService, Logger: &logger}
 
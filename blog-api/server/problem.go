@@ -0,0 +1,103 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"rakia.ai/blog-api/v2/internal"
+)
+
+// Problem is an RFC 7807 problem details body, code is stable so clients can match on it instead of the detail
+type Problem struct {
+	Type   string       `json:"type"`
+	Title  string       `json:"title"`
+	Status int          `json:"status"`
+	Detail string       `json:"detail,omitempty"`
+	Code   string       `json:"code"`
+	Errors []FieldError `json:"errors,omitempty"`
+
+	// Who holds the edit lock of a locked post and until when
+	Lock *internal.EditLock `json:"lock,omitempty"`
+}
+
+// FieldError is a failed validation of a field of the request, so clients can show it at the right input
+type FieldError struct {
+	Field  string `json:"field"`
+	Code   string `json:"code"`
+	Detail string `json:"detail"`
+}
+
+// newProblem creates a problem without a type of its own, so its title is the status text
+func newProblem(status int, code string, detail string) Problem {
+	return Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// problemCode turns the status into a code for problems which are not an error of the domain, e.g. not_found
+func problemCode(status int) string {
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
+// writeProblem writes the problem as an application/problem+json response
+func writeProblem(w http.ResponseWriter, problem Problem) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(problem.Status)
+	json.NewEncoder(w).Encode(problem)
+}
+
+// writeJSONError writes a problem for errors found by the handlers themselves, e.g. an invalid payload
+func writeJSONError(w http.ResponseWriter, message string, statusCode int) {
+	writeProblem(w, newProblem(statusCode, problemCode(statusCode), message))
+}
+
+// fieldError returns the field of the error, if it is about one
+func fieldError(err *internal.Error) []FieldError {
+	if err.Field == "" {
+		return nil
+	}
+	return []FieldError{{Field: err.Field, Code: err.Code, Detail: err.Message}}
+}
+
+// writeError is the one mapping of errors to responses. Errors of the domain are answered with their status, code
+// and field, ValidationErrors with every invalid field. Other errors are answered with 500 and msg, so internals
+// do not leak
+func writeError(w http.ResponseWriter, err error, msg string) {
+	var (
+		problem    Problem
+		validation internal.ValidationErrors
+		domain     *internal.Error
+	)
+	switch {
+	case errors.As(err, &validation):
+		problem = newProblem(http.StatusBadRequest, "validation_failed", "the request has invalid fields")
+		for _, failure := range validation {
+			problem.Errors = append(problem.Errors, fieldError(failure)...)
+		}
+	case errors.As(err, &domain):
+		problem = newProblem(domain.Status, domain.Code, domain.Message)
+		problem.Errors = fieldError(domain)
+	default:
+		problem = newProblem(http.StatusInternalServerError, "internal_error", msg)
+	}
+
+	// Tell the client when to try again
+	var locked *internal.PostLockedError
+	if errors.As(err, &locked) {
+		problem.Lock = &locked.Lock
+		w.Header().Set("Retry-After", strconv.Itoa(seconds(time.Until(locked.Lock.ExpiresAt))))
+	}
+	var lockout *internal.LockoutError
+	if errors.As(err, &lockout) {
+		w.Header().Set("Retry-After", strconv.Itoa(seconds(lockout.RetryAfter)))
+	}
+	writeProblem(w, problem)
+}
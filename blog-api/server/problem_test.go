@@ -0,0 +1,105 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"rakia.ai/blog-api/v2/internal"
+)
+
+// responseCode returns the code of the problem in the response
+func responseCode(rr *httptest.ResponseRecorder) string {
+	var problem Problem
+	json.Unmarshal(rr.Body.Bytes(), &problem)
+	return problem.Code
+}
+
+func TestProblemResponses(t *testing.T) {
+	invalid := internal.Post{Title: "", Content: "short", Author: "Author 1"}
+	mockPostsService := new(MockPostsService)
+	mockPostsService.On("CreatePosts", invalid, "Author 1").Return(nil, internal.ValidationErrors{internal.ErrTitleEmpty, internal.ErrContentInvalid})
+	mockPostsService.On("CreatePosts", internal.Post{Title: "", Content: "short"}, "Author 1").Return(nil, internal.ValidationErrors{internal.ErrAuthorEmpty})
+	mockPostsService.On("GetPostByID", 9).Return((*internal.Post)(nil), internal.ErrPostNotFound)
+
+	server := NewServer(mux.NewRouter(), mockPostsService, nil, &logger)
+	server.Routes()
+	token, _ := createToken("Author 1", "", time.Minute)
+
+	request := func(method string, path string, body string) (*httptest.ResponseRecorder, Problem) {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		server.Router.ServeHTTP(rr, req)
+		var problem Problem
+		json.Unmarshal(rr.Body.Bytes(), &problem)
+		return rr, problem
+	}
+
+	// Every invalid field is listed with its code
+	rr, problem := request("POST", "/api/posts", `{"title":"","content":"short","author":"Author 1"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "application/problem+json", rr.Header().Get("Content-Type"))
+	assert.Equal(t, "validation_failed", problem.Code)
+	assert.Equal(t, http.StatusBadRequest, problem.Status)
+	assert.Equal(t, []FieldError{
+		{Field: "title", Code: "title_empty", Detail: internal.ErrTitleEmpty.Message},
+		{Field: "content", Code: "content_invalid", Detail: internal.ErrContentInvalid.Message},
+	}, problem.Errors)
+
+	// Errors of the domain carry their status and code
+	rr, problem = request("GET", "/api/posts/9", "")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Equal(t, Problem{Type: "about:blank", Title: "Not Found", Status: 404, Detail: "post not found", Code: "post_not_found"}, problem)
+
+	// Posts for another author are refused with their own code, an empty author is left to the validation
+	rr, problem = request("POST", "/api/posts", `{"title":"","content":"short","author":"Author 2"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "author_mismatch", problem.Code)
+	assert.Equal(t, []FieldError{{Field: "author", Code: "author_mismatch", Detail: internal.ErrAuthorMismatch.Message}}, problem.Errors)
+	rr, problem = request("PUT", "/api/posts/1", `{"title":"","content":"short","author":"Author 2"}`)
+	assert.Equal(t, "author_mismatch", problem.Code)
+	rr, problem = request("POST", "/api/posts", `{"title":"","content":"short","author":""}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, []FieldError{{Field: "author", Code: "author_empty", Detail: internal.ErrAuthorEmpty.Message}}, problem.Errors)
+
+	// Errors found by the handlers get a code of their status
+	rr, problem = request("POST", "/api/posts", "not json")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "bad_request", problem.Code)
+	assert.Equal(t, "invalid request payload", problem.Detail)
+
+	// Requests for unknown routes are problems too
+	rr, problem = request("GET", "/unknown", "")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Equal(t, "not_found", problem.Code)
+}
+
+func TestWriteError(t *testing.T) {
+	// Other errors do not leak their message
+	rr := httptest.NewRecorder()
+	writeError(rr, fmt.Errorf("disk on fire"), "error saving post")
+	var problem Problem
+	json.Unmarshal(rr.Body.Bytes(), &problem)
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.Equal(t, "internal_error", problem.Code)
+	assert.Equal(t, "error saving post", problem.Detail)
+
+	// Wrapped errors are found
+	rr = httptest.NewRecorder()
+	writeError(rr, fmt.Errorf("saving: %w", internal.ErrTagsInvalid), "error saving post")
+	json.Unmarshal(rr.Body.Bytes(), &problem)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, []FieldError{{Field: "tags", Code: "tags_invalid", Detail: internal.ErrTagsInvalid.Message}}, problem.Errors)
+
+	rr = httptest.NewRecorder()
+	writeError(rr, &internal.LockoutError{RetryAfter: time.Second * 90}, "")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "90", rr.Header().Get("Retry-After"))
+}
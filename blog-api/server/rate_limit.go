@@ -0,0 +1,114 @@
+package server
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// allowRequest counts the request of the key against the limiter and sets the RateLimit headers. When the limit is
+// reached it answers 429 and returns false, when the limiter fails the request is let through
+func allowRequest(w http.ResponseWriter, r *http.Request, limiter RateLimiter, key string) bool {
+	limit, err := limiter.Allow(key)
+	if err != nil {
+		zerolog.Ctx(r.Context()).Error().Err(err).Msg("error checking rate limit")
+		return true
+	}
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(limit.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(seconds(limit.Reset)))
+	if !limit.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(seconds(limit.RetryAfter)))
+		writeJSONError(w, "too many requests, try again later", http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+// seconds rounds the duration up to whole seconds, so clients do not retry too early
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// rateLimitKey returns the authenticated author of the request, or the IP address of anonymous clients
+func rateLimitKey(r *http.Request) string {
+	if author, ok := r.Context().Value(ContextAuthor).(string); ok {
+		return "author:" + author
+	}
+	return "ip:" + clientIP(r)
+}
+
+// RateLimitMiddleware limits the requests of each author, or of each IP address before logging in. Requests with
+// one of the skipped methods are not counted. Without a limiter requests are not limited
+func RateLimitMiddleware(limiter RateLimiter, skip ...string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if limiter == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, method := range skip {
+				if r.Method == method {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			if !allowRequest(w, r, limiter, rateLimitKey(r)) {
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// trusted reports whether the address is one of the trusted proxies
+func trusted(proxies []*net.IPNet, ip net.IP) bool {
+	for _, network := range proxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor returns the client the trusted proxies forwarded the request for. X-Forwarded-For is read from the
+// right, since only the entries added by trusted proxies can be believed, the first untrusted address is the client.
+// When the walk ends on a trusted proxy, because the hops run out or one cannot be parsed, there is no client
+func forwardedFor(r *http.Request, proxies []*net.IPNet) (string, bool) {
+	peer := net.ParseIP(clientIP(r))
+	if peer == nil || !trusted(proxies, peer) {
+		return "", false
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break
+		}
+		if !trusted(proxies, ip) {
+			return ip.String(), true
+		}
+	}
+	return "", false
+}
+
+// TrustProxies takes the client address from X-Forwarded-For when the request comes from a trusted proxy, so rate
+// limits, login lockouts and logs see the client instead of the proxy
+func TrustProxies(proxies []*net.IPNet) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(proxies) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if client, ok := forwardedFor(r, proxies); ok {
+				r = r.WithContext(r.Context())
+				r.RemoteAddr = client
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"rakia.ai/blog-api/v2/internal"
+)
+
+func TestRateLimitHeaders(t *testing.T) {
+	limiter, _ := internal.NewRateLimiter("public", 2, time.Minute, nil, nil)
+	server := publicTestServer(limiter)
+
+	req, _ := http.NewRequest("GET", "/api/posts/1", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	rr := httptest.NewRecorder()
+	server.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "2", rr.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", rr.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "30", rr.Header().Get("RateLimit-Reset"))
+
+	rr = httptest.NewRecorder()
+	server.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "0", rr.Header().Get("RateLimit-Remaining"))
+
+	rr = httptest.NewRecorder()
+	server.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "0", rr.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "30", rr.Header().Get("Retry-After"))
+}
+
+func TestWriteRateLimit(t *testing.T) {
+	mockPostsService := new(MockPostsService)
+	mockPostsService.On("DeletePosts", 1, "Author 1").Return(&internal.Post{ID: 1, Author: "Author 1"}, nil)
+	mockPostsService.On("DeletePosts", 1, "Author 2").Return(&internal.Post{ID: 1, Author: "Author 1"}, nil)
+	mockPostsService.On("GetPostByID", 1).Return(&internal.Post{ID: 1, Author: "Author 1", Status: internal.PostPublished}, nil)
+
+	server := NewServer(mux.NewRouter(), mockPostsService, nil, &logger)
+	server.WriteLimiter, _ = internal.NewRateLimiter("write", 1, time.Minute, nil, nil)
+	server.Routes()
+
+	assert.Equal(t, http.StatusAccepted, authorRequest(server.Router, "DELETE", "/api/posts/1", "", "Author 1").Code)
+	rr := authorRequest(server.Router, "DELETE", "/api/posts/1", "", "Author 1")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+
+	// Every author has their own limit, and reads are not counted
+	assert.Equal(t, http.StatusAccepted, authorRequest(server.Router, "DELETE", "/api/posts/1", "", "Author 2").Code)
+	assert.Equal(t, http.StatusOK, authorRequest(server.Router, "GET", "/api/posts/1", "", "Author 1").Code)
+}
+
+func TestLoginRateLimit(t *testing.T) {
+	server := NewServer(mux.NewRouter(), new(MockPostsService), nil, &logger)
+	server.LoginLimiter, _ = internal.NewRateLimiter("login", 1, time.Minute, nil, nil)
+	server.TrustedProxies, _ = internal.ParseTrustedProxies("10.0.0.0/8")
+	server.Routes()
+
+	login := func(remoteAddr string, forwardedFor string) int {
+		req, _ := http.NewRequest("POST", "/login", bytes.NewBufferString("invalid"))
+		req.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		rr := httptest.NewRecorder()
+		server.Router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// Clients behind the trusted proxy are limited by their own address
+	assert.Equal(t, http.StatusBadRequest, login("10.0.0.1:1234", "203.0.113.1"))
+	assert.Equal(t, http.StatusTooManyRequests, login("10.0.0.2:1234", "203.0.113.1"))
+	assert.Equal(t, http.StatusBadRequest, login("10.0.0.1:1234", "203.0.113.2"))
+
+	// The header of an untrusted client is ignored
+	assert.Equal(t, http.StatusBadRequest, login("198.51.100.1:1234", "203.0.113.3"))
+	assert.Equal(t, http.StatusTooManyRequests, login("198.51.100.1:1234", "203.0.113.4"))
+}
+
+func TestForwardedFor(t *testing.T) {
+	proxies, err := internal.ParseTrustedProxies("10.0.0.0/8, 192.0.2.1, ::1")
+	assert.NoError(t, err)
+
+	tests := []struct {
+		remoteAddr   string
+		forwardedFor []string
+		client       string
+	}{
+		// Requests from untrusted addresses keep their address
+		{"198.51.100.1:1234", []string{"203.0.113.1"}, ""},
+		{"10.0.0.1:1234", nil, ""},
+		{"10.0.0.1:1234", []string{"203.0.113.1"}, "203.0.113.1"},
+		{"[::1]:1234", []string{"203.0.113.1"}, "203.0.113.1"},
+		// Addresses the client sent itself are skipped, the trusted proxies are walked from the right
+		{"10.0.0.1:1234", []string{"1.2.3.4, 203.0.113.1, 192.0.2.1"}, "203.0.113.1"},
+		{"10.0.0.1:1234", []string{"1.2.3.4", "203.0.113.1, 10.1.1.1"}, "203.0.113.1"},
+		{"10.0.0.1:1234", []string{"garbage, 203.0.113.1"}, "203.0.113.1"},
+		{"10.0.0.1:1234", []string{"garbage"}, ""},
+		// A walk that ends on a trusted proxy has no client
+		{"10.0.0.1:1234", []string{"10.2.2.2, 10.1.1.1"}, ""},
+		{"10.0.0.1:1234", []string{"203.0.113.1, garbage, 10.1.1.1"}, ""},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = test.remoteAddr
+		for _, value := range test.forwardedFor {
+			req.Header.Add("X-Forwarded-For", value)
+		}
+		client, ok := forwardedFor(req, proxies)
+		assert.Equal(t, test.client, client, test.forwardedFor)
+		assert.Equal(t, test.client != "", ok)
+	}
+}
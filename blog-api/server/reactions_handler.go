@@ -0,0 +1,66 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type Reaction struct {
+	Type string `json:"type"`
+}
+
+type ReactionsResponse struct {
+	Reactions map[string]int `json:"reactions"`
+}
+
+// ReactHandler adds a reaction of the author to a post and returns the reaction counts
+func (s *Server) ReactHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		author, post, ok := s.postContext(w, r)
+		if !ok {
+			return
+		}
+
+		var reaction Reaction
+		err := json.NewDecoder(r.Body).Decode(&reaction)
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("invalid request payload")
+			writeJSONError(w, "invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		if err := s.ReactionsService.React(post.ID, author, reaction.Type); err != nil {
+			s.log(r).Error().Err(err).Msg("error adding reaction")
+			writeError(w, err, "error adding reaction")
+			return
+		}
+
+		writeJSON(w, ReactionsResponse{Reactions: s.ReactionsService.Counts(post.ID)}, http.StatusCreated)
+	}
+}
+
+// UnreactHandler removes a reaction of the author from a post and returns the reaction counts
+func (s *Server) UnreactHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		author, post, ok := s.postContext(w, r)
+		if !ok {
+			return
+		}
+
+		var reaction Reaction
+		err := json.NewDecoder(r.Body).Decode(&reaction)
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("invalid request payload")
+			writeJSONError(w, "invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		if err := s.ReactionsService.Unreact(post.ID, author, reaction.Type); err != nil {
+			s.log(r).Error().Err(err).Msg("error removing reaction")
+			writeError(w, err, "error removing reaction")
+			return
+		}
+
+		writeJSON(w, ReactionsResponse{Reactions: s.ReactionsService.Counts(post.ID)}, http.StatusAccepted)
+	}
+}
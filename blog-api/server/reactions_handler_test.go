@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"rakia.ai/blog-api/v2/internal"
+)
+
+func TestReactionHandlers(t *testing.T) {
+	post := &internal.Post{ID: 1, Title: "First", Author: "Author 1"}
+	mockPostsService := new(MockPostsService)
+	mockPostsService.On("GetPostByID", 1).Return(post, nil)
+
+	reactions, _ := internal.NewReactionService(&logger)
+	server := &Server{PostsService: mockPostsService, ReactionsService: reactions, Logger: &logger}
+	vars := map[string]string{"id": "1"}
+
+	rr := httptest.NewRecorder()
+	server.ReactHandler().ServeHTTP(rr, commentRequest("POST", `{"type":"like"}`, "Author 2", vars))
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.JSONEq(t, `{"reactions":{"like":1}}`, rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	server.ReactHandler().ServeHTTP(rr, commentRequest("POST", `{"type":"like"}`, "Author 2", vars))
+	assert.Equal(t, http.StatusConflict, rr.Code)
+
+	rr = httptest.NewRecorder()
+	server.ReactHandler().ServeHTTP(rr, commentRequest("POST", `{"type":"angry"}`, "Author 2", vars))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = httptest.NewRecorder()
+	server.UnreactHandler().ServeHTTP(rr, commentRequest("DELETE", `{"type":"like"}`, "Author 2", vars))
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+	assert.JSONEq(t, `{"reactions":{}}`, rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	server.UnreactHandler().ServeHTTP(rr, commentRequest("DELETE", `{"type":"like"}`, "Author 2", vars))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestGetPostsHandlerEngagement(t *testing.T) {
+	post := &internal.Post{ID: 1, Title: "First", Author: "Author 1"}
+	mockPostsService := new(MockPostsService)
+	mockPostsService.On("GetPostByID", 1).Return(post, nil)
+	mockPostsService.On("Views").Return(map[int]int64{1: 41}, nil)
+
+	reactions, _ := internal.NewReactionService(&logger)
+	reactions.React(1, "Author 2", internal.ReactionLove)
+	views, _ := internal.NewViewCounter(context.Background(), mockPostsService, &logger)
+	server := &Server{PostsService: mockPostsService, ReactionsService: reactions, ViewCounter: views, Logger: &logger}
+
+	rr := httptest.NewRecorder()
+	server.GetPostsHandler().ServeHTTP(rr, commentRequest("GET", "", "Author 2", map[string]string{"id": "1"}))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response PostResponse
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	assert.Equal(t, map[string]int{internal.ReactionLove: 1}, response.Reactions)
+	assert.Equal(t, int64(42), response.Views)
+}
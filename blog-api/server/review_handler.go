@@ -0,0 +1,120 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"rakia.ai/blog-api/v2/internal"
+)
+
+type ReviewDecision struct {
+	Comment string `json:"comment"`
+}
+
+// reviewPostID gets the post ID from the URL, it writes the error when it is invalid
+func (s *Server) reviewPostID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	postID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		s.log(r).Error().Err(err).Msg("invalid post id")
+		writeJSONError(w, "invalid post id", http.StatusBadRequest)
+		return 0, false
+	}
+	return postID, true
+}
+
+// SubmitPostHandler submits a draft of the author for review
+func (s *Server) SubmitPostHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		author, _ := r.Context().Value(ContextAuthor).(string)
+		postID, ok := s.reviewPostID(w, r)
+		if !ok {
+			return
+		}
+
+		change, err := s.ReviewService.SubmitPost(r.Context(), postID, author)
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("error submitting post")
+			writeError(w, err, "error submitting post")
+			return
+		}
+		s.auditChange(r, author, internal.AuditPostSubmit, change)
+
+		writeJSON(w, change.After, http.StatusAccepted)
+	}
+}
+
+// ReviewPostHandler approves or rejects a post in review, only editors and admin
+func (s *Server) ReviewPostHandler(approve bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reviewer, _ := r.Context().Value(ContextAuthor).(string)
+		postID, ok := s.reviewPostID(w, r)
+		if !ok {
+			return
+		}
+
+		var decision ReviewDecision
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&decision); err != nil {
+				s.log(r).Error().Err(err).Msg("invalid request payload")
+				writeJSONError(w, "invalid request payload", http.StatusBadRequest)
+				return
+			}
+		}
+
+		review, action := s.ReviewService.RejectPost, internal.AuditPostReject
+		if approve {
+			review, action = s.ReviewService.ApprovePost, internal.AuditPostApprove
+		}
+		change, err := review(r.Context(), postID, reviewer, decision.Comment)
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("error reviewing post")
+			writeError(w, err, "error reviewing post")
+			return
+		}
+		s.auditChange(r, reviewer, action, change)
+
+		writeJSON(w, change.After, http.StatusAccepted)
+	}
+}
+
+// GetTransitionsHandler lists the status changes of a post, for its author, editors and admin
+func (s *Server) GetTransitionsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		author, _ := r.Context().Value(ContextAuthor).(string)
+		postID, ok := s.reviewPostID(w, r)
+		if !ok {
+			return
+		}
+
+		post, err := s.PostsService.GetPostByID(r.Context(), postID)
+		if err != nil || (post.Author != author && !s.AuthorsService.IsEditor(author)) {
+			writeJSONError(w, "post not found", http.StatusNotFound)
+			return
+		}
+
+		transitions, err := s.ReviewService.Transitions(r.Context(), postID)
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("error getting transitions")
+			writeError(w, err, "error getting transitions")
+			return
+		}
+
+		writeJSON(w, transitions, http.StatusOK)
+	}
+}
+
+// GetReviewQueueHandler lists the posts waiting for review, the longest waiting first
+func (s *Server) GetReviewQueueHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		queue, err := s.ReviewService.ReviewQueue(r.Context())
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("error getting review queue")
+			writeJSONError(w, "error getting review queue", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, queue, http.StatusOK)
+	}
+}
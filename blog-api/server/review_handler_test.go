@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"rakia.ai/blog-api/v2/internal"
+)
+
+func TestReviewHandlers(t *testing.T) {
+	ctx := context.Background()
+	p := map[string]map[int]internal.Post{"Author 1": {}, "Author 2": {}}
+	posts, _ := internal.NewPostsService(&p, &logger)
+	authors := &MockAuthorService{profiles: map[string]*internal.AuthorProfile{
+		"Author 1": {Name: "Author 1", Role: internal.RoleContributor},
+		"Author 2": {Name: "Author 2", Role: internal.RoleAuthor},
+	}}
+	posts.RequireReview(func(author string) bool { return authors.profiles[author].Role == internal.RoleContributor })
+	content := strings.Repeat("Lorem ipsum dolor sit amet. ", 5)
+	_, err := posts.CreatePosts(ctx, internal.Post{Title: "Title 1", Content: content, Author: "Author 1"}, "Author 1")
+	assert.NoError(t, err)
+
+	server := NewServer(mux.NewRouter(), posts, authors, &logger)
+	server.ReviewService = posts
+	server.Routes()
+
+	rr := authorRequest(server.Router, "POST", "/api/posts/1/submit", "", "Author 2")
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	rr = authorRequest(server.Router, "POST", "/api/posts/1/submit", "", "Author 1")
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"status":"in_review"`)
+	rr = authorRequest(server.Router, "POST", "/api/posts/1/submit", "", "Author 1")
+	assert.Equal(t, http.StatusConflict, rr.Code)
+
+	// Only editors review
+	rr = authorRequest(server.Router, "GET", "/api/reviews", "", "Author 2")
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	rr = authorRequest(server.Router, "PUT", "/api/authors/Author 2/role", `{"role":"editor"}`, "Author 2")
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	rr = authorRequest(server.Router, "PUT", "/api/authors/Author 2/role", `{"role":"editor"}`, "admin")
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+
+	rr = authorRequest(server.Router, "GET", "/api/reviews", "", "Author 2")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var queue []internal.Submission
+	json.Unmarshal(rr.Body.Bytes(), &queue)
+	assert.Len(t, queue, 1)
+	assert.Equal(t, 1, queue[0].Post.ID)
+
+	rr = authorRequest(server.Router, "POST", "/api/posts/1/reject", "", "Author 2")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	rr = authorRequest(server.Router, "POST", "/api/posts/1/reject", `{"comment":"Needs a better title"}`, "Author 2")
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"status":"draft"`)
+
+	// The author sees why the post was rejected
+	rr = authorRequest(server.Router, "GET", "/api/posts/1/transitions", "", "Author 1")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var transitions []internal.Transition
+	json.Unmarshal(rr.Body.Bytes(), &transitions)
+	assert.Len(t, transitions, 3)
+	assert.Equal(t, "Needs a better title", transitions[2].Comment)
+	rr = authorRequest(server.Router, "GET", "/api/posts/1/transitions", "", "Author 3")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	authorRequest(server.Router, "POST", "/api/posts/1/submit", "", "Author 1")
+	rr = authorRequest(server.Router, "POST", "/api/posts/1/approve", "", "Author 2")
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"status":"published"`)
+}
@@ -1,45 +1,180 @@
 package server
 
 import (
-	"encoding/json"
+	"context"
+	"io"
+	"net"
 	"net/http"
-	"os"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/rs/zerolog"
 	"rakia.ai/blog-api/v2/internal"
 )
 
-func writeJSONError(w http.ResponseWriter, message string, statusCode int) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(statusCode)
-	json.NewEncoder(w).Encode(map[string]string{"error": message})
-}
-
 type PostsService interface {
-	CreatePosts(post internal.Post, author string) error
-	GetAllPosts() ([]*internal.Post, error)
-	UpdatePosts(post internal.Post, author string) error
-	GetPostByID(id int) (*internal.Post, error)
-	DeletePosts(id int, author string) error
+	CreatePosts(ctx context.Context, post internal.Post, author string) (*internal.Post, error)
+	GetAllPosts(ctx context.Context) ([]*internal.Post, error)
+	UpdatePosts(ctx context.Context, post internal.Post, author string) (*internal.PostChange, error)
+	GetPostByID(ctx context.Context, id int) (*internal.Post, error)
+	DeletePosts(ctx context.Context, id int, author string) (*internal.Post, error)
+	ReassignPosts(ctx context.Context, from string, to string) ([]internal.PostChange, error)
+	DeleteAuthorPosts(ctx context.Context, author string) ([]internal.Post, error)
 }
 
 type AuthorsService interface {
 	ValidAuthor(username string, password string) (bool, error)
+	ListAuthors() ([]*internal.AuthorProfile, error)
+	GetAuthor(name string) (*internal.AuthorProfile, error)
+	UpdateProfile(name string, profile internal.AuthorProfile) (*internal.AuthorProfile, error)
+	SetAuthorDisabled(name string, disabled bool) error
+	SetAuthorRole(name string, role string) error
+	IsEditor(name string) bool
+	CheckCredentials(name string, issuedAt time.Time) error
+	DeleteAuthor(name string, removePosts func() error) error
+	ChangePassword(name string, oldPassword string, newPassword string) error
 }
 
-type Server struct {
-	Router         *mux.Router
-	PostsService   PostsService
-	AuthorsService AuthorsService
-	Logger         *zerolog.Logger
+type PasswordResetService interface {
+	RequestReset(ctx context.Context, author string) error
+	ResetPassword(token string, password string) error
+}
+
+type TokensService interface {
+	CreateToken(author string, name string, scopes []string, expiresAt *time.Time) (*internal.Token, string, error)
+	ListTokens(author string) ([]*internal.Token, error)
+	RevokeToken(id string, author string) error
+	ValidateToken(raw string) (*internal.Token, error)
+	RevokeAll(author string) int
+}
+
+type LoginGuard interface {
+	Check(author string, ip string) error
+	RecordFailure(author string, ip string)
+	RecordSuccess(author string, ip string)
+	Unlock(author string, ip string)
+}
+
+type TwoFactorService interface {
+	Enroll(author string) (*internal.TwoFactorEnrollment, error)
+	Confirm(author string, code string) ([]string, error)
+	Enabled(author string) bool
+	Verify(author string, code string) error
+	Disable(author string, code string) error
+	Reset(author string)
+}
+
+type CommentsService interface {
+	CreateComment(post internal.Post, parentID int, author string, body string) (*internal.Comment, error)
+	ListComments(post internal.Post, viewer string) ([]*internal.Comment, error)
+	UpdateComment(post internal.Post, id int, author string, body string) (*internal.Comment, error)
+	DeleteComment(post internal.Post, id int, author string) error
+	ModerateComment(post internal.Post, id int, moderator string, status string) (*internal.Comment, error)
+}
+
+type ReactionsService interface {
+	React(postID int, author string, reaction string) error
+	Unreact(postID int, author string, reaction string) error
+	Counts(postID int) map[string]int
+}
+
+type ViewCounter interface {
+	Increment(postID int)
+	Views(postID int) int64
 }
 
-func NewLogger() *zerolog.Logger {
-	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr}).With().Timestamp().Logger()
-	return &logger
+type RateLimiter interface {
+	Allow(key string) (internal.RateLimit, error)
 }
 
+type EventBus interface {
+	Subscribe(subscriber string, lastEventID int64, filter func(internal.Event) bool) (*internal.Subscription, []internal.Event)
+	Unsubscribe(sub *internal.Subscription)
+}
+
+type WebhooksService interface {
+	CreateWebhook(url string, events []string) (*internal.Webhook, error)
+	ListWebhooks() ([]*internal.Webhook, error)
+	DeleteWebhook(id string) error
+	ListDeliveries(webhookID string) ([]*internal.Delivery, error)
+	Redeliver(webhookID string, deliveryID string) (*internal.Delivery, error)
+}
+
+type AuditLog interface {
+	Record(entry internal.AuditEntry) error
+	Query(filter internal.AuditFilter) ([]internal.AuditEntry, error)
+}
+
+type ReviewService interface {
+	SubmitPost(ctx context.Context, id int, author string) (*internal.PostChange, error)
+	ApprovePost(ctx context.Context, id int, reviewer string, comment string) (*internal.PostChange, error)
+	RejectPost(ctx context.Context, id int, reviewer string, comment string) (*internal.PostChange, error)
+	Transitions(ctx context.Context, id int) ([]internal.Transition, error)
+	ReviewQueue(ctx context.Context) ([]*internal.Submission, error)
+}
+
+// ChangeTracker tells when the posts last changed, deleted posts included
+type ChangeTracker interface {
+	LastChange(ctx context.Context) time.Time
+}
+
+type LockService interface {
+	Acquire(postID int, author string) (*internal.EditLock, error)
+	Renew(postID int, author string) (*internal.EditLock, error)
+	Release(postID int, author string) error
+	Break(postID int, author string) error
+	Get(postID int) (*internal.EditLock, error)
+}
+
+type MediaService interface {
+	Upload(r io.Reader, uploader string) (*internal.Media, bool, error)
+	Open(hash string) (*internal.Media, io.ReadSeekCloser, error)
+	OpenVariant(hash string, width int) (*internal.Variant, io.ReadSeekCloser, error)
+	Get(hash string) (*internal.Media, error)
+	MaxSize() int64
+}
+
+// HealthChecker is implemented by subsystems which can be unable to serve requests, e.g. when their storage is gone
+type HealthChecker interface {
+	CheckHealth(ctx context.Context) error
+}
+
+type Server struct {
+	Router           *mux.Router
+	PostsService     PostsService
+	AuthorsService   AuthorsService
+	TokensService    TokensService
+	LoginGuard       LoginGuard
+	TwoFactorService TwoFactorService
+	PasswordResets   PasswordResetService
+	CommentsService  CommentsService
+	ReactionsService ReactionsService
+	ViewCounter      ViewCounter
+	Authenticators   map[string]Authenticator
+	PasswordAuth     Authenticator
+	BaseURL          string       // Public URL of the server used for links in feeds and to media
+	Development      bool         // Takes the base URL from the request when BaseURL is empty
+	PublicLimiter    RateLimiter  // Anonymous reads of the public API, per IP address
+	LoginLimiter     RateLimiter  // Logins and password resets, per IP address
+	WriteLimiter     RateLimiter  // Changes through the API, per author
+	TrustedProxies   []*net.IPNet // Reverse proxies whose X-Forwarded-For header is believed
+	CORS             *CORS
+	Events           EventBus
+	WebhooksService  WebhooksService
+	AuditLog         AuditLog
+	ReviewService    ReviewService
+	Changes          ChangeTracker
+	Locks            LockService
+	MediaService     MediaService
+	Metrics          *Metrics
+	Health           *Health
+	Version          BuildInfo
+	Logger           *zerolog.Logger
+}
+
+// How long anonymous readers may cache public responses
+const publicMaxAge = time.Minute
+
 func NewServer(router *mux.Router, blogs PostsService, authors AuthorsService, logger *zerolog.Logger) *Server {
 	return &Server{
 		Router:         router,
@@ -51,23 +186,220 @@ func NewServer(router *mux.Router, blogs PostsService, authors AuthorsService, l
 
 func (s *Server) Routes() {
 
+	// Request IDs, request loggers and the access log, requests which match no route are logged too
+	// The client address is taken from the trusted proxies first, so everything after sees the client
+	trustProxies := TrustProxies(s.TrustedProxies)
+	accessLog := AccessLog(s.Logger)
+	s.Router.Use(trustProxies, accessLog)
+	s.Router.NotFoundHandler = trustProxies(accessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSONError(w, "not found", http.StatusNotFound)
+	})))
+
+	// Browsers calling the API from other origins, preflights are answered for every path with routes
+	if s.CORS != nil {
+		s.Router.Use(s.CORS.Middleware)
+		s.Router.Methods("OPTIONS").Name(preflightRoute).HandlerFunc(s.PreflightHandler())
+	}
+
+	// Prometheus metrics of every request
+	if s.Metrics != nil {
+		s.Router.Use(s.Metrics.Middleware)
+		s.Router.Handle("/metrics", s.Metrics.Handler()).Methods("GET")
+	}
+
+	// Probes of the orchestrator and the build of the binary
+	s.Router.HandleFunc("/healthz", s.LivenessHandler()).Methods("GET")
+	if s.Health != nil {
+		s.Router.HandleFunc("/readyz", s.ReadinessHandler()).Methods("GET")
+	}
+	s.Router.HandleFunc("/version", s.VersionHandler()).Methods("GET")
+
+	// Logins and password resets are limited per IP address, against guessing passwords and codes
+	limitLogins := RateLimitMiddleware(s.LoginLimiter)
+
 	// Login Author and get a JWT
-	s.Router.HandleFunc("/login", s.LoginHandler()).Methods("POST")
+	s.Router.Handle("/login", limitLogins(s.LoginHandler())).Methods("POST")
+	// Exchange the two-factor challenge and a code for a JWT
+	if s.TwoFactorService != nil {
+		s.Router.Handle("/login/2fa", limitLogins(s.TwoFactorLoginHandler())).Methods("POST")
+	}
+
+	// Log in through other authenticators such as an OpenID Connect identity provider
+	for name, authenticator := range s.Authenticators {
+		if redirector, ok := authenticator.(Redirector); ok {
+			s.Router.HandleFunc("/login/"+name, redirector.Redirect).Methods("GET")
+		}
+		s.Router.Handle("/login/"+name+"/callback", limitLogins(s.ExternalLoginHandler(name, authenticator))).Methods("GET")
+	}
+
+	// Forgotten passwords are reset with a token sent by mail
+	if s.PasswordResets != nil {
+		s.Router.Handle("/password/forgot", limitLogins(s.ForgotPasswordHandler())).Methods("POST")
+		s.Router.Handle("/password/reset", limitLogins(s.ResetPasswordHandler())).Methods("POST")
+	}
+
+	// Public feeds of all posts, of the posts of an author and of the posts with a tag, limited per IP address
+	// like the other anonymous reads
+	limitPublic := RateLimitMiddleware(s.PublicLimiter)
+	s.Router.Handle("/feed.xml", limitPublic(s.FeedHandler(FeedRSS))).Methods("GET")
+	s.Router.Handle("/atom.xml", limitPublic(s.FeedHandler(FeedAtom))).Methods("GET")
+	s.Router.Handle("/authors/{name}/feed.xml", limitPublic(s.FeedHandler(FeedRSS))).Methods("GET")
+	s.Router.Handle("/authors/{name}/atom.xml", limitPublic(s.FeedHandler(FeedAtom))).Methods("GET")
+	s.Router.Handle("/tags/{tag}/feed.xml", limitPublic(s.FeedHandler(FeedRSS))).Methods("GET")
+	s.Router.Handle("/tags/{tag}/atom.xml", limitPublic(s.FeedHandler(FeedAtom))).Methods("GET")
+
+	// Uploaded media, public since it is addressed by the hash of its content
+	if s.MediaService != nil {
+		s.Router.HandleFunc("/media/{hash:[0-9a-f]{64}}", s.GetMediaHandler()).Methods("GET", "HEAD")
+		s.Router.HandleFunc("/media/{hash:[0-9a-f]{64}}/{width:[0-9]{1,5}}", s.GetMediaVariantHandler()).Methods("GET", "HEAD")
+	}
+
+	// Public read-only routes, anonymous readers only see published posts. When a JWT or personal access
+	// token is sent the author also sees their drafts. Routes which do not match here fall through to the
+	// authenticated routes below
+	public := s.Router.PathPrefix("/api").Methods("GET").Subrouter()
+	public.Use(OptionalMiddleware(s.TokensService, s.AuthorsService), PublicMiddleware(s.PublicLimiter, publicMaxAge))
+
+	// Get one post
+	public.HandleFunc("/posts/{id}", readScope(internal.ScopePostsRead, s.GetPostsHandler()))
+	// Get all posts
+	public.HandleFunc("/posts", readScope(internal.ScopePostsRead, s.GetAllPostsHandler()))
+	// Get the comments of a post
+	if s.CommentsService != nil {
+		public.HandleFunc("/posts/{id}/comments", readScope(internal.ScopePostsRead, s.GetCommentsHandler()))
+	}
+	// Get the metadata and thumbnails of media
+	if s.MediaService != nil {
+		public.HandleFunc("/media/{hash:[0-9a-f]{64}}", s.GetMediaInfoHandler())
+	}
 
 	api := s.Router.PathPrefix("/api").Subrouter()
 
-	// Authenticated routes
-	api.Use(Middleware(*s.Logger))
+	// Authenticated routes, with either a JWT or a personal access token. Changes are limited per author
+	api.Use(Middleware(s.TokensService, s.AuthorsService), RateLimitMiddleware(s.WriteLimiter, "GET", "HEAD"))
 
 	// Create a new post for an author
-	api.HandleFunc("/posts", s.CreatePostsHandler()).Methods("POST")
-	// Get one post for an author
-	api.HandleFunc("/posts/{id}", s.GetPostsHandler()).Methods("GET")
-	// Get all posts for an author
-	api.HandleFunc("/posts", s.GetAllPostsHandler()).Methods("GET")
+	api.HandleFunc("/posts", requireScope(internal.ScopePostsWrite, s.CreatePostsHandler())).Methods("POST")
 	// Update a post for an author
-	api.HandleFunc("/posts/{id}", s.UpdatePostsHandler()).Methods("PUT")
+	api.HandleFunc("/posts/{id}", requireScope(internal.ScopePostsWrite, s.UpdatePostsHandler())).Methods("PUT")
 	// Delete a post for an author
-	api.HandleFunc("/posts/{id}", s.DeletePostsHandler()).Methods("DELETE")
+	api.HandleFunc("/posts/{id}", requireScope(internal.ScopePostsDelete, s.DeletePostsHandler())).Methods("DELETE")
+
+	// Comments on posts, post authors and admin moderate them
+	if s.CommentsService != nil {
+		// Comment on a post or reply to a comment
+		api.HandleFunc("/posts/{id}/comments", requireScope(internal.ScopePostsWrite, s.CreateCommentHandler())).Methods("POST")
+		// Edit a comment of the author
+		api.HandleFunc("/posts/{id}/comments/{commentID}", requireScope(internal.ScopePostsWrite, s.UpdateCommentHandler())).Methods("PUT")
+		// Delete a comment and its replies
+		api.HandleFunc("/posts/{id}/comments/{commentID}", requireScope(internal.ScopePostsDelete, s.DeleteCommentHandler())).Methods("DELETE")
+		// Approve or reject a comment
+		api.HandleFunc("/posts/{id}/comments/{commentID}/moderation", requireScope(internal.ScopePostsWrite, s.ModerateCommentHandler())).Methods("POST")
+	}
+
+	// Reactions on posts
+	if s.ReactionsService != nil {
+		// React to a post
+		api.HandleFunc("/posts/{id}/reactions", requireScope(internal.ScopePostsWrite, s.ReactHandler())).Methods("POST")
+		// Remove a reaction from a post
+		api.HandleFunc("/posts/{id}/reactions", requireScope(internal.ScopePostsWrite, s.UnreactHandler())).Methods("DELETE")
+	}
+
+	// Review of posts before they are published, editors and admin approve or reject them
+	if s.ReviewService != nil {
+		// Submit a draft for review
+		api.HandleFunc("/posts/{id}/submit", requireScope(internal.ScopePostsWrite, s.SubmitPostHandler())).Methods("POST")
+		// Publish a post in review
+		api.HandleFunc("/posts/{id}/approve", requireScope(internal.ScopePostsWrite, s.requireEditor(s.ReviewPostHandler(true)))).Methods("POST")
+		// Return a post in review to draft
+		api.HandleFunc("/posts/{id}/reject", requireScope(internal.ScopePostsWrite, s.requireEditor(s.ReviewPostHandler(false)))).Methods("POST")
+		// List the status changes of a post
+		api.HandleFunc("/posts/{id}/transitions", requireScope(internal.ScopePostsRead, s.GetTransitionsHandler())).Methods("GET")
+		// List the posts waiting for review
+		api.HandleFunc("/reviews", requireScope(internal.ScopePostsRead, s.requireEditor(s.GetReviewQueueHandler()))).Methods("GET")
+	}
+
+	// Upload media to reference from posts
+	if s.MediaService != nil {
+		api.HandleFunc("/media", requireScope(internal.ScopePostsWrite, s.UploadMediaHandler())).Methods("POST")
+	}
+
+	// Edit locks, only the holder of the lock of a post can update it
+	if s.Locks != nil {
+		// Lock a post for editing
+		api.HandleFunc("/posts/{id}/lock", requireScope(internal.ScopePostsWrite, s.AcquireLockHandler())).Methods("POST")
+		// Renew the lock while editing
+		api.HandleFunc("/posts/{id}/lock", requireScope(internal.ScopePostsWrite, s.RenewLockHandler())).Methods("PUT")
+		// Release the lock, admin can break it with ?force=true
+		api.HandleFunc("/posts/{id}/lock", requireScope(internal.ScopePostsWrite, s.ReleaseLockHandler())).Methods("DELETE")
+		// Show who holds the lock
+		api.HandleFunc("/posts/{id}/lock", requireScope(internal.ScopePostsRead, s.GetLockHandler())).Methods("GET")
+	}
+
+	// Stream post changes as Server-Sent Events
+	if s.Events != nil {
+		api.HandleFunc("/events", requireScope(internal.ScopePostsRead, s.EventsHandler())).Methods("GET")
+	}
+
+	// List all authors
+	api.HandleFunc("/authors", requireScope(internal.ScopePostsRead, s.GetAuthorsHandler())).Methods("GET")
+	// Update the profile of the logged in author
+	api.HandleFunc("/authors/me", requireSession(s.UpdateProfileHandler())).Methods("PUT")
+	// Change the password of the logged in author
+	api.HandleFunc("/authors/me/password", requireSession(s.ChangePasswordHandler())).Methods("POST")
+	// Get the profile of an author
+	api.HandleFunc("/authors/{name}", requireScope(internal.ScopePostsRead, s.GetAuthorHandler())).Methods("GET")
+	// Disable an author, only admin
+	api.HandleFunc("/authors/{name}/disable", requireSession(s.SetAuthorDisabledHandler(true))).Methods("POST")
+	// Enable a disabled author, only admin
+	api.HandleFunc("/authors/{name}/enable", requireSession(s.SetAuthorDisabledHandler(false))).Methods("POST")
+	// Change the role of an author, only admin
+	api.HandleFunc("/authors/{name}/role", requireSession(requireAdmin(s.SetAuthorRoleHandler()))).Methods("PUT")
+	// Delete an author and reassign or delete their posts, only admin
+	api.HandleFunc("/authors/{name}", requireSession(s.DeleteAuthorHandler())).Methods("DELETE")
+
+	// Personal access tokens can only be managed after logging in with a password
+	if s.TokensService != nil {
+		// Create a new personal access token
+		api.HandleFunc("/tokens", requireSession(s.CreateTokenHandler())).Methods("POST")
+		// List the personal access tokens of the author
+		api.HandleFunc("/tokens", requireSession(s.GetTokensHandler())).Methods("GET")
+		// Revoke a personal access token
+		api.HandleFunc("/tokens/{id}", requireSession(s.RevokeTokenHandler())).Methods("DELETE")
+	}
+
+	// Two-factor authentication can only be managed after logging in with a password
+	if s.TwoFactorService != nil {
+		// Start enrolling an authenticator app
+		api.HandleFunc("/2fa/enroll", requireSession(s.EnrollTwoFactorHandler())).Methods("POST")
+		// Confirm the enrollment with a first code
+		api.HandleFunc("/2fa/confirm", requireSession(s.ConfirmTwoFactorHandler())).Methods("POST")
+		// Turn two-factor authentication off
+		api.HandleFunc("/2fa/disable", requireSession(s.DisableTwoFactorHandler())).Methods("POST")
+	}
+
+	// Webhooks are managed by admin after logging in with a password
+	if s.WebhooksService != nil {
+		// Add a webhook
+		api.HandleFunc("/webhooks", requireSession(requireAdmin(s.CreateWebhookHandler()))).Methods("POST")
+		// List the webhooks
+		api.HandleFunc("/webhooks", requireSession(requireAdmin(s.GetWebhooksHandler()))).Methods("GET")
+		// Delete a webhook
+		api.HandleFunc("/webhooks/{id}", requireSession(requireAdmin(s.DeleteWebhookHandler()))).Methods("DELETE")
+		// List the deliveries of a webhook
+		api.HandleFunc("/webhooks/{id}/deliveries", requireSession(requireAdmin(s.GetDeliveriesHandler()))).Methods("GET")
+		// Send a delivery again
+		api.HandleFunc("/webhooks/{id}/deliveries/{deliveryID}/redeliver", requireSession(requireAdmin(s.RedeliverHandler()))).Methods("POST")
+	}
+
+	// Read the audit log
+	if s.AuditLog != nil {
+		api.HandleFunc("/audit", requireSession(requireAdmin(s.GetAuditHandler()))).Methods("GET")
+	}
+
+	// Lift a lockout caused by too many failed logins
+	if s.LoginGuard != nil {
+		api.HandleFunc("/lockouts/unlock", requireSession(s.UnlockLoginHandler())).Methods("POST")
+	}
 
 }
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"rakia.ai/blog-api/v2/internal"
+)
+
+type TokenCreate struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type TokenCreateResponse struct {
+	*internal.Token
+	Secret string `json:"token"`
+}
+
+// CreateTokenHandler creates a new personal access token for the author
+func (s *Server) CreateTokenHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get the context from the request
+		author, ok := r.Context().Value(ContextAuthor).(string)
+		if !ok {
+			s.log(r).Error().Msg("error getting author from context")
+			writeJSONError(w, ErrInvalidRequest, http.StatusBadRequest)
+			return
+		}
+
+		// Validate incoming token
+		var tokenRequest TokenCreate
+		err := json.NewDecoder(r.Body).Decode(&tokenRequest)
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("invalid request payload")
+			writeJSONError(w, "invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		// Create the token
+		token, raw, err := s.TokensService.CreateToken(author, tokenRequest.Name, tokenRequest.Scopes, tokenRequest.ExpiresAt)
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("error creating token")
+			writeError(w, err, "error creating token")
+			return
+		}
+
+		// JSON encode the token, the secret is only ever shown in this response
+		jsonResponse, err := json.Marshal(TokenCreateResponse{Token: token, Secret: raw})
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("error marshalling token")
+			writeError(w, err, "error creating token")
+			return
+		}
+
+		// Set the content-type header to json
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+
+		// Send the response
+		w.Write(jsonResponse)
+	}
+}
+
+// GetTokensHandler lists the personal access tokens of the author
+func (s *Server) GetTokensHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get the context from the request
+		author, ok := r.Context().Value(ContextAuthor).(string)
+		if !ok {
+			s.log(r).Error().Msg("error getting author from context")
+			writeJSONError(w, ErrInvalidRequest, http.StatusBadRequest)
+			return
+		}
+
+		// Get all tokens for the author
+		tokens, err := s.TokensService.ListTokens(author)
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("error getting tokens")
+			writeError(w, err, "error getting tokens")
+			return
+		}
+
+		// JSON encode the tokens
+		jsonResponse, err := json.Marshal(tokens)
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("error marshalling tokens")
+			writeError(w, err, "error getting tokens")
+			return
+		}
+
+		// Set the content-type header to json
+		w.Header().Set("Content-Type", "application/json")
+
+		// Send the response
+		w.Write(jsonResponse)
+	}
+}
+
+// RevokeTokenHandler revokes a personal access token
+func (s *Server) RevokeTokenHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get the context from the request
+		author, ok := r.Context().Value(ContextAuthor).(string)
+		if !ok {
+			s.log(r).Error().Msg("error getting author from context")
+			writeJSONError(w, ErrInvalidRequest, http.StatusBadRequest)
+			return
+		}
+
+		// Get the token ID from the URL
+		id, ok := mux.Vars(r)["id"]
+		if !ok {
+			s.log(r).Error().Msg("missing token id")
+			writeJSONError(w, "missing token id", http.StatusBadRequest)
+			return
+		}
+
+		// Revoke the token
+		err := s.TokensService.RevokeToken(id, author)
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("error revoking token")
+			writeError(w, err, "error revoking token")
+			return
+		}
+
+		// Status accepted
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
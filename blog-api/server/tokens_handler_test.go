@@ -0,0 +1,123 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"rakia.ai/blog-api/v2/internal"
+)
+
+// MockTokensService is a mock implementation of the TokensService interface
+type MockTokensService struct {
+	mock.Mock
+}
+
+func (m *MockTokensService) CreateToken(author string, name string, scopes []string, expiresAt *time.Time) (*internal.Token, string, error) {
+	args := m.Called(author, name, scopes, expiresAt)
+	return args.Get(0).(*internal.Token), args.String(1), args.Error(2)
+}
+
+func (m *MockTokensService) ListTokens(author string) ([]*internal.Token, error) {
+	args := m.Called(author)
+	return args.Get(0).([]*internal.Token), args.Error(1)
+}
+
+func (m *MockTokensService) RevokeToken(id string, author string) error {
+	args := m.Called(id, author)
+	return args.Error(0)
+}
+
+func (m *MockTokensService) ValidateToken(raw string) (*internal.Token, error) {
+	args := m.Called(raw)
+	return args.Get(0).(*internal.Token), args.Error(1)
+}
+
+func (m *MockTokensService) RevokeAll(author string) int {
+	args := m.Called(author)
+	return args.Int(0)
+}
+
+func TestCreateTokenHandler(t *testing.T) {
+	token := &internal.Token{ID: "abc", Name: "ci", Author: "Author 1", Scopes: []string{internal.ScopePostsWrite}}
+
+	mockTokensService := new(MockTokensService)
+	mockTokensService.On("CreateToken", "Author 1", "ci", []string{internal.ScopePostsWrite}, (*time.Time)(nil)).Return(token, "blog_pat_secret", nil)
+
+	server := &Server{TokensService: mockTokensService, Logger: &logger}
+
+	body := `{"name":"ci","scopes":["posts:write"]}`
+	req, _ := http.NewRequest("POST", "/api/tokens", bytes.NewBufferString(body))
+	ctx := context.WithValue(req.Context(), ContextAuthor, "Author 1")
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	server.CreateTokenHandler().ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusCreated, rr.Code)
+
+	var response map[string]interface{}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, "blog_pat_secret", response["token"])
+	assert.Equal(t, "abc", response["id"])
+}
+
+func TestRevokeTokenForbiddenHandler(t *testing.T) {
+	mockTokensService := new(MockTokensService)
+	mockTokensService.On("RevokeToken", "abc", "Author 2").Return(internal.ErrTokenNotAllowed)
+
+	server := &Server{TokensService: mockTokensService, Logger: &logger}
+
+	req, _ := http.NewRequest("DELETE", "/api/tokens/abc", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "abc"})
+	ctx := context.WithValue(req.Context(), ContextAuthor, "Author 2")
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	server.RevokeTokenHandler().ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Equal(t, "token_not_allowed", responseCode(rr))
+}
+
+func TestPersonalAccessTokenScopes(t *testing.T) {
+	token := &internal.Token{ID: "abc", Name: "ci", Author: "Author 1", Scopes: []string{internal.ScopePostsRead}}
+
+	mockTokensService := new(MockTokensService)
+	mockTokensService.On("ValidateToken", "blog_pat_secret").Return(token, nil)
+
+	mockPostsService := new(MockPostsService)
+	mockPostsService.On("GetAllPosts").Return([]*internal.Post{{ID: 1, Title: "Title 1", Author: "Author 1"}}, nil)
+
+	server := NewServer(mux.NewRouter(), mockPostsService, nil, &logger)
+	server.TokensService = mockTokensService
+	server.Routes()
+
+	// Reading is allowed with the posts:read scope
+	req, _ := http.NewRequest("GET", "/api/posts", nil)
+	req.Header.Set("Authorization", "Bearer blog_pat_secret")
+	rr := httptest.NewRecorder()
+	server.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// Writing needs the posts:write scope
+	req, _ = http.NewRequest("POST", "/api/posts", bytes.NewBufferString(`{}`))
+	req.Header.Set("Authorization", "Bearer blog_pat_secret")
+	rr = httptest.NewRecorder()
+	server.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	// Tokens cannot be used to manage tokens
+	req, _ = http.NewRequest("GET", "/api/tokens", nil)
+	req.Header.Set("Authorization", "Bearer blog_pat_secret")
+	rr = httptest.NewRecorder()
+	server.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"rakia.ai/blog-api/v2/internal"
+)
+
+func TestTracing(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer provider.Shutdown(context.Background())
+
+	p := map[string]map[int]internal.Post{"Author 1": {}}
+	posts, _ := internal.NewPostsService(&p, &logger)
+	content := strings.Repeat("Lorem ipsum dolor sit amet. ", 5)
+	posts.CreatePosts(context.Background(), internal.Post{Title: "Title 1", Content: content, Author: "Author 1"}, "Author 1")
+
+	server := NewServer(mux.NewRouter(), posts, &MockAuthorService{}, &logger)
+	server.ReviewService = posts
+	server.Routes()
+	server.Router.Use(Tracing)
+
+	// The request continues the trace of the caller
+	req, _ := http.NewRequest("GET", "/api/posts/1", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rr := httptest.NewRecorder()
+	server.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	ended := spans.Ended()
+	var router, service sdktrace.ReadOnlySpan
+	for _, span := range ended {
+		switch span.Name() {
+		case "GET /api/posts/{id}":
+			router = span
+		case "PostService.GetPostByID":
+			service = span
+		}
+	}
+	if assert.NotNil(t, router) && assert.NotNil(t, service) {
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", router.SpanContext().TraceID().String())
+		assert.Equal(t, "00f067aa0ba902b7", router.Parent().SpanID().String())
+		assert.Contains(t, router.Attributes(), attribute.Int("http.status_code", http.StatusOK))
+		assert.Equal(t, router.SpanContext().SpanID(), service.Parent().SpanID())
+		assert.Contains(t, service.Attributes(), attribute.Int("post.id", 1))
+	}
+
+	// Failed calls mark their span, published posts cannot be submitted for review
+	rr = authorRequest(server.Router, "POST", "/api/posts/1/submit", "", "Author 1")
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	router, service = nil, nil
+	for _, span := range spans.Ended() {
+		switch span.Name() {
+		case "POST /api/posts/{id}/submit":
+			router = span
+		case "PostService.SubmitPost":
+			service = span
+		}
+	}
+	if assert.NotNil(t, router) && assert.NotNil(t, service) {
+		assert.Equal(t, router.SpanContext().SpanID(), service.Parent().SpanID())
+		assert.Equal(t, codes.Error, service.Status().Code)
+	}
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"rakia.ai/blog-api/v2/internal"
+)
+
+type TwoFactorCode struct {
+	Code string `json:"code"`
+}
+
+type TwoFactorLogin struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// TwoFactorLoginHandler exchanges the challenge token from /login and a code for a JWT
+func (s *Server) TwoFactorLoginHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var login TwoFactorLogin
+
+		// Decode the incoming JSON payload
+		err := json.NewDecoder(r.Body).Decode(&login)
+		if err != nil {
+			writeJSONError(w, "invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		// The challenge must be a two-factor challenge signed by this server which has not expired
+		claims, err := parseToken(login.ChallengeToken)
+		if err != nil || claims.Purpose != purposeTwoFactor {
+			writeJSONError(w, "invalid challenge token", http.StatusUnauthorized)
+			return
+		}
+
+		// Wrong codes count as failed logins
+		if s.lockedOut(w, r, claims.Username) {
+			return
+		}
+
+		if err := s.TwoFactorService.Verify(claims.Username, login.Code); err != nil {
+			s.log(r).Error().Err(err).Msg("invalid two-factor code")
+			// A code which could not be saved is no failed login
+			var domain *internal.Error
+			if !errors.As(err, &domain) {
+				writeError(w, err, "error checking two-factor code")
+				return
+			}
+			s.loginFailed(w, r, claims.Username, internal.ErrTwoFactorCodeInvalid)
+			return
+		}
+
+		s.loginSucceeded(w, r, claims.Username, claims.Login)
+	}
+}
+
+// EnrollTwoFactorHandler starts enrolling an authenticator app for the author
+func (s *Server) EnrollTwoFactorHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get the context from the request
+		author, ok := r.Context().Value(ContextAuthor).(string)
+		if !ok {
+			s.log(r).Error().Msg("error getting author from context")
+			writeJSONError(w, ErrInvalidRequest, http.StatusBadRequest)
+			return
+		}
+
+		enrollment, err := s.TwoFactorService.Enroll(author)
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("error enrolling two-factor authentication")
+			writeError(w, err, "error with two-factor authentication")
+			return
+		}
+
+		writeJSON(w, enrollment, http.StatusCreated)
+	}
+}
+
+// ConfirmTwoFactorHandler confirms the enrollment with a first code and returns the recovery codes
+func (s *Server) ConfirmTwoFactorHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get the context from the request
+		author, ok := r.Context().Value(ContextAuthor).(string)
+		if !ok {
+			s.log(r).Error().Msg("error getting author from context")
+			writeJSONError(w, ErrInvalidRequest, http.StatusBadRequest)
+			return
+		}
+
+		var code TwoFactorCode
+		err := json.NewDecoder(r.Body).Decode(&code)
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("invalid request payload")
+			writeJSONError(w, "invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		recoveryCodes, err := s.TwoFactorService.Confirm(author, code.Code)
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("error confirming two-factor authentication")
+			writeError(w, err, "error with two-factor authentication")
+			return
+		}
+
+		writeJSON(w, RecoveryCodesResponse{RecoveryCodes: recoveryCodes}, http.StatusOK)
+	}
+}
+
+// DisableTwoFactorHandler turns two-factor authentication off for the author
+func (s *Server) DisableTwoFactorHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get the context from the request
+		author, ok := r.Context().Value(ContextAuthor).(string)
+		if !ok {
+			s.log(r).Error().Msg("error getting author from context")
+			writeJSONError(w, ErrInvalidRequest, http.StatusBadRequest)
+			return
+		}
+
+		var code TwoFactorCode
+		err := json.NewDecoder(r.Body).Decode(&code)
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("invalid request payload")
+			writeJSONError(w, "invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		if err := s.TwoFactorService.Disable(author, code.Code); err != nil {
+			s.log(r).Error().Err(err).Msg("error disabling two-factor authentication")
+			writeError(w, err, "error with two-factor authentication")
+			return
+		}
+
+		// Status accepted
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"rakia.ai/blog-api/v2/internal"
+)
+
+// MockTwoFactorService is a mock implementation of the TwoFactorService interface
+type MockTwoFactorService struct {
+	mock.Mock
+}
+
+func (m *MockTwoFactorService) Enroll(author string) (*internal.TwoFactorEnrollment, error) {
+	args := m.Called(author)
+	return args.Get(0).(*internal.TwoFactorEnrollment), args.Error(1)
+}
+
+func (m *MockTwoFactorService) Confirm(author string, code string) ([]string, error) {
+	args := m.Called(author, code)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *MockTwoFactorService) Enabled(author string) bool {
+	args := m.Called(author)
+	return args.Bool(0)
+}
+
+func (m *MockTwoFactorService) Verify(author string, code string) error {
+	args := m.Called(author, code)
+	return args.Error(0)
+}
+
+func (m *MockTwoFactorService) Disable(author string, code string) error {
+	args := m.Called(author, code)
+	return args.Error(0)
+}
+
+func (m *MockTwoFactorService) Reset(author string) {
+	m.Called(author)
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	mockTwoFactorService := new(MockTwoFactorService)
+	mockTwoFactorService.On("Enabled", "Author 1").Return(true)
+	mockTwoFactorService.On("Verify", "Author 1", "000000").Return(internal.ErrTwoFactorCodeInvalid)
+	mockTwoFactorService.On("Verify", "Author 1", "123456").Return(nil)
+
+	s := NewServer(mux.NewRouter(), new(MockPostsService), &MockAuthorService{validAuthor: true}, &logger)
+	s.TwoFactorService = mockTwoFactorService
+	s.Routes()
+
+	// The password only yields a challenge
+	req, _ := http.NewRequest("POST", "/login", bytes.NewBufferString(`{"author":"Author 1","password":"password1"}`))
+	rr := httptest.NewRecorder()
+	s.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var challenge LoginChallengeResponse
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &challenge))
+	assert.True(t, challenge.TwoFactorRequired)
+
+	// The challenge is not a session
+	req, _ = http.NewRequest("GET", "/api/posts", nil)
+	req.Header.Set("Authorization", "Bearer "+challenge.ChallengeToken)
+	rr = httptest.NewRecorder()
+	s.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	// A wrong code is rejected
+	body, _ := json.Marshal(TwoFactorLogin{ChallengeToken: challenge.ChallengeToken, Code: "000000"})
+	req, _ = http.NewRequest("POST", "/login/2fa", bytes.NewBuffer(body))
+	rr = httptest.NewRecorder()
+	s.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	// The right code gives the real JWT
+	body, _ = json.Marshal(TwoFactorLogin{ChallengeToken: challenge.ChallengeToken, Code: "123456"})
+	req, _ = http.NewRequest("POST", "/login/2fa", bytes.NewBuffer(body))
+	rr = httptest.NewRecorder()
+	s.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var login LoginResponse
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &login))
+	assert.NotEmpty(t, login.Token)
+
+	// A session token cannot be used as a challenge
+	body, _ = json.Marshal(TwoFactorLogin{ChallengeToken: login.Token, Code: "123456"})
+	req, _ = http.NewRequest("POST", "/login/2fa", bytes.NewBuffer(body))
+	rr = httptest.NewRecorder()
+	s.Router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
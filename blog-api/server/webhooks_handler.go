@@ -0,0 +1,98 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"rakia.ai/blog-api/v2/internal"
+)
+
+type WebhookCreate struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+type WebhookCreateResponse struct {
+	*internal.Webhook
+	Secret string `json:"secret"`
+}
+
+// CreateWebhookHandler adds a webhook, the signing secret is only ever shown in this response
+func (s *Server) CreateWebhookHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var webhookRequest WebhookCreate
+		err := json.NewDecoder(r.Body).Decode(&webhookRequest)
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("invalid request payload")
+			writeJSONError(w, "invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		webhook, err := s.WebhooksService.CreateWebhook(webhookRequest.URL, webhookRequest.Events)
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("error creating webhook")
+			writeError(w, err, "error creating webhook")
+			return
+		}
+
+		writeJSON(w, WebhookCreateResponse{Webhook: webhook, Secret: webhook.Secret}, http.StatusCreated)
+	}
+}
+
+// GetWebhooksHandler lists the webhooks
+func (s *Server) GetWebhooksHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		webhooks, err := s.WebhooksService.ListWebhooks()
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("error getting webhooks")
+			writeError(w, err, "error getting webhooks")
+			return
+		}
+
+		writeJSON(w, webhooks, http.StatusOK)
+	}
+}
+
+// DeleteWebhookHandler deletes a webhook and its deliveries
+func (s *Server) DeleteWebhookHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := s.WebhooksService.DeleteWebhook(mux.Vars(r)["id"]); err != nil {
+			s.log(r).Error().Err(err).Msg("error deleting webhook")
+			writeError(w, err, "error deleting webhook")
+			return
+		}
+
+		// Status accepted
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// GetDeliveriesHandler lists the deliveries of a webhook with their attempts
+func (s *Server) GetDeliveriesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		deliveries, err := s.WebhooksService.ListDeliveries(mux.Vars(r)["id"])
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("error getting deliveries")
+			writeError(w, err, "error getting deliveries")
+			return
+		}
+
+		writeJSON(w, deliveries, http.StatusOK)
+	}
+}
+
+// RedeliverHandler queues a delivery to be sent again
+func (s *Server) RedeliverHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		delivery, err := s.WebhooksService.Redeliver(vars["id"], vars["deliveryID"])
+		if err != nil {
+			s.log(r).Error().Err(err).Msg("error redelivering")
+			writeError(w, err, "error redelivering")
+			return
+		}
+
+		writeJSON(w, delivery, http.StatusAccepted)
+	}
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"rakia.ai/blog-api/v2/internal"
+)
+
+func TestWebhookHandlers(t *testing.T) {
+	webhooks, _ := internal.NewWebhookService(internal.DefaultWebhookConfig, "", nil, nil, &logger)
+	server := NewServer(mux.NewRouter(), new(MockPostsService), nil, &logger)
+	server.WebhooksService = webhooks
+	server.Routes()
+
+	// Only admin manages webhooks
+	rr := authorRequest(server.Router, "POST", "/api/webhooks", `{"url":"https://example.com/hook"}`, "Author 1")
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr = authorRequest(server.Router, "POST", "/api/webhooks", `{"url":"not a url"}`, "admin")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	// The secret is shown once
+	rr = authorRequest(server.Router, "POST", "/api/webhooks", `{"url":"https://example.com/hook","events":["post.created"]}`, "admin")
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	var created map[string]interface{}
+	json.Unmarshal(rr.Body.Bytes(), &created)
+	assert.NotEmpty(t, created["secret"])
+	id := created["id"].(string)
+
+	rr = authorRequest(server.Router, "GET", "/api/webhooks", "", "admin")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotContains(t, rr.Body.String(), "secret")
+
+	webhooks.Enqueue(internal.Event{ID: 1, Type: internal.EventPostCreated, PostID: 1})
+	rr = authorRequest(server.Router, "GET", "/api/webhooks/"+id+"/deliveries", "", "admin")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var deliveries []internal.Delivery
+	json.Unmarshal(rr.Body.Bytes(), &deliveries)
+	assert.Len(t, deliveries, 1)
+
+	rr = authorRequest(server.Router, "POST", "/api/webhooks/"+id+"/deliveries/"+deliveries[0].ID+"/redeliver", "", "admin")
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+	rr = authorRequest(server.Router, "POST", "/api/webhooks/"+id+"/deliveries/unknown/redeliver", "", "admin")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = authorRequest(server.Router, "DELETE", "/api/webhooks/"+id, "", "admin")
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+	rr = authorRequest(server.Router, "GET", "/api/webhooks/"+id+"/deliveries", "", "admin")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}